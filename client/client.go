@@ -0,0 +1,674 @@
+// Package client provides a typed Go client for the psql-ledger HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+	"github.com/ATMackay/psql-ledger/service"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Client is an HTTP client for the psql-ledger service.
+type Client struct {
+	baseURL    string
+	authToken  string
+	httpClient *http.Client
+	retry      RetryPolicy
+
+	newIdempotencyKey func() string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAuthToken sets a bearer token sent with every request.
+func WithAuthToken(token string) Option {
+	return func(c *Client) {
+		c.authToken = token
+	}
+}
+
+// WithTimeout sets the timeout applied to each individual HTTP attempt.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetryPolicy sets the policy used to retry failed requests.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// New returns a client for the service listening at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL '%v': scheme must be http or https", baseURL)
+	}
+	c := &Client{
+		baseURL:           strings.TrimSuffix(baseURL, "/"),
+		httpClient:        &http.Client{Timeout: defaultTimeout},
+		retry:             DefaultRetryPolicy,
+		newIdempotencyKey: newIdempotencyKey,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// BaseURL returns the service URL the client was created with.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// StatusError is returned when the service responds with a non-200 status code.
+// RequestID identifies the failed request in the service logs.
+type StatusError struct {
+	StatusCode int
+	RequestID  string
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %v", e.StatusCode, http.StatusText(e.StatusCode), e.Err)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// IsNotFound reports whether err is a 404 response from the service.
+func IsNotFound(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.StatusCode == http.StatusNotFound
+}
+
+// GetAccount fetches the account with the supplied ID.
+func (c *Client) GetAccount(ctx context.Context, id int64) (database.Account, error) {
+	var acc database.Account
+	err := c.do(ctx, http.MethodPost, service.GetAccountEndPnt, database.Account{ID: id}, &acc)
+	return acc, err
+}
+
+// GetAccountByEmail fetches the account registered with the supplied email address.
+func (c *Client) GetAccountByEmail(ctx context.Context, email string) (database.Account, error) {
+	var acc database.Account
+	req := database.Account{Email: sql.NullString{String: email, Valid: true}}
+	err := c.do(ctx, http.MethodPost, service.GetAccountByEmailEndPnt, req, &acc)
+	return acc, err
+}
+
+// GetAccountByUsername fetches the account registered with the supplied username.
+func (c *Client) GetAccountByUsername(ctx context.Context, username string) (database.Account, error) {
+	var acc database.Account
+	err := c.do(ctx, http.MethodPost, service.GetAccountByUsernameEndPnt, database.Account{Username: username}, &acc)
+	return acc, err
+}
+
+// ListAccounts fetches all accounts.
+func (c *Client) ListAccounts(ctx context.Context) ([]database.Account, error) {
+	var accs []database.Account
+	err := c.do(ctx, http.MethodGet, service.AccountsEndPnt, nil, &accs)
+	return accs, err
+}
+
+// ListAccountsByStatus fetches all accounts with the supplied status.
+func (c *Client) ListAccountsByStatus(ctx context.Context, status string) ([]database.Account, error) {
+	var accs []database.Account
+	err := c.do(ctx, http.MethodGet, service.AccountsEndPnt+"?status="+url.QueryEscape(status), nil, &accs)
+	return accs, err
+}
+
+// SearchAccounts fetches the accounts matching filters, e.g. status, category,
+// external_reference or metadata.<key>.
+func (c *Client) SearchAccounts(ctx context.Context, filters url.Values) ([]database.Account, error) {
+	var accs []database.Account
+	path := service.AccountsEndPnt
+	if len(filters) > 0 {
+		path += "?" + filters.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &accs)
+	return accs, err
+}
+
+// SetAccountStatus freezes, unfreezes or closes an account.
+func (c *Client) SetAccountStatus(ctx context.Context, req service.AccountStatusRequest) (database.Account, error) {
+	var acc database.Account
+	err := c.do(ctx, http.MethodPut, service.AccountStatusEndPnt, req, &acc)
+	return acc, err
+}
+
+// SetOverdraftLimit changes how far below zero the balance of account id may go.
+func (c *Client) SetOverdraftLimit(ctx context.Context, id, limit int64) (database.Account, error) {
+	var acc database.Account
+	err := c.do(ctx, http.MethodPut, idPath(service.OverdraftLimitEndPnt, id), service.OverdraftLimitRequest{OverdraftLimit: limit}, &acc)
+	return acc, err
+}
+
+// SetInterestRate sets the annual interest rate, in basis points, earned by
+// account id.
+func (c *Client) SetInterestRate(ctx context.Context, id, bps int64) (database.InterestRate, error) {
+	var rate database.InterestRate
+	err := c.do(ctx, http.MethodPut, idPath(service.InterestRateEndPnt, id), service.InterestRateRequest{AnnualRateBps: bps}, &rate)
+	return rate, err
+}
+
+// AccountInterest returns the interest rate of account id and the interest it
+// has accrued but not yet been paid.
+func (c *Client) AccountInterest(ctx context.Context, id int64) (service.InterestResponse, error) {
+	var resp service.InterestResponse
+	err := c.do(ctx, http.MethodGet, idPath(service.AccountInterestEndPnt, id), nil, &resp)
+	return resp, err
+}
+
+// AuditLog fetches audit log entries. The filters entity_type, entity_id, from,
+// to, after_id and limit are supplied as query parameters.
+func (c *Client) AuditLog(ctx context.Context, filters url.Values) ([]database.AuditLog, error) {
+	var entries []database.AuditLog
+	path := service.AuditEndPnt
+	if len(filters) > 0 {
+		path += "?" + filters.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &entries)
+	return entries, err
+}
+
+// AccountBalance returns the balance of an account after all transactions
+// created at or before asOf. The current balance is returned if asOf is zero.
+func (c *Client) AccountBalance(ctx context.Context, id int64, asOf time.Time) (service.BalanceResponse, error) {
+	var b service.BalanceResponse
+	path := strings.Replace(service.AccountBalanceEndPnt, ":id", strconv.FormatInt(id, 10), 1)
+	if !asOf.IsZero() {
+		path += "?" + url.Values{"as_of": {asOf.Format(time.RFC3339Nano)}}.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &b)
+	return b, err
+}
+
+// Balances returns the balances of several accounts at the instant asOf, or
+// now if asOf is zero. Unknown accounts are omitted.
+func (c *Client) Balances(ctx context.Context, ids []int64, asOf time.Time) ([]service.BalanceResponse, error) {
+	var balances []service.BalanceResponse
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	q := url.Values{"ids": {strings.Join(s, ",")}}
+	if !asOf.IsZero() {
+		q.Set("as_of", asOf.Format(time.RFC3339Nano))
+	}
+	err := c.do(ctx, http.MethodGet, service.BalancesEndPnt+"?"+q.Encode(), nil, &balances)
+	return balances, err
+}
+
+// Statement streams the statement of an account for transactions created in
+// [from, to) to w in the format json, csv or html. Zero times select the
+// server defaults, the current month up to now.
+func (c *Client) Statement(ctx context.Context, id int64, from, to time.Time, format string, w io.Writer) error {
+	q := url.Values{"format": {format}}
+	if !from.IsZero() {
+		q.Set("from", from.Format(time.RFC3339Nano))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.Format(time.RFC3339Nano))
+	}
+	path := strings.Replace(service.AccountStatementEndPnt, ":id", strconv.FormatInt(id, 10), 1)
+	return c.do(ctx, http.MethodGet, path+"?"+q.Encode(), nil, w)
+}
+
+// ExportAccounts streams the accounts selected by p to w in the format csv,
+// jsonl or parquet. The response is gzip encoded in transit.
+func (c *Client) ExportAccounts(ctx context.Context, format string, p database.ExportParams, w io.Writer) error {
+	return c.do(ctx, http.MethodGet, service.ExportAccountsEndPnt+"?"+exportQuery(format, p), nil, w)
+}
+
+// ExportTransactions streams the transactions selected by p to w in the format
+// csv, jsonl or parquet. The response is gzip encoded in transit.
+func (c *Client) ExportTransactions(ctx context.Context, format string, p database.ExportParams, w io.Writer) error {
+	return c.do(ctx, http.MethodGet, service.ExportTransactionsEndPnt+"?"+exportQuery(format, p), nil, w)
+}
+
+func exportQuery(format string, p database.ExportParams) string {
+	q := url.Values{"format": {format}}
+	if p.SinceID > 0 {
+		q.Set("since_id", strconv.FormatInt(p.SinceID, 10))
+	}
+	if p.Since.Valid {
+		q.Set("since", p.Since.Time.Format(time.RFC3339Nano))
+	}
+	return q.Encode()
+}
+
+// Import uploads a CSV or JSONL file of accounts or transactions, see
+// service.ImportAccounts and service.ImportCSV, and returns the queued job.
+// With dryRun set the rows are validated but not loaded.
+func (c *Client) Import(ctx context.Context, kind, format string, dryRun bool, r io.Reader) (service.ImportJob, error) {
+	var job service.ImportJob
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return job, err
+	}
+	contentType := "text/csv"
+	if format == service.ImportJSONL {
+		contentType = "application/jsonl"
+	}
+	q := url.Values{"kind": {kind}, "format": {format}, "dry_run": {strconv.FormatBool(dryRun)}}
+	err = c.do(ctx, http.MethodPost, service.ImportEndPnt+"?"+q.Encode(), rawBody{b: b, contentType: contentType}, &job)
+	return job, err
+}
+
+// ImportStatus fetches the progress and row errors of an import job.
+func (c *Client) ImportStatus(ctx context.Context, id string) (service.ImportJob, error) {
+	var job service.ImportJob
+	err := c.do(ctx, http.MethodGet, strings.Replace(service.ImportJobEndPnt, ":id", url.PathEscape(id), 1), nil, &job)
+	return job, err
+}
+
+// VerifyChain walks the transaction hash chain and reports the first broken
+// link, if any.
+func (c *Client) VerifyChain(ctx context.Context) (database.ChainVerification, error) {
+	var v database.ChainVerification
+	err := c.do(ctx, http.MethodGet, service.VerifyEndPnt, nil, &v)
+	return v, err
+}
+
+// CreateAccount registers a new account.
+func (c *Client) CreateAccount(ctx context.Context, params database.CreateAccountParams) (database.Account, error) {
+	var acc database.Account
+	err := c.do(ctx, http.MethodPut, service.CreateAccountEndPnt, params, &acc)
+	return acc, err
+}
+
+// CreateTransaction posts a new transaction between two accounts. The
+// response includes the fees charged to the sender.
+func (c *Client) CreateTransaction(ctx context.Context, params database.CreateTransactionParams) (service.TxResponse, error) {
+	var resp service.TxResponse
+	err := c.do(ctx, http.MethodPut, service.CreateTxEndPnt, params, &resp)
+	return resp, err
+}
+
+// BatchTransactions posts several transfers at once. Unless req.BestEffort is
+// set either all transfers are applied or, with an error, none are.
+func (c *Client) BatchTransactions(ctx context.Context, req service.BatchTxRequest) (service.BatchTxResponse, error) {
+	var resp service.BatchTxResponse
+	err := c.do(ctx, http.MethodPost, service.BatchTxEndPnt, req, &resp)
+	return resp, err
+}
+
+// CreateScheduledTransfer creates a transfer repeating on a cron expression or
+// at a fixed interval.
+func (c *Client) CreateScheduledTransfer(ctx context.Context, req service.ScheduledTransferRequest) (database.ScheduledTransfer, error) {
+	var st database.ScheduledTransfer
+	err := c.do(ctx, http.MethodPost, service.ScheduledTransfersEndPnt, req, &st)
+	return st, err
+}
+
+// ScheduledTransfers lists scheduled transfers. The filters account_id and
+// status are supplied as query parameters.
+func (c *Client) ScheduledTransfers(ctx context.Context, filters url.Values) ([]database.ScheduledTransfer, error) {
+	var sts []database.ScheduledTransfer
+	path := service.ScheduledTransfersEndPnt
+	if len(filters) > 0 {
+		path += "?" + filters.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &sts)
+	return sts, err
+}
+
+// GetScheduledTransfer fetches the scheduled transfer with the supplied ID.
+func (c *Client) GetScheduledTransfer(ctx context.Context, id int64) (database.ScheduledTransfer, error) {
+	var st database.ScheduledTransfer
+	err := c.do(ctx, http.MethodGet, idPath(service.ScheduledTransferEndPnt, id), nil, &st)
+	return st, err
+}
+
+// ScheduledTransferRuns lists the runs of a scheduled transfer. The filters
+// after_id and limit are supplied as query parameters.
+func (c *Client) ScheduledTransferRuns(ctx context.Context, id int64, filters url.Values) ([]database.ScheduledTransferRun, error) {
+	var runs []database.ScheduledTransferRun
+	path := idPath(service.ScheduledTransferRunsEndPnt, id)
+	if len(filters) > 0 {
+		path += "?" + filters.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &runs)
+	return runs, err
+}
+
+// PauseScheduledTransfer stops a scheduled transfer from running until it is
+// resumed.
+func (c *Client) PauseScheduledTransfer(ctx context.Context, id int64) (database.ScheduledTransfer, error) {
+	var st database.ScheduledTransfer
+	err := c.do(ctx, http.MethodPost, idPath(service.PauseScheduledTransferEndPnt, id), nil, &st)
+	return st, err
+}
+
+// ResumeScheduledTransfer resumes a paused scheduled transfer.
+func (c *Client) ResumeScheduledTransfer(ctx context.Context, id int64) (database.ScheduledTransfer, error) {
+	var st database.ScheduledTransfer
+	err := c.do(ctx, http.MethodPost, idPath(service.ResumeScheduledTransferEndPnt, id), nil, &st)
+	return st, err
+}
+
+// CancelScheduledTransfer permanently stops a scheduled transfer.
+func (c *Client) CancelScheduledTransfer(ctx context.Context, id int64) (database.ScheduledTransfer, error) {
+	var st database.ScheduledTransfer
+	err := c.do(ctx, http.MethodPost, idPath(service.CancelScheduledTransferEndPnt, id), nil, &st)
+	return st, err
+}
+
+// CreateDeposit creates a deposit to a user account, pending unless req.Status is
+// settled.
+func (c *Client) CreateDeposit(ctx context.Context, req service.ExternalTransferRequest) (database.ExternalTransfer, error) {
+	var et database.ExternalTransfer
+	err := c.do(ctx, http.MethodPost, service.DepositsEndPnt, req, &et)
+	return et, err
+}
+
+// Deposits lists deposits. The filters account_id, status, after_id and limit are
+// supplied as query parameters.
+func (c *Client) Deposits(ctx context.Context, filters url.Values) ([]database.ExternalTransfer, error) {
+	var ets []database.ExternalTransfer
+	path := service.DepositsEndPnt
+	if len(filters) > 0 {
+		path += "?" + filters.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &ets)
+	return ets, err
+}
+
+// GetDeposit fetches the deposit with the supplied ID.
+func (c *Client) GetDeposit(ctx context.Context, id int64) (database.ExternalTransfer, error) {
+	var et database.ExternalTransfer
+	err := c.do(ctx, http.MethodGet, idPath(service.DepositEndPnt, id), nil, &et)
+	return et, err
+}
+
+// SettleDeposit settles a pending deposit, posting it to its account.
+func (c *Client) SettleDeposit(ctx context.Context, id int64) (database.ExternalTransfer, error) {
+	var et database.ExternalTransfer
+	err := c.do(ctx, http.MethodPost, idPath(service.SettleDepositEndPnt, id), nil, &et)
+	return et, err
+}
+
+// FailDeposit fails a pending deposit with a reason.
+func (c *Client) FailDeposit(ctx context.Context, id int64, reason string) (database.ExternalTransfer, error) {
+	var et database.ExternalTransfer
+	err := c.do(ctx, http.MethodPost, idPath(service.FailDepositEndPnt, id), service.ExternalTransferFailRequest{Reason: reason}, &et)
+	return et, err
+}
+
+// CreateWithdrawal creates a withdrawal from a user account, pending unless
+// req.Status is settled. The funds are taken from the account immediately.
+func (c *Client) CreateWithdrawal(ctx context.Context, req service.ExternalTransferRequest) (database.ExternalTransfer, error) {
+	var et database.ExternalTransfer
+	err := c.do(ctx, http.MethodPost, service.WithdrawalsEndPnt, req, &et)
+	return et, err
+}
+
+// Withdrawals lists withdrawals. The filters account_id, status, after_id and limit are
+// supplied as query parameters.
+func (c *Client) Withdrawals(ctx context.Context, filters url.Values) ([]database.ExternalTransfer, error) {
+	var ets []database.ExternalTransfer
+	path := service.WithdrawalsEndPnt
+	if len(filters) > 0 {
+		path += "?" + filters.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &ets)
+	return ets, err
+}
+
+// GetWithdrawal fetches the withdrawal with the supplied ID.
+func (c *Client) GetWithdrawal(ctx context.Context, id int64) (database.ExternalTransfer, error) {
+	var et database.ExternalTransfer
+	err := c.do(ctx, http.MethodGet, idPath(service.WithdrawalEndPnt, id), nil, &et)
+	return et, err
+}
+
+// SettleWithdrawal settles a pending withdrawal.
+func (c *Client) SettleWithdrawal(ctx context.Context, id int64) (database.ExternalTransfer, error) {
+	var et database.ExternalTransfer
+	err := c.do(ctx, http.MethodPost, idPath(service.SettleWithdrawalEndPnt, id), nil, &et)
+	return et, err
+}
+
+// FailWithdrawal fails a pending withdrawal with a reason, returning the funds
+// to its account.
+func (c *Client) FailWithdrawal(ctx context.Context, id int64, reason string) (database.ExternalTransfer, error) {
+	var et database.ExternalTransfer
+	err := c.do(ctx, http.MethodPost, idPath(service.FailWithdrawalEndPnt, id), service.ExternalTransferFailRequest{Reason: reason}, &et)
+	return et, err
+}
+
+// CreateFeeRule creates a fee rule charged on new transactions.
+func (c *Client) CreateFeeRule(ctx context.Context, req service.FeeRuleRequest) (database.FeeRule, error) {
+	var fr database.FeeRule
+	err := c.do(ctx, http.MethodPost, service.FeeRulesEndPnt, req, &fr)
+	return fr, err
+}
+
+// FeeRules lists fee rules. The filters status, after_id and limit are
+// supplied as query parameters.
+func (c *Client) FeeRules(ctx context.Context, filters url.Values) ([]database.FeeRule, error) {
+	var frs []database.FeeRule
+	path := service.FeeRulesEndPnt
+	if len(filters) > 0 {
+		path += "?" + filters.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &frs)
+	return frs, err
+}
+
+// GetFeeRule fetches the fee rule with the supplied ID.
+func (c *Client) GetFeeRule(ctx context.Context, id int64) (database.FeeRule, error) {
+	var fr database.FeeRule
+	err := c.do(ctx, http.MethodGet, idPath(service.FeeRuleEndPnt, id), nil, &fr)
+	return fr, err
+}
+
+// DisableFeeRule stops a fee rule being charged.
+func (c *Client) DisableFeeRule(ctx context.Context, id int64) (database.FeeRule, error) {
+	var fr database.FeeRule
+	err := c.do(ctx, http.MethodPost, idPath(service.DisableFeeRuleEndPnt, id), nil, &fr)
+	return fr, err
+}
+
+// idPath fills the :id parameter of endpoint.
+func idPath(endpoint string, id int64) string {
+	return strings.Replace(endpoint, ":id", strconv.FormatInt(id, 10), 1)
+}
+
+// GetTransaction fetches the transaction with the supplied ID.
+func (c *Client) GetTransaction(ctx context.Context, id int64) (database.Transaction, error) {
+	var tx database.Transaction
+	err := c.do(ctx, http.MethodPost, service.GetTransactionByIndexEndPnt, database.Transaction{ID: id}, &tx)
+	return tx, err
+}
+
+// TransactionHistory fetches all transactions to or from the supplied account.
+func (c *Client) TransactionHistory(ctx context.Context, accountID int64) ([]database.GetUserTransactionsRow, error) {
+	var txs []database.GetUserTransactionsRow
+	err := c.do(ctx, http.MethodPost, service.GetAccountTransactionsEndPnt, database.Account{ID: accountID}, &txs)
+	return txs, err
+}
+
+// Transactions lists transactions in ID order matching filters, e.g.
+// account_id, category, external_reference or metadata.<key>, paged with
+// after_id and limit.
+func (c *Client) Transactions(ctx context.Context, filters url.Values) ([]database.Transaction, error) {
+	var txs []database.Transaction
+	path := service.TransactionsEndPnt
+	if len(filters) > 0 {
+		path += "?" + filters.Encode()
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &txs)
+	return txs, err
+}
+
+// Status fetches the service status.
+func (c *Client) Status(ctx context.Context) (service.StatusResponse, error) {
+	var st service.StatusResponse
+	err := c.do(ctx, http.MethodGet, service.StatusEndPnt, nil, &st)
+	return st, err
+}
+
+// Health fetches the service health. A non-nil error is returned alongside the
+// response when the service reports failures.
+func (c *Client) Health(ctx context.Context) (service.HealthResponse, error) {
+	var h service.HealthResponse
+	err := c.do(ctx, http.MethodGet, service.HealthEndPnt, nil, &h)
+	var se *StatusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusServiceUnavailable {
+		return h, fmt.Errorf("service unhealthy: %v", h.Failures)
+	}
+	return h, err
+}
+
+// rawBody is a request body sent as is rather than encoded as JSON.
+type rawBody struct {
+	b           []byte
+	contentType string
+}
+
+// postReads are the read endpoints requested with POST. They are not sent an
+// idempotency key since the service only caches the responses of writes.
+var postReads = map[string]bool{
+	service.GetAccountEndPnt:             true,
+	service.GetAccountByEmailEndPnt:      true,
+	service.GetAccountByUsernameEndPnt:   true,
+	service.GetAccountTransactionsEndPnt: true,
+	service.GetTransactionByIndexEndPnt:  true,
+}
+
+// do executes the request, retrying according to the client retry policy, and
+// decodes a successful JSON response into out. If out is an io.Writer the
+// response body is copied to it instead.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	contentType := "application/json"
+	if raw, ok := in.(rawBody); ok {
+		body, contentType = raw.b, raw.contentType
+	} else if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	var idempotencyKey string
+	if method != http.MethodGet && !postReads[path] {
+		idempotencyKey = c.newIdempotencyKey()
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = c.attempt(ctx, method, path, body, contentType, idempotencyKey, out)
+		if err == nil || !retry || attempt >= c.retry.MaxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(c.retry.backoff(attempt)):
+		}
+	}
+}
+
+// attempt performs a single HTTP round trip. It reports whether a failed
+// attempt may be retried.
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, contentType, idempotencyKey string, out any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(service.IdempotencyKeyHeader, idempotencyKey)
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(service.RequestIDHeader, id)
+	}
+	// Propagate the caller's trace, if any, as a W3C traceparent header
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Transport errors are retried unless the caller gave up.
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	// Streamed responses are copied to out as they are read. A failure part
+	// way through is not retried since out may already have been written to.
+	if w, ok := out.(io.Writer); ok && resp.StatusCode < http.StatusMultipleChoices {
+		_, err := io.Copy(w, resp.Body)
+		return false, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+
+	if err := service.HandleResponseErr(resp); err != nil {
+		if resp.StatusCode == http.StatusServiceUnavailable && out != nil {
+			// Health responses carry the failure list in a 503 body
+			_ = json.Unmarshal(b, out)
+		}
+		return c.retry.retryable(resp.StatusCode), &StatusError{StatusCode: resp.StatusCode, Err: err}
+	}
+
+	if out == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return false, fmt.Errorf("cannot decode response: %w", err)
+	}
+	return false, nil
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
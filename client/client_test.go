@@ -0,0 +1,343 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+)
+
+func newTestClient(t *testing.T, opts ...Option) *Client {
+	s := service.New(0, 1, database.NewMemoryDBClient())
+	srv := httptest.NewServer(s.Server().Handler())
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Test_ClientAPI(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	h, err := c.Health(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := h.Service, service.ServiceName; g != w {
+		t.Fatalf("unexpected service name, want %v got %v", w, g)
+	}
+
+	acc1, err := c.CreateAccount(ctx, database.CreateAccountParams{Username: "myusername", Email: sql.NullString{String: "myname@emailprovider.com", Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc2, err := c.CreateAccount(ctx, database.CreateAccountParams{Username: "yourusername", Email: sql.NullString{String: "yourname@emailprovider.com", Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetAccount(ctx, acc1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != acc1.Username {
+		t.Fatalf("unexpected account, want %+v got %+v", acc1, got)
+	}
+
+	got, err = c.GetAccountByEmail(ctx, "yourname@emailprovider.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != acc2.ID {
+		t.Fatalf("unexpected account, want %+v got %+v", acc2, got)
+	}
+
+	accs, err := c.ListAccounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Including the deposit, withdrawal, fee revenue and interest expense system accounts
+	if g, w := len(accs), 6; g != w {
+		t.Fatalf("unexpected number of accounts, want %v got %v", w, g)
+	}
+
+	if acc1, err = c.SetOverdraftLimit(ctx, acc1.ID, 100); err != nil || acc1.OverdraftLimit != 100 {
+		t.Fatalf("cannot set overdraft limit %+v: %v", acc1, err)
+	}
+
+	tx, err := c.CreateTransaction(ctx, database.CreateTransactionParams{
+		FromAccount:       sql.NullInt64{Int64: acc1.ID, Valid: true},
+		ToAccount:         sql.NullInt64{Int64: acc2.ID, Valid: true},
+		Amount:            sql.NullInt64{Int64: 10, Valid: true},
+		ExternalReference: sql.NullString{String: "order-123", Valid: true},
+		Metadata:          json.RawMessage(`{"order_id": 123}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch, err := c.BatchTransactions(ctx, service.BatchTxRequest{Transactions: []database.CreateTransactionParams{
+		{FromAccount: sql.NullInt64{Int64: acc1.ID, Valid: true}, ToAccount: sql.NullInt64{Int64: acc2.ID, Valid: true}, Amount: sql.NullInt64{Int64: 10, Valid: true}},
+		{FromAccount: sql.NullInt64{Int64: acc1.ID, Valid: true}, ToAccount: sql.NullInt64{Int64: 99, Valid: true}, Amount: sql.NullInt64{Int64: 10, Valid: true}},
+	}})
+	if err == nil || batch.Applied != 0 {
+		t.Fatalf("expected batch to be rejected, got %+v", batch)
+	}
+
+	st, err := c.CreateScheduledTransfer(ctx, service.ScheduledTransferRequest{FromAccount: acc1.ID, ToAccount: acc2.ID, Amount: 5, Interval: "24h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, err = c.PauseScheduledTransfer(ctx, st.ID); err != nil || st.Status != database.ScheduledTransferPaused {
+		t.Fatalf("cannot pause scheduled transfer %+v: %v", st, err)
+	}
+	if _, err := c.ResumeScheduledTransfer(ctx, st.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CancelScheduledTransfer(ctx, st.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ResumeScheduledTransfer(ctx, st.ID); err == nil {
+		t.Fatal("expected a cancelled transfer not to resume")
+	}
+	sts, err := c.ScheduledTransfers(ctx, url.Values{"account_id": {strconv.FormatInt(acc2.ID, 10)}})
+	if err != nil || len(sts) != 1 || sts[0].Status != database.ScheduledTransferCancelled {
+		t.Fatalf("unexpected scheduled transfers %+v: %v", sts, err)
+	}
+	if got, err := c.GetScheduledTransfer(ctx, st.ID); err != nil || got.Amount != 5 {
+		t.Fatalf("unexpected scheduled transfer %+v: %v", got, err)
+	}
+	if runs, err := c.ScheduledTransferRuns(ctx, st.ID, nil); err != nil || len(runs) != 0 {
+		t.Fatalf("unexpected runs %+v: %v", runs, err)
+	}
+
+	gotTx, err := c.GetTransaction(ctx, tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotTx.Amount.Int64 != 10 || gotTx.ExternalReference.String != "order-123" {
+		t.Fatalf("unexpected transaction %+v", gotTx)
+	}
+	if txs, err := c.Transactions(ctx, url.Values{"metadata.order_id": {"123"}}); err != nil || len(txs) != 1 || txs[0].ID != tx.ID {
+		t.Fatalf("unexpected transactions %+v: %v", txs, err)
+	}
+
+	history, err := c.TransactionHistory(ctx, acc2.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(history), 1; g != w {
+		t.Fatalf("unexpected history length, want %v got %v", w, g)
+	}
+
+	balance, err := c.AccountBalance(ctx, acc2.ID, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := balance.Balance, int64(10); g != w {
+		t.Fatalf("unexpected balance, want %v got %v", w, g)
+	}
+	balances, err := c.Balances(ctx, []int64{acc1.ID, acc2.ID}, tx.CreatedAt.Time.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 2 || balances[0].Balance != 0 || balances[1].Balance != 0 {
+		t.Fatalf("unexpected balances before transaction %+v", balances)
+	}
+
+	var statement bytes.Buffer
+	if err := c.Statement(ctx, acc1.ID, time.Time{}, time.Time{}, service.StatementCSV, &statement); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(statement.String(), ",yourusername,10,,-10\n") {
+		t.Fatalf("unexpected statement %s", statement.String())
+	}
+
+	var export bytes.Buffer
+	if err := c.ExportTransactions(ctx, service.ExportCSV, database.ExportParams{SinceID: tx.ID - 1}, &export); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(export.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], fmt.Sprintf("%d,%d,%d,10,", tx.ID, acc1.ID, acc2.ID)) {
+		t.Fatalf("unexpected export %s", export.String())
+	}
+
+	job, err := c.Import(ctx, service.ImportAccounts, service.ImportCSV, true, strings.NewReader("username\ncarol\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != service.ImportQueued || !job.DryRun {
+		t.Fatalf("unexpected import job %+v", job)
+	}
+	if got, err := c.ImportStatus(ctx, job.ID); err != nil || got.ID != job.ID {
+		t.Fatalf("unexpected import status %+v (%v)", got, err)
+	}
+
+	dep, err := c.CreateDeposit(ctx, service.ExternalTransferRequest{AccountID: acc2.ID, Amount: 50, ExternalReference: "ach-1"})
+	if err != nil || dep.Status != database.ExternalTransferPending {
+		t.Fatalf("unexpected deposit %+v: %v", dep, err)
+	}
+	if dep, err = c.SettleDeposit(ctx, dep.ID); err != nil || !dep.TransactionID.Valid {
+		t.Fatalf("cannot settle deposit %+v: %v", dep, err)
+	}
+	wd, err := c.CreateWithdrawal(ctx, service.ExternalTransferRequest{AccountID: acc2.ID, Amount: 60, ExternalReference: "ach-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd, err = c.FailWithdrawal(ctx, wd.ID, "account closed"); err != nil || !wd.ReversalTransactionID.Valid {
+		t.Fatalf("cannot fail withdrawal %+v: %v", wd, err)
+	}
+	if got, err := c.GetWithdrawal(ctx, wd.ID); err != nil || got.Status != database.ExternalTransferFailed {
+		t.Fatalf("unexpected withdrawal %+v: %v", got, err)
+	}
+	if _, err := c.SettleWithdrawal(ctx, wd.ID); err == nil {
+		t.Fatal("expected a failed withdrawal not to settle")
+	}
+	if deps, err := c.Deposits(ctx, url.Values{"status": {database.ExternalTransferSettled}}); err != nil || len(deps) != 1 {
+		t.Fatalf("unexpected deposits %+v: %v", deps, err)
+	}
+	if wds, err := c.Withdrawals(ctx, nil); err != nil || len(wds) != 1 {
+		t.Fatalf("unexpected withdrawals %+v: %v", wds, err)
+	}
+	if _, err := c.GetDeposit(ctx, 99); !IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	from := acc2.ID
+	fr, err := c.CreateFeeRule(ctx, service.FeeRuleRequest{Name: "outgoing", FromAccount: &from, Kind: database.FeeRuleFlat, FlatFee: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeTx, err := c.CreateTransaction(ctx, database.CreateTransactionParams{
+		FromAccount: sql.NullInt64{Int64: acc2.ID, Valid: true},
+		ToAccount:   sql.NullInt64{Int64: acc1.ID, Valid: true},
+		Amount:      sql.NullInt64{Int64: 5, Valid: true},
+	})
+	if err != nil || feeTx.TotalFee != 2 || len(feeTx.Fees) != 1 || feeTx.Fees[0].FeeRuleID != fr.ID || feeTx.FeeTransaction == nil {
+		t.Fatalf("unexpected fees %+v: %v", feeTx, err)
+	}
+	if fr, err = c.DisableFeeRule(ctx, fr.ID); err != nil || fr.Status != database.FeeRuleDisabled {
+		t.Fatalf("cannot disable fee rule %+v: %v", fr, err)
+	}
+	if got, err := c.GetFeeRule(ctx, fr.ID); err != nil || got.Status != database.FeeRuleDisabled {
+		t.Fatalf("unexpected fee rule %+v: %v", got, err)
+	}
+	if frs, err := c.FeeRules(ctx, url.Values{"status": {database.FeeRuleActive}}); err != nil || len(frs) != 0 {
+		t.Fatalf("unexpected fee rules %+v: %v", frs, err)
+	}
+
+	if rate, err := c.SetInterestRate(ctx, acc1.ID, 250); err != nil || rate.AccountID != acc1.ID || rate.AnnualRateBps != 250 {
+		t.Fatalf("cannot set interest rate %+v: %v", rate, err)
+	}
+	if resp, err := c.AccountInterest(ctx, acc1.ID); err != nil || resp.AnnualRateBps != 250 || resp.Accrued != 0 {
+		t.Fatalf("unexpected interest %+v: %v", resp, err)
+	}
+
+	if _, err := c.GetAccount(ctx, 99); !IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func Test_ClientRetry(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			service.RespondWithError(w, http.StatusServiceUnavailable, "unavailable")
+			return
+		}
+		_ = service.RespondWithJSON(w, http.StatusOK, []database.Account{})
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryOn: []int{http.StatusServiceUnavailable}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListAccounts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if g, w := calls.Load(), int32(3); g != w {
+		t.Fatalf("unexpected number of attempts, want %v got %v", w, g)
+	}
+
+	calls.Store(0)
+	c.retry = NoRetry
+	if _, err := c.ListAccounts(context.Background()); err == nil {
+		t.Fatal("expected error with retries disabled")
+	}
+}
+
+func Test_ClientIdempotencyKey(t *testing.T) {
+	c := newTestClient(t)
+	c.newIdempotencyKey = func() string { return "fixed-key" }
+	ctx := context.Background()
+
+	params := database.CreateAccountParams{Username: "myusername"}
+	first, err := c.CreateAccount(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A retried write with the same key replays the original response
+	second, err := c.CreateAccount(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != second.ID {
+		t.Fatalf("expected replayed account %v, got %v", first.ID, second.ID)
+	}
+	accs, err := c.ListAccounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Including the deposit, withdrawal, fee revenue and interest expense system accounts
+	if g, w := len(accs), 5; g != w {
+		t.Fatalf("unexpected number of accounts, want %v got %v", w, g)
+	}
+}
+
+func Test_ClientIdempotencyKeyWritesOnly(t *testing.T) {
+	keys := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys[r.URL.Path] = r.Header.Get(service.IdempotencyKeyHeader)
+		_ = service.RespondWithJSON(w, http.StatusOK, database.Account{})
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := c.GetAccount(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateAccount(ctx, database.CreateAccountParams{Username: "myusername"}); err != nil {
+		t.Fatal(err)
+	}
+	if k := keys[service.GetAccountEndPnt]; k != "" {
+		t.Fatalf("unexpected idempotency key %q on read", k)
+	}
+	if k := keys[service.CreateAccountEndPnt]; k == "" {
+		t.Fatal("expected idempotency key on write")
+	}
+}
+
+func Test_NewInvalidURL(t *testing.T) {
+	if _, err := New("localhost:8080"); err == nil {
+		t.Fatal("expected error for URL without scheme")
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Write requests are
+// always sent with an idempotency key so that retrying them is safe; reads are
+// safe to retry without one.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made, including the first.
+	// A value of 1 or less disables retries.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It doubles on each
+	// subsequent retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// RetryOn lists the HTTP status codes that are retried. Transport errors
+	// are always retried.
+	RetryOn []int
+}
+
+// DefaultRetryPolicy retries transient failures up to three times.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	RetryOn: []int{
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// NoRetry disables retries.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+func (p RetryPolicy) retryable(statusCode int) bool {
+	for _, c := range p.RetryOn {
+		if c == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the retry following the supplied attempt
+// number, with up to 20% jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			d = p.MaxBackoff
+			break
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5 + 1)) // #nosec G404 -- jitter does not need a secure source
+	return d + jitter
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+)
+
+// chainReport is the output of chain verify.
+type chainReport struct {
+	database.ChainVerification
+	Checkpoints       int    `json:"checkpoints,omitempty"`
+	CheckpointFailure string `json:"checkpoint_failure,omitempty"`
+}
+
+func newChainCmd(loadConfig func() (service.Config, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "chain",
+		Short: "Verify the transaction hash chain and manage checkpoint signing keys",
+	}
+
+	var out string
+	keygen := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an ed25519 key for signing chain checkpoints",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := service.GenerateSigningKey(out)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "public key: %x\n", key.Public())
+			return err
+		},
+	}
+	keygen.Flags().StringVar(&out, "out", "", "file to write the key to, must not exist")
+	_ = keygen.MarkFlagRequired("out")
+
+	var checkpoints, publicKey string
+	verify := &cobra.Command{
+		Use:   "verify",
+		Short: "Walk the hash chain and report the first broken link",
+		Long: "Walk the hash chain and report the first broken link. With --checkpoints the signature of each\n" +
+			"checkpoint is verified and the chain is checked to contain the checkpointed hashes.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			dbClient, err := service.ConnectDB(cfg)
+			if err != nil {
+				return err
+			}
+			defer dbClient.DB().Close()
+
+			q := dbClient.NewQuery()
+			v, err := database.VerifyChain(cmd.Context(), q)
+			if err != nil {
+				return err
+			}
+			report := chainReport{ChainVerification: v}
+			if v.Valid && checkpoints != "" {
+				pub, err := checkpointKey(cfg, publicKey)
+				if err != nil {
+					return err
+				}
+				cps, err := service.ReadCheckpoints(checkpoints)
+				if err != nil {
+					return err
+				}
+				for i, cp := range cps {
+					if err := verifyCheckpoint(cmd.Context(), q, cp, pub, v.LastTxID); err != nil {
+						report.CheckpointFailure = fmt.Sprintf("checkpoint %d: %v", i+1, err)
+						break
+					}
+					report.Checkpoints++
+				}
+			}
+
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+			if !v.Valid {
+				return fmt.Errorf("hash chain broken at transaction %d: %v", v.BrokenLink.TxID, v.BrokenLink.Reason)
+			}
+			if report.CheckpointFailure != "" {
+				return errors.New(report.CheckpointFailure)
+			}
+			return nil
+		},
+	}
+	verify.Flags().StringVar(&checkpoints, "checkpoints", "", "checkpoint file to verify against the chain")
+	verify.Flags().StringVar(&publicKey, "public-key", "", "hex encoded checkpoint public key, derived from chain_signing_key_file if empty")
+
+	cmd.AddCommand(keygen, verify)
+	return cmd
+}
+
+func checkpointKey(cfg service.Config, publicKey string) (ed25519.PublicKey, error) {
+	if publicKey != "" {
+		b, err := hex.DecodeString(publicKey)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid --public-key: want %d hex encoded bytes", ed25519.PublicKeySize)
+		}
+		return ed25519.PublicKey(b), nil
+	}
+	if cfg.ChainSigningKeyFile == "" {
+		return nil, fmt.Errorf("--public-key or chain_signing_key_file is required to verify checkpoints")
+	}
+	key, err := service.LoadSigningKey(cfg.ChainSigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// verifyCheckpoint checks the signature of cp and that the chain, verified up
+// to lastTxID, contains the checkpointed hash.
+func verifyCheckpoint(ctx context.Context, q database.DBQuery, cp service.Checkpoint, pub ed25519.PublicKey, lastTxID int64) error {
+	if err := service.VerifyCheckpoint(cp, pub); err != nil {
+		return err
+	}
+	if cp.TxID > lastTxID {
+		return fmt.Errorf("transaction %d is missing from the chain", cp.TxID)
+	}
+	want := database.GenesisHash()
+	if cp.TxID > 0 {
+		tx, err := q.GetTx(ctx, cp.TxID)
+		if err != nil {
+			return fmt.Errorf("cannot read transaction %d: %w", cp.TxID, err)
+		}
+		want = tx.Hash
+	}
+	if got, err := hex.DecodeString(cp.Hash); err != nil || !bytes.Equal(got, want) {
+		return fmt.Errorf("hash of transaction %d does not match checkpoint", cp.TxID)
+	}
+	return nil
+}
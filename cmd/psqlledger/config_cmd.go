@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func newConfigCmd(loadConfig func() (service.Config, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the service configuration",
+	}
+
+	validate := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration file and environment variables",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), "config OK")
+			return err
+		},
+	}
+
+	var showDefaults bool
+	print := &cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration with secrets redacted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if showDefaults {
+				cfg = service.SanitizeConfig(cfg)
+			}
+			b, err := yaml.Marshal(cfg.Redacted())
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(b)
+			return err
+		},
+	}
+	print.Flags().BoolVar(&showDefaults, "defaults", true, "fill unset values with their defaults")
+
+	cmd.AddCommand(validate, print)
+	return cmd
+}
@@ -1,57 +1,66 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 
 	"github.com/ATMackay/psql-ledger/cmd/config"
 	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
 )
 
 const envPrefix = "PSQLLEDGER"
 
-var (
-	configFilePath string
-	configFilePtr  = flag.String("config", "config.yml", "path to config file")
-)
-
 // RUN WITH PLAINTEXT CONFIG [RECOMMENDED FOR TESTING ONLY]
-// $ go run main.go --config ./config.yml
-// $ go run main.go --config {path_to_config_file}
+// $ go run main.go serve --config ./config.yml
+// $ go run main.go serve --config {path_to_config_file}
 //
 // OR RUN WITH ENVIRONMENT VARIABLES
 //
 // $ go build
 // $ export PSQLLEDGER_POSTGRES_PASSWORD=<your_password>
-// $ ./psqlledger
+// $ ./psqlledger serve
+//
+// RUN MIGRATIONS AS A SEPARATE DEPLOY STEP
 //
+// $ ./psqlledger migrate up
+// $ ./psqlledger serve --skip-migrations
 //
 
-func init() {
-	// Parse flag containing path to config file
-	flag.Parse()
-	if configFilePtr != nil {
-		configFilePath = *configFilePtr
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
+		os.Exit(1)
 	}
 }
 
-func main() {
-	var cfg service.Config
-
-	if err := config.ParseYAMLConfig(configFilePath, &cfg, envPrefix); err != nil {
-		panic(fmt.Sprintf("error parsing config: %v", err))
+func newRootCmd() *cobra.Command {
+	var configFilePath string
+	loadConfig := func() (service.Config, error) {
+		var cfg service.Config
+		if err := config.ParseYAMLConfig(configFilePath, &cfg, envPrefix); err != nil {
+			return cfg, fmt.Errorf("error parsing config: %v", err)
+		}
+		return cfg, nil
 	}
 
-	psqlLedger, err := service.BuildService(cfg)
-	if err != nil {
-		panic(fmt.Sprintf("error building service: %v", err))
+	serve := newServeCmd(loadConfig)
+	root := &cobra.Command{
+		Use:          "psqlledger",
+		Short:        "A simple transaction ledger implemented in Go and PostgreSQL",
+		SilenceUsage: true,
+		// Running without a subcommand starts the server for backwards compatibility
+		RunE: serve.RunE,
 	}
+	root.Flags().AddFlagSet(serve.Flags())
+	root.PersistentFlags().StringVar(&configFilePath, "config", "config.yml", "path to config file")
 
-	psqlLedger.Start()
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
-	sig := <-sigChan
-	psqlLedger.Stop(sig)
+	root.AddCommand(
+		serve,
+		newMigrateCmd(loadConfig),
+		newSeedCmd(loadConfig),
+		newChainCmd(loadConfig),
+		newConfigCmd(loadConfig),
+		newVersionCmd(),
+	)
+	return root
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+)
+
+func runCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func Test_ConfigCmd(t *testing.T) {
+	t.Setenv(envPrefix+"_POSTGRES_PASSWORD", "supersecret")
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+
+	out, err := runCmd(t, "--config", configFile, "config", "print")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "supersecret") {
+		t.Fatalf("password not redacted: %s", out)
+	}
+	if !strings.Contains(out, "postgres_db: bank") {
+		t.Fatalf("expected defaults in output: %s", out)
+	}
+
+	if _, err := runCmd(t, "--config", configFile, "config", "validate"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(envPrefix+"_LOGFORMAT", "xml")
+	if _, err := runCmd(t, "--config", configFile, "config", "validate"); err == nil {
+		t.Fatal("expected validation error")
+	}
+}
+
+func Test_VersionCmd(t *testing.T) {
+	out, err := runCmd(t, "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{service.Version, service.GitCommitHash, service.BuildDate} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("expected %v in output %s", s, out)
+		}
+	}
+}
+
+func Test_Seed(t *testing.T) {
+	ctx := context.Background()
+	dbClient := database.NewMemoryDBClient()
+	q := dbClient.NewQuery()
+	// Frozen accounts are never picked
+	frozen, err := q.CreateAccount(ctx, database.CreateAccountParams{Username: "frozen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.UpdateAccountStatus(ctx, database.UpdateAccountStatusParams{ID: frozen.ID, Status: database.AccountStatusFrozen}); err != nil {
+		t.Fatal(err)
+	}
+
+	accs, txs, err := seed(ctx, dbClient, 5, 20, 100, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accs != 5 || txs != 20 {
+		t.Fatalf("unexpected seed result: %d accounts, %d transactions", accs, txs)
+	}
+	all, err := q.GetUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Including the deposit, withdrawal, fee revenue and interest expense system accounts
+	if g, w := len(all), 10; g != w {
+		t.Fatalf("unexpected number of accounts, want %v got %v", w, g)
+	}
+
+	// Balances are the sum of the transactions posted
+	posted, err := q.ListTransactions(ctx, database.ListTransactionsParams{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(posted), 5+20; g != w {
+		t.Fatalf("unexpected number of transactions, want %v got %v", w, g)
+	}
+	sums := make(map[int64]int64)
+	for _, tx := range posted {
+		if tx.FromAccount.Int64 == frozen.ID || tx.ToAccount.Int64 == frozen.ID || (tx.FromAccount.Int64 < 0 && tx.FromAccount.Int64 != database.DepositsAccountID) || tx.ToAccount.Int64 < 0 {
+			t.Fatalf("unexpected seeded transaction %+v", tx)
+		}
+		sums[tx.FromAccount.Int64] -= tx.Amount.Int64
+		sums[tx.ToAccount.Int64] += tx.Amount.Int64
+	}
+	for _, a := range all {
+		if a.Balance != sums[a.ID] || (a.ID > 0 && a.Balance < 0) {
+			t.Errorf("account %d: balance %d, transactions sum to %d", a.ID, a.Balance, sums[a.ID])
+		}
+	}
+}
+
+func Test_ChainKeygenAndCheckpoint(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "chain.key")
+	out, err := runCmd(t, "chain", "keygen", "--out", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "public key: ") {
+		t.Fatalf("unexpected output %s", out)
+	}
+	if _, err := runCmd(t, "chain", "keygen", "--out", keyFile); err == nil {
+		t.Fatal("expected existing key not to be overwritten")
+	}
+
+	key, err := service.LoadSigningKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := checkpointKey(service.Config{ChainSigningKeyFile: keyFile}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbClient := database.NewChainingClient(database.NewMemoryDBClient())
+	q := dbClient.NewQuery()
+	if _, err := q.CreateTransaction(context.Background(), database.CreateTransactionParams{}); err != nil {
+		t.Fatal(err)
+	}
+	head, err := q.GetLedgerChain(context.Background(), database.DefaultLedgerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := service.SignCheckpoint(key, head, time.Now())
+	if err := verifyCheckpoint(context.Background(), q, cp, pub, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCheckpoint(context.Background(), q, cp, pub, 0); err == nil {
+		t.Fatal("expected checkpoint beyond the verified chain to fail")
+	}
+	head.Hash = database.GenesisHash()
+	if err := verifyCheckpoint(context.Background(), q, service.SignCheckpoint(key, head, time.Now()), pub, 1); err == nil {
+		t.Fatal("expected hash mismatch")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func newMigrateCmd(loadConfig func() (service.Config, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the database schema",
+	}
+
+	// withMigrator runs fn against a migrator connected to the configured database
+	withMigrator := func(fn func(m *database.Migrator) error) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		m, err := service.NewMigrator(cfg)
+		if err != nil {
+			return fmt.Errorf("cannot create migrator: %w", err)
+		}
+		defer m.Close()
+		return fn(m)
+	}
+
+	printStatus := func(cmd *cobra.Command, m *database.Migrator) error {
+		st, err := m.Status()
+		if err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(st)
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(b)
+		return err
+	}
+
+	up := &cobra.Command{
+		Use:   "up",
+		Short: "Apply all pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(m *database.Migrator) error {
+				if err := m.Up(); err != nil {
+					return err
+				}
+				return printStatus(cmd, m)
+			})
+		},
+	}
+
+	var all bool
+	down := &cobra.Command{
+		Use:   "down [N]",
+		Short: "Revert the last N migrations (default 1)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n := 1
+			if len(args) == 1 {
+				var err error
+				if n, err = strconv.Atoi(args[0]); err != nil {
+					return fmt.Errorf("invalid number of migrations '%v'", args[0])
+				}
+			}
+			return withMigrator(func(m *database.Migrator) error {
+				var err error
+				if all {
+					err = m.DownAll()
+				} else {
+					err = m.Down(n)
+				}
+				if err != nil {
+					return err
+				}
+				return printStatus(cmd, m)
+			})
+		},
+	}
+	down.Flags().BoolVar(&all, "all", false, "revert all migrations")
+
+	to := &cobra.Command{
+		Use:   "to <version>",
+		Short: "Migrate up or down to the supplied version",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid version '%v'", args[0])
+			}
+			return withMigrator(func(m *database.Migrator) error {
+				if err := m.To(uint(v)); err != nil {
+					return err
+				}
+				return printStatus(cmd, m)
+			})
+		},
+	}
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show the current schema version and pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(m *database.Migrator) error {
+				return printStatus(cmd, m)
+			})
+		},
+	}
+
+	force := &cobra.Command{
+		Use:   "force <version>",
+		Short: "Set the schema version without running migrations and clear the dirty flag",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid version '%v'", args[0])
+			}
+			return withMigrator(func(m *database.Migrator) error {
+				if err := m.Force(v); err != nil {
+					return err
+				}
+				return printStatus(cmd, m)
+			})
+		},
+	}
+
+	cmd.AddCommand(up, down, to, status, force)
+	return cmd
+}
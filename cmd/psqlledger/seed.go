@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+)
+
+func newSeedCmd(loadConfig func() (service.Config, error)) *cobra.Command {
+	var nAccounts, nTxs int
+	var maxAmount, deposit int64
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Populate the database with random accounts and transactions for testing",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if nAccounts < 0 || nTxs < 0 || maxAmount <= 0 || deposit < 0 {
+				return fmt.Errorf("--accounts, --txs and --deposit must not be negative and --max-amount must be positive")
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			dbClient, err := service.ConnectDB(cfg)
+			if err != nil {
+				return err
+			}
+			defer dbClient.DB().Close()
+
+			ctx := database.WithActor(cmd.Context(), "seed")
+			accs, txs, err := seed(ctx, database.NewAuditingClient(database.NewChainingClient(dbClient)), nAccounts, nTxs, maxAmount, deposit)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "created %d accounts and %d transactions\n", accs, txs)
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&nAccounts, "accounts", 10, "number of accounts to create")
+	cmd.Flags().IntVar(&nTxs, "txs", 100, "number of transactions to create between random accounts")
+	cmd.Flags().Int64Var(&maxAmount, "max-amount", 1000, "maximum transaction amount")
+	cmd.Flags().Int64Var(&deposit, "deposit", 10000, "amount deposited to each new account")
+	return cmd
+}
+
+// seed creates nAccounts accounts, each funded with a settled deposit, followed
+// by nTxs transactions between random pairs of active user accounts. Every
+// posting moves the account balances as the API would. System accounts and
+// accounts that are not active are never picked.
+func seed(ctx context.Context, dbClient database.DBClient, nAccounts, nTxs int, maxAmount, deposit int64) (int, int, error) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano())) // #nosec G404 -- test data only
+	run := time.Now().Unix()
+	q := dbClient.NewQuery()
+
+	for i := 0; i < nAccounts; i++ {
+		username := fmt.Sprintf("seed%d%d", run, i)
+		acc, err := q.CreateAccount(ctx, database.CreateAccountParams{
+			Username: username,
+			Email:    sql.NullString{String: username + "@example.com", Valid: true},
+		})
+		if err != nil {
+			return i, 0, fmt.Errorf("cannot create account: %w", err)
+		}
+		if deposit > 0 {
+			if _, err := service.Deposit(ctx, dbClient, acc.ID, deposit, "seed-"+username); err != nil {
+				return i, 0, fmt.Errorf("cannot fund account %d: %w", acc.ID, err)
+			}
+		}
+	}
+
+	if nTxs == 0 {
+		return nAccounts, 0, nil
+	}
+
+	all, err := q.GetUsers(ctx)
+	if err != nil {
+		return nAccounts, 0, err
+	}
+	var accs []database.Account
+	for _, a := range all {
+		if a.AccountType == database.AccountTypeUser && a.Status == database.AccountStatusActive {
+			accs = append(accs, a)
+		}
+	}
+	if len(accs) < 2 {
+		return nAccounts, 0, fmt.Errorf("at least two active user accounts are required to seed transactions")
+	}
+
+	for i := 0; i < nTxs; i++ {
+		// Only accounts with funds send, so that no transfer is rejected
+		var funded []int
+		for j, a := range accs {
+			if a.Balance > 0 {
+				funded = append(funded, j)
+			}
+		}
+		if len(funded) == 0 {
+			return nAccounts, i, fmt.Errorf("no account has funds to send, use --deposit")
+		}
+		from := funded[r.Intn(len(funded))]
+		to := r.Intn(len(accs))
+		for to == from {
+			to = r.Intn(len(accs))
+		}
+		amount := r.Int63n(min(maxAmount, accs[from].Balance)) + 1
+		if _, err := service.PostTransaction(ctx, dbClient, database.CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: accs[from].ID, Valid: true},
+			ToAccount:   sql.NullInt64{Int64: accs[to].ID, Valid: true},
+			Amount:      sql.NullInt64{Int64: amount, Valid: true},
+		}); err != nil {
+			return nAccounts, i, fmt.Errorf("cannot create transaction: %w", err)
+		}
+		accs[from].Balance -= amount
+		accs[to].Balance += amount
+	}
+	return nAccounts, nTxs, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+)
+
+func newServeCmd(loadConfig func() (service.Config, error)) *cobra.Command {
+	var skipMigrations bool
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Start the psql-ledger HTTP service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if skipMigrations {
+				cfg.SkipMigrations = true
+			}
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+
+			psqlLedger, err := service.BuildService(cfg)
+			if err != nil {
+				return fmt.Errorf("error building service: %v", err)
+			}
+
+			// Shut down gracefully on Ctrl-C or a SIGTERM from the container runtime
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return psqlLedger.Run(ctx)
+		},
+	}
+	cmd.Flags().BoolVar(&skipMigrations, "skip-migrations", false, "do not apply pending migrations on startup")
+	return cmd
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+)
+
+func newVersionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print version information",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := fmt.Fprintf(cmd.OutOrStdout(), "version:     %v\ncommit:      %v\nbuild date:  %v\ncommit date: %v\ngo version:  %v\n",
+				service.Version, service.GitCommitHash, service.BuildDate, service.CommitDate, runtime.Version())
+			return err
+		},
+	}
+}
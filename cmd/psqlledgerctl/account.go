@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+)
+
+func newAccountCmd(g *globalFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "account",
+		Aliases: []string{"accounts", "acc"},
+		Short:   "Query and create accounts",
+	}
+
+	var email, username string
+	get := &cobra.Command{
+		Use:   "get [id]",
+		Short: "Get an account by ID, --email or --username",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			var acc database.Account
+			switch {
+			case len(args) == 1:
+				id, err := parseID(args[0])
+				if err != nil {
+					return err
+				}
+				acc, err = c.GetAccount(cmd.Context(), id)
+				if err != nil {
+					return err
+				}
+			case email != "":
+				if acc, err = c.GetAccountByEmail(cmd.Context(), email); err != nil {
+					return err
+				}
+			case username != "":
+				if acc, err = c.GetAccountByUsername(cmd.Context(), username); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("one of account ID, --email or --username must be supplied")
+			}
+			return g.print(cmd, accountTable{acc})
+		},
+	}
+	get.Flags().StringVar(&email, "email", "", "account email address")
+	get.Flags().StringVar(&username, "username", "", "account username")
+	get.MarkFlagsMutuallyExclusive("email", "username")
+
+	var status string
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List all accounts, optionally filtered by --status",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			var accs []database.Account
+			if status != "" {
+				accs, err = c.ListAccountsByStatus(cmd.Context(), status)
+			} else {
+				accs, err = c.ListAccounts(cmd.Context())
+			}
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, accountTable(accs))
+		},
+	}
+	list.Flags().StringVar(&status, "status", "", "only list accounts with this status (active|frozen|closed)")
+
+	var params database.CreateAccountParams
+	var createEmail string
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Create a new account",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			if createEmail != "" {
+				params.Email = sql.NullString{String: createEmail, Valid: true}
+			}
+			acc, err := c.CreateAccount(cmd.Context(), params)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, accountTable{acc})
+		},
+	}
+	create.Flags().StringVar(&params.Username, "username", "", "account username")
+	create.Flags().StringVar(&createEmail, "email", "", "account email address")
+	create.Flags().StringVar(&params.AccountType, "type", database.AccountTypeUser, "account type, only user accounts can be created")
+	_ = create.MarkFlagRequired("username")
+
+	var asOf string
+	balance := &cobra.Command{
+		Use:   "balance id...",
+		Short: "Get the balances of accounts, optionally at a past instant with --as-of",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids := make([]int64, len(args))
+			for i, arg := range args {
+				id, err := parseID(arg)
+				if err != nil {
+					return err
+				}
+				ids[i] = id
+			}
+			var t time.Time
+			if asOf != "" {
+				var err error
+				if t, err = time.Parse(time.RFC3339Nano, asOf); err != nil {
+					return fmt.Errorf("invalid --as-of '%v': must be an RFC 3339 timestamp", asOf)
+				}
+			}
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			if len(ids) == 1 {
+				b, err := c.AccountBalance(cmd.Context(), ids[0], t)
+				if err != nil {
+					return err
+				}
+				return g.print(cmd, balanceTable{b})
+			}
+			balances, err := c.Balances(cmd.Context(), ids, t)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, balanceTable(balances))
+		},
+	}
+	balance.Flags().StringVar(&asOf, "as-of", "", "RFC 3339 timestamp, defaults to now")
+
+	var from, to, format string
+	statement := &cobra.Command{
+		Use:   "statement id",
+		Short: "Write the statement of an account for a period as json, csv or html",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			var fromTime, toTime time.Time
+			for _, t := range []struct {
+				flag, value string
+				dst         *time.Time
+			}{{"--from", from, &fromTime}, {"--to", to, &toTime}} {
+				if t.value == "" {
+					continue
+				}
+				if *t.dst, err = time.Parse(time.RFC3339Nano, t.value); err != nil {
+					return fmt.Errorf("invalid %v '%v': must be an RFC 3339 timestamp", t.flag, t.value)
+				}
+			}
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			return c.Statement(cmd.Context(), id, fromTime, toTime, format, cmd.OutOrStdout())
+		},
+	}
+	statement.Flags().StringVar(&from, "from", "", "RFC 3339 start of the period, defaults to the start of the month")
+	statement.Flags().StringVar(&to, "to", "", "RFC 3339 end of the period (exclusive), defaults to now")
+	statement.Flags().StringVar(&format, "format", service.StatementCSV, "statement format json|csv|html")
+
+	var limit int64
+	overdraft := &cobra.Command{
+		Use:   "overdraft-limit id",
+		Short: "Set how far below zero the balance of a user account may go",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			acc, err := c.SetOverdraftLimit(cmd.Context(), id, limit)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, accountTable{acc})
+		},
+	}
+	overdraft.Flags().Int64Var(&limit, "limit", 0, "overdraft limit")
+	_ = overdraft.MarkFlagRequired("limit")
+
+	cmd.AddCommand(get, list, create, balance, statement, overdraft,
+		newAccountStatusCmd(g, "freeze", database.AccountStatusFrozen, "Freeze an account, blocking transfers to and from it"),
+		newAccountStatusCmd(g, "unfreeze", database.AccountStatusActive, "Unfreeze a frozen account"),
+		newAccountStatusCmd(g, "close", database.AccountStatusClosed, "Close an account with zero balance"))
+	return cmd
+}
+
+func newAccountStatusCmd(g *globalFlags, use, status, short string) *cobra.Command {
+	var reason string
+	cmd := &cobra.Command{
+		Use:   use + " id",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			acc, err := c.SetAccountStatus(cmd.Context(), service.AccountStatusRequest{ID: id, Status: status, Reason: reason})
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, accountTable{acc})
+		},
+	}
+	cmd.Flags().StringVar(&reason, "reason", "", "reason for the status change")
+	_ = cmd.MarkFlagRequired("reason")
+	return cmd
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid ID '%v': must be a positive integer", s)
+	}
+	return id, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+)
+
+func newExportCmd(g *globalFlags) *cobra.Command {
+	var format, since, out string
+	var sinceID int64
+	cmd := &cobra.Command{
+		Use:       "export accounts|transactions",
+		Short:     "Export accounts or transactions as csv, jsonl or parquet",
+		Long:      "Export accounts or transactions in ID order. Incremental exports select rows with an ID above --since-id created at or after --since.",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"accounts", "transactions"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p := database.ExportParams{SinceID: sinceID}
+			if since != "" {
+				t, err := time.Parse(time.RFC3339Nano, since)
+				if err != nil {
+					return fmt.Errorf("invalid --since '%v': must be an RFC 3339 timestamp", since)
+				}
+				p.Since = sql.NullTime{Time: t, Valid: true}
+			}
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			var w io.Writer = cmd.OutOrStdout()
+			var f *os.File
+			if out != "" {
+				if f, err = os.Create(out); err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			if args[0] == "accounts" {
+				err = c.ExportAccounts(cmd.Context(), format, p, w)
+			} else {
+				err = c.ExportTransactions(cmd.Context(), format, p, w)
+			}
+			if err != nil || f == nil {
+				return err
+			}
+			return f.Close()
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", service.ExportJSONL, "export format csv|jsonl|parquet")
+	cmd.Flags().Int64Var(&sinceID, "since-id", 0, "only export rows with a greater ID")
+	cmd.Flags().StringVar(&since, "since", "", "only export rows created at or after this RFC 3339 timestamp")
+	cmd.Flags().StringVar(&out, "out", "", "write the export to this file instead of stdout")
+	return cmd
+}
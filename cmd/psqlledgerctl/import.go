@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/service"
+	"github.com/spf13/cobra"
+)
+
+// importPollInterval is how often --wait polls the job status.
+const importPollInterval = time.Second
+
+func newImportCmd(g *globalFlags) *cobra.Command {
+	var kind, format string
+	var dryRun, wait bool
+	cmd := &cobra.Command{
+		Use:   "import file",
+		Short: "Bulk import accounts or transactions from a CSV or JSONL file",
+		Long: `Bulk import accounts or transactions from a CSV or JSONL file.
+
+CSV files start with a header row naming the columns username,email for
+accounts or from_account,to_account,amount for transactions. JSONL files hold
+one object per line with the same field names. The format is inferred from the
+file extension unless --format is given.
+
+The import runs in the background. Use --wait to poll until it has finished and
+print the rejected rows.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format == "" {
+				switch strings.ToLower(filepath.Ext(args[0])) {
+				case ".csv":
+					format = service.ImportCSV
+				case ".jsonl", ".ndjson":
+					format = service.ImportJSONL
+				default:
+					return fmt.Errorf("cannot infer the format of '%v', use --format csv|jsonl", args[0])
+				}
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			job, err := c.Import(cmd.Context(), kind, format, dryRun, f)
+			if err != nil {
+				return err
+			}
+			for wait && job.Status != service.ImportCompleted && job.Status != service.ImportFailed {
+				select {
+				case <-cmd.Context().Done():
+					return cmd.Context().Err()
+				case <-time.After(importPollInterval):
+				}
+				if job, err = c.ImportStatus(cmd.Context(), job.ID); err != nil {
+					return err
+				}
+			}
+			if err := g.print(cmd, importTable(job)); err != nil {
+				return err
+			}
+			if g.output == outputTable && len(job.Errors) > 0 {
+				fmt.Fprintln(cmd.OutOrStdout())
+				if err := printTable(cmd.OutOrStdout(), importErrorTable(job.Errors)); err != nil {
+					return err
+				}
+			}
+			if job.Status == service.ImportFailed {
+				return fmt.Errorf("import failed: %v", job.Error)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&kind, "kind", "", "records in the file: accounts|transactions")
+	cmd.Flags().StringVar(&format, "format", "", "file format csv|jsonl, inferred from the extension by default")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "validate the file without loading it")
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for the import to finish")
+	_ = cmd.MarkFlagRequired("kind")
+	_ = cmd.RegisterFlagCompletionFunc("kind", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return []string{service.ImportAccounts, service.ImportTransactions}, cobra.ShellCompDirectiveNoFileComp
+	})
+	return cmd
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/client"
+	"github.com/spf13/cobra"
+)
+
+// psqlledgerctl is a command-line client for operating a psql-ledger service.
+//
+// $ psqlledgerctl --url http://localhost:8080 account list
+// $ psqlledgerctl profile set prod --url https://ledger.example.com --token <api_token>
+// $ psqlledgerctl --profile prod tx send --from 1 --to 2 --amount 100 -o json
+//
+// Shell completion scripts are generated with
+//
+// $ psqlledgerctl completion bash|zsh|fish|powershell
+
+type globalFlags struct {
+	profileFile string
+	profile     string
+	baseURL     string
+	token       string
+	timeout     time.Duration
+	output      string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := newRootCmd().ExecuteContext(ctx); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCmd() *cobra.Command {
+	g := &globalFlags{}
+	root := &cobra.Command{
+		Use:           "psqlledgerctl",
+		Short:         "Command-line client for the psql-ledger service",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return validOutputFormat(g.output)
+		},
+	}
+	root.PersistentFlags().StringVar(&g.profileFile, "profile-file", defaultProfilePath(), "path to the profile configuration file")
+	root.PersistentFlags().StringVarP(&g.profile, "profile", "p", "", "profile to use (defaults to the current profile)")
+	root.PersistentFlags().StringVar(&g.baseURL, "url", "", "service base URL, overrides the profile")
+	root.PersistentFlags().StringVar(&g.token, "token", "", "API token, overrides the profile")
+	root.PersistentFlags().DurationVar(&g.timeout, "timeout", 0, "request timeout, overrides the profile")
+	root.PersistentFlags().StringVarP(&g.output, "output", "o", outputTable, "output format: table|json|yaml")
+	_ = root.RegisterFlagCompletionFunc("output", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return []string{outputTable, outputJSON, outputYAML}, cobra.ShellCompDirectiveNoFileComp
+	})
+	_ = root.RegisterFlagCompletionFunc("profile", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		f, err := loadProfileFile(g.profileFile)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var names []string
+		for n := range f.Profiles {
+			names = append(names, n)
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	root.AddCommand(
+		newAccountCmd(g),
+		newTxCmd(g),
+		newHealthCmd(g),
+		newStatusCmd(g),
+		newVerifyCmd(g),
+		newImportCmd(g),
+		newExportCmd(g),
+		newProfileCmd(g),
+	)
+	return root
+}
+
+// newClient builds an API client from the selected profile and flag overrides.
+func (g *globalFlags) newClient() (*client.Client, error) {
+	f, err := loadProfileFile(g.profileFile)
+	if err != nil {
+		return nil, err
+	}
+	p, err := f.resolve(g.profile)
+	if err != nil {
+		return nil, err
+	}
+	if g.baseURL != "" {
+		p.BaseURL = g.baseURL
+	}
+	if g.token != "" {
+		p.Token = g.token
+	}
+	if g.timeout != 0 {
+		p.Timeout = g.timeout
+	}
+	opts := []client.Option{client.WithTimeout(p.Timeout)}
+	if p.Token != "" {
+		opts = append(opts, client.WithAuthToken(p.Token))
+	}
+	return client.New(p.BaseURL, opts...)
+}
+
+func (g *globalFlags) print(cmd *cobra.Command, v any) error {
+	return printOutput(cmd.OutOrStdout(), g.output, v)
+}
+
+func newHealthCmd(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "health",
+		Short: "Check service health",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			h, err := c.Health(cmd.Context())
+			if printErr := g.print(cmd, healthTable(h)); printErr != nil {
+				return printErr
+			}
+			return err
+		},
+	}
+}
+
+func newVerifyCmd(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the transaction hash chain",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			v, err := c.VerifyChain(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if err := g.print(cmd, v); err != nil {
+				return err
+			}
+			if !v.Valid {
+				return fmt.Errorf("hash chain broken at transaction %d: %v", v.BrokenLink.TxID, v.BrokenLink.Reason)
+			}
+			return nil
+		},
+	}
+}
+
+func newStatusCmd(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show service status and version",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			s, err := c.Status(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, statusTable(s))
+		},
+	}
+}
+
+func newProfileCmd(g *globalFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage connection profiles",
+	}
+
+	var p Profile
+	set := &cobra.Command{
+		Use:   "set <name>",
+		Short: "Create or update a profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := loadProfileFile(g.profileFile)
+			if err != nil {
+				return err
+			}
+			existing, ok := f.Profiles[args[0]]
+			if !ok {
+				existing = &Profile{}
+				f.Profiles[args[0]] = existing
+			}
+			if cmd.Flags().Changed("url") {
+				existing.BaseURL = p.BaseURL
+			}
+			if cmd.Flags().Changed("token") {
+				existing.Token = p.Token
+			}
+			if cmd.Flags().Changed("timeout") {
+				existing.Timeout = p.Timeout
+			}
+			if f.CurrentProfile == "" {
+				f.CurrentProfile = args[0]
+			}
+			return f.save(g.profileFile)
+		},
+	}
+	// Local flags shadow the global overrides of the same name
+	set.Flags().StringVar(&p.BaseURL, "url", "", "service base URL")
+	set.Flags().StringVar(&p.Token, "token", "", "API token")
+	set.Flags().DurationVar(&p.Timeout, "timeout", 0, "request timeout")
+
+	use := &cobra.Command{
+		Use:   "use <name>",
+		Short: "Set the current profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := loadProfileFile(g.profileFile)
+			if err != nil {
+				return err
+			}
+			if _, ok := f.Profiles[args[0]]; !ok {
+				return fmt.Errorf("profile '%v' not found", args[0])
+			}
+			f.CurrentProfile = args[0]
+			return f.save(g.profileFile)
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := loadProfileFile(g.profileFile)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, profileTable{f})
+		},
+	}
+
+	cmd.AddCommand(set, use, list)
+	return cmd
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+)
+
+func runCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func Test_Commands(t *testing.T) {
+	s := service.New(0, 1, database.NewMemoryDBClient())
+	srv := httptest.NewServer(s.Server().Handler())
+	t.Cleanup(srv.Close)
+
+	profileFile := filepath.Join(t.TempDir(), "profiles.yml")
+	if _, err := runCmd(t, "--profile-file", profileFile, "profile", "set", "test", "--url", srv.URL, "--token", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	base := []string{"--profile-file", profileFile}
+
+	out, err := runCmd(t, append(base, "profile", "list", "-o", "json")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "secret") {
+		t.Fatalf("profile token not redacted: %s", out)
+	}
+
+	if _, err := runCmd(t, append(base, "account", "create", "--username", "myusername")...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCmd(t, append(base, "account", "create", "--username", "yourusername")...); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = runCmd(t, append(base, "account", "list", "-o", "json")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accs []database.Account
+	if err := json.Unmarshal([]byte(out), &accs); err != nil {
+		t.Fatalf("cannot decode output %s: %v", out, err)
+	}
+	// Including the deposit, withdrawal, fee revenue and interest expense system accounts
+	if g, w := len(accs), 6; g != w {
+		t.Fatalf("unexpected number of accounts, want %v got %v", w, g)
+	}
+
+	if _, err := runCmd(t, append(base, "tx", "send", "--from", "1", "--to", "2", "--amount", "5")...); err == nil {
+		t.Fatal("expected error sending without funds")
+	}
+	if _, err := runCmd(t, append(base, "account", "overdraft-limit", "1", "--limit", "100")...); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = runCmd(t, append(base, "tx", "send", "--from", "1", "--to", "2", "--amount", "5")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "ID") {
+		t.Fatalf("expected table output, got %s", out)
+	}
+
+	out, err = runCmd(t, append(base, "tx", "history", "1", "-o", "yaml")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "transaction_id: 1") {
+		t.Fatalf("unexpected yaml output %s", out)
+	}
+
+	if _, err := runCmd(t, append(base, "account", "freeze", "2", "--reason", "fraud review")...); err != nil {
+		t.Fatal(err)
+	}
+	out, err = runCmd(t, append(base, "account", "list", "--status", "frozen", "-o", "json")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(out), &accs); err != nil {
+		t.Fatalf("cannot decode output %s: %v", out, err)
+	}
+	if len(accs) != 1 || accs[0].ID != 2 {
+		t.Fatalf("unexpected frozen accounts %+v", accs)
+	}
+	if _, err := runCmd(t, append(base, "tx", "send", "--from", "1", "--to", "2", "--amount", "5")...); err == nil {
+		t.Fatal("expected error sending to frozen account")
+	}
+
+	file := filepath.Join(t.TempDir(), "accounts.csv")
+	if err := os.WriteFile(file, []byte("username,email\ncarol,\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out, err = runCmd(t, append(base, "import", file, "--kind", "accounts", "--dry-run", "-o", "json")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"status": "queued"`) || !strings.Contains(out, `"format": "csv"`) {
+		t.Fatalf("unexpected import output %s", out)
+	}
+	if _, err := runCmd(t, append(base, "import", strings.TrimSuffix(file, ".csv")+".txt", "--kind", "accounts")...); err == nil {
+		t.Fatal("expected error for unknown import file extension")
+	}
+
+	out, err = runCmd(t, append(base, "export", "transactions", "--format", "csv")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "id,from_account,to_account,amount,") || !strings.Contains(out, "\n1,1,2,5,") {
+		t.Fatalf("unexpected export output %s", out)
+	}
+
+	if _, err := runCmd(t, append(base, "account", "get", "5")...); err == nil {
+		t.Fatal("expected error for unknown account")
+	}
+	if _, err := runCmd(t, append(base, "health", "-o", "xml")...); err == nil {
+		t.Fatal("expected error for unsupported output format")
+	}
+}
+
+func Test_ProfileResolve(t *testing.T) {
+	f := &ProfileFile{Profiles: map[string]*Profile{"prod": {BaseURL: "https://ledger.example.com"}}}
+
+	p, err := f.resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := p.BaseURL, defaultBaseURL; g != w {
+		t.Fatalf("unexpected default URL, want %v got %v", w, g)
+	}
+
+	f.CurrentProfile = "prod"
+	p, err = f.resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := p.BaseURL, "https://ledger.example.com"; g != w {
+		t.Fatalf("unexpected URL, want %v got %v", w, g)
+	}
+	if g, w := p.Timeout, defaultTimeout; g != w {
+		t.Fatalf("unexpected timeout, want %v got %v", w, g)
+	}
+
+	if _, err := f.resolve("staging"); err == nil {
+		t.Fatal("expected error for unknown profile")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// tabular is implemented by values that can be rendered as a table.
+type tabular interface {
+	header() []string
+	rows() [][]string
+}
+
+func validOutputFormat(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format '%v', must be one of table|json|yaml", format)
+}
+
+// printOutput writes v to w in the requested format. Table output is only
+// available for values implementing tabular, JSON is used otherwise.
+func printOutput(w io.Writer, format string, v any) error {
+	switch format {
+	case outputYAML:
+		// Round trip through JSON so that YAML output uses the API field names
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic any
+		if err := json.Unmarshal(b, &generic); err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(generic); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outputTable:
+		if t, ok := v.(tabular); ok {
+			return printTable(w, t)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printTable(w io.Writer, t tabular) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, strings.Join(t.header(), "\t")); err != nil {
+		return err
+	}
+	for _, r := range t.rows() {
+		if _, err := fmt.Fprintln(tw, strings.Join(r, "\t")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	defaultProfileName = "default"
+	defaultBaseURL     = "http://localhost:8080"
+	defaultTimeout     = 10 * time.Second
+)
+
+// Profile holds the connection settings for a single psql-ledger deployment.
+type Profile struct {
+	BaseURL string        `yaml:"base_url"`
+	Token   string        `yaml:"token,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// ProfileFile is the on-disk profile configuration, e.g.
+//
+//	current_profile: prod
+//	profiles:
+//	  local:
+//	    base_url: http://localhost:8080
+//	  prod:
+//	    base_url: https://ledger.example.com
+//	    token: <api_token>
+type ProfileFile struct {
+	CurrentProfile string              `yaml:"current_profile"`
+	Profiles       map[string]*Profile `yaml:"profiles"`
+}
+
+func defaultProfilePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".psqlledgerctl.yml"
+	}
+	return filepath.Join(home, ".psqlledgerctl.yml")
+}
+
+// loadProfileFile reads the profile file at path. A missing file is not an error.
+func loadProfileFile(path string) (*ProfileFile, error) {
+	f := &ProfileFile{Profiles: map[string]*Profile{}}
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return f, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("cannot parse profile file %v: %w", path, err)
+	}
+	if f.Profiles == nil {
+		f.Profiles = map[string]*Profile{}
+	}
+	return f, nil
+}
+
+// save writes the profile file to path. The file may contain credentials so
+// it is only readable by the current user.
+func (f *ProfileFile) save(path string) error {
+	b, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Clean(path), b, 0600)
+}
+
+// resolve returns the named profile, falling back to the current profile and
+// then to the default settings.
+func (f *ProfileFile) resolve(name string) (Profile, error) {
+	if name == "" {
+		name = f.CurrentProfile
+	}
+	if name == "" {
+		name = defaultProfileName
+	}
+	p := Profile{BaseURL: defaultBaseURL, Timeout: defaultTimeout}
+	stored, ok := f.Profiles[name]
+	if !ok {
+		if name != defaultProfileName {
+			return p, fmt.Errorf("profile '%v' not found", name)
+		}
+		return p, nil
+	}
+	if stored.BaseURL != "" {
+		p.BaseURL = stored.BaseURL
+	}
+	if stored.Timeout != 0 {
+		p.Timeout = stored.Timeout
+	}
+	p.Token = stored.Token
+	return p, nil
+}
+
+const redacted = "********"
+
+// profileTable renders the profile file with credentials redacted.
+type profileTable struct {
+	f *ProfileFile
+}
+
+func (p profileTable) names() []string {
+	var names []string
+	for n := range p.f.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p profileTable) header() []string {
+	return []string{"CURRENT", "NAME", "URL", "TOKEN", "TIMEOUT"}
+}
+
+func (p profileTable) rows() [][]string {
+	var r [][]string
+	for _, n := range p.names() {
+		prof := p.f.Profiles[n]
+		current := ""
+		if n == p.f.CurrentProfile {
+			current = "*"
+		}
+		token := ""
+		if prof.Token != "" {
+			token = redacted
+		}
+		r = append(r, []string{current, n, prof.BaseURL, token, prof.Timeout.String()})
+	}
+	return r
+}
+
+func (p profileTable) MarshalJSON() ([]byte, error) {
+	out := ProfileFile{CurrentProfile: p.f.CurrentProfile, Profiles: map[string]*Profile{}}
+	for n, prof := range p.f.Profiles {
+		cp := *prof
+		if cp.Token != "" {
+			cp.Token = redacted
+		}
+		out.Profiles[n] = &cp
+	}
+	return json.Marshal(out)
+}
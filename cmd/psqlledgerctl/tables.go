@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/service"
+)
+
+type accountTable []database.Account
+
+func (a accountTable) header() []string {
+	return []string{"ID", "USERNAME", "TYPE", "BALANCE", "OVERDRAFT_LIMIT", "EMAIL", "STATUS", "CREATED_AT"}
+}
+
+func (a accountTable) rows() [][]string {
+	var r [][]string
+	for _, acc := range a {
+		r = append(r, []string{
+			strconv.FormatInt(acc.ID, 10),
+			acc.Username,
+			acc.AccountType,
+			strconv.FormatInt(acc.Balance, 10),
+			strconv.FormatInt(acc.OverdraftLimit, 10),
+			fmtNullString(acc.Email),
+			acc.Status,
+			fmtNullTime(acc.CreatedAt),
+		})
+	}
+	return r
+}
+
+type balanceTable []service.BalanceResponse
+
+func (b balanceTable) header() []string {
+	return []string{"ACCOUNT_ID", "BALANCE", "AS_OF"}
+}
+
+func (b balanceTable) rows() [][]string {
+	var r [][]string
+	for _, bal := range b {
+		r = append(r, []string{
+			strconv.FormatInt(bal.AccountID, 10),
+			strconv.FormatInt(bal.Balance, 10),
+			bal.AsOf.Format(time.RFC3339),
+		})
+	}
+	return r
+}
+
+type txTable []database.Transaction
+
+func (t txTable) header() []string {
+	return []string{"ID", "FROM", "TO", "AMOUNT", "CREATED_AT"}
+}
+
+func (t txTable) rows() [][]string {
+	var r [][]string
+	for _, tx := range t {
+		r = append(r, []string{
+			strconv.FormatInt(tx.ID, 10),
+			fmtNullInt(tx.FromAccount),
+			fmtNullInt(tx.ToAccount),
+			fmtNullInt(tx.Amount),
+			fmtNullTime(tx.CreatedAt),
+		})
+	}
+	return r
+}
+
+// sendTable is a sent transaction with the total fee charged to the sender.
+type sendTable service.TxResponse
+
+func (t sendTable) header() []string {
+	return []string{"ID", "FROM", "TO", "AMOUNT", "FEE", "CREATED_AT"}
+}
+
+func (t sendTable) rows() [][]string {
+	return [][]string{{
+		strconv.FormatInt(t.ID, 10),
+		fmtNullInt(t.FromAccount),
+		fmtNullInt(t.ToAccount),
+		fmtNullInt(t.Amount),
+		strconv.FormatInt(t.TotalFee, 10),
+		fmtNullTime(t.CreatedAt),
+	}}
+}
+
+type historyTable []database.GetUserTransactionsRow
+
+func (h historyTable) header() []string {
+	return []string{"ID", "FROM", "FROM_USER", "TO", "TO_USER", "AMOUNT", "CREATED_AT"}
+}
+
+func (h historyTable) rows() [][]string {
+	var r [][]string
+	for _, tx := range h {
+		r = append(r, []string{
+			strconv.FormatInt(tx.TransactionID, 10),
+			fmtNullInt(tx.FromAccountID),
+			tx.FromUsername,
+			fmtNullInt(tx.ToAccountID),
+			tx.ToUsername,
+			fmtNullInt(tx.Amount),
+			fmtNullTime(tx.TransactionCreatedAt),
+		})
+	}
+	return r
+}
+
+type healthTable service.HealthResponse
+
+func (h healthTable) header() []string {
+	return []string{"SERVICE", "VERSION", "HEALTHY", "FAILURES"}
+}
+
+func (h healthTable) rows() [][]string {
+	return [][]string{{h.Service, h.Version, strconv.FormatBool(len(h.Failures) == 0), strings.Join(h.Failures, "; ")}}
+}
+
+type statusTable service.StatusResponse
+
+func (s statusTable) header() []string {
+	return []string{"SERVICE", "VERSION", "MESSAGE"}
+}
+
+func (s statusTable) rows() [][]string {
+	return [][]string{{s.Service, s.Version, s.Message}}
+}
+
+func fmtNullString(s sql.NullString) string {
+	if s.String == "" {
+		return "-"
+	}
+	return s.String
+}
+
+func fmtNullInt(i sql.NullInt64) string {
+	if !i.Valid && i.Int64 == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(i.Int64, 10)
+}
+
+func fmtNullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return "-"
+	}
+	return t.Time.Format(time.RFC3339)
+}
+
+type importTable service.ImportJob
+
+func (j importTable) header() []string {
+	return []string{"ID", "KIND", "DRY_RUN", "STATUS", "ROWS", "IMPORTED", "FAILED", "ERROR"}
+}
+
+func (j importTable) rows() [][]string {
+	errMsg := j.Error
+	if errMsg == "" {
+		errMsg = "-"
+	}
+	return [][]string{{j.ID, j.Kind, strconv.FormatBool(j.DryRun), j.Status, strconv.Itoa(j.Rows), strconv.Itoa(j.Imported), strconv.Itoa(j.Failed), errMsg}}
+}
+
+type importErrorTable []service.ImportRowError
+
+func (e importErrorTable) header() []string {
+	return []string{"LINE", "ERROR"}
+}
+
+func (e importErrorTable) rows() [][]string {
+	var r [][]string
+	for _, re := range e {
+		r = append(r, []string{strconv.Itoa(re.Line), re.Error})
+	}
+	return r
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/spf13/cobra"
+)
+
+func newTxCmd(g *globalFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "tx",
+		Aliases: []string{"transaction", "transactions"},
+		Short:   "Send and query transactions",
+	}
+
+	var from, to, amount int64
+	send := &cobra.Command{
+		Use:   "send",
+		Short: "Send funds between two accounts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			resp, err := c.CreateTransaction(cmd.Context(), database.CreateTransactionParams{
+				FromAccount: sql.NullInt64{Int64: from, Valid: true},
+				ToAccount:   sql.NullInt64{Int64: to, Valid: true},
+				Amount:      sql.NullInt64{Int64: amount, Valid: true},
+			})
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, sendTable(resp))
+		},
+	}
+	send.Flags().Int64Var(&from, "from", 0, "sending account ID")
+	send.Flags().Int64Var(&to, "to", 0, "receiving account ID")
+	send.Flags().Int64Var(&amount, "amount", 0, "amount to send")
+	_ = send.MarkFlagRequired("from")
+	_ = send.MarkFlagRequired("to")
+	_ = send.MarkFlagRequired("amount")
+
+	get := &cobra.Command{
+		Use:   "get <id>",
+		Short: "Get a transaction by ID",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			tx, err := c.GetTransaction(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, txTable{tx})
+		},
+	}
+
+	history := &cobra.Command{
+		Use:   "history <account-id>",
+		Short: "List all transactions to or from an account",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := g.newClient()
+			if err != nil {
+				return err
+			}
+			txs, err := c.TransactionHistory(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd, historyTable(txs))
+		},
+	}
+
+	cmd.AddCommand(send, get, history)
+	return cmd
+}
@@ -0,0 +1,370 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/ATMackay/psql-ledger/logging"
+)
+
+// Audited entity types.
+const (
+	AuditEntityAccount     = "account"
+	AuditEntityTransaction = "transaction"
+
+	AuditEntityScheduledTransfer = "scheduled_transfer"
+	AuditEntityExternalTransfer  = "external_transfer"
+	AuditEntityFeeRule           = "fee_rule"
+)
+
+// Audited actions.
+const (
+	AuditActionCreate        = "create"
+	AuditActionDelete        = "delete"
+	AuditActionStatusChange  = "status_change"
+	AuditActionBalanceChange = "balance_change"
+	AuditActionRun           = "run"
+
+	AuditActionOverdraftLimitChange = "overdraft_limit_change"
+	AuditActionInterestRateChange   = "interest_rate_change"
+)
+
+// ActorAnonymous is recorded as the actor of changes made without an
+// authenticated principal.
+const ActorAnonymous = "anonymous"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the actor recorded in the audit log
+// for changes made with it.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor carried by ctx or ActorAnonymous if there is none.
+func Actor(ctx context.Context) string {
+	if actor, _ := ctx.Value(actorKey{}).(string); actor != "" {
+		return actor
+	}
+	return ActorAnonymous
+}
+
+// NewAuditingClient wraps c so that every mutating DBQuery call writes an
+// audit_log entry in the same DB transaction as the change. Calls made outside
+// ExecTx are run in a DB transaction of their own. The actor and request ID
+// are taken from the context passed to each call.
+func NewAuditingClient(c DBClient) DBClient {
+	return auditingClient{DBClient: c}
+}
+
+type auditingClient struct {
+	DBClient
+}
+
+func (c auditingClient) NewQuery() DBQuery {
+	return auditQuery{DBQuery: c.DBClient.NewQuery(), client: c.DBClient}
+}
+
+func (c auditingClient) NewQueryWithTx() (DBQuery, error) {
+	q, err := c.DBClient.NewQueryWithTx()
+	if err != nil {
+		return nil, err
+	}
+	return auditQuery{DBQuery: q}, nil
+}
+
+func (c auditingClient) ExecTx(ctx context.Context, fn func(DBQuery) error) error {
+	return c.DBClient.ExecTx(ctx, func(q DBQuery) error {
+		return fn(auditQuery{DBQuery: q})
+	})
+}
+
+// auditQuery records the mutating DBQuery methods it overrides. Read methods
+// are passed through to the embedded DBQuery, so new mutating methods must be
+// overridden here. client is nil if the query is already part of a DB
+// transaction.
+type auditQuery struct {
+	DBQuery
+	client DBClient
+}
+
+func recordAudit(ctx context.Context, q DBQuery, entityType string, entityID int64, action string, before, after any) error {
+	b, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	a, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+	_, err = q.CreateAuditEntry(ctx, CreateAuditEntryParams{
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Actor:      Actor(ctx),
+		RequestID:  logging.RequestID(ctx),
+		Before:     b,
+		After:      a,
+	})
+	return err
+}
+
+func auditJSON(v any) (json.RawMessage, error) {
+	if v == nil {
+		return json.RawMessage("null"), nil
+	}
+	return json.Marshal(v)
+}
+
+func (a auditQuery) AddAccountBalance(ctx context.Context, arg AddAccountBalanceParams) (Account, error) {
+	var acc Account
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		before, err := q.GetUser(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+		if acc, err = q.AddAccountBalance(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityAccount, acc.ID, AuditActionBalanceChange, before, acc)
+	})
+	return acc, err
+}
+
+func (a auditQuery) AdvanceScheduledTransfer(ctx context.Context, arg AdvanceScheduledTransferParams) (ScheduledTransfer, error) {
+	var st ScheduledTransfer
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		before, err := q.GetScheduledTransfer(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+		if st, err = q.AdvanceScheduledTransfer(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityScheduledTransfer, st.ID, AuditActionRun, before, st)
+	})
+	return st, err
+}
+
+func (a auditQuery) CopyAccounts(ctx context.Context, arg []CreateAccountParams) ([]Account, error) {
+	var accs []Account
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		var err error
+		if accs, err = q.CopyAccounts(ctx, arg); err != nil {
+			return err
+		}
+		for _, acc := range accs {
+			if err := recordAudit(ctx, q, AuditEntityAccount, acc.ID, AuditActionCreate, nil, acc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return accs, err
+}
+
+func (a auditQuery) CopyTransactions(ctx context.Context, arg []CreateTransactionParams) ([]Transaction, error) {
+	var txs []Transaction
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		var err error
+		if txs, err = q.CopyTransactions(ctx, arg); err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			if err := recordAudit(ctx, q, AuditEntityTransaction, tx.ID, AuditActionCreate, nil, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return txs, err
+}
+
+func (a auditQuery) CreateAccount(ctx context.Context, arg CreateAccountParams) (Account, error) {
+	var acc Account
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		var err error
+		if acc, err = q.CreateAccount(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityAccount, acc.ID, AuditActionCreate, nil, acc)
+	})
+	return acc, err
+}
+
+func (a auditQuery) CreateExternalTransfer(ctx context.Context, arg CreateExternalTransferParams) (ExternalTransfer, error) {
+	var et ExternalTransfer
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		var err error
+		if et, err = q.CreateExternalTransfer(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityExternalTransfer, et.ID, AuditActionCreate, nil, et)
+	})
+	return et, err
+}
+
+func (a auditQuery) CreateFeeRule(ctx context.Context, arg CreateFeeRuleParams) (FeeRule, error) {
+	var fr FeeRule
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		var err error
+		if fr, err = q.CreateFeeRule(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityFeeRule, fr.ID, AuditActionCreate, nil, fr)
+	})
+	return fr, err
+}
+
+func (a auditQuery) CreateScheduledTransfer(ctx context.Context, arg CreateScheduledTransferParams) (ScheduledTransfer, error) {
+	var st ScheduledTransfer
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		var err error
+		if st, err = q.CreateScheduledTransfer(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityScheduledTransfer, st.ID, AuditActionCreate, nil, st)
+	})
+	return st, err
+}
+
+func (a auditQuery) CreateTransaction(ctx context.Context, arg CreateTransactionParams) (Transaction, error) {
+	var tx Transaction
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		var err error
+		if tx, err = q.CreateTransaction(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityTransaction, tx.ID, AuditActionCreate, nil, tx)
+	})
+	return tx, err
+}
+
+func (a auditQuery) DeleteAccount(ctx context.Context, id int64) error {
+	return execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		before, err := q.GetUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := q.DeleteAccount(ctx, id); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityAccount, id, AuditActionDelete, before, nil)
+	})
+}
+
+func (a auditQuery) UpdateAccountOverdraftLimit(ctx context.Context, arg UpdateAccountOverdraftLimitParams) (Account, error) {
+	var acc Account
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		before, err := q.GetUser(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+		if acc, err = q.UpdateAccountOverdraftLimit(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityAccount, acc.ID, AuditActionOverdraftLimitChange, before, acc)
+	})
+	return acc, err
+}
+
+func (a auditQuery) UpdateAccountStatus(ctx context.Context, arg UpdateAccountStatusParams) (Account, error) {
+	var acc Account
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		before, err := q.GetUser(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+		if acc, err = q.UpdateAccountStatus(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityAccount, acc.ID, AuditActionStatusChange, before, acc)
+	})
+	return acc, err
+}
+
+func (a auditQuery) UpdateExternalTransferStatus(ctx context.Context, arg UpdateExternalTransferStatusParams) (ExternalTransfer, error) {
+	var et ExternalTransfer
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		before, err := q.GetExternalTransfer(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+		if et, err = q.UpdateExternalTransferStatus(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityExternalTransfer, et.ID, AuditActionStatusChange, before, et)
+	})
+	return et, err
+}
+
+func (a auditQuery) UpdateFeeRuleStatus(ctx context.Context, arg UpdateFeeRuleStatusParams) (FeeRule, error) {
+	var fr FeeRule
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		before, err := q.GetFeeRule(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+		if fr, err = q.UpdateFeeRuleStatus(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityFeeRule, fr.ID, AuditActionStatusChange, before, fr)
+	})
+	return fr, err
+}
+
+func (a auditQuery) UpdateScheduledTransferStatus(ctx context.Context, arg UpdateScheduledTransferStatusParams) (ScheduledTransfer, error) {
+	var st ScheduledTransfer
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		before, err := q.GetScheduledTransfer(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+		if st, err = q.UpdateScheduledTransferStatus(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityScheduledTransfer, st.ID, AuditActionStatusChange, before, st)
+	})
+	return st, err
+}
+
+func (a auditQuery) UpsertInterestRate(ctx context.Context, arg UpsertInterestRateParams) (InterestRate, error) {
+	var r InterestRate
+	err := execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		var before any
+		prev, err := q.GetInterestRate(ctx, arg.AccountID)
+		switch {
+		case err == nil:
+			before = prev
+		case !errors.Is(err, ErrNotFound) && !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+		if r, err = q.UpsertInterestRate(ctx, arg); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityAccount, r.AccountID, AuditActionInterestRateChange, before, r)
+	})
+	return r, err
+}
+
+func (a auditQuery) DeleteInterestRate(ctx context.Context, accountID int64) error {
+	return execTx(ctx, a.client, a.DBQuery, func(q DBQuery) error {
+		prev, err := q.GetInterestRate(ctx, accountID)
+		switch {
+		case errors.Is(err, ErrNotFound) || errors.Is(err, sql.ErrNoRows):
+			return nil
+		case err != nil:
+			return err
+		}
+		if err := q.DeleteInterestRate(ctx, accountID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, AuditEntityAccount, accountID, AuditActionInterestRateChange, prev, nil)
+	})
+}
+
+func (a auditQuery) WithTx(tx DBTX) DBQuery {
+	return auditQuery{DBQuery: a.DBQuery.WithTx(tx)}
+}
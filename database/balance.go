@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// BalanceAsOf returns the balance of the account after all transactions
+// created at or before asOf. Accounts created after asOf have a zero balance.
+func BalanceAsOf(ctx context.Context, q DBQuery, id int64, asOf time.Time) (int64, error) {
+	row, err := q.GetAccountBalanceAsOf(ctx, GetAccountBalanceAsOfParams{AsOf: asOf, AsOfDay: utcDay(asOf), ID: id})
+	if err != nil {
+		return 0, err
+	}
+	return row.Balance, nil
+}
+
+// BalancesAsOf is BalanceAsOf for several accounts. Unknown accounts are
+// omitted from the result, which is ordered by account ID.
+func BalancesAsOf(ctx context.Context, q DBQuery, ids []int64, asOf time.Time) ([]GetAccountBalancesAsOfRow, error) {
+	return q.GetAccountBalancesAsOf(ctx, GetAccountBalancesAsOfParams{AsOf: asOf, AsOfDay: utcDay(asOf), Ids: ids})
+}
+
+// SettledUntil returns the earlier of t and the start of the oldest DB
+// transaction in progress. Every transaction created before the returned time
+// has committed or rolled back.
+func SettledUntil(ctx context.Context, q DBQuery, t time.Time) (time.Time, error) {
+	oldest, err := q.GetOldestTransactionStart(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot read oldest transaction start: %w", err)
+	}
+	if oldest.Before(t) {
+		return oldest, nil
+	}
+	return t, nil
+}
+
+// CheckpointBalances records the closing balance of every account for each UTC
+// day after the latest checkpoint that ended at or before until, starting
+// from the day of the first transaction. It returns the number of days
+// checkpointed. Each day builds on the checkpoint of the previous day, so a
+// day is only checkpointed once no DB transaction that started on or before
+// it is still in progress.
+func CheckpointBalances(ctx context.Context, q DBQuery, until time.Time) (int, error) {
+	until, err := SettledUntil(ctx, q, until)
+	if err != nil {
+		return 0, err
+	}
+
+	var next time.Time
+	latest, err := q.GetLatestBalanceCheckpointDay(ctx)
+	switch {
+	case err == nil:
+		next = utcDay(latest).Add(day)
+	case errors.Is(err, sql.ErrNoRows):
+		first, err := q.GetFirstTransactionTime(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("cannot read first transaction: %w", err)
+		}
+		next = utcDay(first.Time)
+	default:
+		return 0, fmt.Errorf("cannot read latest balance checkpoint: %w", err)
+	}
+
+	var days int
+	for ; !next.Add(day).After(until); next = next.Add(day) {
+		if _, err := q.CreateBalanceCheckpoints(ctx, CreateBalanceCheckpointsParams{Day: next, DayStart: next, DayEnd: next.Add(day)}); err != nil {
+			return days, fmt.Errorf("cannot checkpoint balances for %v: %w", next.Format(time.DateOnly), err)
+		}
+		days++
+	}
+	return days, nil
+}
+
+// utcDay returns the start of the UTC day containing t.
+func utcDay(t time.Time) time.Time {
+	return t.UTC().Truncate(day)
+}
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// DefaultLedgerID identifies the ledger_chain row created by migration. All
+// transactions are appended to its hash chain.
+const DefaultLedgerID = 1
+
+const verifyPageSize = 1000
+
+// GenesisHash is the prev_hash of the first transaction in a chain.
+func GenesisHash() []byte {
+	return make([]byte, sha256.Size)
+}
+
+// Versions of the canonical encoding of a transaction. Each transaction
+// records the version its chain hash was computed with, so that transactions
+// chained before a field was added still verify.
+const (
+	// ChainVersionLegacy covers id|from_account|to_account|amount|created_at.
+	ChainVersionLegacy = 1
+	// ChainVersion also covers the description, external reference,
+	// category and metadata. It is used for all new transactions.
+	ChainVersion = 2
+)
+
+// CanonicalTxBytes returns the encoding of tx covered by its chain hash. The
+// legacy encoding is id|from_account|to_account|amount|created_at with NULL
+// fields empty and created_at in UTC with microsecond precision; the chain
+// migration encodes existing transactions identically. Version 2 is prefixed
+// with v2| and appends the description, external reference and category as
+// JSON strings, empty if NULL, and the metadata as compact JSON with sorted
+// keys. Transactions without a version are encoded as legacy.
+func CanonicalTxBytes(tx Transaction) []byte {
+	nullInt := func(n sql.NullInt64) string {
+		if !n.Valid {
+			return ""
+		}
+		return strconv.FormatInt(n.Int64, 10)
+	}
+	var createdAt string
+	if tx.CreatedAt.Valid {
+		createdAt = tx.CreatedAt.Time.UTC().Format("2006-01-02T15:04:05.000000Z")
+	}
+	legacy := fmt.Sprintf("%d|%s|%s|%s|%s", tx.ID, nullInt(tx.FromAccount), nullInt(tx.ToAccount), nullInt(tx.Amount), createdAt)
+	if tx.ChainVersion <= ChainVersionLegacy {
+		return []byte(legacy)
+	}
+
+	// Quoting the strings keeps a '|' in one from shifting the field boundaries
+	nullString := func(s sql.NullString) string {
+		if !s.Valid {
+			return ""
+		}
+		b, _ := json.Marshal(s.String)
+		return string(b)
+	}
+	return []byte(fmt.Sprintf("v%d|%s|%s|%s|%s|%s", tx.ChainVersion, legacy, nullString(tx.Description),
+		nullString(tx.ExternalReference), nullString(tx.Category), canonicalJSON(tx.Metadata)))
+}
+
+// canonicalJSON re-encodes m compactly with object keys sorted, so that the
+// encoding does not depend on how the DB formats jsonb. Numbers are kept as
+// written. Absent metadata is encoded as the column default {} and invalid
+// JSON as written.
+func canonicalJSON(m json.RawMessage) string {
+	if len(bytes.TrimSpace(m)) == 0 {
+		return "{}"
+	}
+	d := json.NewDecoder(bytes.NewReader(m))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return string(m)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return string(m)
+	}
+	return string(b)
+}
+
+// ChainHash returns H(prevHash || CanonicalTxBytes(tx)) using SHA-256.
+func ChainHash(prevHash []byte, tx Transaction) []byte {
+	h := sha256.New()
+	h.Write(prevHash)
+	h.Write(CanonicalTxBytes(tx))
+	return h.Sum(nil)
+}
+
+// NewChainingClient wraps c so that every transaction created is appended to
+// the hash chain of the default ledger in the same DB transaction. The chain
+// head is locked before the transaction is inserted so that the chain follows
+// transaction ID order.
+func NewChainingClient(c DBClient) DBClient {
+	return chainingClient{DBClient: c}
+}
+
+type chainingClient struct {
+	DBClient
+}
+
+func (c chainingClient) NewQuery() DBQuery {
+	return chainQuery{DBQuery: c.DBClient.NewQuery(), client: c.DBClient}
+}
+
+func (c chainingClient) NewQueryWithTx() (DBQuery, error) {
+	q, err := c.DBClient.NewQueryWithTx()
+	if err != nil {
+		return nil, err
+	}
+	return chainQuery{DBQuery: q}, nil
+}
+
+func (c chainingClient) ExecTx(ctx context.Context, fn func(DBQuery) error) error {
+	return c.DBClient.ExecTx(ctx, func(q DBQuery) error {
+		return fn(chainQuery{DBQuery: q})
+	})
+}
+
+// chainQuery overrides CreateTransaction and CopyTransactions. client is nil if the query is
+// already part of a DB transaction.
+type chainQuery struct {
+	DBQuery
+	client DBClient
+}
+
+func (c chainQuery) CreateTransaction(ctx context.Context, arg CreateTransactionParams) (Transaction, error) {
+	var tx Transaction
+	err := execTx(ctx, c.client, c.DBQuery, func(q DBQuery) error {
+		head, err := q.GetLedgerChainForUpdate(ctx, DefaultLedgerID)
+		if err != nil {
+			return fmt.Errorf("cannot lock ledger chain: %w", err)
+		}
+		if tx, err = q.CreateTransaction(ctx, arg); err != nil {
+			return err
+		}
+		hash := ChainHash(head.Hash, tx)
+		if tx, err = q.SetTransactionHash(ctx, SetTransactionHashParams{ID: tx.ID, PrevHash: head.Hash, Hash: hash}); err != nil {
+			return err
+		}
+		_, err = q.UpdateLedgerChain(ctx, UpdateLedgerChainParams{ID: DefaultLedgerID, LastTxID: tx.ID, Hash: hash})
+		return err
+	})
+	return tx, err
+}
+
+func (c chainQuery) CopyTransactions(ctx context.Context, arg []CreateTransactionParams) ([]Transaction, error) {
+	var txs []Transaction
+	err := execTx(ctx, c.client, c.DBQuery, func(q DBQuery) error {
+		head, err := q.GetLedgerChainForUpdate(ctx, DefaultLedgerID)
+		if err != nil {
+			return fmt.Errorf("cannot lock ledger chain: %w", err)
+		}
+		if txs, err = q.CopyTransactions(ctx, arg); err != nil || len(txs) == 0 {
+			return err
+		}
+		prev := head.Hash
+		for i, tx := range txs {
+			hash := ChainHash(prev, tx)
+			if txs[i], err = q.SetTransactionHash(ctx, SetTransactionHashParams{ID: tx.ID, PrevHash: prev, Hash: hash}); err != nil {
+				return err
+			}
+			prev = hash
+		}
+		_, err = q.UpdateLedgerChain(ctx, UpdateLedgerChainParams{ID: DefaultLedgerID, LastTxID: txs[len(txs)-1].ID, Hash: prev})
+		return err
+	})
+	return txs, err
+}
+
+func (c chainQuery) WithTx(tx DBTX) DBQuery {
+	return chainQuery{DBQuery: c.DBQuery.WithTx(tx)}
+}
+
+// execTx runs fn with q if client is nil, i.e. q is already part of a DB
+// transaction, and in a new DB transaction otherwise.
+func execTx(ctx context.Context, client DBClient, q DBQuery, fn func(DBQuery) error) error {
+	if client == nil {
+		return fn(q)
+	}
+	return client.ExecTx(ctx, fn)
+}
+
+// ChainVerification is the result of walking the hash chain.
+type ChainVerification struct {
+	Valid        bool        `json:"valid"`
+	Transactions int64       `json:"transactions"`
+	LastTxID     int64       `json:"last_tx_id"`
+	HeadHash     string      `json:"head_hash"`
+	BrokenLink   *ChainBreak `json:"broken_link,omitempty"`
+}
+
+// ChainBreak describes the first transaction whose link in the chain does not
+// verify. Hashes are hex encoded.
+type ChainBreak struct {
+	TxID     int64  `json:"tx_id"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// VerifyChain walks the transactions in ID order recomputing each hash and
+// checking that it links to the previous transaction, then checks that the
+// chain head matches the last transaction. It stops at the first broken link.
+// Transactions appended after the chain head is read are not verified.
+func VerifyChain(ctx context.Context, q DBQuery) (ChainVerification, error) {
+	head, err := q.GetLedgerChain(ctx, DefaultLedgerID)
+	if err != nil {
+		return ChainVerification{}, fmt.Errorf("cannot read ledger chain: %w", err)
+	}
+
+	var v ChainVerification
+	prev := GenesisHash()
+	broken := func(id int64, reason string, expected, actual []byte) (ChainVerification, error) {
+		v.BrokenLink = &ChainBreak{TxID: id, Reason: reason, Expected: hex.EncodeToString(expected), Actual: hex.EncodeToString(actual)}
+		return v, nil
+	}
+walk:
+	for {
+		txs, err := q.ListTransactions(ctx, ListTransactionsParams{ID: v.LastTxID, Limit: verifyPageSize})
+		if err != nil {
+			return ChainVerification{}, err
+		}
+		for _, tx := range txs {
+			if tx.ID > head.LastTxID {
+				break walk
+			}
+			switch {
+			case tx.Hash == nil:
+				return broken(tx.ID, "transaction is not chained", nil, nil)
+			case tx.ChainVersion > ChainVersion:
+				return broken(tx.ID, fmt.Sprintf("unknown chain version %d", tx.ChainVersion), nil, nil)
+			case !bytes.Equal(tx.PrevHash, prev):
+				return broken(tx.ID, "prev_hash does not match hash of previous transaction", prev, tx.PrevHash)
+			}
+			if h := ChainHash(prev, tx); !bytes.Equal(h, tx.Hash) {
+				return broken(tx.ID, "hash does not match transaction contents", h, tx.Hash)
+			}
+			prev = tx.Hash
+			v.Transactions++
+			v.LastTxID = tx.ID
+		}
+		if len(txs) < verifyPageSize {
+			break
+		}
+	}
+
+	if head.LastTxID != v.LastTxID || !bytes.Equal(head.Hash, prev) {
+		return broken(head.LastTxID, "chain head does not match last transaction", head.Hash, prev)
+	}
+	v.Valid = true
+	v.HeadHash = hex.EncodeToString(prev)
+	return v, nil
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// Bulk inserts are not supported by sqlc for lib/pq. Rows are streamed with
+// COPY into a temporary staging table and inserted from there so that the
+// created rows can be returned. The staging tables are dropped on commit, so
+// these queries must be called in a DB transaction.
+
+const createImportAccounts = `CREATE TEMP TABLE IF NOT EXISTS import_accounts (
+	ord integer NOT NULL, username varchar NOT NULL, balance bigint NOT NULL, email varchar, account_type varchar NOT NULL,
+	description varchar, external_reference varchar, category varchar, metadata jsonb
+) ON COMMIT DROP`
+
+const insertImportAccounts = `INSERT INTO accounts (username, balance, email, account_type, description, external_reference, category, metadata)
+SELECT username, balance, email, COALESCE(NULLIF(account_type, ''), 'user'), description, external_reference, category, COALESCE(metadata, '{}') FROM import_accounts
+ORDER BY ord
+RETURNING id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata`
+
+const createImportTransactions = `CREATE TEMP TABLE IF NOT EXISTS import_transactions (
+	ord integer NOT NULL, from_account bigint, to_account bigint, amount bigint,
+	description varchar, external_reference varchar, category varchar, metadata jsonb
+) ON COMMIT DROP`
+
+const insertImportTransactions = `INSERT INTO transactions (from_account, to_account, amount, description, external_reference, category, metadata)
+SELECT from_account, to_account, amount, description, external_reference, category, COALESCE(metadata, '{}') FROM import_transactions
+ORDER BY ord
+RETURNING id, from_account, to_account, amount, created_at, prev_hash, hash, description, external_reference, category, metadata, chain_version`
+
+// CopyAccounts inserts the accounts using COPY and returns them in ID order,
+// which is the order of arg.
+func (q *Queries) CopyAccounts(ctx context.Context, arg []CreateAccountParams) ([]Account, error) {
+	err := q.copyIn(ctx, createImportAccounts, "import_accounts", []string{"ord", "username", "balance", "email", "account_type", "description", "external_reference", "category", "metadata"}, len(arg), func(i int) []any {
+		return []any{i, arg[i].Username, arg[i].Balance, arg[i].Email, arg[i].AccountType, arg[i].Description, arg[i].ExternalReference, arg[i].Category, copyJSON(arg[i].Metadata)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.db.QueryContext(ctx, insertImportAccounts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Account
+	for rows.Next() {
+		var i Account
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Balance,
+			&i.Email,
+			&i.CreatedAt,
+			&i.Status,
+			&i.StatusReason,
+			&i.StatusUpdatedAt,
+			&i.AccountType,
+			&i.OverdraftLimit,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+// CopyTransactions inserts the transactions using COPY and returns them in ID
+// order, which is the order of arg. Account balances are not changed.
+func (q *Queries) CopyTransactions(ctx context.Context, arg []CreateTransactionParams) ([]Transaction, error) {
+	err := q.copyIn(ctx, createImportTransactions, "import_transactions", []string{"ord", "from_account", "to_account", "amount", "description", "external_reference", "category", "metadata"}, len(arg), func(i int) []any {
+		return []any{i, arg[i].FromAccount, arg[i].ToAccount, arg[i].Amount, arg[i].Description, arg[i].ExternalReference, arg[i].Category, copyJSON(arg[i].Metadata)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.db.QueryContext(ctx, insertImportTransactions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transaction
+	for rows.Next() {
+		var i Transaction
+		if err := rows.Scan(
+			&i.ID,
+			&i.FromAccount,
+			&i.ToAccount,
+			&i.Amount,
+			&i.CreatedAt,
+			&i.PrevHash,
+			&i.Hash,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+			&i.ChainVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+// copyJSON returns a JSON value for COPY, which would encode it as bytea.
+func copyJSON(v json.RawMessage) any {
+	if v == nil {
+		return nil
+	}
+	return string(v)
+}
+
+// copyIn (re)creates an empty staging table and copies n rows into it.
+func (q *Queries) copyIn(ctx context.Context, create, table string, columns []string, n int, row func(int) []any) error {
+	if _, err := q.db.ExecContext(ctx, create); err != nil {
+		return fmt.Errorf("cannot create staging table: %w", err)
+	}
+	if _, err := q.db.ExecContext(ctx, "TRUNCATE "+pq.QuoteIdentifier(table)); err != nil {
+		return fmt.Errorf("cannot truncate staging table: %w", err)
+	}
+	stmt, err := q.db.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if _, err := stmt.ExecContext(ctx, row(i)...); err != nil {
+			_ = stmt.Close()
+			return err
+		}
+	}
+	// An Exec without arguments flushes the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
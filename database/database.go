@@ -3,17 +3,23 @@ package database
 import (
 	"context"
 	"database/sql"
+	"io/fs"
+	"time"
 )
 
 // DBClient represents a database client.
 type DBClient interface {
 	CheckDatabaseExists(ctx context.Context, dbName string) (bool, error)
-	InitializeSchema(schemaPath string) error
+	InitializeSchema(migrations fs.FS, migrationsTable string) error
+	SchemaVersion(ctx context.Context, migrationsTable string) (version uint, dirty bool, err error)
 
 	DB() DB
 	NewQuery() DBQuery
 	NewTransaction() (DBTX, error)
 	NewQueryWithTx() (DBQuery, error)
+	// ExecTx runs fn in a DB transaction. The transaction is committed if fn
+	// returns nil and rolled back otherwise.
+	ExecTx(ctx context.Context, fn func(DBQuery) error) error
 }
 
 // DB represents basic database operations.
@@ -24,14 +30,72 @@ type DB interface {
 
 // DBQuery is an interface for executing queries on the database.
 type DBQuery interface {
+	AddAccountBalance(ctx context.Context, arg AddAccountBalanceParams) (Account, error)
+	AdvanceScheduledTransfer(ctx context.Context, arg AdvanceScheduledTransferParams) (ScheduledTransfer, error)
+	ClaimDueScheduledTransfers(ctx context.Context, arg ClaimDueScheduledTransfersParams) ([]ScheduledTransfer, error)
+	CopyAccounts(ctx context.Context, arg []CreateAccountParams) ([]Account, error)
+	CopyTransactions(ctx context.Context, arg []CreateTransactionParams) ([]Transaction, error)
 	CreateAccount(ctx context.Context, arg CreateAccountParams) (Account, error)
+	CreateAuditEntry(ctx context.Context, arg CreateAuditEntryParams) (AuditLog, error)
+	CreateBalanceCheckpoints(ctx context.Context, arg CreateBalanceCheckpointsParams) (int64, error)
+	CreateExternalTransfer(ctx context.Context, arg CreateExternalTransferParams) (ExternalTransfer, error)
+	CreateFeeRule(ctx context.Context, arg CreateFeeRuleParams) (FeeRule, error)
+	CreateInterestAccrual(ctx context.Context, arg CreateInterestAccrualParams) (InterestAccrual, error)
+	CreateScheduledTransfer(ctx context.Context, arg CreateScheduledTransferParams) (ScheduledTransfer, error)
+	CreateScheduledTransferRun(ctx context.Context, arg CreateScheduledTransferRunParams) (ScheduledTransferRun, error)
 	CreateTransaction(ctx context.Context, arg CreateTransactionParams) (Transaction, error)
+	CreateTransactionFee(ctx context.Context, arg CreateTransactionFeeParams) (TransactionFee, error)
 	DeleteAccount(ctx context.Context, id int64) error
+	DeleteInterestRate(ctx context.Context, accountID int64) error
+	ExportAccounts(ctx context.Context, arg ExportParams, fn func(Account) error) error
+	ExportTransactions(ctx context.Context, arg ExportParams, fn func(Transaction) error) error
+	GetAccountBalanceAsOf(ctx context.Context, arg GetAccountBalanceAsOfParams) (GetAccountBalanceAsOfRow, error)
+	GetAccountBalancesAsOf(ctx context.Context, arg GetAccountBalancesAsOfParams) ([]GetAccountBalancesAsOfRow, error)
+	GetExternalTransfer(ctx context.Context, id int64) (ExternalTransfer, error)
+	GetExternalTransferByReference(ctx context.Context, arg GetExternalTransferByReferenceParams) (ExternalTransfer, error)
+	GetExternalTransferForUpdate(ctx context.Context, id int64) (ExternalTransfer, error)
+	GetFeeRule(ctx context.Context, id int64) (FeeRule, error)
+	GetFirstTransactionTime(ctx context.Context) (sql.NullTime, error)
+	GetInterestRate(ctx context.Context, accountID int64) (InterestRate, error)
+	GetInterestRateForUpdate(ctx context.Context, accountID int64) (InterestRate, error)
+	GetLatestBalanceCheckpointDay(ctx context.Context) (time.Time, error)
+	GetLatestInterestAccrual(ctx context.Context, accountID int64) (InterestAccrual, error)
+	GetLedgerChain(ctx context.Context, id int64) (LedgerChain, error)
+	GetLedgerChainForUpdate(ctx context.Context, id int64) (LedgerChain, error)
+	GetOldestTransactionStart(ctx context.Context) (time.Time, error)
+	GetScheduledTransfer(ctx context.Context, id int64) (ScheduledTransfer, error)
+	GetScheduledTransferForUpdate(ctx context.Context, id int64) (ScheduledTransfer, error)
+	GetTransactionTime(ctx context.Context) (time.Time, error)
 	GetTx(ctx context.Context, id int64) (Transaction, error)
+	GetTxByExternalReference(ctx context.Context, arg GetTxByExternalReferenceParams) (Transaction, error)
 	GetUser(ctx context.Context, id int64) (Account, error)
 	GetUserByEmail(ctx context.Context, email sql.NullString) (Account, error)
+	GetUserByExternalReference(ctx context.Context, externalReference sql.NullString) (Account, error)
 	GetUserByUsername(ctx context.Context, username string) (Account, error)
-	GetUsers(ctx context.Context) ([]Account, error)
+	GetUserForUpdate(ctx context.Context, id int64) (Account, error)
 	GetUserTransactions(ctx context.Context) ([]GetUserTransactionsRow, error)
+	GetUsers(ctx context.Context) ([]Account, error)
+	GetUsersByStatus(ctx context.Context, status string) ([]Account, error)
+	ListAccountTransactions(ctx context.Context, arg ListAccountTransactionsParams) ([]ListAccountTransactionsRow, error)
+	ListAuditEntries(ctx context.Context, arg ListAuditEntriesParams) ([]AuditLog, error)
+	ListExternalTransfers(ctx context.Context, arg ListExternalTransfersParams) ([]ExternalTransfer, error)
+	ListFeeRules(ctx context.Context, arg ListFeeRulesParams) ([]FeeRule, error)
+	ListInterestRates(ctx context.Context) ([]InterestRate, error)
+	ListMatchingFeeRules(ctx context.Context, arg ListMatchingFeeRulesParams) ([]FeeRule, error)
+	ListScheduledTransferRuns(ctx context.Context, arg ListScheduledTransferRunsParams) ([]ScheduledTransferRun, error)
+	ListScheduledTransfers(ctx context.Context, arg ListScheduledTransfersParams) ([]ScheduledTransfer, error)
+	ListTransactions(ctx context.Context, arg ListTransactionsParams) ([]Transaction, error)
+	MarkInterestAccrualsPosted(ctx context.Context, arg MarkInterestAccrualsPostedParams) (int64, error)
+	SearchAccounts(ctx context.Context, arg SearchAccountsParams) ([]Account, error)
+	SearchTransactions(ctx context.Context, arg SearchTransactionsParams) ([]Transaction, error)
+	SetTransactionHash(ctx context.Context, arg SetTransactionHashParams) (Transaction, error)
+	SumUnpostedInterest(ctx context.Context, arg SumUnpostedInterestParams) (SumUnpostedInterestRow, error)
+	UpdateAccountOverdraftLimit(ctx context.Context, arg UpdateAccountOverdraftLimitParams) (Account, error)
+	UpdateAccountStatus(ctx context.Context, arg UpdateAccountStatusParams) (Account, error)
+	UpdateExternalTransferStatus(ctx context.Context, arg UpdateExternalTransferStatusParams) (ExternalTransfer, error)
+	UpdateFeeRuleStatus(ctx context.Context, arg UpdateFeeRuleStatusParams) (FeeRule, error)
+	UpdateLedgerChain(ctx context.Context, arg UpdateLedgerChainParams) (LedgerChain, error)
+	UpdateScheduledTransferStatus(ctx context.Context, arg UpdateScheduledTransferStatusParams) (ScheduledTransfer, error)
+	UpsertInterestRate(ctx context.Context, arg UpsertInterestRateParams) (InterestRate, error)
 	WithTx(tx DBTX) DBQuery
 }
@@ -3,7 +3,12 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/sqlc"
 )
 
 func TestMemDBQuery_CreateAccount(t *testing.T) {
@@ -85,6 +90,48 @@ func TestMemDBQuery_DeleteAccount(t *testing.T) {
 	}
 }
 
+func TestMemDBClient_ExecTxRollback(t *testing.T) {
+	dbClient := NewMemoryDBClient()
+	ctx := context.Background()
+
+	if _, err := dbClient.NewQuery().CreateAccount(ctx, CreateAccountParams{Username: "testuser"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes made by a failed transaction are discarded
+	errAbort := errors.New("abort")
+	err := dbClient.ExecTx(ctx, func(q DBQuery) error {
+		if _, err := q.AddAccountBalance(ctx, AddAccountBalanceParams{ID: 1, Amount: 100}); err != nil {
+			return err
+		}
+		if _, err := q.UpdateAccountStatus(ctx, UpdateAccountStatusParams{ID: 1, Status: AccountStatusFrozen}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	acc, err := dbClient.NewQuery().GetUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Balance != 0 || acc.Status != AccountStatusActive {
+		t.Fatalf("transaction not rolled back: %+v", acc)
+	}
+
+	// and kept by a successful one
+	if err := dbClient.ExecTx(ctx, func(q DBQuery) error {
+		_, err := q.AddAccountBalance(ctx, AddAccountBalanceParams{ID: 1, Amount: 100})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if acc, _ := dbClient.NewQuery().GetUser(ctx, 1); acc.Balance != 100 {
+		t.Fatalf("unexpected balance %v", acc.Balance)
+	}
+}
+
 func TestMemDBClient_CheckDatabaseExists(t *testing.T) {
 	dbClient := NewMemoryDBClient()
 	ctx := context.Background()
@@ -100,3 +147,210 @@ func TestMemDBClient_CheckDatabaseExists(t *testing.T) {
 		t.Errorf("Expected database 'bank' to exist, but it does not")
 	}
 }
+
+func TestMigrationVersions(t *testing.T) {
+	versions, err := MigrationVersions(sqlc.Migrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) == 0 || versions[0] != 20240129110552 {
+		t.Fatalf("unexpected embedded migration versions: %v", versions)
+	}
+	for i := 1; i < len(versions); i++ {
+		if versions[i] <= versions[i-1] {
+			t.Fatalf("migration versions not ascending: %v", versions)
+		}
+	}
+
+	latest, err := LatestMigrationVersion(sqlc.Migrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := latest, versions[len(versions)-1]; g != w {
+		t.Fatalf("unexpected latest version, want %v got %v", w, g)
+	}
+}
+
+func TestCanonicalTxBytes(t *testing.T) {
+	tx := Transaction{
+		ID:          7,
+		FromAccount: sql.NullInt64{Int64: 1, Valid: true},
+		ToAccount:   sql.NullInt64{Int64: 2, Valid: true},
+		Amount:      sql.NullInt64{Int64: 100, Valid: true},
+		CreatedAt:   sql.NullTime{Time: time.Date(2024, 1, 2, 4, 4, 5, 123456000, time.FixedZone("CET", 3600)), Valid: true},
+	}
+	// Must match the encoding used by the hash chain migration
+	if g, w := string(CanonicalTxBytes(tx)), "7|1|2|100|2024-01-02T03:04:05.123456Z"; g != w {
+		t.Fatalf("unexpected canonical bytes, want %v got %v", w, g)
+	}
+	if g, w := string(CanonicalTxBytes(Transaction{ID: 8})), "8||||"; g != w {
+		t.Fatalf("unexpected canonical bytes for NULL fields, want %v got %v", w, g)
+	}
+
+	tx.ChainVersion = ChainVersion
+	tx.Description = sql.NullString{String: "rent|march", Valid: true}
+	tx.Category = sql.NullString{String: "", Valid: true}
+	tx.Metadata = json.RawMessage(`{"b": [1, 2.50], "a": {"d": null, "c": "x"}}`)
+	if g, w := string(CanonicalTxBytes(tx)), `v2|7|1|2|100|2024-01-02T03:04:05.123456Z|"rent|march"||""|{"a":{"c":"x","d":null},"b":[1,2.50]}`; g != w {
+		t.Fatalf("unexpected version 2 canonical bytes, want %v got %v", w, g)
+	}
+	if g, w := string(CanonicalTxBytes(Transaction{ID: 8, ChainVersion: ChainVersion})), "v2|8||||||||{}"; g != w {
+		t.Fatalf("unexpected version 2 canonical bytes for NULL fields, want %v got %v", w, g)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	mem := NewMemoryDBClient()
+	dbClient := NewChainingClient(mem)
+	ctx := context.Background()
+
+	for i := int64(1); i <= 3; i++ {
+		if _, err := dbClient.NewQuery().CreateTransaction(ctx, CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: 1, Valid: true},
+			ToAccount:   sql.NullInt64{Int64: 2, Valid: true},
+			Amount:      sql.NullInt64{Int64: i, Valid: true},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := VerifyChain(ctx, dbClient.NewQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid || v.Transactions != 3 || v.LastTxID != 3 {
+		t.Fatalf("unexpected verification %+v", v)
+	}
+
+	// Tamper with the second transaction
+	tx, err := mem.NewQuery().GetTx(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Amount.Int64 = 1000
+	if _, err := mem.NewQuery().SetTransactionHash(ctx, SetTransactionHashParams{ID: 2, PrevHash: tx.PrevHash, Hash: ChainHash(GenesisHash(), tx)}); err != nil {
+		t.Fatal(err)
+	}
+	v, err = VerifyChain(ctx, dbClient.NewQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid || v.BrokenLink == nil || v.BrokenLink.TxID != 2 || v.Transactions != 1 {
+		t.Fatalf("expected broken link at tx 2, got %+v", v)
+	}
+
+	// Tampering with the metadata of a transaction breaks the chain too
+	mem = NewMemoryDBClient()
+	dbClient = NewChainingClient(mem)
+	for _, m := range []string{`{"order_id": 1}`, `{"order_id": 2}`} {
+		if _, err := dbClient.NewQuery().CreateTransaction(ctx, CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: 1, Valid: true},
+			ToAccount:   sql.NullInt64{Int64: 2, Valid: true},
+			Amount:      sql.NullInt64{Int64: 1, Valid: true},
+			Metadata:    json.RawMessage(m),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := VerifyChain(ctx, dbClient.NewQuery()); err != nil || !v.Valid {
+		t.Fatalf("unexpected verification %+v %v", v, err)
+	}
+	db := mem.q.db
+	db.mu.Lock()
+	tx = db.transactions[1]
+	tx.Metadata = json.RawMessage(`{"order_id": 3}`)
+	db.transactions[1] = tx
+	db.mu.Unlock()
+	v, err = VerifyChain(ctx, dbClient.NewQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid || v.BrokenLink == nil || v.BrokenLink.TxID != 1 || v.BrokenLink.Reason != "hash does not match transaction contents" {
+		t.Fatalf("expected tampered metadata to break the chain at tx 1, got %+v", v)
+	}
+
+	// Transactions created without the chaining client are not chained
+	mem = NewMemoryDBClient()
+	if _, err := mem.NewQuery().CreateTransaction(ctx, CreateTransactionParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.NewQuery().UpdateLedgerChain(ctx, UpdateLedgerChainParams{ID: DefaultLedgerID, LastTxID: 1, Hash: GenesisHash()}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := VerifyChain(ctx, mem.NewQuery()); v.Valid || v.BrokenLink.Reason != "transaction is not chained" {
+		t.Fatalf("expected unchained transaction, got %+v", v)
+	}
+}
+
+func TestBalanceCheckpoints(t *testing.T) {
+	mem := NewMemoryDBClient()
+	q := mem.NewQuery()
+	ctx := context.Background()
+	now := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	mem.SetClock(func() time.Time { return now })
+
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := q.CreateAccount(ctx, CreateAccountParams{Username: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// alice sends bob 10 on the first day, 5 on the second and bob returns 3
+	// on the third
+	send := func(from, to, amount int64) {
+		if _, err := q.CreateTransaction(ctx, CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: from, Valid: true},
+			ToAccount:   sql.NullInt64{Int64: to, Valid: true},
+			Amount:      sql.NullInt64{Int64: amount, Valid: true},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(1, 2, 10)
+	now = now.Add(24 * time.Hour)
+	send(1, 2, 5)
+	now = now.Add(24 * time.Hour)
+	send(2, 1, 3)
+
+	check := func(asOf time.Time, alice, bob int64) {
+		t.Helper()
+		rows, err := BalancesAsOf(ctx, q, []int64{2, 1, 7}, asOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []GetAccountBalancesAsOfRow{{AccountID: 1, Balance: alice}, {AccountID: 2, Balance: bob}}
+		if len(rows) != 2 || rows[0] != want[0] || rows[1] != want[1] {
+			t.Fatalf("unexpected balances at %v, want %v got %v", asOf, want, rows)
+		}
+		b, err := BalanceAsOf(ctx, q, 1, asOf)
+		if err != nil || b != alice {
+			t.Fatalf("unexpected balance at %v, want %v got %v (%v)", asOf, alice, b, err)
+		}
+	}
+	day1 := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	assertBalances := func() {
+		check(day1.Add(-time.Second), 0, 0)
+		check(day1.Add(12*time.Hour), -10, 10)
+		check(day1.Add(36*time.Hour-time.Nanosecond), -10, 10)
+		check(day1.Add(36*time.Hour), -15, 15)
+		check(day1.Add(72*time.Hour), -12, 12)
+	}
+	assertBalances()
+
+	// Only completed days are checkpointed, and not those after the start of
+	// the oldest DB transaction in progress, which for MemDB is now
+	days, err := CheckpointBalances(ctx, q, now.Add(72*time.Hour))
+	if err != nil || days != 2 {
+		t.Fatalf("expected 2 days checkpointed, got %v (%v)", days, err)
+	}
+	if days, err := CheckpointBalances(ctx, q, now); err != nil || days != 0 {
+		t.Fatalf("expected no days checkpointed, got %v (%v)", days, err)
+	}
+	latest, err := q.GetLatestBalanceCheckpointDay(ctx)
+	if err != nil || !latest.Equal(day1.Add(24*time.Hour)) {
+		t.Fatalf("unexpected latest checkpoint %v (%v)", latest, err)
+	}
+	assertBalances()
+
+	if _, err := BalanceAsOf(ctx, q, 7, now); err == nil {
+		t.Fatal("expected unknown account to be rejected")
+	}
+}
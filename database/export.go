@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Exports are read a page of rows at a time, each page selected by a keyset
+// query in its own statement, so that memory use does not grow with the size
+// of the table and no DB transaction is held open while a slow client reads
+// the export. An open transaction would hold back SettledUntil, and with it
+// balance checkpoints and interest accrual.
+
+const exportPageSize = 1000
+
+// ExportParams selects the rows of an incremental export: rows with an ID
+// greater than SinceID created at or after Since, if set.
+type ExportParams struct {
+	SinceID int64
+	Since   sql.NullTime
+}
+
+const exportAccounts = `SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+WHERE id > $1 AND ($2::timestamptz IS NULL OR created_at >= $2)
+ORDER BY id
+LIMIT $3`
+
+const exportTransactions = `SELECT id, from_account, to_account, amount, created_at, prev_hash, hash, description, external_reference, category, metadata, chain_version FROM transactions
+WHERE id > $1 AND ($2::timestamptz IS NULL OR created_at >= $2)
+ORDER BY id
+LIMIT $3`
+
+// ExportAccounts calls fn for each account selected by arg in ID order.
+func (q *Queries) ExportAccounts(ctx context.Context, arg ExportParams, fn func(Account) error) error {
+	return exportPages(ctx, q.db, exportAccounts, arg, func(rows *sql.Rows) (Account, error) {
+		var i Account
+		err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Balance,
+			&i.Email,
+			&i.CreatedAt,
+			&i.Status,
+			&i.StatusReason,
+			&i.StatusUpdatedAt,
+			&i.AccountType,
+			&i.OverdraftLimit,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+		)
+		return i, err
+	}, func(i Account) int64 { return i.ID }, fn)
+}
+
+// ExportTransactions calls fn for each transaction selected by arg in ID order.
+func (q *Queries) ExportTransactions(ctx context.Context, arg ExportParams, fn func(Transaction) error) error {
+	return exportPages(ctx, q.db, exportTransactions, arg, func(rows *sql.Rows) (Transaction, error) {
+		var i Transaction
+		err := rows.Scan(
+			&i.ID,
+			&i.FromAccount,
+			&i.ToAccount,
+			&i.Amount,
+			&i.CreatedAt,
+			&i.PrevHash,
+			&i.Hash,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+			&i.ChainVersion,
+		)
+		return i, err
+	}, func(i Transaction) int64 { return i.ID }, fn)
+}
+
+// exportPages reads the rows of query a page at a time, after the ID of the
+// last row of the previous page, and calls fn for each. A page is read in full
+// before fn is called so that its connection is returned to the pool while
+// the rows are written out.
+func exportPages[T any](ctx context.Context, db DBTX, query string, arg ExportParams, scan func(*sql.Rows) (T, error), id func(T) int64, fn func(T) error) error {
+	after := arg.SinceID
+	page := make([]T, 0, exportPageSize)
+	for {
+		rows, err := db.QueryContext(ctx, query, after, arg.Since, exportPageSize)
+		if err != nil {
+			return err
+		}
+		page = page[:0]
+		for rows.Next() {
+			v, err := scan(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			page = append(page, v)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, v := range page {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+		after = id(page[len(page)-1])
+	}
+}
@@ -3,8 +3,15 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io/fs"
+	"maps"
+	"reflect"
+	"slices"
 	"sort"
+	"sync"
+	"time"
 )
 
 // To be used for testing both in and outside of this package
@@ -20,13 +27,33 @@ type MemDBClient struct {
 
 func NewMemoryDBClient() MemDBClient {
 	db := newMemDB()
-	return MemDBClient{tx: FakeDBTx{db: &db}, q: MemDBQuery{db: &db}}
+	return MemDBClient{tx: FakeDBTx{db: db}, q: MemDBQuery{db: db}}
 }
 
-func (m MemDBClient) InitializeSchema(migrationDir string) error {
+func (m MemDBClient) InitializeSchema(migrations fs.FS, migrationsTable string) error {
+	v, err := LatestMigrationVersion(migrations)
+	if err != nil {
+		return err
+	}
+	m.q.db.mu.Lock()
+	defer m.q.db.mu.Unlock()
+	m.q.db.schemaVersion = v
 	return nil
 }
 
+func (m MemDBClient) SchemaVersion(ctx context.Context, migrationsTable string) (uint, bool, error) {
+	m.q.db.mu.Lock()
+	defer m.q.db.mu.Unlock()
+	return m.q.db.schemaVersion, false, nil
+}
+
+// SetClock sets the function used to timestamp accounts and transactions.
+func (m MemDBClient) SetClock(now func() time.Time) {
+	m.q.db.mu.Lock()
+	defer m.q.db.mu.Unlock()
+	m.q.db.now = now
+}
+
 func (m MemDBClient) NewQuery() DBQuery {
 	return m.q
 }
@@ -47,22 +74,90 @@ func (m MemDBClient) CheckDatabaseExists(ctx context.Context, dbName string) (bo
 	return true, nil
 }
 
-func newMemDB() MemDB {
+// ExecTx runs fn with exclusive access to the in-memory DB. All changes made
+// by fn are discarded if it returns an error.
+func (m MemDBClient) ExecTx(ctx context.Context, fn func(DBQuery) error) error {
+	db := m.q.db
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	db.mu.Lock()
+	snapshot := db.clone()
+	db.mu.Unlock()
+
+	if err := fn(m.q); err != nil {
+		db.mu.Lock()
+		db.restore(snapshot)
+		db.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func newMemDB() *MemDB {
 	a := make(map[int64]Account)
 	t := make(map[int64]Transaction)
-	return MemDB{accounts: a, transactions: t}
+	// The default ledger chain and the deposit, withdrawal, fee revenue and
+	// interest expense system accounts are created by migration
+	c := map[int64]LedgerChain{DefaultLedgerID: {ID: DefaultLedgerID, Hash: GenesisHash(), UpdatedAt: time.Now()}}
+	for id, username := range map[int64]string{DepositsAccountID: "external_deposits", WithdrawalsAccountID: "external_withdrawals", FeeRevenueAccountID: "fee_revenue", InterestExpenseAccountID: "interest_expense"} {
+		a[id] = Account{ID: id, Username: username, CreatedAt: sql.NullTime{Time: time.Now(), Valid: true}, Status: AccountStatusActive, AccountType: AccountTypeSystem, Metadata: emptyMetadata()}
+	}
+	return &MemDB{accounts: a, transactions: t, chains: c, scheduled: make(map[int64]ScheduledTransfer), interestRates: make(map[int64]InterestRate), now: time.Now}
 }
 
+// MemDB is an in-memory DB. mu guards the tables and txMu serializes
+// transactions.
 type MemDB struct {
-	accounts     map[int64]Account
-	transactions map[int64]Transaction
+	mu            sync.Mutex
+	txMu          sync.Mutex
+	accounts      map[int64]Account
+	transactions  map[int64]Transaction
+	auditLog      []AuditLog
+	chains        map[int64]LedgerChain
+	checkpoints   []BalanceCheckpoint
+	scheduled     map[int64]ScheduledTransfer
+	scheduledRuns []ScheduledTransferRun
+	external      []ExternalTransfer
+	feeRules      []FeeRule
+	txFees        []TransactionFee
+	interestRates map[int64]InterestRate
+	accruals      []InterestAccrual
+	schemaVersion uint
+	now           func() time.Time
 }
 
-func (m MemDB) Ping() error {
+type memDBTables struct {
+	accounts      map[int64]Account
+	transactions  map[int64]Transaction
+	auditLog      []AuditLog
+	chains        map[int64]LedgerChain
+	checkpoints   []BalanceCheckpoint
+	scheduled     map[int64]ScheduledTransfer
+	scheduledRuns []ScheduledTransferRun
+	external      []ExternalTransfer
+	feeRules      []FeeRule
+	txFees        []TransactionFee
+	interestRates map[int64]InterestRate
+	accruals      []InterestAccrual
+}
+
+func (m *MemDB) clone() memDBTables {
+	return memDBTables{accounts: maps.Clone(m.accounts), transactions: maps.Clone(m.transactions), auditLog: slices.Clone(m.auditLog), chains: maps.Clone(m.chains), checkpoints: slices.Clone(m.checkpoints), scheduled: maps.Clone(m.scheduled), scheduledRuns: slices.Clone(m.scheduledRuns), external: slices.Clone(m.external), feeRules: slices.Clone(m.feeRules), txFees: slices.Clone(m.txFees), interestRates: maps.Clone(m.interestRates), accruals: slices.Clone(m.accruals)}
+}
+
+func (m *MemDB) restore(t memDBTables) {
+	m.accounts, m.transactions, m.auditLog, m.chains, m.checkpoints = t.accounts, t.transactions, t.auditLog, t.chains, t.checkpoints
+	m.scheduled, m.scheduledRuns, m.external = t.scheduled, t.scheduledRuns, t.external
+	m.feeRules, m.txFees = t.feeRules, t.txFees
+	m.interestRates, m.accruals = t.interestRates, t.accruals
+}
+
+func (m *MemDB) Ping() error {
 	return nil
 }
 
-func (m MemDB) Close() error {
+func (m *MemDB) Close() error {
 	return nil
 }
 
@@ -70,27 +165,297 @@ type MemDBQuery struct {
 	db *MemDB
 }
 
+func (f MemDBQuery) CopyAccounts(ctx context.Context, arg []CreateAccountParams) ([]Account, error) {
+	var accs []Account
+	for _, p := range arg {
+		acc, err := f.CreateAccount(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		accs = append(accs, acc)
+	}
+	return accs, nil
+}
+
+func (f MemDBQuery) CopyTransactions(ctx context.Context, arg []CreateTransactionParams) ([]Transaction, error) {
+	var txs []Transaction
+	for _, p := range arg {
+		tx, err := f.CreateTransaction(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
 func (f MemDBQuery) CreateAccount(ctx context.Context, arg CreateAccountParams) (Account, error) {
-	l := len(f.db.accounts)
-	index := int64(l + 1)
-	a := Account{ID: index, Balance: 0, Username: arg.Username, Email: arg.Email}
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	// System accounts created by migration have negative IDs outside the
+	// sequence
+	index := int64(1)
+	for id := range f.db.accounts {
+		if id >= index {
+			index = id + 1
+		}
+	}
+	accountType := arg.AccountType
+	if accountType == "" {
+		accountType = AccountTypeUser
+	}
+	if arg.ExternalReference.Valid {
+		for _, a := range f.db.accounts {
+			if a.ExternalReference == arg.ExternalReference {
+				return Account{}, fmt.Errorf("duplicate account external reference %q", arg.ExternalReference.String)
+			}
+		}
+	}
+	a := Account{
+		ID:                index,
+		Balance:           arg.Balance,
+		Username:          arg.Username,
+		Email:             arg.Email,
+		CreatedAt:         sql.NullTime{Time: f.db.now(), Valid: true},
+		Status:            AccountStatusActive,
+		AccountType:       accountType,
+		Description:       arg.Description,
+		ExternalReference: arg.ExternalReference,
+		Category:          arg.Category,
+		Metadata:          metadataOrEmpty(arg.Metadata),
+	}
 	f.db.accounts[index] = a
 	return a, nil
 }
 
+func (f MemDBQuery) CreateAuditEntry(ctx context.Context, arg CreateAuditEntryParams) (AuditLog, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	e := AuditLog{
+		ID:         int64(len(f.db.auditLog) + 1),
+		EntityType: arg.EntityType,
+		EntityID:   arg.EntityID,
+		Action:     arg.Action,
+		Actor:      arg.Actor,
+		RequestID:  arg.RequestID,
+		Before:     arg.Before,
+		After:      arg.After,
+		CreatedAt:  time.Now(),
+	}
+	f.db.auditLog = append(f.db.auditLog, e)
+	return e, nil
+}
+
+func (f MemDBQuery) CreateBalanceCheckpoints(ctx context.Context, arg CreateBalanceCheckpointsParams) (int64, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	day := arg.Day.UTC().Truncate(24 * time.Hour)
+	var rows int64
+	for _, a := range f.db.accounts {
+		if _, ok := f.db.checkpoint(a.ID, day); ok {
+			continue
+		}
+		from := time.Time{}
+		prev, ok := f.db.checkpoint(a.ID, day.AddDate(0, 0, -1))
+		if ok {
+			from = arg.DayStart
+		}
+		balance := prev.Balance + f.db.netAmount(a.ID, from, func(t time.Time) bool { return t.Before(arg.DayEnd) })
+		f.db.checkpoints = append(f.db.checkpoints, BalanceCheckpoint{AccountID: a.ID, Day: day, Balance: balance, CreatedAt: f.db.now()})
+		rows++
+	}
+	return rows, nil
+}
+
 func (f MemDBQuery) CreateTransaction(ctx context.Context, arg CreateTransactionParams) (Transaction, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
 	l := len(f.db.transactions)
 	index := int64(l + 1)
-	tx := Transaction{ID: index, FromAccount: arg.FromAccount, ToAccount: arg.ToAccount, Amount: arg.Amount}
+	if arg.ExternalReference.Valid {
+		for _, tx := range f.db.transactions {
+			if tx.FromAccount == arg.FromAccount && tx.ExternalReference == arg.ExternalReference {
+				return Transaction{}, fmt.Errorf("duplicate transaction external reference %q", arg.ExternalReference.String)
+			}
+		}
+	}
+	tx := Transaction{
+		ID:                index,
+		FromAccount:       arg.FromAccount,
+		ToAccount:         arg.ToAccount,
+		Amount:            arg.Amount,
+		CreatedAt:         sql.NullTime{Time: f.db.now(), Valid: true},
+		Description:       arg.Description,
+		ExternalReference: arg.ExternalReference,
+		Category:          arg.Category,
+		Metadata:          metadataOrEmpty(arg.Metadata),
+		ChainVersion:      ChainVersion,
+	}
 	f.db.transactions[index] = tx
 	return tx, nil
 }
 
 func (f MemDBQuery) DeleteAccount(ctx context.Context, id int64) error {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	return nil
+}
+
+func (f MemDBQuery) ExportAccounts(ctx context.Context, arg ExportParams, fn func(Account) error) error {
+	f.db.mu.Lock()
+	var accs []Account
+	for _, a := range f.db.accounts {
+		if a.ID > arg.SinceID && (!arg.Since.Valid || !a.CreatedAt.Time.Before(arg.Since.Time)) {
+			accs = append(accs, a)
+		}
+	}
+	f.db.mu.Unlock()
+	sort.Slice(accs, func(i, j int) bool { return accs[i].ID < accs[j].ID })
+	for _, a := range accs {
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f MemDBQuery) ExportTransactions(ctx context.Context, arg ExportParams, fn func(Transaction) error) error {
+	f.db.mu.Lock()
+	var txs []Transaction
+	for _, tx := range f.db.transactions {
+		if tx.ID > arg.SinceID && (!arg.Since.Valid || !tx.CreatedAt.Time.Before(arg.Since.Time)) {
+			txs = append(txs, tx)
+		}
+	}
+	f.db.mu.Unlock()
+	sort.Slice(txs, func(i, j int) bool { return txs[i].ID < txs[j].ID })
+	for _, tx := range txs {
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (f MemDBQuery) GetAccountBalanceAsOf(ctx context.Context, arg GetAccountBalanceAsOfParams) (GetAccountBalanceAsOfRow, error) {
+	rows, err := f.GetAccountBalancesAsOf(ctx, GetAccountBalancesAsOfParams{AsOf: arg.AsOf, AsOfDay: arg.AsOfDay, Ids: []int64{arg.ID}})
+	if err != nil {
+		return GetAccountBalanceAsOfRow{}, err
+	}
+	if len(rows) == 0 {
+		return GetAccountBalanceAsOfRow{}, ErrNotFound
+	}
+	return GetAccountBalanceAsOfRow(rows[0]), nil
+}
+
+func (f MemDBQuery) GetAccountBalancesAsOf(ctx context.Context, arg GetAccountBalancesAsOfParams) ([]GetAccountBalancesAsOfRow, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	asOfDay := arg.AsOfDay.UTC().Truncate(24 * time.Hour)
+	var rows []GetAccountBalancesAsOfRow
+	for _, id := range arg.Ids {
+		if _, ok := f.db.accounts[id]; !ok {
+			continue
+		}
+		// Start from the latest checkpoint of a day ending at or before as_of
+		var cp BalanceCheckpoint
+		from := time.Time{}
+		for _, c := range f.db.checkpoints {
+			if c.AccountID == id && c.Day.Before(asOfDay) && !c.Day.Before(cp.Day) {
+				cp, from = c, c.Day.AddDate(0, 0, 1)
+			}
+		}
+		balance := cp.Balance + f.db.netAmount(id, from, func(t time.Time) bool { return !t.After(arg.AsOf) })
+		rows = append(rows, GetAccountBalancesAsOfRow{AccountID: id, Balance: balance})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].AccountID < rows[j].AccountID })
+	return rows, nil
+}
+
+func (f MemDBQuery) GetExternalTransfer(ctx context.Context, id int64) (ExternalTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	if id < 1 || id > int64(len(f.db.external)) {
+		return ExternalTransfer{}, ErrNotFound
+	}
+	return f.db.external[id-1], nil
+}
+
+func (f MemDBQuery) GetExternalTransferByReference(ctx context.Context, arg GetExternalTransferByReferenceParams) (ExternalTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	for _, et := range f.db.external {
+		if et.Kind == arg.Kind && et.ExternalReference == arg.ExternalReference {
+			return et, nil
+		}
+	}
+	return ExternalTransfer{}, ErrNotFound
+}
+
+// GetExternalTransferForUpdate is equivalent to GetExternalTransfer since
+// MemDB transactions are serialized.
+func (f MemDBQuery) GetExternalTransferForUpdate(ctx context.Context, id int64) (ExternalTransfer, error) {
+	return f.GetExternalTransfer(ctx, id)
+}
+
+func (f MemDBQuery) GetFirstTransactionTime(ctx context.Context) (sql.NullTime, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	tx, ok := f.db.transactions[1]
+	if !ok {
+		return sql.NullTime{}, sql.ErrNoRows
+	}
+	return tx.CreatedAt, nil
+}
+
+func (f MemDBQuery) GetLatestBalanceCheckpointDay(ctx context.Context) (time.Time, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	if len(f.db.checkpoints) == 0 {
+		return time.Time{}, sql.ErrNoRows
+	}
+	var day time.Time
+	for _, c := range f.db.checkpoints {
+		if c.Day.After(day) {
+			day = c.Day
+		}
+	}
+	return day, nil
+}
+
+func (f MemDBQuery) GetLedgerChain(ctx context.Context, id int64) (LedgerChain, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	c, ok := f.db.chains[id]
+	if !ok {
+		return LedgerChain{}, ErrNotFound
+	}
+	return c, nil
+}
+
+// GetLedgerChainForUpdate is equivalent to GetLedgerChain since MemDB
+// transactions are serialized.
+func (f MemDBQuery) GetLedgerChainForUpdate(ctx context.Context, id int64) (LedgerChain, error) {
+	return f.GetLedgerChain(ctx, id)
+}
+
+// GetOldestTransactionStart returns the current time since MemDB changes are
+// visible as soon as they are made.
+func (f MemDBQuery) GetOldestTransactionStart(ctx context.Context) (time.Time, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	return f.db.now(), nil
+}
+
+func (f MemDBQuery) GetTransactionTime(ctx context.Context) (time.Time, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	return f.db.now(), nil
+}
+
 func (f MemDBQuery) GetTx(ctx context.Context, id int64) (Transaction, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
 	tx, ok := f.db.transactions[id]
 	if !ok {
 		return Transaction{}, fmt.Errorf("not found")
@@ -99,6 +464,8 @@ func (f MemDBQuery) GetTx(ctx context.Context, id int64) (Transaction, error) {
 }
 
 func (f MemDBQuery) GetUser(ctx context.Context, id int64) (Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
 	a, ok := f.db.accounts[id]
 	if !ok {
 		return Account{}, fmt.Errorf("not found")
@@ -107,6 +474,8 @@ func (f MemDBQuery) GetUser(ctx context.Context, id int64) (Account, error) {
 }
 
 func (f MemDBQuery) GetUserByEmail(ctx context.Context, email sql.NullString) (Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
 	var a Account
 	for i := range f.db.accounts {
 		if f.db.accounts[i].Email.String == email.String {
@@ -117,6 +486,8 @@ func (f MemDBQuery) GetUserByEmail(ctx context.Context, email sql.NullString) (A
 }
 
 func (f MemDBQuery) GetUserByUsername(ctx context.Context, username string) (Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
 	var a Account
 	for i := range f.db.accounts {
 		if f.db.accounts[i].Username == username {
@@ -127,6 +498,8 @@ func (f MemDBQuery) GetUserByUsername(ctx context.Context, username string) (Acc
 }
 
 func (f MemDBQuery) GetUsers(ctx context.Context) ([]Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
 	var a []Account
 	for i := range f.db.accounts {
 
@@ -138,6 +511,8 @@ func (f MemDBQuery) GetUsers(ctx context.Context) ([]Account, error) {
 }
 
 func (f MemDBQuery) GetUserTransactions(ctx context.Context) ([]GetUserTransactionsRow, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
 	var txs []GetUserTransactionsRow
 	for i := range f.db.transactions {
 		tx := f.db.transactions[i]
@@ -147,10 +522,700 @@ func (f MemDBQuery) GetUserTransactions(ctx context.Context) ([]GetUserTransacti
 	return txs, nil
 }
 
+func (f MemDBQuery) AddAccountBalance(ctx context.Context, arg AddAccountBalanceParams) (Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	a, ok := f.db.accounts[arg.ID]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	a.Balance += arg.Amount
+	f.db.accounts[arg.ID] = a
+	return a, nil
+}
+
+// GetUserForUpdate is equivalent to GetUser. Rows do not need to be locked
+// since MemDB transactions are serialized.
+func (f MemDBQuery) GetUserForUpdate(ctx context.Context, id int64) (Account, error) {
+	return f.GetUser(ctx, id)
+}
+
+func (f MemDBQuery) GetUsersByStatus(ctx context.Context, status string) ([]Account, error) {
+	all, err := f.GetUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var a []Account
+	for _, acc := range all {
+		if acc.Status == status {
+			a = append(a, acc)
+		}
+	}
+	return a, nil
+}
+
+func (f MemDBQuery) ListAccountTransactions(ctx context.Context, arg ListAccountTransactionsParams) ([]ListAccountTransactionsRow, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var rows []ListAccountTransactionsRow
+	for _, tx := range f.db.transactions {
+		t := tx.CreatedAt.Time
+		switch {
+		case tx.ID <= arg.AfterID,
+			tx.FromAccount.Int64 != arg.AccountID.Int64 && tx.ToAccount.Int64 != arg.AccountID.Int64,
+			t.Before(arg.FromTime), !t.Before(arg.ToTime):
+			continue
+		}
+		from, okFrom := f.db.accounts[tx.FromAccount.Int64]
+		to, okTo := f.db.accounts[tx.ToAccount.Int64]
+		if !okFrom || !okTo {
+			continue
+		}
+		rows = append(rows, ListAccountTransactionsRow{
+			TransactionID:        tx.ID,
+			FromAccountID:        tx.FromAccount,
+			FromUsername:         from.Username,
+			ToAccountID:          tx.ToAccount,
+			ToUsername:           to.Username,
+			Amount:               tx.Amount,
+			TransactionCreatedAt: tx.CreatedAt,
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].TransactionID < rows[j].TransactionID })
+	if len(rows) > int(arg.MaxResults) {
+		rows = rows[:arg.MaxResults]
+	}
+	return rows, nil
+}
+
+func (f MemDBQuery) ListAuditEntries(ctx context.Context, arg ListAuditEntriesParams) ([]AuditLog, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var entries []AuditLog
+	for _, e := range f.db.auditLog {
+		if len(entries) >= int(arg.MaxResults) {
+			break
+		}
+		switch {
+		case e.ID <= arg.AfterID,
+			arg.EntityType.Valid && e.EntityType != arg.EntityType.String,
+			arg.EntityID.Valid && e.EntityID != arg.EntityID.Int64,
+			arg.FromTime.Valid && e.CreatedAt.Before(arg.FromTime.Time),
+			arg.ToTime.Valid && !e.CreatedAt.Before(arg.ToTime.Time):
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (f MemDBQuery) ListTransactions(ctx context.Context, arg ListTransactionsParams) ([]Transaction, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var txs []Transaction
+	for _, tx := range f.db.transactions {
+		if tx.ID > arg.ID {
+			txs = append(txs, tx)
+		}
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].ID < txs[j].ID })
+	if len(txs) > int(arg.Limit) {
+		txs = txs[:arg.Limit]
+	}
+	return txs, nil
+}
+
+func (f MemDBQuery) SetTransactionHash(ctx context.Context, arg SetTransactionHashParams) (Transaction, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	tx, ok := f.db.transactions[arg.ID]
+	if !ok {
+		return Transaction{}, ErrNotFound
+	}
+	tx.PrevHash, tx.Hash = arg.PrevHash, arg.Hash
+	f.db.transactions[arg.ID] = tx
+	return tx, nil
+}
+
+func (f MemDBQuery) UpdateAccountOverdraftLimit(ctx context.Context, arg UpdateAccountOverdraftLimitParams) (Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	a, ok := f.db.accounts[arg.ID]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	a.OverdraftLimit = arg.OverdraftLimit
+	f.db.accounts[arg.ID] = a
+	return a, nil
+}
+
+func (f MemDBQuery) UpdateAccountStatus(ctx context.Context, arg UpdateAccountStatusParams) (Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	a, ok := f.db.accounts[arg.ID]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	a.Status = arg.Status
+	a.StatusReason = arg.StatusReason
+	a.StatusUpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	f.db.accounts[arg.ID] = a
+	return a, nil
+}
+
+func (f MemDBQuery) UpdateLedgerChain(ctx context.Context, arg UpdateLedgerChainParams) (LedgerChain, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	if _, ok := f.db.chains[arg.ID]; !ok {
+		return LedgerChain{}, ErrNotFound
+	}
+	c := LedgerChain{ID: arg.ID, LastTxID: arg.LastTxID, Hash: arg.Hash, UpdatedAt: time.Now()}
+	f.db.chains[arg.ID] = c
+	return c, nil
+}
+
+func (f MemDBQuery) AdvanceScheduledTransfer(ctx context.Context, arg AdvanceScheduledTransferParams) (ScheduledTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	st, ok := f.db.scheduled[arg.ID]
+	if !ok {
+		return ScheduledTransfer{}, ErrNotFound
+	}
+	st.NextRunAt, st.LastRunAt, st.Status, st.UpdatedAt = arg.NextRunAt, arg.LastRunAt, arg.Status, f.db.now()
+	f.db.scheduled[arg.ID] = st
+	return st, nil
+}
+
+func (f MemDBQuery) ClaimDueScheduledTransfers(ctx context.Context, arg ClaimDueScheduledTransfersParams) ([]ScheduledTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var due []ScheduledTransfer
+	for _, st := range f.db.scheduled {
+		if st.Status == ScheduledTransferActive && st.NextRunAt.Valid && !st.NextRunAt.Time.After(arg.Now) {
+			due = append(due, st)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if ti, tj := due[i].NextRunAt.Time, due[j].NextRunAt.Time; !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > int(arg.MaxResults) {
+		due = due[:arg.MaxResults]
+	}
+	return due, nil
+}
+
+func (f MemDBQuery) CreateExternalTransfer(ctx context.Context, arg CreateExternalTransferParams) (ExternalTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	for _, et := range f.db.external {
+		if et.Kind == arg.Kind && et.ExternalReference == arg.ExternalReference {
+			return ExternalTransfer{}, fmt.Errorf("duplicate %v external reference %q", arg.Kind, arg.ExternalReference)
+		}
+	}
+	now := f.db.now()
+	et := ExternalTransfer{
+		ID:                int64(len(f.db.external) + 1),
+		Kind:              arg.Kind,
+		AccountID:         arg.AccountID,
+		SystemAccountID:   arg.SystemAccountID,
+		Amount:            arg.Amount,
+		ExternalReference: arg.ExternalReference,
+		Status:            arg.Status,
+		TransactionID:     arg.TransactionID,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	f.db.external = append(f.db.external, et)
+	return et, nil
+}
+
+func (f MemDBQuery) CreateScheduledTransfer(ctx context.Context, arg CreateScheduledTransferParams) (ScheduledTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	now := f.db.now()
+	st := ScheduledTransfer{
+		ID:              int64(len(f.db.scheduled) + 1),
+		FromAccount:     arg.FromAccount,
+		ToAccount:       arg.ToAccount,
+		Amount:          arg.Amount,
+		CronExpr:        arg.CronExpr,
+		IntervalSeconds: arg.IntervalSeconds,
+		StartAt:         arg.StartAt,
+		EndAt:           arg.EndAt,
+		NextRunAt:       arg.NextRunAt,
+		Status:          ScheduledTransferActive,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	f.db.scheduled[st.ID] = st
+	return st, nil
+}
+
+func (f MemDBQuery) CreateScheduledTransferRun(ctx context.Context, arg CreateScheduledTransferRunParams) (ScheduledTransferRun, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	for _, r := range f.db.scheduledRuns {
+		if r.ScheduledTransferID == arg.ScheduledTransferID && r.ScheduledFor.Equal(arg.ScheduledFor) {
+			return ScheduledTransferRun{}, fmt.Errorf("duplicate run of scheduled transfer %d at %v", arg.ScheduledTransferID, arg.ScheduledFor)
+		}
+	}
+	r := ScheduledTransferRun{
+		ID:                  int64(len(f.db.scheduledRuns) + 1),
+		ScheduledTransferID: arg.ScheduledTransferID,
+		ScheduledFor:        arg.ScheduledFor,
+		TransactionID:       arg.TransactionID,
+		Status:              arg.Status,
+		Error:               arg.Error,
+		CreatedAt:           f.db.now(),
+		FeeTransactionID:    arg.FeeTransactionID,
+		TotalFee:            arg.TotalFee,
+		Fees:                arg.Fees,
+	}
+	if len(r.Fees) == 0 {
+		r.Fees = json.RawMessage("[]")
+	}
+	f.db.scheduledRuns = append(f.db.scheduledRuns, r)
+	return r, nil
+}
+
+func (f MemDBQuery) GetScheduledTransfer(ctx context.Context, id int64) (ScheduledTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	st, ok := f.db.scheduled[id]
+	if !ok {
+		return ScheduledTransfer{}, ErrNotFound
+	}
+	return st, nil
+}
+
+// GetScheduledTransferForUpdate is equivalent to GetScheduledTransfer since
+// MemDB transactions are serialized.
+func (f MemDBQuery) GetScheduledTransferForUpdate(ctx context.Context, id int64) (ScheduledTransfer, error) {
+	return f.GetScheduledTransfer(ctx, id)
+}
+
+func (f MemDBQuery) ListExternalTransfers(ctx context.Context, arg ListExternalTransfersParams) ([]ExternalTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var ets []ExternalTransfer
+	for _, et := range f.db.external {
+		if len(ets) >= int(arg.MaxResults) {
+			break
+		}
+		switch {
+		case et.Kind != arg.Kind, et.ID <= arg.AfterID,
+			arg.AccountID.Valid && et.AccountID != arg.AccountID.Int64,
+			arg.Status.Valid && et.Status != arg.Status.String:
+			continue
+		}
+		ets = append(ets, et)
+	}
+	return ets, nil
+}
+
+func (f MemDBQuery) ListScheduledTransferRuns(ctx context.Context, arg ListScheduledTransferRunsParams) ([]ScheduledTransferRun, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var runs []ScheduledTransferRun
+	for _, r := range f.db.scheduledRuns {
+		if len(runs) >= int(arg.MaxResults) {
+			break
+		}
+		if r.ScheduledTransferID == arg.ScheduledTransferID && r.ID > arg.AfterID {
+			runs = append(runs, r)
+		}
+	}
+	return runs, nil
+}
+
+func (f MemDBQuery) ListScheduledTransfers(ctx context.Context, arg ListScheduledTransfersParams) ([]ScheduledTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var sts []ScheduledTransfer
+	for _, st := range f.db.scheduled {
+		switch {
+		case arg.AccountID.Valid && st.FromAccount != arg.AccountID.Int64 && st.ToAccount != arg.AccountID.Int64,
+			arg.Status.Valid && st.Status != arg.Status.String:
+			continue
+		}
+		sts = append(sts, st)
+	}
+	sort.Slice(sts, func(i, j int) bool { return sts[i].ID < sts[j].ID })
+	return sts, nil
+}
+
+func (f MemDBQuery) UpdateExternalTransferStatus(ctx context.Context, arg UpdateExternalTransferStatusParams) (ExternalTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	if arg.ID < 1 || arg.ID > int64(len(f.db.external)) {
+		return ExternalTransfer{}, ErrNotFound
+	}
+	et := &f.db.external[arg.ID-1]
+	et.Status, et.FailureReason, et.TransactionID, et.ReversalTransactionID = arg.Status, arg.FailureReason, arg.TransactionID, arg.ReversalTransactionID
+	et.UpdatedAt = f.db.now()
+	return *et, nil
+}
+
+func (f MemDBQuery) UpdateScheduledTransferStatus(ctx context.Context, arg UpdateScheduledTransferStatusParams) (ScheduledTransfer, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	st, ok := f.db.scheduled[arg.ID]
+	if !ok {
+		return ScheduledTransfer{}, ErrNotFound
+	}
+	st.Status, st.NextRunAt, st.UpdatedAt = arg.Status, arg.NextRunAt, f.db.now()
+	f.db.scheduled[arg.ID] = st
+	return st, nil
+}
+
+func (f MemDBQuery) CreateFeeRule(ctx context.Context, arg CreateFeeRuleParams) (FeeRule, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	now := f.db.now()
+	fr := FeeRule{
+		ID:          int64(len(f.db.feeRules) + 1),
+		Name:        arg.Name,
+		AccountType: arg.AccountType,
+		FromAccount: arg.FromAccount,
+		ToAccount:   arg.ToAccount,
+		Kind:        arg.Kind,
+		FlatFee:     arg.FlatFee,
+		BasisPoints: arg.BasisPoints,
+		MinFee:      arg.MinFee,
+		MaxFee:      arg.MaxFee,
+		Tiers:       arg.Tiers,
+		Status:      FeeRuleActive,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	f.db.feeRules = append(f.db.feeRules, fr)
+	return fr, nil
+}
+
+func (f MemDBQuery) CreateTransactionFee(ctx context.Context, arg CreateTransactionFeeParams) (TransactionFee, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	fee := TransactionFee{
+		TransactionID:    arg.TransactionID,
+		FeeRuleID:        arg.FeeRuleID,
+		FeeTransactionID: arg.FeeTransactionID,
+		Amount:           arg.Amount,
+		CreatedAt:        f.db.now(),
+	}
+	f.db.txFees = append(f.db.txFees, fee)
+	return fee, nil
+}
+
+func (f MemDBQuery) GetFeeRule(ctx context.Context, id int64) (FeeRule, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	if id < 1 || id > int64(len(f.db.feeRules)) {
+		return FeeRule{}, ErrNotFound
+	}
+	return f.db.feeRules[id-1], nil
+}
+
+func (f MemDBQuery) ListFeeRules(ctx context.Context, arg ListFeeRulesParams) ([]FeeRule, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var frs []FeeRule
+	for _, fr := range f.db.feeRules {
+		if len(frs) >= int(arg.MaxResults) {
+			break
+		}
+		if fr.ID > arg.AfterID && (!arg.Status.Valid || fr.Status == arg.Status.String) {
+			frs = append(frs, fr)
+		}
+	}
+	return frs, nil
+}
+
+func (f MemDBQuery) ListMatchingFeeRules(ctx context.Context, arg ListMatchingFeeRulesParams) ([]FeeRule, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var frs []FeeRule
+	for _, fr := range f.db.feeRules {
+		switch {
+		case fr.Status != FeeRuleActive,
+			fr.AccountType.Valid && fr.AccountType.String != arg.AccountType,
+			fr.FromAccount.Valid && fr.FromAccount.Int64 != arg.FromAccount,
+			fr.ToAccount.Valid && fr.ToAccount.Int64 != arg.ToAccount:
+			continue
+		}
+		frs = append(frs, fr)
+	}
+	return frs, nil
+}
+
+func (f MemDBQuery) UpdateFeeRuleStatus(ctx context.Context, arg UpdateFeeRuleStatusParams) (FeeRule, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	if arg.ID < 1 || arg.ID > int64(len(f.db.feeRules)) {
+		return FeeRule{}, ErrNotFound
+	}
+	fr := &f.db.feeRules[arg.ID-1]
+	fr.Status, fr.UpdatedAt = arg.Status, f.db.now()
+	return *fr, nil
+}
+
+func (f MemDBQuery) UpsertInterestRate(ctx context.Context, arg UpsertInterestRateParams) (InterestRate, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	now := f.db.now()
+	r, ok := f.db.interestRates[arg.AccountID]
+	if !ok {
+		r = InterestRate{AccountID: arg.AccountID, CreatedAt: now}
+	}
+	r.AnnualRateBps, r.UpdatedAt = arg.AnnualRateBps, now
+	f.db.interestRates[arg.AccountID] = r
+	return r, nil
+}
+
+func (f MemDBQuery) DeleteInterestRate(ctx context.Context, accountID int64) error {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	delete(f.db.interestRates, accountID)
+	return nil
+}
+
+func (f MemDBQuery) GetInterestRate(ctx context.Context, accountID int64) (InterestRate, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	r, ok := f.db.interestRates[accountID]
+	if !ok {
+		return InterestRate{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (f MemDBQuery) GetInterestRateForUpdate(ctx context.Context, accountID int64) (InterestRate, error) {
+	return f.GetInterestRate(ctx, accountID)
+}
+
+func (f MemDBQuery) ListInterestRates(ctx context.Context) ([]InterestRate, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var rs []InterestRate
+	for _, r := range f.db.interestRates {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].AccountID < rs[j].AccountID })
+	return rs, nil
+}
+
+func (f MemDBQuery) CreateInterestAccrual(ctx context.Context, arg CreateInterestAccrualParams) (InterestAccrual, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	for _, a := range f.db.accruals {
+		if a.AccountID == arg.AccountID && a.Day.Equal(arg.Day) {
+			return InterestAccrual{}, fmt.Errorf("duplicate interest accrual for account %d on %v", arg.AccountID, arg.Day.Format(time.DateOnly))
+		}
+	}
+	a := InterestAccrual{
+		AccountID:     arg.AccountID,
+		Day:           arg.Day,
+		Balance:       arg.Balance,
+		AnnualRateBps: arg.AnnualRateBps,
+		Amount:        arg.Amount,
+		Carry:         arg.Carry,
+		CreatedAt:     f.db.now(),
+	}
+	f.db.accruals = append(f.db.accruals, a)
+	return a, nil
+}
+
+func (f MemDBQuery) GetLatestInterestAccrual(ctx context.Context, accountID int64) (InterestAccrual, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var latest *InterestAccrual
+	for i, a := range f.db.accruals {
+		if a.AccountID == accountID && (latest == nil || a.Day.After(latest.Day)) {
+			latest = &f.db.accruals[i]
+		}
+	}
+	if latest == nil {
+		return InterestAccrual{}, ErrNotFound
+	}
+	return *latest, nil
+}
+
+func (f MemDBQuery) SumUnpostedInterest(ctx context.Context, arg SumUnpostedInterestParams) (SumUnpostedInterestRow, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var row SumUnpostedInterestRow
+	for _, a := range f.db.accruals {
+		if a.AccountID != arg.AccountID || a.PostedAt.Valid || (arg.Before.Valid && !a.Day.Before(arg.Before.Time)) {
+			continue
+		}
+		row.Amount += a.Amount
+		row.Accruals++
+		if !row.FromDay.Valid || a.Day.Before(row.FromDay.Time) {
+			row.FromDay = sql.NullTime{Time: a.Day, Valid: true}
+		}
+		if !row.ToDay.Valid || a.Day.After(row.ToDay.Time) {
+			row.ToDay = sql.NullTime{Time: a.Day, Valid: true}
+		}
+	}
+	return row, nil
+}
+
+func (f MemDBQuery) MarkInterestAccrualsPosted(ctx context.Context, arg MarkInterestAccrualsPostedParams) (int64, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var rows int64
+	for i, a := range f.db.accruals {
+		if a.AccountID != arg.AccountID || a.PostedAt.Valid || !a.Day.Before(arg.Before) {
+			continue
+		}
+		f.db.accruals[i].PostedAt = sql.NullTime{Time: f.db.now(), Valid: true}
+		f.db.accruals[i].TransactionID = arg.TransactionID
+		rows++
+	}
+	return rows, nil
+}
+
 func (f MemDBQuery) WithTx(tx DBTX) DBQuery {
 	return f
 }
 
+func (m *MemDB) checkpoint(accountID int64, day time.Time) (BalanceCheckpoint, bool) {
+	for _, c := range m.checkpoints {
+		if c.AccountID == accountID && c.Day.Equal(day) {
+			return c, true
+		}
+	}
+	return BalanceCheckpoint{}, false
+}
+
+// netAmount returns the amount received less the amount sent by the account in
+// transactions created at or after from and for which until returns true.
+func (m *MemDB) netAmount(accountID int64, from time.Time, until func(time.Time) bool) int64 {
+	var net int64
+	for _, tx := range m.transactions {
+		t := tx.CreatedAt.Time
+		if t.Before(from) || !until(t) {
+			continue
+		}
+		if tx.ToAccount.Int64 == accountID {
+			net += tx.Amount.Int64
+		}
+		if tx.FromAccount.Int64 == accountID {
+			net -= tx.Amount.Int64
+		}
+	}
+	return net
+}
+
+func (f MemDBQuery) GetTxByExternalReference(ctx context.Context, arg GetTxByExternalReferenceParams) (Transaction, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	for _, tx := range f.db.transactions {
+		if arg.FromAccount.Valid && tx.FromAccount == arg.FromAccount && tx.ExternalReference.Valid && tx.ExternalReference == arg.ExternalReference {
+			return tx, nil
+		}
+	}
+	return Transaction{}, ErrNotFound
+}
+
+func (f MemDBQuery) GetUserByExternalReference(ctx context.Context, externalReference sql.NullString) (Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	for _, a := range f.db.accounts {
+		if externalReference.Valid && a.ExternalReference == externalReference {
+			return a, nil
+		}
+	}
+	return Account{}, ErrNotFound
+}
+
+func (f MemDBQuery) SearchAccounts(ctx context.Context, arg SearchAccountsParams) ([]Account, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var accs []Account
+	for _, a := range f.db.accounts {
+		if arg.Status.Valid && a.Status != arg.Status.String {
+			continue
+		}
+		if arg.Category.Valid && a.Category != arg.Category {
+			continue
+		}
+		if arg.ExternalReference.Valid && a.ExternalReference != arg.ExternalReference {
+			continue
+		}
+		if !metadataContains(a.Metadata, arg.Metadata) {
+			continue
+		}
+		accs = append(accs, a)
+	}
+	sort.Slice(accs, func(i, j int) bool { return accs[i].Username < accs[j].Username })
+	return accs, nil
+}
+
+func (f MemDBQuery) SearchTransactions(ctx context.Context, arg SearchTransactionsParams) ([]Transaction, error) {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	var txs []Transaction
+	for _, tx := range f.db.transactions {
+		if tx.ID <= arg.AfterID {
+			continue
+		}
+		if arg.AccountID.Valid && tx.FromAccount != arg.AccountID && tx.ToAccount != arg.AccountID {
+			continue
+		}
+		if arg.Category.Valid && tx.Category != arg.Category {
+			continue
+		}
+		if arg.ExternalReference.Valid && tx.ExternalReference != arg.ExternalReference {
+			continue
+		}
+		if !metadataContains(tx.Metadata, arg.Metadata) {
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].ID < txs[j].ID })
+	if len(txs) > int(arg.MaxResults) {
+		txs = txs[:arg.MaxResults]
+	}
+	return txs, nil
+}
+
+// emptyMetadata is the column default for metadata.
+func emptyMetadata() json.RawMessage {
+	return json.RawMessage("{}")
+}
+
+func metadataOrEmpty(m json.RawMessage) json.RawMessage {
+	if len(m) == 0 {
+		return emptyMetadata()
+	}
+	return slices.Clone(m)
+}
+
+// metadataContains mirrors the top-level behaviour of the jsonb @> operator.
+// A nil filter matches everything.
+func metadataContains(m, filter json.RawMessage) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	var have, want map[string]any
+	if err := json.Unmarshal(m, &have); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(filter, &want); err != nil {
+		return false
+	}
+	for k, v := range want {
+		if got, ok := have[k]; !ok || !reflect.DeepEqual(got, v) {
+			return false
+		}
+	}
+	return true
+}
+
 type FakeDBTx struct {
 	db *MemDB
 }
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
+)
+
+// DefaultMigrationsTable is the table used to record the applied schema version.
+const DefaultMigrationsTable = "schema_migrations"
+
+// MigrationStatus describes the schema version of a database relative to the
+// available migrations.
+type MigrationStatus struct {
+	Version  uint   `json:"version" yaml:"version"`
+	Dirty    bool   `json:"dirty" yaml:"dirty"`
+	Latest   uint   `json:"latest" yaml:"latest"`
+	Pending  []uint `json:"pending" yaml:"pending"`
+	Applied  bool   `json:"applied" yaml:"applied"`
+	Database string `json:"database" yaml:"database"`
+}
+
+// Migrator applies and inspects schema migrations on a postgres database.
+type Migrator struct {
+	dbName     string
+	migrations fs.FS
+	m          *migrate.Migrate
+}
+
+// NewMigrator returns a Migrator for the connected postgres instance using the
+// migrations contained in the supplied file system. The applied version is
+// recorded in migrationsTable.
+func (p *PSQLClient) NewMigrator(migrations fs.FS, migrationsTable string) (*Migrator, error) {
+	if migrationsTable == "" {
+		migrationsTable = DefaultMigrationsTable
+	}
+	driver, err := postgres.WithInstance(p.db, &postgres.Config{DatabaseName: p.dbName, MigrationsTable: migrationsTable})
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := iofs.New(migrations, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{dbName: p.dbName, migrations: migrations, m: m}, nil
+}
+
+// RenameMigrationsTable renames the migrations table from one name to another if
+// the former exists and the latter does not. It reports whether the table was renamed.
+func (p *PSQLClient) RenameMigrationsTable(ctx context.Context, from, to string) (bool, error) {
+	var fromExists, toExists bool
+	const q = "SELECT to_regclass($1) IS NOT NULL"
+	if err := p.db.QueryRowContext(ctx, q, pq.QuoteIdentifier(from)).Scan(&fromExists); err != nil {
+		return false, err
+	}
+	if err := p.db.QueryRowContext(ctx, q, pq.QuoteIdentifier(to)).Scan(&toExists); err != nil {
+		return false, err
+	}
+	if !fromExists || toExists {
+		return false, nil
+	}
+	if _, err := p.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", pq.QuoteIdentifier(from), pq.QuoteIdentifier(to))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up() error {
+	return ignoreNoChange(m.m.Up())
+}
+
+// Down reverts the last n applied migrations.
+func (m *Migrator) Down(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("number of migrations to revert must be positive, got %d", n)
+	}
+	return ignoreNoChange(m.m.Steps(-n))
+}
+
+// DownAll reverts all applied migrations.
+func (m *Migrator) DownAll() error {
+	return ignoreNoChange(m.m.Down())
+}
+
+// To migrates up or down to the supplied schema version.
+func (m *Migrator) To(version uint) error {
+	return ignoreNoChange(m.m.Migrate(version))
+}
+
+// Force sets the schema version without running any migrations and clears
+// the dirty flag. It is used to recover from a failed migration.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+// Status returns the current schema version and any pending migrations.
+func (m *Migrator) Status() (MigrationStatus, error) {
+	st := MigrationStatus{Database: m.dbName, Pending: []uint{}}
+	v, dirty, err := m.m.Version()
+	switch {
+	case err == nil:
+		st.Version, st.Dirty, st.Applied = v, dirty, true
+	case errors.Is(err, migrate.ErrNilVersion):
+	default:
+		return st, err
+	}
+
+	versions, err := MigrationVersions(m.migrations)
+	if err != nil {
+		return st, err
+	}
+	for _, ver := range versions {
+		if !st.Applied || ver > st.Version {
+			st.Pending = append(st.Pending, ver)
+		}
+		st.Latest = ver
+	}
+	return st, nil
+}
+
+// Close releases the migration source and closes the underlying database connection.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+// MigrationVersions lists the migration versions contained in the supplied file
+// system in ascending order.
+func MigrationVersions(migrations fs.FS) ([]uint, error) {
+	src, err := iofs.New(migrations, ".")
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return sourceVersions(src)
+}
+
+// LatestMigrationVersion returns the highest migration version contained in the
+// supplied file system.
+func LatestMigrationVersion(migrations fs.FS) (uint, error) {
+	versions, err := MigrationVersions(migrations)
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, fmt.Errorf("no migrations found")
+	}
+	return versions[len(versions)-1], nil
+}
+
+func sourceVersions(src source.Driver) ([]uint, error) {
+	v, err := src.First()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	versions := []uint{v}
+	for {
+		v, err = src.Next(v)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return versions, nil
+			}
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
@@ -6,20 +6,152 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
 )
 
 type Account struct {
-	ID        int64          `json:"id"`
-	Username  string         `json:"username"`
-	Balance   int64          `json:"balance"`
-	Email     sql.NullString `json:"email"`
-	CreatedAt sql.NullTime   `json:"created_at"`
+	ID                int64           `json:"id"`
+	Username          string          `json:"username"`
+	Balance           int64           `json:"balance"`
+	Email             sql.NullString  `json:"email"`
+	CreatedAt         sql.NullTime    `json:"created_at"`
+	Status            string          `json:"status"`
+	StatusReason      sql.NullString  `json:"status_reason"`
+	StatusUpdatedAt   sql.NullTime    `json:"status_updated_at"`
+	AccountType       string          `json:"account_type"`
+	OverdraftLimit    int64           `json:"overdraft_limit"`
+	Description       sql.NullString  `json:"description"`
+	ExternalReference sql.NullString  `json:"external_reference"`
+	Category          sql.NullString  `json:"category"`
+	Metadata          json.RawMessage `json:"metadata"`
+}
+
+type AuditLog struct {
+	ID         int64           `json:"id"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int64           `json:"entity_id"`
+	Action     string          `json:"action"`
+	Actor      string          `json:"actor"`
+	RequestID  string          `json:"request_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type BalanceCheckpoint struct {
+	AccountID int64     `json:"account_id"`
+	Day       time.Time `json:"day"`
+	Balance   int64     `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExternalTransfer struct {
+	ID                    int64          `json:"id"`
+	Kind                  string         `json:"kind"`
+	AccountID             int64          `json:"account_id"`
+	SystemAccountID       int64          `json:"system_account_id"`
+	Amount                int64          `json:"amount"`
+	ExternalReference     string         `json:"external_reference"`
+	Status                string         `json:"status"`
+	FailureReason         sql.NullString `json:"failure_reason"`
+	TransactionID         sql.NullInt64  `json:"transaction_id"`
+	ReversalTransactionID sql.NullInt64  `json:"reversal_transaction_id"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+}
+
+type FeeRule struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	AccountType sql.NullString  `json:"account_type"`
+	FromAccount sql.NullInt64   `json:"from_account"`
+	ToAccount   sql.NullInt64   `json:"to_account"`
+	Kind        string          `json:"kind"`
+	FlatFee     int64           `json:"flat_fee"`
+	BasisPoints int64           `json:"basis_points"`
+	MinFee      sql.NullInt64   `json:"min_fee"`
+	MaxFee      sql.NullInt64   `json:"max_fee"`
+	Tiers       json.RawMessage `json:"tiers"`
+	Status      string          `json:"status"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+type InterestAccrual struct {
+	AccountID     int64         `json:"account_id"`
+	Day           time.Time     `json:"day"`
+	Balance       int64         `json:"balance"`
+	AnnualRateBps int64         `json:"annual_rate_bps"`
+	Amount        int64         `json:"amount"`
+	Carry         int64         `json:"carry"`
+	PostedAt      sql.NullTime  `json:"posted_at"`
+	TransactionID sql.NullInt64 `json:"transaction_id"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+type InterestRate struct {
+	AccountID     int64     `json:"account_id"`
+	AnnualRateBps int64     `json:"annual_rate_bps"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type LedgerChain struct {
+	ID        int64     `json:"id"`
+	LastTxID  int64     `json:"last_tx_id"`
+	Hash      []byte    `json:"hash"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ScheduledTransfer struct {
+	ID              int64          `json:"id"`
+	FromAccount     int64          `json:"from_account"`
+	ToAccount       int64          `json:"to_account"`
+	Amount          int64          `json:"amount"`
+	CronExpr        sql.NullString `json:"cron_expr"`
+	IntervalSeconds sql.NullInt64  `json:"interval_seconds"`
+	StartAt         time.Time      `json:"start_at"`
+	EndAt           sql.NullTime   `json:"end_at"`
+	NextRunAt       sql.NullTime   `json:"next_run_at"`
+	LastRunAt       sql.NullTime   `json:"last_run_at"`
+	Status          string         `json:"status"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+type ScheduledTransferRun struct {
+	ID                  int64           `json:"id"`
+	ScheduledTransferID int64           `json:"scheduled_transfer_id"`
+	ScheduledFor        time.Time       `json:"scheduled_for"`
+	TransactionID       sql.NullInt64   `json:"transaction_id"`
+	Status              string          `json:"status"`
+	Error               sql.NullString  `json:"error"`
+	CreatedAt           time.Time       `json:"created_at"`
+	FeeTransactionID    sql.NullInt64   `json:"fee_transaction_id"`
+	TotalFee            int64           `json:"total_fee"`
+	Fees                json.RawMessage `json:"fees"`
 }
 
 type Transaction struct {
-	ID          int64         `json:"id"`
-	FromAccount sql.NullInt64 `json:"from_account"`
-	ToAccount   sql.NullInt64 `json:"to_account"`
-	Amount      sql.NullInt64 `json:"amount"`
-	CreatedAt   sql.NullTime  `json:"created_at"`
+	ID                int64           `json:"id"`
+	FromAccount       sql.NullInt64   `json:"from_account"`
+	ToAccount         sql.NullInt64   `json:"to_account"`
+	Amount            sql.NullInt64   `json:"amount"`
+	CreatedAt         sql.NullTime    `json:"created_at"`
+	PrevHash          []byte          `json:"prev_hash"`
+	Hash              []byte          `json:"hash"`
+	Description       sql.NullString  `json:"description"`
+	ExternalReference sql.NullString  `json:"external_reference"`
+	Category          sql.NullString  `json:"category"`
+	Metadata          json.RawMessage `json:"metadata"`
+	ChainVersion      int16           `json:"chain_version"`
+}
+
+type TransactionFee struct {
+	TransactionID    int64     `json:"transaction_id"`
+	FeeRuleID        int64     `json:"fee_rule_id"`
+	FeeTransactionID int64     `json:"fee_transaction_id"`
+	Amount           int64     `json:"amount"`
+	CreatedAt        time.Time `json:"created_at"`
 }
@@ -6,10 +6,9 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io/fs"
 
-	"github.com/golang-migrate/migrate"
-	"github.com/golang-migrate/migrate/database/postgres"
-	_ "github.com/golang-migrate/migrate/source/file"
+	"github.com/lib/pq"
 )
 
 var ErrNotFound = errors.New("not found")
@@ -28,7 +27,7 @@ func NewPSQLClient(dbName string, c driver.Connector) (*PSQLClient, error) {
 
 	// Check connection
 	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("db ping err: %v", err)
+		return nil, fmt.Errorf("db ping err: %w", err)
 	}
 
 	return &PSQLClient{
@@ -46,26 +45,41 @@ func (p *PSQLClient) CheckDatabaseExists(ctx context.Context, dbName string) (bo
 	return exists, nil
 }
 
-// InitializeSchema migrates up the connected postgres instance with the schema contained in the
-// supplied migration directory.
-func (p *PSQLClient) InitializeSchema(migrationDir string) error {
-
-	driver, err := postgres.WithInstance(p.db, &postgres.Config{DatabaseName: p.dbName, MigrationsTable: migrationDir})
-	if err != nil {
-		return err
+// CreateDatabase creates a new database owned by the supplied role. The client
+// must be connected to a different database, e.g. the 'postgres' maintenance DB.
+func (p *PSQLClient) CreateDatabase(ctx context.Context, dbName, owner string) error {
+	// Identifiers cannot be passed as query parameters
+	query := fmt.Sprintf("CREATE DATABASE %s", pq.QuoteIdentifier(dbName))
+	if owner != "" {
+		query += fmt.Sprintf(" OWNER %s", pq.QuoteIdentifier(owner))
 	}
+	_, err := p.db.ExecContext(ctx, query)
+	return err
+}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		fmt.Sprintf("file://%s", migrationDir),
-		"postgres", driver)
+// InitializeSchema migrates up the connected postgres instance with the schema contained in the
+// supplied migrations file system.
+func (p *PSQLClient) InitializeSchema(migrations fs.FS, migrationsTable string) error {
+	m, err := p.NewMigrator(migrations, migrationsTable)
 	if err != nil {
 		return err
 	}
+	return m.Up()
+}
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return err
+// SchemaVersion returns the schema version recorded in the migrations table. A
+// zero version is returned if no migrations have been applied.
+func (p *PSQLClient) SchemaVersion(ctx context.Context, migrationsTable string) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := p.db.QueryRowContext(ctx, fmt.Sprintf("SELECT version, dirty FROM %s LIMIT 1", pq.QuoteIdentifier(migrationsTable))).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, err
 	}
-	return nil
+	return uint(version), dirty, nil
 }
 
 // DB returns the underlying DB interface with Open and Close commands.
@@ -98,3 +112,19 @@ func (p *PSQLClient) NewTransaction() (DBTX, error) {
 	}
 	return sqlTx, nil
 }
+
+// ExecTx runs fn in a DB transaction which is committed if fn returns nil and
+// rolled back otherwise.
+func (p *PSQLClient) ExecTx(ctx context.Context, fn func(DBQuery) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(New(tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback failed: %w", rbErr))
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -8,25 +8,167 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+const addAccountBalance = `-- name: AddAccountBalance :one
+UPDATE accounts
+SET balance = balance + $1
+WHERE id = $2
+RETURNING id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata
+`
+
+type AddAccountBalanceParams struct {
+	Amount int64 `json:"amount"`
+	ID     int64 `json:"id"`
+}
+
+func (q *Queries) AddAccountBalance(ctx context.Context, arg AddAccountBalanceParams) (Account, error) {
+	row := q.db.QueryRowContext(ctx, addAccountBalance, arg.Amount, arg.ID)
+	var i Account
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+	)
+	return i, err
+}
+
+const advanceScheduledTransfer = `-- name: AdvanceScheduledTransfer :one
+UPDATE scheduled_transfers
+SET next_run_at = $2, last_run_at = $3, status = $4, updated_at = now()
+WHERE id = $1
+RETURNING id, from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at, last_run_at, status, created_at, updated_at
+`
+
+type AdvanceScheduledTransferParams struct {
+	ID        int64        `json:"id"`
+	NextRunAt sql.NullTime `json:"next_run_at"`
+	LastRunAt sql.NullTime `json:"last_run_at"`
+	Status    string       `json:"status"`
+}
+
+func (q *Queries) AdvanceScheduledTransfer(ctx context.Context, arg AdvanceScheduledTransferParams) (ScheduledTransfer, error) {
+	row := q.db.QueryRowContext(ctx, advanceScheduledTransfer,
+		arg.ID,
+		arg.NextRunAt,
+		arg.LastRunAt,
+		arg.Status,
+	)
+	var i ScheduledTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Amount,
+		&i.CronExpr,
+		&i.IntervalSeconds,
+		&i.StartAt,
+		&i.EndAt,
+		&i.NextRunAt,
+		&i.LastRunAt,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const claimDueScheduledTransfers = `-- name: ClaimDueScheduledTransfers :many
+SELECT id, from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at, last_run_at, status, created_at, updated_at FROM scheduled_transfers
+WHERE status = 'active' AND next_run_at <= $1::timestamptz
+ORDER BY next_run_at, id
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`
+
+type ClaimDueScheduledTransfersParams struct {
+	Now        time.Time `json:"now"`
+	MaxResults int32     `json:"max_results"`
+}
+
+func (q *Queries) ClaimDueScheduledTransfers(ctx context.Context, arg ClaimDueScheduledTransfersParams) ([]ScheduledTransfer, error) {
+	rows, err := q.db.QueryContext(ctx, claimDueScheduledTransfers, arg.Now, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ScheduledTransfer
+	for rows.Next() {
+		var i ScheduledTransfer
+		if err := rows.Scan(
+			&i.ID,
+			&i.FromAccount,
+			&i.ToAccount,
+			&i.Amount,
+			&i.CronExpr,
+			&i.IntervalSeconds,
+			&i.StartAt,
+			&i.EndAt,
+			&i.NextRunAt,
+			&i.LastRunAt,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createAccount = `-- name: CreateAccount :one
 INSERT INTO accounts (
-	username, balance, email
+	username, balance, email, account_type, description, external_reference, category, metadata
 ) VALUES (
-	$1, $2, $3
+	$1, $2, $3, COALESCE(NULLIF($4::varchar, ''), 'user'), $5, $6, $7, COALESCE($8::jsonb, '{}')
 )
-RETURNING id, username, balance, email, created_at
+RETURNING id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata
 `
 
 type CreateAccountParams struct {
-	Username string         `json:"username"`
-	Balance  int64          `json:"balance"`
-	Email    sql.NullString `json:"email"`
+	Username          string          `json:"username"`
+	Balance           int64           `json:"balance"`
+	Email             sql.NullString  `json:"email"`
+	AccountType       string          `json:"account_type"`
+	Description       sql.NullString  `json:"description"`
+	ExternalReference sql.NullString  `json:"external_reference"`
+	Category          sql.NullString  `json:"category"`
+	Metadata          json.RawMessage `json:"metadata"`
 }
 
 func (q *Queries) CreateAccount(ctx context.Context, arg CreateAccountParams) (Account, error) {
-	row := q.db.QueryRowContext(ctx, createAccount, arg.Username, arg.Balance, arg.Email)
+	row := q.db.QueryRowContext(ctx, createAccount,
+		arg.Username,
+		arg.Balance,
+		arg.Email,
+		arg.AccountType,
+		arg.Description,
+		arg.ExternalReference,
+		arg.Category,
+		arg.Metadata,
+	)
 	var i Account
 	err := row.Scan(
 		&i.ID,
@@ -34,169 +176,510 @@ func (q *Queries) CreateAccount(ctx context.Context, arg CreateAccountParams) (A
 		&i.Balance,
 		&i.Email,
 		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
 	)
 	return i, err
 }
 
-const createTransaction = `-- name: CreateTransaction :one
-INSERT INTO transactions (
-	from_account, to_account, amount
+const createAuditEntry = `-- name: CreateAuditEntry :one
+INSERT INTO audit_log (
+	entity_type, entity_id, action, actor, request_id, before, after
 ) VALUES (
-	$1, $2, $3
+	$1, $2, $3, $4, $5, $6, $7
 )
-RETURNING id, from_account, to_account, amount, created_at
+RETURNING id, entity_type, entity_id, action, actor, request_id, before, after, created_at
 `
 
-type CreateTransactionParams struct {
-	FromAccount sql.NullInt64 `json:"from_account"`
-	ToAccount   sql.NullInt64 `json:"to_account"`
-	Amount      sql.NullInt64 `json:"amount"`
+type CreateAuditEntryParams struct {
+	EntityType string          `json:"entity_type"`
+	EntityID   int64           `json:"entity_id"`
+	Action     string          `json:"action"`
+	Actor      string          `json:"actor"`
+	RequestID  string          `json:"request_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
 }
 
-func (q *Queries) CreateTransaction(ctx context.Context, arg CreateTransactionParams) (Transaction, error) {
-	row := q.db.QueryRowContext(ctx, createTransaction, arg.FromAccount, arg.ToAccount, arg.Amount)
-	var i Transaction
+func (q *Queries) CreateAuditEntry(ctx context.Context, arg CreateAuditEntryParams) (AuditLog, error) {
+	row := q.db.QueryRowContext(ctx, createAuditEntry,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Action,
+		arg.Actor,
+		arg.RequestID,
+		arg.Before,
+		arg.After,
+	)
+	var i AuditLog
+	err := row.Scan(
+		&i.ID,
+		&i.EntityType,
+		&i.EntityID,
+		&i.Action,
+		&i.Actor,
+		&i.RequestID,
+		&i.Before,
+		&i.After,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createBalanceCheckpoints = `-- name: CreateBalanceCheckpoints :execrows
+INSERT INTO balance_checkpoints (account_id, day, balance)
+SELECT a.id, $1::date,
+  (COALESCE(prev.balance, 0) + COALESCE((
+    SELECT SUM(CASE WHEN t.to_account = a.id THEN t.amount ELSE -t.amount END)
+    FROM transactions t
+    WHERE (t.to_account = a.id OR t.from_account = a.id)
+      AND t.created_at < $2::timestamptz
+      AND (prev.balance IS NULL OR t.created_at >= $3::timestamptz)
+  ), 0))::bigint
+FROM accounts a
+LEFT JOIN balance_checkpoints prev ON prev.account_id = a.id AND prev.day = $1::date - 1
+ON CONFLICT (account_id, day) DO NOTHING
+`
+
+type CreateBalanceCheckpointsParams struct {
+	Day      time.Time `json:"day"`
+	DayEnd   time.Time `json:"day_end"`
+	DayStart time.Time `json:"day_start"`
+}
+
+func (q *Queries) CreateBalanceCheckpoints(ctx context.Context, arg CreateBalanceCheckpointsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createBalanceCheckpoints, arg.Day, arg.DayEnd, arg.DayStart)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createExternalTransfer = `-- name: CreateExternalTransfer :one
+INSERT INTO external_transfers (
+	kind, account_id, system_account_id, amount, external_reference, status, transaction_id
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7
+)
+RETURNING id, kind, account_id, system_account_id, amount, external_reference, status, failure_reason, transaction_id, reversal_transaction_id, created_at, updated_at
+`
+
+type CreateExternalTransferParams struct {
+	Kind              string        `json:"kind"`
+	AccountID         int64         `json:"account_id"`
+	SystemAccountID   int64         `json:"system_account_id"`
+	Amount            int64         `json:"amount"`
+	ExternalReference string        `json:"external_reference"`
+	Status            string        `json:"status"`
+	TransactionID     sql.NullInt64 `json:"transaction_id"`
+}
+
+func (q *Queries) CreateExternalTransfer(ctx context.Context, arg CreateExternalTransferParams) (ExternalTransfer, error) {
+	row := q.db.QueryRowContext(ctx, createExternalTransfer,
+		arg.Kind,
+		arg.AccountID,
+		arg.SystemAccountID,
+		arg.Amount,
+		arg.ExternalReference,
+		arg.Status,
+		arg.TransactionID,
+	)
+	var i ExternalTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.AccountID,
+		&i.SystemAccountID,
+		&i.Amount,
+		&i.ExternalReference,
+		&i.Status,
+		&i.FailureReason,
+		&i.TransactionID,
+		&i.ReversalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createFeeRule = `-- name: CreateFeeRule :one
+INSERT INTO fee_rules (
+	name, account_type, from_account, to_account, kind, flat_fee, basis_points, min_fee, max_fee, tiers
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+RETURNING id, name, account_type, from_account, to_account, kind, flat_fee, basis_points, min_fee, max_fee, tiers, status, created_at, updated_at
+`
+
+type CreateFeeRuleParams struct {
+	Name        string          `json:"name"`
+	AccountType sql.NullString  `json:"account_type"`
+	FromAccount sql.NullInt64   `json:"from_account"`
+	ToAccount   sql.NullInt64   `json:"to_account"`
+	Kind        string          `json:"kind"`
+	FlatFee     int64           `json:"flat_fee"`
+	BasisPoints int64           `json:"basis_points"`
+	MinFee      sql.NullInt64   `json:"min_fee"`
+	MaxFee      sql.NullInt64   `json:"max_fee"`
+	Tiers       json.RawMessage `json:"tiers"`
+}
+
+func (q *Queries) CreateFeeRule(ctx context.Context, arg CreateFeeRuleParams) (FeeRule, error) {
+	row := q.db.QueryRowContext(ctx, createFeeRule,
+		arg.Name,
+		arg.AccountType,
+		arg.FromAccount,
+		arg.ToAccount,
+		arg.Kind,
+		arg.FlatFee,
+		arg.BasisPoints,
+		arg.MinFee,
+		arg.MaxFee,
+		arg.Tiers,
+	)
+	var i FeeRule
 	err := row.Scan(
 		&i.ID,
+		&i.Name,
+		&i.AccountType,
 		&i.FromAccount,
 		&i.ToAccount,
-		&i.Amount,
+		&i.Kind,
+		&i.FlatFee,
+		&i.BasisPoints,
+		&i.MinFee,
+		&i.MaxFee,
+		&i.Tiers,
+		&i.Status,
 		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const deleteAccount = `-- name: DeleteAccount :exec
-DELETE FROM accounts
-WHERE id = $1
+const createInterestAccrual = `-- name: CreateInterestAccrual :one
+INSERT INTO interest_accruals (
+	account_id, day, balance, annual_rate_bps, amount, carry
+) VALUES (
+	$1, $2, $3, $4, $5, $6
+)
+RETURNING account_id, day, balance, annual_rate_bps, amount, carry, posted_at, transaction_id, created_at
 `
 
-func (q *Queries) DeleteAccount(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, deleteAccount, id)
-	return err
+type CreateInterestAccrualParams struct {
+	AccountID     int64     `json:"account_id"`
+	Day           time.Time `json:"day"`
+	Balance       int64     `json:"balance"`
+	AnnualRateBps int64     `json:"annual_rate_bps"`
+	Amount        int64     `json:"amount"`
+	Carry         int64     `json:"carry"`
 }
 
-const getTx = `-- name: GetTx :one
-SELECT id, from_account, to_account, amount, created_at FROM transactions
-WHERE id = $1 LIMIT 1
+func (q *Queries) CreateInterestAccrual(ctx context.Context, arg CreateInterestAccrualParams) (InterestAccrual, error) {
+	row := q.db.QueryRowContext(ctx, createInterestAccrual,
+		arg.AccountID,
+		arg.Day,
+		arg.Balance,
+		arg.AnnualRateBps,
+		arg.Amount,
+		arg.Carry,
+	)
+	var i InterestAccrual
+	err := row.Scan(
+		&i.AccountID,
+		&i.Day,
+		&i.Balance,
+		&i.AnnualRateBps,
+		&i.Amount,
+		&i.Carry,
+		&i.PostedAt,
+		&i.TransactionID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createScheduledTransfer = `-- name: CreateScheduledTransfer :one
+INSERT INTO scheduled_transfers (
+	from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7, $8
+)
+RETURNING id, from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at, last_run_at, status, created_at, updated_at
 `
 
-func (q *Queries) GetTx(ctx context.Context, id int64) (Transaction, error) {
-	row := q.db.QueryRowContext(ctx, getTx, id)
-	var i Transaction
+type CreateScheduledTransferParams struct {
+	FromAccount     int64          `json:"from_account"`
+	ToAccount       int64          `json:"to_account"`
+	Amount          int64          `json:"amount"`
+	CronExpr        sql.NullString `json:"cron_expr"`
+	IntervalSeconds sql.NullInt64  `json:"interval_seconds"`
+	StartAt         time.Time      `json:"start_at"`
+	EndAt           sql.NullTime   `json:"end_at"`
+	NextRunAt       sql.NullTime   `json:"next_run_at"`
+}
+
+func (q *Queries) CreateScheduledTransfer(ctx context.Context, arg CreateScheduledTransferParams) (ScheduledTransfer, error) {
+	row := q.db.QueryRowContext(ctx, createScheduledTransfer,
+		arg.FromAccount,
+		arg.ToAccount,
+		arg.Amount,
+		arg.CronExpr,
+		arg.IntervalSeconds,
+		arg.StartAt,
+		arg.EndAt,
+		arg.NextRunAt,
+	)
+	var i ScheduledTransfer
 	err := row.Scan(
 		&i.ID,
 		&i.FromAccount,
 		&i.ToAccount,
 		&i.Amount,
+		&i.CronExpr,
+		&i.IntervalSeconds,
+		&i.StartAt,
+		&i.EndAt,
+		&i.NextRunAt,
+		&i.LastRunAt,
+		&i.Status,
 		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getUser = `-- name: GetUser :one
-SELECT id, username, balance, email, created_at FROM accounts
-WHERE id = $1 LIMIT 1
+const createScheduledTransferRun = `-- name: CreateScheduledTransferRun :one
+INSERT INTO scheduled_transfer_runs (
+	scheduled_transfer_id, scheduled_for, transaction_id, status, error, fee_transaction_id, total_fee, fees
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7, COALESCE($8::jsonb, '[]')
+)
+RETURNING id, scheduled_transfer_id, scheduled_for, transaction_id, status, error, created_at, fee_transaction_id, total_fee, fees
 `
 
-func (q *Queries) GetUser(ctx context.Context, id int64) (Account, error) {
-	row := q.db.QueryRowContext(ctx, getUser, id)
-	var i Account
+type CreateScheduledTransferRunParams struct {
+	ScheduledTransferID int64           `json:"scheduled_transfer_id"`
+	ScheduledFor        time.Time       `json:"scheduled_for"`
+	TransactionID       sql.NullInt64   `json:"transaction_id"`
+	Status              string          `json:"status"`
+	Error               sql.NullString  `json:"error"`
+	FeeTransactionID    sql.NullInt64   `json:"fee_transaction_id"`
+	TotalFee            int64           `json:"total_fee"`
+	Fees                json.RawMessage `json:"fees"`
+}
+
+func (q *Queries) CreateScheduledTransferRun(ctx context.Context, arg CreateScheduledTransferRunParams) (ScheduledTransferRun, error) {
+	row := q.db.QueryRowContext(ctx, createScheduledTransferRun,
+		arg.ScheduledTransferID,
+		arg.ScheduledFor,
+		arg.TransactionID,
+		arg.Status,
+		arg.Error,
+		arg.FeeTransactionID,
+		arg.TotalFee,
+		arg.Fees,
+	)
+	var i ScheduledTransferRun
 	err := row.Scan(
 		&i.ID,
-		&i.Username,
-		&i.Balance,
-		&i.Email,
+		&i.ScheduledTransferID,
+		&i.ScheduledFor,
+		&i.TransactionID,
+		&i.Status,
+		&i.Error,
 		&i.CreatedAt,
+		&i.FeeTransactionID,
+		&i.TotalFee,
+		&i.Fees,
 	)
 	return i, err
 }
 
-const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, username, balance, email, created_at FROM accounts
-WHERE email = $1 LIMIT 1
+const createTransaction = `-- name: CreateTransaction :one
+INSERT INTO transactions (
+	from_account, to_account, amount, description, external_reference, category, metadata
+) VALUES (
+	$1, $2, $3, $4, $5, $6, COALESCE($7::jsonb, '{}')
+)
+RETURNING id, from_account, to_account, amount, created_at, prev_hash, hash, description, external_reference, category, metadata, chain_version
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email sql.NullString) (Account, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
-	var i Account
+type CreateTransactionParams struct {
+	FromAccount       sql.NullInt64   `json:"from_account"`
+	ToAccount         sql.NullInt64   `json:"to_account"`
+	Amount            sql.NullInt64   `json:"amount"`
+	Description       sql.NullString  `json:"description"`
+	ExternalReference sql.NullString  `json:"external_reference"`
+	Category          sql.NullString  `json:"category"`
+	Metadata          json.RawMessage `json:"metadata"`
+}
+
+func (q *Queries) CreateTransaction(ctx context.Context, arg CreateTransactionParams) (Transaction, error) {
+	row := q.db.QueryRowContext(ctx, createTransaction,
+		arg.FromAccount,
+		arg.ToAccount,
+		arg.Amount,
+		arg.Description,
+		arg.ExternalReference,
+		arg.Category,
+		arg.Metadata,
+	)
+	var i Transaction
 	err := row.Scan(
 		&i.ID,
-		&i.Username,
-		&i.Balance,
-		&i.Email,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Amount,
 		&i.CreatedAt,
+		&i.PrevHash,
+		&i.Hash,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+		&i.ChainVersion,
 	)
 	return i, err
 }
 
-const getUserByUsername = `-- name: GetUserByUsername :one
-SELECT id, username, balance, email, created_at FROM accounts
-WHERE username = $1 LIMIT 1
+const createTransactionFee = `-- name: CreateTransactionFee :one
+INSERT INTO transaction_fees (
+	transaction_id, fee_rule_id, fee_transaction_id, amount
+) VALUES (
+	$1, $2, $3, $4
+)
+RETURNING transaction_id, fee_rule_id, fee_transaction_id, amount, created_at
 `
 
-func (q *Queries) GetUserByUsername(ctx context.Context, username string) (Account, error) {
-	row := q.db.QueryRowContext(ctx, getUserByUsername, username)
-	var i Account
+type CreateTransactionFeeParams struct {
+	TransactionID    int64 `json:"transaction_id"`
+	FeeRuleID        int64 `json:"fee_rule_id"`
+	FeeTransactionID int64 `json:"fee_transaction_id"`
+	Amount           int64 `json:"amount"`
+}
+
+func (q *Queries) CreateTransactionFee(ctx context.Context, arg CreateTransactionFeeParams) (TransactionFee, error) {
+	row := q.db.QueryRowContext(ctx, createTransactionFee,
+		arg.TransactionID,
+		arg.FeeRuleID,
+		arg.FeeTransactionID,
+		arg.Amount,
+	)
+	var i TransactionFee
 	err := row.Scan(
-		&i.ID,
-		&i.Username,
-		&i.Balance,
-		&i.Email,
+		&i.TransactionID,
+		&i.FeeRuleID,
+		&i.FeeTransactionID,
+		&i.Amount,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const getUserTransactions = `-- name: GetUserTransactions :many
-SELECT
-    t.id AS transaction_id,
-    t.from_account AS from_account_id,
-    from_acc.username AS from_username,
-    t.to_account AS to_account_id,
-    to_acc.username AS to_username,
-    t.amount,
-    t.created_at AS transaction_created_at
-FROM
-    transactions t
-JOIN
-    accounts from_acc ON t.from_account = from_acc.id
-JOIN
-    accounts to_acc ON t.to_account = to_acc.id
-WHERE
-    from_acc.username = 'desired_username' OR to_acc.username = 'desired_username'
-ORDER BY
-    t.created_at DESC
+const deleteAccount = `-- name: DeleteAccount :exec
+DELETE FROM accounts
+WHERE id = $1
 `
 
-type GetUserTransactionsRow struct {
-	TransactionID        int64         `json:"transaction_id"`
-	FromAccountID        sql.NullInt64 `json:"from_account_id"`
-	FromUsername         string        `json:"from_username"`
-	ToAccountID          sql.NullInt64 `json:"to_account_id"`
-	ToUsername           string        `json:"to_username"`
-	Amount               sql.NullInt64 `json:"amount"`
-	TransactionCreatedAt sql.NullTime  `json:"transaction_created_at"`
+func (q *Queries) DeleteAccount(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteAccount, id)
+	return err
 }
 
-func (q *Queries) GetUserTransactions(ctx context.Context) ([]GetUserTransactionsRow, error) {
-	rows, err := q.db.QueryContext(ctx, getUserTransactions)
+const deleteInterestRate = `-- name: DeleteInterestRate :exec
+DELETE FROM interest_rates
+WHERE account_id = $1
+`
+
+func (q *Queries) DeleteInterestRate(ctx context.Context, accountID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteInterestRate, accountID)
+	return err
+}
+
+const getAccountBalanceAsOf = `-- name: GetAccountBalanceAsOf :one
+SELECT a.id AS account_id,
+  (COALESCE(cp.balance, 0) + COALESCE((
+    SELECT SUM(CASE WHEN t.to_account = a.id THEN t.amount ELSE -t.amount END)
+    FROM transactions t
+    WHERE (t.to_account = a.id OR t.from_account = a.id)
+      AND t.created_at <= $1::timestamptz
+      AND t.created_at >= COALESCE((cp.day + 1)::timestamp AT TIME ZONE 'UTC', '-infinity')
+  ), 0))::bigint AS balance
+FROM accounts a
+LEFT JOIN LATERAL (
+  SELECT day, balance FROM balance_checkpoints c
+  WHERE c.account_id = a.id AND c.day < $2::date
+  ORDER BY day DESC LIMIT 1
+) cp ON true
+WHERE a.id = $3
+`
+
+type GetAccountBalanceAsOfParams struct {
+	AsOf    time.Time `json:"as_of"`
+	AsOfDay time.Time `json:"as_of_day"`
+	ID      int64     `json:"id"`
+}
+
+type GetAccountBalanceAsOfRow struct {
+	AccountID int64 `json:"account_id"`
+	Balance   int64 `json:"balance"`
+}
+
+func (q *Queries) GetAccountBalanceAsOf(ctx context.Context, arg GetAccountBalanceAsOfParams) (GetAccountBalanceAsOfRow, error) {
+	row := q.db.QueryRowContext(ctx, getAccountBalanceAsOf, arg.AsOf, arg.AsOfDay, arg.ID)
+	var i GetAccountBalanceAsOfRow
+	err := row.Scan(&i.AccountID, &i.Balance)
+	return i, err
+}
+
+const getAccountBalancesAsOf = `-- name: GetAccountBalancesAsOf :many
+SELECT a.id AS account_id,
+  (COALESCE(cp.balance, 0) + COALESCE((
+    SELECT SUM(CASE WHEN t.to_account = a.id THEN t.amount ELSE -t.amount END)
+    FROM transactions t
+    WHERE (t.to_account = a.id OR t.from_account = a.id)
+      AND t.created_at <= $1::timestamptz
+      AND t.created_at >= COALESCE((cp.day + 1)::timestamp AT TIME ZONE 'UTC', '-infinity')
+  ), 0))::bigint AS balance
+FROM accounts a
+LEFT JOIN LATERAL (
+  SELECT day, balance FROM balance_checkpoints c
+  WHERE c.account_id = a.id AND c.day < $2::date
+  ORDER BY day DESC LIMIT 1
+) cp ON true
+WHERE a.id = ANY($3::bigint[])
+ORDER BY a.id
+`
+
+type GetAccountBalancesAsOfParams struct {
+	AsOf    time.Time `json:"as_of"`
+	AsOfDay time.Time `json:"as_of_day"`
+	Ids     []int64   `json:"ids"`
+}
+
+type GetAccountBalancesAsOfRow struct {
+	AccountID int64 `json:"account_id"`
+	Balance   int64 `json:"balance"`
+}
+
+func (q *Queries) GetAccountBalancesAsOf(ctx context.Context, arg GetAccountBalancesAsOfParams) ([]GetAccountBalancesAsOfRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAccountBalancesAsOf, arg.AsOf, arg.AsOfDay, pq.Array(arg.Ids))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetUserTransactionsRow
+	var items []GetAccountBalancesAsOfRow
 	for rows.Next() {
-		var i GetUserTransactionsRow
-		if err := rows.Scan(
-			&i.TransactionID,
-			&i.FromAccountID,
-			&i.FromUsername,
-			&i.ToAccountID,
-			&i.ToUsername,
-			&i.Amount,
-			&i.TransactionCreatedAt,
-		); err != nil {
+		var i GetAccountBalancesAsOfRow
+		if err := rows.Scan(&i.AccountID, &i.Balance); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -210,18 +693,1183 @@ func (q *Queries) GetUserTransactions(ctx context.Context) ([]GetUserTransaction
 	return items, nil
 }
 
-const getUsers = `-- name: GetUsers :many
-SELECT id, username, balance, email, created_at FROM accounts
-ORDER BY username
+const getExternalTransfer = `-- name: GetExternalTransfer :one
+SELECT id, kind, account_id, system_account_id, amount, external_reference, status, failure_reason, transaction_id, reversal_transaction_id, created_at, updated_at FROM external_transfers
+WHERE id = $1 LIMIT 1
 `
 
-func (q *Queries) GetUsers(ctx context.Context) ([]Account, error) {
-	rows, err := q.db.QueryContext(ctx, getUsers)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Account
+func (q *Queries) GetExternalTransfer(ctx context.Context, id int64) (ExternalTransfer, error) {
+	row := q.db.QueryRowContext(ctx, getExternalTransfer, id)
+	var i ExternalTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.AccountID,
+		&i.SystemAccountID,
+		&i.Amount,
+		&i.ExternalReference,
+		&i.Status,
+		&i.FailureReason,
+		&i.TransactionID,
+		&i.ReversalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getExternalTransferByReference = `-- name: GetExternalTransferByReference :one
+SELECT id, kind, account_id, system_account_id, amount, external_reference, status, failure_reason, transaction_id, reversal_transaction_id, created_at, updated_at FROM external_transfers
+WHERE kind = $1 AND external_reference = $2 LIMIT 1
+`
+
+type GetExternalTransferByReferenceParams struct {
+	Kind              string `json:"kind"`
+	ExternalReference string `json:"external_reference"`
+}
+
+func (q *Queries) GetExternalTransferByReference(ctx context.Context, arg GetExternalTransferByReferenceParams) (ExternalTransfer, error) {
+	row := q.db.QueryRowContext(ctx, getExternalTransferByReference, arg.Kind, arg.ExternalReference)
+	var i ExternalTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.AccountID,
+		&i.SystemAccountID,
+		&i.Amount,
+		&i.ExternalReference,
+		&i.Status,
+		&i.FailureReason,
+		&i.TransactionID,
+		&i.ReversalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getExternalTransferForUpdate = `-- name: GetExternalTransferForUpdate :one
+SELECT id, kind, account_id, system_account_id, amount, external_reference, status, failure_reason, transaction_id, reversal_transaction_id, created_at, updated_at FROM external_transfers
+WHERE id = $1 LIMIT 1
+FOR UPDATE
+`
+
+func (q *Queries) GetExternalTransferForUpdate(ctx context.Context, id int64) (ExternalTransfer, error) {
+	row := q.db.QueryRowContext(ctx, getExternalTransferForUpdate, id)
+	var i ExternalTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.AccountID,
+		&i.SystemAccountID,
+		&i.Amount,
+		&i.ExternalReference,
+		&i.Status,
+		&i.FailureReason,
+		&i.TransactionID,
+		&i.ReversalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getFeeRule = `-- name: GetFeeRule :one
+SELECT id, name, account_type, from_account, to_account, kind, flat_fee, basis_points, min_fee, max_fee, tiers, status, created_at, updated_at FROM fee_rules
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetFeeRule(ctx context.Context, id int64) (FeeRule, error) {
+	row := q.db.QueryRowContext(ctx, getFeeRule, id)
+	var i FeeRule
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.AccountType,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Kind,
+		&i.FlatFee,
+		&i.BasisPoints,
+		&i.MinFee,
+		&i.MaxFee,
+		&i.Tiers,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getFirstTransactionTime = `-- name: GetFirstTransactionTime :one
+SELECT created_at FROM transactions
+ORDER BY id LIMIT 1
+`
+
+func (q *Queries) GetFirstTransactionTime(ctx context.Context) (sql.NullTime, error) {
+	row := q.db.QueryRowContext(ctx, getFirstTransactionTime)
+	var created_at sql.NullTime
+	err := row.Scan(&created_at)
+	return created_at, err
+}
+
+const getInterestRate = `-- name: GetInterestRate :one
+SELECT account_id, annual_rate_bps, created_at, updated_at FROM interest_rates
+WHERE account_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetInterestRate(ctx context.Context, accountID int64) (InterestRate, error) {
+	row := q.db.QueryRowContext(ctx, getInterestRate, accountID)
+	var i InterestRate
+	err := row.Scan(
+		&i.AccountID,
+		&i.AnnualRateBps,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getInterestRateForUpdate = `-- name: GetInterestRateForUpdate :one
+SELECT account_id, annual_rate_bps, created_at, updated_at FROM interest_rates
+WHERE account_id = $1 LIMIT 1
+FOR UPDATE
+`
+
+func (q *Queries) GetInterestRateForUpdate(ctx context.Context, accountID int64) (InterestRate, error) {
+	row := q.db.QueryRowContext(ctx, getInterestRateForUpdate, accountID)
+	var i InterestRate
+	err := row.Scan(
+		&i.AccountID,
+		&i.AnnualRateBps,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getLatestBalanceCheckpointDay = `-- name: GetLatestBalanceCheckpointDay :one
+SELECT day FROM balance_checkpoints
+ORDER BY day DESC LIMIT 1
+`
+
+func (q *Queries) GetLatestBalanceCheckpointDay(ctx context.Context) (time.Time, error) {
+	row := q.db.QueryRowContext(ctx, getLatestBalanceCheckpointDay)
+	var day time.Time
+	err := row.Scan(&day)
+	return day, err
+}
+
+const getLatestInterestAccrual = `-- name: GetLatestInterestAccrual :one
+SELECT account_id, day, balance, annual_rate_bps, amount, carry, posted_at, transaction_id, created_at FROM interest_accruals
+WHERE account_id = $1
+ORDER BY day DESC
+LIMIT 1
+`
+
+func (q *Queries) GetLatestInterestAccrual(ctx context.Context, accountID int64) (InterestAccrual, error) {
+	row := q.db.QueryRowContext(ctx, getLatestInterestAccrual, accountID)
+	var i InterestAccrual
+	err := row.Scan(
+		&i.AccountID,
+		&i.Day,
+		&i.Balance,
+		&i.AnnualRateBps,
+		&i.Amount,
+		&i.Carry,
+		&i.PostedAt,
+		&i.TransactionID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getLedgerChain = `-- name: GetLedgerChain :one
+SELECT id, last_tx_id, hash, updated_at FROM ledger_chain
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetLedgerChain(ctx context.Context, id int64) (LedgerChain, error) {
+	row := q.db.QueryRowContext(ctx, getLedgerChain, id)
+	var i LedgerChain
+	err := row.Scan(
+		&i.ID,
+		&i.LastTxID,
+		&i.Hash,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getLedgerChainForUpdate = `-- name: GetLedgerChainForUpdate :one
+SELECT id, last_tx_id, hash, updated_at FROM ledger_chain
+WHERE id = $1 LIMIT 1
+FOR UPDATE
+`
+
+func (q *Queries) GetLedgerChainForUpdate(ctx context.Context, id int64) (LedgerChain, error) {
+	row := q.db.QueryRowContext(ctx, getLedgerChainForUpdate, id)
+	var i LedgerChain
+	err := row.Scan(
+		&i.ID,
+		&i.LastTxID,
+		&i.Hash,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getOldestTransactionStart = `-- name: GetOldestTransactionStart :one
+SELECT COALESCE(min(xact_start), now())::timestamptz AS xact_start FROM pg_stat_activity
+WHERE datname = current_database() AND backend_type = 'client backend'
+`
+
+// Transactions take created_at from the start of their DB transaction, so
+// none created before the oldest DB transaction in progress can still commit.
+// Sessions of other roles are only visible with pg_read_all_stats.
+func (q *Queries) GetOldestTransactionStart(ctx context.Context) (time.Time, error) {
+	row := q.db.QueryRowContext(ctx, getOldestTransactionStart)
+	var xact_start time.Time
+	err := row.Scan(&xact_start)
+	return xact_start, err
+}
+
+const getScheduledTransfer = `-- name: GetScheduledTransfer :one
+SELECT id, from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at, last_run_at, status, created_at, updated_at FROM scheduled_transfers
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetScheduledTransfer(ctx context.Context, id int64) (ScheduledTransfer, error) {
+	row := q.db.QueryRowContext(ctx, getScheduledTransfer, id)
+	var i ScheduledTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Amount,
+		&i.CronExpr,
+		&i.IntervalSeconds,
+		&i.StartAt,
+		&i.EndAt,
+		&i.NextRunAt,
+		&i.LastRunAt,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getScheduledTransferForUpdate = `-- name: GetScheduledTransferForUpdate :one
+SELECT id, from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at, last_run_at, status, created_at, updated_at FROM scheduled_transfers
+WHERE id = $1 LIMIT 1
+FOR UPDATE
+`
+
+func (q *Queries) GetScheduledTransferForUpdate(ctx context.Context, id int64) (ScheduledTransfer, error) {
+	row := q.db.QueryRowContext(ctx, getScheduledTransferForUpdate, id)
+	var i ScheduledTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Amount,
+		&i.CronExpr,
+		&i.IntervalSeconds,
+		&i.StartAt,
+		&i.EndAt,
+		&i.NextRunAt,
+		&i.LastRunAt,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getTransactionTime = `-- name: GetTransactionTime :one
+SELECT now()::timestamptz AS now
+`
+
+// The start of the current DB transaction, which is the created_at of the
+// rows it writes.
+func (q *Queries) GetTransactionTime(ctx context.Context) (time.Time, error) {
+	row := q.db.QueryRowContext(ctx, getTransactionTime)
+	var now time.Time
+	err := row.Scan(&now)
+	return now, err
+}
+
+const getTx = `-- name: GetTx :one
+SELECT id, from_account, to_account, amount, created_at, prev_hash, hash, description, external_reference, category, metadata, chain_version FROM transactions
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetTx(ctx context.Context, id int64) (Transaction, error) {
+	row := q.db.QueryRowContext(ctx, getTx, id)
+	var i Transaction
+	err := row.Scan(
+		&i.ID,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Amount,
+		&i.CreatedAt,
+		&i.PrevHash,
+		&i.Hash,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+		&i.ChainVersion,
+	)
+	return i, err
+}
+
+const getTxByExternalReference = `-- name: GetTxByExternalReference :one
+SELECT id, from_account, to_account, amount, created_at, prev_hash, hash, description, external_reference, category, metadata, chain_version FROM transactions
+WHERE from_account = $1 AND external_reference = $2 LIMIT 1
+`
+
+type GetTxByExternalReferenceParams struct {
+	FromAccount       sql.NullInt64  `json:"from_account"`
+	ExternalReference sql.NullString `json:"external_reference"`
+}
+
+func (q *Queries) GetTxByExternalReference(ctx context.Context, arg GetTxByExternalReferenceParams) (Transaction, error) {
+	row := q.db.QueryRowContext(ctx, getTxByExternalReference, arg.FromAccount, arg.ExternalReference)
+	var i Transaction
+	err := row.Scan(
+		&i.ID,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Amount,
+		&i.CreatedAt,
+		&i.PrevHash,
+		&i.Hash,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+		&i.ChainVersion,
+	)
+	return i, err
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id int64) (Account, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i Account
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+WHERE email = $1 LIMIT 1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email sql.NullString) (Account, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i Account
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+	)
+	return i, err
+}
+
+const getUserByExternalReference = `-- name: GetUserByExternalReference :one
+SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+WHERE external_reference = $1 LIMIT 1
+`
+
+func (q *Queries) GetUserByExternalReference(ctx context.Context, externalReference sql.NullString) (Account, error) {
+	row := q.db.QueryRowContext(ctx, getUserByExternalReference, externalReference)
+	var i Account
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+WHERE username = $1 LIMIT 1
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (Account, error) {
+	row := q.db.QueryRowContext(ctx, getUserByUsername, username)
+	var i Account
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+	)
+	return i, err
+}
+
+const getUserForUpdate = `-- name: GetUserForUpdate :one
+SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+WHERE id = $1 LIMIT 1
+FOR UPDATE
+`
+
+func (q *Queries) GetUserForUpdate(ctx context.Context, id int64) (Account, error) {
+	row := q.db.QueryRowContext(ctx, getUserForUpdate, id)
+	var i Account
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+	)
+	return i, err
+}
+
+const getUserTransactions = `-- name: GetUserTransactions :many
+SELECT
+    t.id AS transaction_id,
+    t.from_account AS from_account_id,
+    from_acc.username AS from_username,
+    t.to_account AS to_account_id,
+    to_acc.username AS to_username,
+    t.amount,
+    t.created_at AS transaction_created_at
+FROM
+    transactions t
+JOIN
+    accounts from_acc ON t.from_account = from_acc.id
+JOIN
+    accounts to_acc ON t.to_account = to_acc.id
+WHERE
+    from_acc.username = 'desired_username' OR to_acc.username = 'desired_username'
+ORDER BY
+    t.created_at DESC
+`
+
+type GetUserTransactionsRow struct {
+	TransactionID        int64         `json:"transaction_id"`
+	FromAccountID        sql.NullInt64 `json:"from_account_id"`
+	FromUsername         string        `json:"from_username"`
+	ToAccountID          sql.NullInt64 `json:"to_account_id"`
+	ToUsername           string        `json:"to_username"`
+	Amount               sql.NullInt64 `json:"amount"`
+	TransactionCreatedAt sql.NullTime  `json:"transaction_created_at"`
+}
+
+func (q *Queries) GetUserTransactions(ctx context.Context) ([]GetUserTransactionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUserTransactions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUserTransactionsRow
+	for rows.Next() {
+		var i GetUserTransactionsRow
+		if err := rows.Scan(
+			&i.TransactionID,
+			&i.FromAccountID,
+			&i.FromUsername,
+			&i.ToAccountID,
+			&i.ToUsername,
+			&i.Amount,
+			&i.TransactionCreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsers = `-- name: GetUsers :many
+SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+ORDER BY username
+`
+
+func (q *Queries) GetUsers(ctx context.Context) ([]Account, error) {
+	rows, err := q.db.QueryContext(ctx, getUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Account
+	for rows.Next() {
+		var i Account
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Balance,
+			&i.Email,
+			&i.CreatedAt,
+			&i.Status,
+			&i.StatusReason,
+			&i.StatusUpdatedAt,
+			&i.AccountType,
+			&i.OverdraftLimit,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsersByStatus = `-- name: GetUsersByStatus :many
+SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+WHERE status = $1
+ORDER BY username
+`
+
+func (q *Queries) GetUsersByStatus(ctx context.Context, status string) ([]Account, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByStatus, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Account
+	for rows.Next() {
+		var i Account
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Balance,
+			&i.Email,
+			&i.CreatedAt,
+			&i.Status,
+			&i.StatusReason,
+			&i.StatusUpdatedAt,
+			&i.AccountType,
+			&i.OverdraftLimit,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAccountTransactions = `-- name: ListAccountTransactions :many
+SELECT
+    t.id AS transaction_id,
+    t.from_account AS from_account_id,
+    from_acc.username AS from_username,
+    t.to_account AS to_account_id,
+    to_acc.username AS to_username,
+    t.amount,
+    t.created_at AS transaction_created_at
+FROM
+    transactions t
+JOIN
+    accounts from_acc ON t.from_account = from_acc.id
+JOIN
+    accounts to_acc ON t.to_account = to_acc.id
+WHERE
+    (t.from_account = $1 OR t.to_account = $1)
+    AND t.created_at >= $2::timestamptz
+    AND t.created_at < $3::timestamptz
+    AND t.id > $4
+ORDER BY
+    t.id
+LIMIT $5
+`
+
+type ListAccountTransactionsParams struct {
+	AccountID  sql.NullInt64 `json:"account_id"`
+	FromTime   time.Time     `json:"from_time"`
+	ToTime     time.Time     `json:"to_time"`
+	AfterID    int64         `json:"after_id"`
+	MaxResults int32         `json:"max_results"`
+}
+
+type ListAccountTransactionsRow struct {
+	TransactionID        int64         `json:"transaction_id"`
+	FromAccountID        sql.NullInt64 `json:"from_account_id"`
+	FromUsername         string        `json:"from_username"`
+	ToAccountID          sql.NullInt64 `json:"to_account_id"`
+	ToUsername           string        `json:"to_username"`
+	Amount               sql.NullInt64 `json:"amount"`
+	TransactionCreatedAt sql.NullTime  `json:"transaction_created_at"`
+}
+
+func (q *Queries) ListAccountTransactions(ctx context.Context, arg ListAccountTransactionsParams) ([]ListAccountTransactionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listAccountTransactions,
+		arg.AccountID,
+		arg.FromTime,
+		arg.ToTime,
+		arg.AfterID,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAccountTransactionsRow
+	for rows.Next() {
+		var i ListAccountTransactionsRow
+		if err := rows.Scan(
+			&i.TransactionID,
+			&i.FromAccountID,
+			&i.FromUsername,
+			&i.ToAccountID,
+			&i.ToUsername,
+			&i.Amount,
+			&i.TransactionCreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditEntries = `-- name: ListAuditEntries :many
+SELECT id, entity_type, entity_id, action, actor, request_id, before, after, created_at FROM audit_log
+WHERE ($1::varchar IS NULL OR entity_type = $1)
+  AND ($2::bigint IS NULL OR entity_id = $2)
+  AND ($3::timestamptz IS NULL OR created_at >= $3)
+  AND ($4::timestamptz IS NULL OR created_at < $4)
+  AND id > $5
+ORDER BY id
+LIMIT $6
+`
+
+type ListAuditEntriesParams struct {
+	EntityType sql.NullString `json:"entity_type"`
+	EntityID   sql.NullInt64  `json:"entity_id"`
+	FromTime   sql.NullTime   `json:"from_time"`
+	ToTime     sql.NullTime   `json:"to_time"`
+	AfterID    int64          `json:"after_id"`
+	MaxResults int32          `json:"max_results"`
+}
+
+func (q *Queries) ListAuditEntries(ctx context.Context, arg ListAuditEntriesParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditEntries,
+		arg.EntityType,
+		arg.EntityID,
+		arg.FromTime,
+		arg.ToTime,
+		arg.AfterID,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.EntityType,
+			&i.EntityID,
+			&i.Action,
+			&i.Actor,
+			&i.RequestID,
+			&i.Before,
+			&i.After,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExternalTransfers = `-- name: ListExternalTransfers :many
+SELECT id, kind, account_id, system_account_id, amount, external_reference, status, failure_reason, transaction_id, reversal_transaction_id, created_at, updated_at FROM external_transfers
+WHERE kind = $1
+  AND ($2::bigint IS NULL OR account_id = $2)
+  AND ($3::varchar IS NULL OR status = $3)
+  AND id > $4
+ORDER BY id
+LIMIT $5
+`
+
+type ListExternalTransfersParams struct {
+	Kind       string         `json:"kind"`
+	AccountID  sql.NullInt64  `json:"account_id"`
+	Status     sql.NullString `json:"status"`
+	AfterID    int64          `json:"after_id"`
+	MaxResults int32          `json:"max_results"`
+}
+
+func (q *Queries) ListExternalTransfers(ctx context.Context, arg ListExternalTransfersParams) ([]ExternalTransfer, error) {
+	rows, err := q.db.QueryContext(ctx, listExternalTransfers,
+		arg.Kind,
+		arg.AccountID,
+		arg.Status,
+		arg.AfterID,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ExternalTransfer
+	for rows.Next() {
+		var i ExternalTransfer
+		if err := rows.Scan(
+			&i.ID,
+			&i.Kind,
+			&i.AccountID,
+			&i.SystemAccountID,
+			&i.Amount,
+			&i.ExternalReference,
+			&i.Status,
+			&i.FailureReason,
+			&i.TransactionID,
+			&i.ReversalTransactionID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFeeRules = `-- name: ListFeeRules :many
+SELECT id, name, account_type, from_account, to_account, kind, flat_fee, basis_points, min_fee, max_fee, tiers, status, created_at, updated_at FROM fee_rules
+WHERE ($1::varchar IS NULL OR status = $1)
+  AND id > $2
+ORDER BY id
+LIMIT $3
+`
+
+type ListFeeRulesParams struct {
+	Status     sql.NullString `json:"status"`
+	AfterID    int64          `json:"after_id"`
+	MaxResults int32          `json:"max_results"`
+}
+
+func (q *Queries) ListFeeRules(ctx context.Context, arg ListFeeRulesParams) ([]FeeRule, error) {
+	rows, err := q.db.QueryContext(ctx, listFeeRules, arg.Status, arg.AfterID, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeeRule
+	for rows.Next() {
+		var i FeeRule
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.AccountType,
+			&i.FromAccount,
+			&i.ToAccount,
+			&i.Kind,
+			&i.FlatFee,
+			&i.BasisPoints,
+			&i.MinFee,
+			&i.MaxFee,
+			&i.Tiers,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInterestRates = `-- name: ListInterestRates :many
+SELECT account_id, annual_rate_bps, created_at, updated_at FROM interest_rates
+ORDER BY account_id
+`
+
+func (q *Queries) ListInterestRates(ctx context.Context) ([]InterestRate, error) {
+	rows, err := q.db.QueryContext(ctx, listInterestRates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []InterestRate
+	for rows.Next() {
+		var i InterestRate
+		if err := rows.Scan(
+			&i.AccountID,
+			&i.AnnualRateBps,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMatchingFeeRules = `-- name: ListMatchingFeeRules :many
+SELECT id, name, account_type, from_account, to_account, kind, flat_fee, basis_points, min_fee, max_fee, tiers, status, created_at, updated_at FROM fee_rules
+WHERE status = 'active'
+  AND (account_type IS NULL OR account_type = $1)
+  AND (from_account IS NULL OR from_account = $2)
+  AND (to_account IS NULL OR to_account = $3)
+ORDER BY id
+`
+
+type ListMatchingFeeRulesParams struct {
+	AccountType string `json:"account_type"`
+	FromAccount int64  `json:"from_account"`
+	ToAccount   int64  `json:"to_account"`
+}
+
+func (q *Queries) ListMatchingFeeRules(ctx context.Context, arg ListMatchingFeeRulesParams) ([]FeeRule, error) {
+	rows, err := q.db.QueryContext(ctx, listMatchingFeeRules, arg.AccountType, arg.FromAccount, arg.ToAccount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeeRule
+	for rows.Next() {
+		var i FeeRule
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.AccountType,
+			&i.FromAccount,
+			&i.ToAccount,
+			&i.Kind,
+			&i.FlatFee,
+			&i.BasisPoints,
+			&i.MinFee,
+			&i.MaxFee,
+			&i.Tiers,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listScheduledTransferRuns = `-- name: ListScheduledTransferRuns :many
+SELECT id, scheduled_transfer_id, scheduled_for, transaction_id, status, error, created_at, fee_transaction_id, total_fee, fees FROM scheduled_transfer_runs
+WHERE scheduled_transfer_id = $1 AND id > $2
+ORDER BY id
+LIMIT $3
+`
+
+type ListScheduledTransferRunsParams struct {
+	ScheduledTransferID int64 `json:"scheduled_transfer_id"`
+	AfterID             int64 `json:"after_id"`
+	MaxResults          int32 `json:"max_results"`
+}
+
+func (q *Queries) ListScheduledTransferRuns(ctx context.Context, arg ListScheduledTransferRunsParams) ([]ScheduledTransferRun, error) {
+	rows, err := q.db.QueryContext(ctx, listScheduledTransferRuns, arg.ScheduledTransferID, arg.AfterID, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ScheduledTransferRun
+	for rows.Next() {
+		var i ScheduledTransferRun
+		if err := rows.Scan(
+			&i.ID,
+			&i.ScheduledTransferID,
+			&i.ScheduledFor,
+			&i.TransactionID,
+			&i.Status,
+			&i.Error,
+			&i.CreatedAt,
+			&i.FeeTransactionID,
+			&i.TotalFee,
+			&i.Fees,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listScheduledTransfers = `-- name: ListScheduledTransfers :many
+SELECT id, from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at, last_run_at, status, created_at, updated_at FROM scheduled_transfers
+WHERE ($1::bigint IS NULL OR from_account = $1 OR to_account = $1)
+  AND ($2::varchar IS NULL OR status = $2)
+ORDER BY id
+`
+
+type ListScheduledTransfersParams struct {
+	AccountID sql.NullInt64  `json:"account_id"`
+	Status    sql.NullString `json:"status"`
+}
+
+func (q *Queries) ListScheduledTransfers(ctx context.Context, arg ListScheduledTransfersParams) ([]ScheduledTransfer, error) {
+	rows, err := q.db.QueryContext(ctx, listScheduledTransfers, arg.AccountID, arg.Status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ScheduledTransfer
+	for rows.Next() {
+		var i ScheduledTransfer
+		if err := rows.Scan(
+			&i.ID,
+			&i.FromAccount,
+			&i.ToAccount,
+			&i.Amount,
+			&i.CronExpr,
+			&i.IntervalSeconds,
+			&i.StartAt,
+			&i.EndAt,
+			&i.NextRunAt,
+			&i.LastRunAt,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTransactions = `-- name: ListTransactions :many
+SELECT id, from_account, to_account, amount, created_at, prev_hash, hash, description, external_reference, category, metadata, chain_version FROM transactions
+WHERE id > $1
+ORDER BY id
+LIMIT $2
+`
+
+type ListTransactionsParams struct {
+	ID    int64 `json:"id"`
+	Limit int32 `json:"limit"`
+}
+
+func (q *Queries) ListTransactions(ctx context.Context, arg ListTransactionsParams) ([]Transaction, error) {
+	rows, err := q.db.QueryContext(ctx, listTransactions, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transaction
+	for rows.Next() {
+		var i Transaction
+		if err := rows.Scan(
+			&i.ID,
+			&i.FromAccount,
+			&i.ToAccount,
+			&i.Amount,
+			&i.CreatedAt,
+			&i.PrevHash,
+			&i.Hash,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+			&i.ChainVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markInterestAccrualsPosted = `-- name: MarkInterestAccrualsPosted :execrows
+UPDATE interest_accruals
+SET posted_at = now(), transaction_id = $1
+WHERE account_id = $2 AND posted_at IS NULL AND day < $3
+`
+
+type MarkInterestAccrualsPostedParams struct {
+	TransactionID sql.NullInt64 `json:"transaction_id"`
+	AccountID     int64         `json:"account_id"`
+	Before        time.Time     `json:"before"`
+}
+
+func (q *Queries) MarkInterestAccrualsPosted(ctx context.Context, arg MarkInterestAccrualsPostedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markInterestAccrualsPosted, arg.TransactionID, arg.AccountID, arg.Before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const searchAccounts = `-- name: SearchAccounts :many
+SELECT id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata FROM accounts
+WHERE ($1::varchar IS NULL OR status = $1)
+  AND ($2::varchar IS NULL OR category = $2)
+  AND ($3::varchar IS NULL OR external_reference = $3)
+  AND ($4::jsonb IS NULL OR metadata @> $4)
+ORDER BY username
+`
+
+type SearchAccountsParams struct {
+	Status            sql.NullString  `json:"status"`
+	Category          sql.NullString  `json:"category"`
+	ExternalReference sql.NullString  `json:"external_reference"`
+	Metadata          json.RawMessage `json:"metadata"`
+}
+
+func (q *Queries) SearchAccounts(ctx context.Context, arg SearchAccountsParams) ([]Account, error) {
+	rows, err := q.db.QueryContext(ctx, searchAccounts,
+		arg.Status,
+		arg.Category,
+		arg.ExternalReference,
+		arg.Metadata,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Account
 	for rows.Next() {
 		var i Account
 		if err := rows.Scan(
@@ -230,6 +1878,78 @@ func (q *Queries) GetUsers(ctx context.Context) ([]Account, error) {
 			&i.Balance,
 			&i.Email,
 			&i.CreatedAt,
+			&i.Status,
+			&i.StatusReason,
+			&i.StatusUpdatedAt,
+			&i.AccountType,
+			&i.OverdraftLimit,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchTransactions = `-- name: SearchTransactions :many
+SELECT id, from_account, to_account, amount, created_at, prev_hash, hash, description, external_reference, category, metadata, chain_version FROM transactions
+WHERE ($1::bigint IS NULL OR from_account = $1 OR to_account = $1)
+  AND ($2::varchar IS NULL OR category = $2)
+  AND ($3::varchar IS NULL OR external_reference = $3)
+  AND ($4::jsonb IS NULL OR metadata @> $4)
+  AND id > $5
+ORDER BY id
+LIMIT $6
+`
+
+type SearchTransactionsParams struct {
+	AccountID         sql.NullInt64   `json:"account_id"`
+	Category          sql.NullString  `json:"category"`
+	ExternalReference sql.NullString  `json:"external_reference"`
+	Metadata          json.RawMessage `json:"metadata"`
+	AfterID           int64           `json:"after_id"`
+	MaxResults        int32           `json:"max_results"`
+}
+
+func (q *Queries) SearchTransactions(ctx context.Context, arg SearchTransactionsParams) ([]Transaction, error) {
+	rows, err := q.db.QueryContext(ctx, searchTransactions,
+		arg.AccountID,
+		arg.Category,
+		arg.ExternalReference,
+		arg.Metadata,
+		arg.AfterID,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transaction
+	for rows.Next() {
+		var i Transaction
+		if err := rows.Scan(
+			&i.ID,
+			&i.FromAccount,
+			&i.ToAccount,
+			&i.Amount,
+			&i.CreatedAt,
+			&i.PrevHash,
+			&i.Hash,
+			&i.Description,
+			&i.ExternalReference,
+			&i.Category,
+			&i.Metadata,
+			&i.ChainVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -243,3 +1963,297 @@ func (q *Queries) GetUsers(ctx context.Context) ([]Account, error) {
 	}
 	return items, nil
 }
+
+const setTransactionHash = `-- name: SetTransactionHash :one
+UPDATE transactions
+SET prev_hash = $2, hash = $3
+WHERE id = $1
+RETURNING id, from_account, to_account, amount, created_at, prev_hash, hash, description, external_reference, category, metadata, chain_version
+`
+
+type SetTransactionHashParams struct {
+	ID       int64  `json:"id"`
+	PrevHash []byte `json:"prev_hash"`
+	Hash     []byte `json:"hash"`
+}
+
+func (q *Queries) SetTransactionHash(ctx context.Context, arg SetTransactionHashParams) (Transaction, error) {
+	row := q.db.QueryRowContext(ctx, setTransactionHash, arg.ID, arg.PrevHash, arg.Hash)
+	var i Transaction
+	err := row.Scan(
+		&i.ID,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Amount,
+		&i.CreatedAt,
+		&i.PrevHash,
+		&i.Hash,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+		&i.ChainVersion,
+	)
+	return i, err
+}
+
+const sumUnpostedInterest = `-- name: SumUnpostedInterest :one
+SELECT COALESCE(SUM(amount), 0)::bigint AS amount, COUNT(*) AS accruals, MIN(day)::date AS from_day, MAX(day)::date AS to_day
+FROM interest_accruals
+WHERE account_id = $1 AND posted_at IS NULL
+  AND ($2::date IS NULL OR day < $2)
+`
+
+type SumUnpostedInterestParams struct {
+	AccountID int64        `json:"account_id"`
+	Before    sql.NullTime `json:"before"`
+}
+
+type SumUnpostedInterestRow struct {
+	Amount   int64        `json:"amount"`
+	Accruals int64        `json:"accruals"`
+	FromDay  sql.NullTime `json:"from_day"`
+	ToDay    sql.NullTime `json:"to_day"`
+}
+
+func (q *Queries) SumUnpostedInterest(ctx context.Context, arg SumUnpostedInterestParams) (SumUnpostedInterestRow, error) {
+	row := q.db.QueryRowContext(ctx, sumUnpostedInterest, arg.AccountID, arg.Before)
+	var i SumUnpostedInterestRow
+	err := row.Scan(
+		&i.Amount,
+		&i.Accruals,
+		&i.FromDay,
+		&i.ToDay,
+	)
+	return i, err
+}
+
+const updateAccountOverdraftLimit = `-- name: UpdateAccountOverdraftLimit :one
+UPDATE accounts
+SET overdraft_limit = $2
+WHERE id = $1
+RETURNING id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata
+`
+
+type UpdateAccountOverdraftLimitParams struct {
+	ID             int64 `json:"id"`
+	OverdraftLimit int64 `json:"overdraft_limit"`
+}
+
+func (q *Queries) UpdateAccountOverdraftLimit(ctx context.Context, arg UpdateAccountOverdraftLimitParams) (Account, error) {
+	row := q.db.QueryRowContext(ctx, updateAccountOverdraftLimit, arg.ID, arg.OverdraftLimit)
+	var i Account
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+	)
+	return i, err
+}
+
+const updateAccountStatus = `-- name: UpdateAccountStatus :one
+UPDATE accounts
+SET status = $2, status_reason = $3, status_updated_at = now()
+WHERE id = $1
+RETURNING id, username, balance, email, created_at, status, status_reason, status_updated_at, account_type, overdraft_limit, description, external_reference, category, metadata
+`
+
+type UpdateAccountStatusParams struct {
+	ID           int64          `json:"id"`
+	Status       string         `json:"status"`
+	StatusReason sql.NullString `json:"status_reason"`
+}
+
+func (q *Queries) UpdateAccountStatus(ctx context.Context, arg UpdateAccountStatusParams) (Account, error) {
+	row := q.db.QueryRowContext(ctx, updateAccountStatus, arg.ID, arg.Status, arg.StatusReason)
+	var i Account
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Balance,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusUpdatedAt,
+		&i.AccountType,
+		&i.OverdraftLimit,
+		&i.Description,
+		&i.ExternalReference,
+		&i.Category,
+		&i.Metadata,
+	)
+	return i, err
+}
+
+const updateExternalTransferStatus = `-- name: UpdateExternalTransferStatus :one
+UPDATE external_transfers
+SET status = $2, failure_reason = $3, transaction_id = $4, reversal_transaction_id = $5, updated_at = now()
+WHERE id = $1
+RETURNING id, kind, account_id, system_account_id, amount, external_reference, status, failure_reason, transaction_id, reversal_transaction_id, created_at, updated_at
+`
+
+type UpdateExternalTransferStatusParams struct {
+	ID                    int64          `json:"id"`
+	Status                string         `json:"status"`
+	FailureReason         sql.NullString `json:"failure_reason"`
+	TransactionID         sql.NullInt64  `json:"transaction_id"`
+	ReversalTransactionID sql.NullInt64  `json:"reversal_transaction_id"`
+}
+
+func (q *Queries) UpdateExternalTransferStatus(ctx context.Context, arg UpdateExternalTransferStatusParams) (ExternalTransfer, error) {
+	row := q.db.QueryRowContext(ctx, updateExternalTransferStatus,
+		arg.ID,
+		arg.Status,
+		arg.FailureReason,
+		arg.TransactionID,
+		arg.ReversalTransactionID,
+	)
+	var i ExternalTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.AccountID,
+		&i.SystemAccountID,
+		&i.Amount,
+		&i.ExternalReference,
+		&i.Status,
+		&i.FailureReason,
+		&i.TransactionID,
+		&i.ReversalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateFeeRuleStatus = `-- name: UpdateFeeRuleStatus :one
+UPDATE fee_rules
+SET status = $2, updated_at = now()
+WHERE id = $1
+RETURNING id, name, account_type, from_account, to_account, kind, flat_fee, basis_points, min_fee, max_fee, tiers, status, created_at, updated_at
+`
+
+type UpdateFeeRuleStatusParams struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+func (q *Queries) UpdateFeeRuleStatus(ctx context.Context, arg UpdateFeeRuleStatusParams) (FeeRule, error) {
+	row := q.db.QueryRowContext(ctx, updateFeeRuleStatus, arg.ID, arg.Status)
+	var i FeeRule
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.AccountType,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Kind,
+		&i.FlatFee,
+		&i.BasisPoints,
+		&i.MinFee,
+		&i.MaxFee,
+		&i.Tiers,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateLedgerChain = `-- name: UpdateLedgerChain :one
+UPDATE ledger_chain
+SET last_tx_id = $2, hash = $3, updated_at = now()
+WHERE id = $1
+RETURNING id, last_tx_id, hash, updated_at
+`
+
+type UpdateLedgerChainParams struct {
+	ID       int64  `json:"id"`
+	LastTxID int64  `json:"last_tx_id"`
+	Hash     []byte `json:"hash"`
+}
+
+func (q *Queries) UpdateLedgerChain(ctx context.Context, arg UpdateLedgerChainParams) (LedgerChain, error) {
+	row := q.db.QueryRowContext(ctx, updateLedgerChain, arg.ID, arg.LastTxID, arg.Hash)
+	var i LedgerChain
+	err := row.Scan(
+		&i.ID,
+		&i.LastTxID,
+		&i.Hash,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateScheduledTransferStatus = `-- name: UpdateScheduledTransferStatus :one
+UPDATE scheduled_transfers
+SET status = $2, next_run_at = $3, updated_at = now()
+WHERE id = $1
+RETURNING id, from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at, last_run_at, status, created_at, updated_at
+`
+
+type UpdateScheduledTransferStatusParams struct {
+	ID        int64        `json:"id"`
+	Status    string       `json:"status"`
+	NextRunAt sql.NullTime `json:"next_run_at"`
+}
+
+func (q *Queries) UpdateScheduledTransferStatus(ctx context.Context, arg UpdateScheduledTransferStatusParams) (ScheduledTransfer, error) {
+	row := q.db.QueryRowContext(ctx, updateScheduledTransferStatus, arg.ID, arg.Status, arg.NextRunAt)
+	var i ScheduledTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.FromAccount,
+		&i.ToAccount,
+		&i.Amount,
+		&i.CronExpr,
+		&i.IntervalSeconds,
+		&i.StartAt,
+		&i.EndAt,
+		&i.NextRunAt,
+		&i.LastRunAt,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertInterestRate = `-- name: UpsertInterestRate :one
+INSERT INTO interest_rates (
+	account_id, annual_rate_bps
+) VALUES (
+	$1, $2
+)
+ON CONFLICT (account_id) DO UPDATE SET annual_rate_bps = EXCLUDED.annual_rate_bps, updated_at = now()
+RETURNING account_id, annual_rate_bps, created_at, updated_at
+`
+
+type UpsertInterestRateParams struct {
+	AccountID     int64 `json:"account_id"`
+	AnnualRateBps int64 `json:"annual_rate_bps"`
+}
+
+func (q *Queries) UpsertInterestRate(ctx context.Context, arg UpsertInterestRateParams) (InterestRate, error) {
+	row := q.db.QueryRowContext(ctx, upsertInterestRate, arg.AccountID, arg.AnnualRateBps)
+	var i InterestRate
+	err := row.Scan(
+		&i.AccountID,
+		&i.AnnualRateBps,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
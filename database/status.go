@@ -0,0 +1,75 @@
+package database
+
+// Account statuses. Transactions may only be posted between active accounts.
+const (
+	AccountStatusActive = "active"
+	AccountStatusFrozen = "frozen"
+	AccountStatusClosed = "closed"
+)
+
+// Account types. System accounts, such as a treasury issuing funds, may have
+// any negative balance while user accounts may not go below minus their
+// overdraft limit.
+const (
+	AccountTypeUser   = "user"
+	AccountTypeSystem = "system"
+)
+
+// System accounts created by migration through which deposits enter and
+// withdrawals leave the ledger, to which fees are paid and from which interest
+// is paid. Their IDs are reserved below the accounts sequence.
+const (
+	DepositsAccountID        int64 = -1
+	WithdrawalsAccountID     int64 = -2
+	FeeRevenueAccountID      int64 = -3
+	InterestExpenseAccountID int64 = -4
+)
+
+// Scheduled transfer statuses. Only active transfers are run. A transfer is
+// completed once its schedule has no further runs before its end.
+const (
+	ScheduledTransferActive    = "active"
+	ScheduledTransferPaused    = "paused"
+	ScheduledTransferCancelled = "cancelled"
+	ScheduledTransferCompleted = "completed"
+)
+
+// Scheduled transfer run statuses.
+const (
+	ScheduledRunSucceeded = "succeeded"
+	ScheduledRunFailed    = "failed"
+)
+
+// External transfer kinds.
+const (
+	ExternalTransferDeposit    = "deposit"
+	ExternalTransferWithdrawal = "withdrawal"
+)
+
+// External transfer statuses. Pending transfers are settled or failed by the
+// payment rail; settled and failed transfers cannot change.
+const (
+	ExternalTransferPending = "pending"
+	ExternalTransferSettled = "settled"
+	ExternalTransferFailed  = "failed"
+)
+
+// Fee rule kinds.
+const (
+	FeeRuleFlat       = "flat"
+	FeeRulePercentage = "percentage"
+	FeeRuleTiered     = "tiered"
+)
+
+// Fee rule statuses. Only active rules are charged.
+const (
+	FeeRuleActive   = "active"
+	FeeRuleDisabled = "disabled"
+)
+
+// Transaction categories set on transactions posted by the ledger itself.
+// Clients may use any other category.
+const (
+	TransactionCategoryFee      = "fee"
+	TransactionCategoryInterest = "interest"
+)
@@ -0,0 +1,555 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/ATMackay/psql-ledger/database"
+
+// NewTracingClient wraps c so that every DBQuery method and every statement
+// executed in a DB transaction is recorded as a span. Spans are children of
+// the span carried by the context passed to each call. DBQuery calls are also
+// logged at debug level with the logger carried by the context.
+func NewTracingClient(c DBClient) DBClient {
+	return tracingClient{DBClient: c}
+}
+
+type tracingClient struct {
+	DBClient
+}
+
+func (c tracingClient) NewQuery() DBQuery {
+	return tracingQuery{q: c.DBClient.NewQuery()}
+}
+
+func (c tracingClient) NewTransaction() (DBTX, error) {
+	tx, err := c.DBClient.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	return tracingTx{tx: tx}, nil
+}
+
+// ExecTx records the transaction as a span with the DBQuery calls made by fn
+// as children.
+func (c tracingClient) ExecTx(ctx context.Context, fn func(DBQuery) error) error {
+	_, err := traced(ctx, "ExecTx", true, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, c.DBClient.ExecTx(ctx, func(q DBQuery) error {
+			return fn(tracingQuery{q: q, inTx: true})
+		})
+	})
+	return err
+}
+
+func (c tracingClient) NewQueryWithTx() (DBQuery, error) {
+	q, err := c.DBClient.NewQueryWithTx()
+	if err != nil {
+		return nil, err
+	}
+	return tracingQuery{q: q, inTx: true}, nil
+}
+
+// traced runs fn inside a client span named after the DBQuery method and logs
+// the query with the request-scoped logger carried by ctx.
+func traced[T any](ctx context.Context, method string, inTx bool, fn func(context.Context) (T, error)) (T, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "DBQuery."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(method), attribute.Bool("db.transaction", inTx)))
+	defer span.End()
+	start := time.Now()
+	res, err := fn(ctx)
+	logging.FromContext(ctx).DebugContext(ctx, "db query", "method", method, "tx", inTx,
+		"elapsed_microseconds", time.Since(start).Microseconds(), "error", err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return res, err
+}
+
+type tracingQuery struct {
+	q    DBQuery
+	inTx bool
+}
+
+func (t tracingQuery) AddAccountBalance(ctx context.Context, arg AddAccountBalanceParams) (Account, error) {
+	return traced(ctx, "AddAccountBalance", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.AddAccountBalance(ctx, arg)
+	})
+}
+
+func (t tracingQuery) AdvanceScheduledTransfer(ctx context.Context, arg AdvanceScheduledTransferParams) (ScheduledTransfer, error) {
+	return traced(ctx, "AdvanceScheduledTransfer", t.inTx, func(ctx context.Context) (ScheduledTransfer, error) {
+		return t.q.AdvanceScheduledTransfer(ctx, arg)
+	})
+}
+
+func (t tracingQuery) ClaimDueScheduledTransfers(ctx context.Context, arg ClaimDueScheduledTransfersParams) ([]ScheduledTransfer, error) {
+	return traced(ctx, "ClaimDueScheduledTransfers", t.inTx, func(ctx context.Context) ([]ScheduledTransfer, error) {
+		return t.q.ClaimDueScheduledTransfers(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CopyAccounts(ctx context.Context, arg []CreateAccountParams) ([]Account, error) {
+	return traced(ctx, "CopyAccounts", t.inTx, func(ctx context.Context) ([]Account, error) {
+		return t.q.CopyAccounts(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CopyTransactions(ctx context.Context, arg []CreateTransactionParams) ([]Transaction, error) {
+	return traced(ctx, "CopyTransactions", t.inTx, func(ctx context.Context) ([]Transaction, error) {
+		return t.q.CopyTransactions(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateAccount(ctx context.Context, arg CreateAccountParams) (Account, error) {
+	return traced(ctx, "CreateAccount", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.CreateAccount(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateAuditEntry(ctx context.Context, arg CreateAuditEntryParams) (AuditLog, error) {
+	return traced(ctx, "CreateAuditEntry", t.inTx, func(ctx context.Context) (AuditLog, error) {
+		return t.q.CreateAuditEntry(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateBalanceCheckpoints(ctx context.Context, arg CreateBalanceCheckpointsParams) (int64, error) {
+	return traced(ctx, "CreateBalanceCheckpoints", t.inTx, func(ctx context.Context) (int64, error) {
+		return t.q.CreateBalanceCheckpoints(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateExternalTransfer(ctx context.Context, arg CreateExternalTransferParams) (ExternalTransfer, error) {
+	return traced(ctx, "CreateExternalTransfer", t.inTx, func(ctx context.Context) (ExternalTransfer, error) {
+		return t.q.CreateExternalTransfer(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateFeeRule(ctx context.Context, arg CreateFeeRuleParams) (FeeRule, error) {
+	return traced(ctx, "CreateFeeRule", t.inTx, func(ctx context.Context) (FeeRule, error) {
+		return t.q.CreateFeeRule(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateInterestAccrual(ctx context.Context, arg CreateInterestAccrualParams) (InterestAccrual, error) {
+	return traced(ctx, "CreateInterestAccrual", t.inTx, func(ctx context.Context) (InterestAccrual, error) {
+		return t.q.CreateInterestAccrual(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateScheduledTransfer(ctx context.Context, arg CreateScheduledTransferParams) (ScheduledTransfer, error) {
+	return traced(ctx, "CreateScheduledTransfer", t.inTx, func(ctx context.Context) (ScheduledTransfer, error) {
+		return t.q.CreateScheduledTransfer(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateScheduledTransferRun(ctx context.Context, arg CreateScheduledTransferRunParams) (ScheduledTransferRun, error) {
+	return traced(ctx, "CreateScheduledTransferRun", t.inTx, func(ctx context.Context) (ScheduledTransferRun, error) {
+		return t.q.CreateScheduledTransferRun(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateTransaction(ctx context.Context, arg CreateTransactionParams) (Transaction, error) {
+	return traced(ctx, "CreateTransaction", t.inTx, func(ctx context.Context) (Transaction, error) {
+		return t.q.CreateTransaction(ctx, arg)
+	})
+}
+
+func (t tracingQuery) CreateTransactionFee(ctx context.Context, arg CreateTransactionFeeParams) (TransactionFee, error) {
+	return traced(ctx, "CreateTransactionFee", t.inTx, func(ctx context.Context) (TransactionFee, error) {
+		return t.q.CreateTransactionFee(ctx, arg)
+	})
+}
+
+func (t tracingQuery) DeleteAccount(ctx context.Context, id int64) error {
+	_, err := traced(ctx, "DeleteAccount", t.inTx, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.q.DeleteAccount(ctx, id)
+	})
+	return err
+}
+
+func (t tracingQuery) DeleteInterestRate(ctx context.Context, accountID int64) error {
+	_, err := traced(ctx, "DeleteInterestRate", t.inTx, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.q.DeleteInterestRate(ctx, accountID)
+	})
+	return err
+}
+
+func (t tracingQuery) ExportAccounts(ctx context.Context, arg ExportParams, fn func(Account) error) error {
+	_, err := traced(ctx, "ExportAccounts", t.inTx, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.q.ExportAccounts(ctx, arg, fn)
+	})
+	return err
+}
+
+func (t tracingQuery) ExportTransactions(ctx context.Context, arg ExportParams, fn func(Transaction) error) error {
+	_, err := traced(ctx, "ExportTransactions", t.inTx, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, t.q.ExportTransactions(ctx, arg, fn)
+	})
+	return err
+}
+
+func (t tracingQuery) GetAccountBalanceAsOf(ctx context.Context, arg GetAccountBalanceAsOfParams) (GetAccountBalanceAsOfRow, error) {
+	return traced(ctx, "GetAccountBalanceAsOf", t.inTx, func(ctx context.Context) (GetAccountBalanceAsOfRow, error) {
+		return t.q.GetAccountBalanceAsOf(ctx, arg)
+	})
+}
+
+func (t tracingQuery) GetAccountBalancesAsOf(ctx context.Context, arg GetAccountBalancesAsOfParams) ([]GetAccountBalancesAsOfRow, error) {
+	return traced(ctx, "GetAccountBalancesAsOf", t.inTx, func(ctx context.Context) ([]GetAccountBalancesAsOfRow, error) {
+		return t.q.GetAccountBalancesAsOf(ctx, arg)
+	})
+}
+
+func (t tracingQuery) GetExternalTransfer(ctx context.Context, id int64) (ExternalTransfer, error) {
+	return traced(ctx, "GetExternalTransfer", t.inTx, func(ctx context.Context) (ExternalTransfer, error) {
+		return t.q.GetExternalTransfer(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetExternalTransferByReference(ctx context.Context, arg GetExternalTransferByReferenceParams) (ExternalTransfer, error) {
+	return traced(ctx, "GetExternalTransferByReference", t.inTx, func(ctx context.Context) (ExternalTransfer, error) {
+		return t.q.GetExternalTransferByReference(ctx, arg)
+	})
+}
+
+func (t tracingQuery) GetExternalTransferForUpdate(ctx context.Context, id int64) (ExternalTransfer, error) {
+	return traced(ctx, "GetExternalTransferForUpdate", t.inTx, func(ctx context.Context) (ExternalTransfer, error) {
+		return t.q.GetExternalTransferForUpdate(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetFeeRule(ctx context.Context, id int64) (FeeRule, error) {
+	return traced(ctx, "GetFeeRule", t.inTx, func(ctx context.Context) (FeeRule, error) {
+		return t.q.GetFeeRule(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetFirstTransactionTime(ctx context.Context) (sql.NullTime, error) {
+	return traced(ctx, "GetFirstTransactionTime", t.inTx, func(ctx context.Context) (sql.NullTime, error) {
+		return t.q.GetFirstTransactionTime(ctx)
+	})
+}
+
+func (t tracingQuery) GetInterestRate(ctx context.Context, accountID int64) (InterestRate, error) {
+	return traced(ctx, "GetInterestRate", t.inTx, func(ctx context.Context) (InterestRate, error) {
+		return t.q.GetInterestRate(ctx, accountID)
+	})
+}
+
+func (t tracingQuery) GetInterestRateForUpdate(ctx context.Context, accountID int64) (InterestRate, error) {
+	return traced(ctx, "GetInterestRateForUpdate", t.inTx, func(ctx context.Context) (InterestRate, error) {
+		return t.q.GetInterestRateForUpdate(ctx, accountID)
+	})
+}
+
+func (t tracingQuery) GetLatestBalanceCheckpointDay(ctx context.Context) (time.Time, error) {
+	return traced(ctx, "GetLatestBalanceCheckpointDay", t.inTx, func(ctx context.Context) (time.Time, error) {
+		return t.q.GetLatestBalanceCheckpointDay(ctx)
+	})
+}
+
+func (t tracingQuery) GetLatestInterestAccrual(ctx context.Context, accountID int64) (InterestAccrual, error) {
+	return traced(ctx, "GetLatestInterestAccrual", t.inTx, func(ctx context.Context) (InterestAccrual, error) {
+		return t.q.GetLatestInterestAccrual(ctx, accountID)
+	})
+}
+
+func (t tracingQuery) GetLedgerChain(ctx context.Context, id int64) (LedgerChain, error) {
+	return traced(ctx, "GetLedgerChain", t.inTx, func(ctx context.Context) (LedgerChain, error) {
+		return t.q.GetLedgerChain(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetLedgerChainForUpdate(ctx context.Context, id int64) (LedgerChain, error) {
+	return traced(ctx, "GetLedgerChainForUpdate", t.inTx, func(ctx context.Context) (LedgerChain, error) {
+		return t.q.GetLedgerChainForUpdate(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetOldestTransactionStart(ctx context.Context) (time.Time, error) {
+	return traced(ctx, "GetOldestTransactionStart", t.inTx, func(ctx context.Context) (time.Time, error) {
+		return t.q.GetOldestTransactionStart(ctx)
+	})
+}
+
+func (t tracingQuery) GetScheduledTransfer(ctx context.Context, id int64) (ScheduledTransfer, error) {
+	return traced(ctx, "GetScheduledTransfer", t.inTx, func(ctx context.Context) (ScheduledTransfer, error) {
+		return t.q.GetScheduledTransfer(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetScheduledTransferForUpdate(ctx context.Context, id int64) (ScheduledTransfer, error) {
+	return traced(ctx, "GetScheduledTransferForUpdate", t.inTx, func(ctx context.Context) (ScheduledTransfer, error) {
+		return t.q.GetScheduledTransferForUpdate(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetTransactionTime(ctx context.Context) (time.Time, error) {
+	return traced(ctx, "GetTransactionTime", t.inTx, func(ctx context.Context) (time.Time, error) {
+		return t.q.GetTransactionTime(ctx)
+	})
+}
+
+func (t tracingQuery) GetTx(ctx context.Context, id int64) (Transaction, error) {
+	return traced(ctx, "GetTx", t.inTx, func(ctx context.Context) (Transaction, error) {
+		return t.q.GetTx(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetTxByExternalReference(ctx context.Context, arg GetTxByExternalReferenceParams) (Transaction, error) {
+	return traced(ctx, "GetTxByExternalReference", t.inTx, func(ctx context.Context) (Transaction, error) {
+		return t.q.GetTxByExternalReference(ctx, arg)
+	})
+}
+
+func (t tracingQuery) GetUser(ctx context.Context, id int64) (Account, error) {
+	return traced(ctx, "GetUser", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.GetUser(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetUserByEmail(ctx context.Context, email sql.NullString) (Account, error) {
+	return traced(ctx, "GetUserByEmail", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.GetUserByEmail(ctx, email)
+	})
+}
+
+func (t tracingQuery) GetUserByExternalReference(ctx context.Context, externalReference sql.NullString) (Account, error) {
+	return traced(ctx, "GetUserByExternalReference", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.GetUserByExternalReference(ctx, externalReference)
+	})
+}
+
+func (t tracingQuery) GetUserByUsername(ctx context.Context, username string) (Account, error) {
+	return traced(ctx, "GetUserByUsername", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.GetUserByUsername(ctx, username)
+	})
+}
+
+func (t tracingQuery) GetUserForUpdate(ctx context.Context, id int64) (Account, error) {
+	return traced(ctx, "GetUserForUpdate", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.GetUserForUpdate(ctx, id)
+	})
+}
+
+func (t tracingQuery) GetUsersByStatus(ctx context.Context, status string) ([]Account, error) {
+	return traced(ctx, "GetUsersByStatus", t.inTx, func(ctx context.Context) ([]Account, error) {
+		return t.q.GetUsersByStatus(ctx, status)
+	})
+}
+
+func (t tracingQuery) GetUsers(ctx context.Context) ([]Account, error) {
+	return traced(ctx, "GetUsers", t.inTx, func(ctx context.Context) ([]Account, error) {
+		return t.q.GetUsers(ctx)
+	})
+}
+
+func (t tracingQuery) GetUserTransactions(ctx context.Context) ([]GetUserTransactionsRow, error) {
+	return traced(ctx, "GetUserTransactions", t.inTx, func(ctx context.Context) ([]GetUserTransactionsRow, error) {
+		return t.q.GetUserTransactions(ctx)
+	})
+}
+
+func (t tracingQuery) ListAccountTransactions(ctx context.Context, arg ListAccountTransactionsParams) ([]ListAccountTransactionsRow, error) {
+	return traced(ctx, "ListAccountTransactions", t.inTx, func(ctx context.Context) ([]ListAccountTransactionsRow, error) {
+		return t.q.ListAccountTransactions(ctx, arg)
+	})
+}
+
+func (t tracingQuery) ListAuditEntries(ctx context.Context, arg ListAuditEntriesParams) ([]AuditLog, error) {
+	return traced(ctx, "ListAuditEntries", t.inTx, func(ctx context.Context) ([]AuditLog, error) {
+		return t.q.ListAuditEntries(ctx, arg)
+	})
+}
+
+func (t tracingQuery) ListExternalTransfers(ctx context.Context, arg ListExternalTransfersParams) ([]ExternalTransfer, error) {
+	return traced(ctx, "ListExternalTransfers", t.inTx, func(ctx context.Context) ([]ExternalTransfer, error) {
+		return t.q.ListExternalTransfers(ctx, arg)
+	})
+}
+
+func (t tracingQuery) ListFeeRules(ctx context.Context, arg ListFeeRulesParams) ([]FeeRule, error) {
+	return traced(ctx, "ListFeeRules", t.inTx, func(ctx context.Context) ([]FeeRule, error) {
+		return t.q.ListFeeRules(ctx, arg)
+	})
+}
+
+func (t tracingQuery) ListInterestRates(ctx context.Context) ([]InterestRate, error) {
+	return traced(ctx, "ListInterestRates", t.inTx, func(ctx context.Context) ([]InterestRate, error) {
+		return t.q.ListInterestRates(ctx)
+	})
+}
+
+func (t tracingQuery) ListMatchingFeeRules(ctx context.Context, arg ListMatchingFeeRulesParams) ([]FeeRule, error) {
+	return traced(ctx, "ListMatchingFeeRules", t.inTx, func(ctx context.Context) ([]FeeRule, error) {
+		return t.q.ListMatchingFeeRules(ctx, arg)
+	})
+}
+
+func (t tracingQuery) ListScheduledTransferRuns(ctx context.Context, arg ListScheduledTransferRunsParams) ([]ScheduledTransferRun, error) {
+	return traced(ctx, "ListScheduledTransferRuns", t.inTx, func(ctx context.Context) ([]ScheduledTransferRun, error) {
+		return t.q.ListScheduledTransferRuns(ctx, arg)
+	})
+}
+
+func (t tracingQuery) ListScheduledTransfers(ctx context.Context, arg ListScheduledTransfersParams) ([]ScheduledTransfer, error) {
+	return traced(ctx, "ListScheduledTransfers", t.inTx, func(ctx context.Context) ([]ScheduledTransfer, error) {
+		return t.q.ListScheduledTransfers(ctx, arg)
+	})
+}
+
+func (t tracingQuery) ListTransactions(ctx context.Context, arg ListTransactionsParams) ([]Transaction, error) {
+	return traced(ctx, "ListTransactions", t.inTx, func(ctx context.Context) ([]Transaction, error) {
+		return t.q.ListTransactions(ctx, arg)
+	})
+}
+
+func (t tracingQuery) MarkInterestAccrualsPosted(ctx context.Context, arg MarkInterestAccrualsPostedParams) (int64, error) {
+	return traced(ctx, "MarkInterestAccrualsPosted", t.inTx, func(ctx context.Context) (int64, error) {
+		return t.q.MarkInterestAccrualsPosted(ctx, arg)
+	})
+}
+
+func (t tracingQuery) SearchAccounts(ctx context.Context, arg SearchAccountsParams) ([]Account, error) {
+	return traced(ctx, "SearchAccounts", t.inTx, func(ctx context.Context) ([]Account, error) {
+		return t.q.SearchAccounts(ctx, arg)
+	})
+}
+
+func (t tracingQuery) SearchTransactions(ctx context.Context, arg SearchTransactionsParams) ([]Transaction, error) {
+	return traced(ctx, "SearchTransactions", t.inTx, func(ctx context.Context) ([]Transaction, error) {
+		return t.q.SearchTransactions(ctx, arg)
+	})
+}
+
+func (t tracingQuery) SetTransactionHash(ctx context.Context, arg SetTransactionHashParams) (Transaction, error) {
+	return traced(ctx, "SetTransactionHash", t.inTx, func(ctx context.Context) (Transaction, error) {
+		return t.q.SetTransactionHash(ctx, arg)
+	})
+}
+
+func (t tracingQuery) SumUnpostedInterest(ctx context.Context, arg SumUnpostedInterestParams) (SumUnpostedInterestRow, error) {
+	return traced(ctx, "SumUnpostedInterest", t.inTx, func(ctx context.Context) (SumUnpostedInterestRow, error) {
+		return t.q.SumUnpostedInterest(ctx, arg)
+	})
+}
+
+func (t tracingQuery) UpdateAccountOverdraftLimit(ctx context.Context, arg UpdateAccountOverdraftLimitParams) (Account, error) {
+	return traced(ctx, "UpdateAccountOverdraftLimit", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.UpdateAccountOverdraftLimit(ctx, arg)
+	})
+}
+
+func (t tracingQuery) UpdateAccountStatus(ctx context.Context, arg UpdateAccountStatusParams) (Account, error) {
+	return traced(ctx, "UpdateAccountStatus", t.inTx, func(ctx context.Context) (Account, error) {
+		return t.q.UpdateAccountStatus(ctx, arg)
+	})
+}
+
+func (t tracingQuery) UpdateExternalTransferStatus(ctx context.Context, arg UpdateExternalTransferStatusParams) (ExternalTransfer, error) {
+	return traced(ctx, "UpdateExternalTransferStatus", t.inTx, func(ctx context.Context) (ExternalTransfer, error) {
+		return t.q.UpdateExternalTransferStatus(ctx, arg)
+	})
+}
+
+func (t tracingQuery) UpdateFeeRuleStatus(ctx context.Context, arg UpdateFeeRuleStatusParams) (FeeRule, error) {
+	return traced(ctx, "UpdateFeeRuleStatus", t.inTx, func(ctx context.Context) (FeeRule, error) {
+		return t.q.UpdateFeeRuleStatus(ctx, arg)
+	})
+}
+
+func (t tracingQuery) UpdateLedgerChain(ctx context.Context, arg UpdateLedgerChainParams) (LedgerChain, error) {
+	return traced(ctx, "UpdateLedgerChain", t.inTx, func(ctx context.Context) (LedgerChain, error) {
+		return t.q.UpdateLedgerChain(ctx, arg)
+	})
+}
+
+func (t tracingQuery) UpdateScheduledTransferStatus(ctx context.Context, arg UpdateScheduledTransferStatusParams) (ScheduledTransfer, error) {
+	return traced(ctx, "UpdateScheduledTransferStatus", t.inTx, func(ctx context.Context) (ScheduledTransfer, error) {
+		return t.q.UpdateScheduledTransferStatus(ctx, arg)
+	})
+}
+
+func (t tracingQuery) UpsertInterestRate(ctx context.Context, arg UpsertInterestRateParams) (InterestRate, error) {
+	return traced(ctx, "UpsertInterestRate", t.inTx, func(ctx context.Context) (InterestRate, error) {
+		return t.q.UpsertInterestRate(ctx, arg)
+	})
+}
+
+func (t tracingQuery) WithTx(tx DBTX) DBQuery {
+	return tracingQuery{q: t.q.WithTx(tx), inTx: true}
+}
+
+// tracingTx records a span for each statement executed in a DB transaction.
+type tracingTx struct {
+	tx DBTX
+}
+
+func (t tracingTx) start(ctx context.Context, op, query string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "DBTX."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatement(query), attribute.Bool("db.transaction", true)))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t tracingTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := t.start(ctx, "ExecContext", query)
+	res, err := t.tx.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+func (t tracingTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := t.start(ctx, "PrepareContext", query)
+	stmt, err := t.tx.PrepareContext(ctx, query)
+	endSpan(span, err)
+	return stmt, err
+}
+
+func (t tracingTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := t.start(ctx, "QueryContext", query)
+	rows, err := t.tx.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (t tracingTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := t.start(ctx, "QueryRowContext", query)
+	row := t.tx.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+// Commit commits the underlying transaction if it supports commits.
+func (t tracingTx) Commit() error {
+	if c, ok := t.tx.(interface{ Commit() error }); ok {
+		return c.Commit()
+	}
+	return nil
+}
+
+// Rollback aborts the underlying transaction if it supports rollbacks.
+func (t tracingTx) Rollback() error {
+	if r, ok := t.tx.(interface{ Rollback() error }); ok {
+		return r.Rollback()
+	}
+	return nil
+}
@@ -62,9 +62,12 @@ func startPSQLContainer(ctx context.Context) (*postgresDBContainer, error) {
 type stack struct {
 	psql       *postgresDBContainer
 	psqlLedger *service.Service
+	cfg        service.Config
 }
 
-func createStack(t testing.TB) *stack {
+// createStack starts postgres and the service. opts change the default test
+// config.
+func createStack(t testing.TB, opts ...func(*service.Config)) *stack {
 	ctx := context.Background()
 
 	// start postgres container
@@ -80,11 +83,11 @@ func createStack(t testing.TB) *stack {
 	cfg.PostgresUser = postgresUsr
 	cfg.PostgresPassword = postgresPswd
 	cfg.PostgresDB = postgresDB
-	cfg.MigrationsPath = "../sqlc/migrations"
 	cfg.LogLevel = "debug"
 	cfg.MaxThreads = 1
-
-	time.Sleep(500 * time.Millisecond) // TODO - code smell, fix
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	psqlLedger, err := service.BuildService(cfg)
 	if err != nil {
@@ -105,7 +108,7 @@ func createStack(t testing.TB) *stack {
 	psqlLedger.Start()
 	time.Sleep(50 * time.Millisecond) // TODO - code smell
 
-	return &stack{psql: psqlContainer, psqlLedger: psqlLedger}
+	return &stack{psql: psqlContainer, psqlLedger: psqlLedger, cfg: cfg}
 }
 
 func executeRequest(methodType, url string, body io.Reader) (*http.Response, error) {
@@ -2,11 +2,14 @@ package integrationtests
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -256,3 +259,268 @@ func Test_MultipleReads(t *testing.T) {
 	elapsed := time.Since(start)
 	fmt.Printf("completed %d writes in %v milliseconds (%v/s)", N, elapsed, (float64(N) * 1000.0 / float64(elapsed.Milliseconds())))
 }
+
+// Test_ConcurrentExternalTransfers creates and settles deposits and creates and
+// fails withdrawals of one account concurrently. Each locks the account and a
+// system account, so a DB transaction locking them out of order would
+// deadlock with another.
+func Test_ConcurrentExternalTransfers(t *testing.T) {
+	s := createStack(t, func(cfg *service.Config) { cfg.MaxThreads = 8 })
+	serverURL := fmt.Sprintf("http://0.0.0.0%v", s.psqlLedger.Server().Addr())
+
+	do := func(method, path string, body any) (database.ExternalTransfer, error) {
+		var et database.ExternalTransfer
+		b, err := json.Marshal(body)
+		if err != nil {
+			return et, err
+		}
+		response, err := executeRequest(method, serverURL+path, bytes.NewReader(b))
+		if err != nil {
+			return et, err
+		}
+		defer response.Body.Close()
+		b, err = io.ReadAll(response.Body)
+		if err != nil {
+			return et, err
+		}
+		if response.StatusCode != http.StatusOK {
+			return et, fmt.Errorf("%v %v: expected %v, got %v: %s", method, path, http.StatusOK, response.StatusCode, b)
+		}
+		return et, json.Unmarshal(b, &et)
+	}
+
+	response, err := executeRequest(http.MethodPut, serverURL+service.CreateAccountEndPnt, strings.NewReader(`{"username":"alice"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var acc database.Account
+	if err := json.NewDecoder(response.Body).Decode(&acc); err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	N := 50
+	errs := make(chan error, 2*N)
+	var wg sync.WaitGroup
+	for n := range N {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			et, err := do(http.MethodPost, service.DepositsEndPnt, service.ExternalTransferRequest{AccountID: acc.ID, Amount: 10, ExternalReference: fmt.Sprint("deposit-", n)})
+			if err == nil {
+				_, err = do(http.MethodPost, fmt.Sprintf("%v/%d/settle", service.DepositsEndPnt, et.ID), nil)
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			// Withdrawals are only made from settled deposits
+			_, err := do(http.MethodPost, service.DepositsEndPnt, service.ExternalTransferRequest{AccountID: acc.ID, Amount: 5, ExternalReference: fmt.Sprint("funding-", n), Status: database.ExternalTransferSettled})
+			if err != nil {
+				errs <- err
+				return
+			}
+			et, err := do(http.MethodPost, service.WithdrawalsEndPnt, service.ExternalTransferRequest{AccountID: acc.ID, Amount: 5, ExternalReference: fmt.Sprint("withdrawal-", n)})
+			if err == nil {
+				_, err = do(http.MethodPost, fmt.Sprintf("%v/%d/fail", service.WithdrawalsEndPnt, et.ID), service.ExternalTransferFailRequest{Reason: "rejected by bank"})
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	response, err = executeRequest(http.MethodPost, serverURL+service.GetAccountEndPnt, strings.NewReader(fmt.Sprintf(`{"id":%d}`, acc.ID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if err := json.NewDecoder(response.Body).Decode(&acc); err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(N * 15); acc.Balance != want {
+		t.Fatalf("expected balance %v, got %v", want, acc.Balance)
+	}
+}
+
+// Test_MigrationsDownUp reverts and reapplies the migrations on a database
+// with transactions that reference the system accounts.
+func Test_MigrationsDownUp(t *testing.T) {
+	s := createStack(t)
+	ctx := context.Background()
+
+	dbClient, err := service.ConnectDB(s.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbClient.DB().Close()
+
+	acc, err := dbClient.NewQuery().CreateAccount(ctx, database.CreateAccountParams{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Deposit(ctx, dbClient, acc.ID, 100, "deposit-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range [][2]int64{{acc.ID, database.FeeRevenueAccountID}, {database.InterestExpenseAccountID, acc.ID}} {
+		if _, err := service.PostTransaction(ctx, dbClient, database.CreateTransactionParams{FromAccount: sql.NullInt64{Int64: p[0], Valid: true}, ToAccount: sql.NullInt64{Int64: p[1], Valid: true}, Amount: sql.NullInt64{Int64: 1, Valid: true}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := service.NewMigrator(s.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// Revert the migrations that create system accounts
+	if err := m.To(20241124090000); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	// The system accounts are kept with their balances
+	for id, balance := range map[int64]int64{database.DepositsAccountID: -100, database.WithdrawalsAccountID: 0, database.FeeRevenueAccountID: 1, database.InterestExpenseAccountID: -1} {
+		sys, err := dbClient.NewQuery().GetUser(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sys.AccountType != database.AccountTypeSystem {
+			t.Fatalf("expected account %d to be a %v account, got %v", id, database.AccountTypeSystem, sys.AccountType)
+		}
+		if sys.Balance != balance {
+			t.Fatalf("expected account %d balance %v, got %v", id, balance, sys.Balance)
+		}
+	}
+
+	if err := m.DownAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test_BalanceReconciliation upgrades a database written before transfers
+// moved account balances and checks that the balances are recomputed from the
+// transactions.
+func Test_BalanceReconciliation(t *testing.T) {
+	s := createStack(t)
+	ctx := context.Background()
+
+	m, err := service.NewMigrator(s.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.DownAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.To(20240129110552); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("pgx", fmt.Sprintf("host=%v port=%v user=%v password=%v dbname=%v sslmode=disable",
+		s.cfg.PostgresHost, s.cfg.PostgresPort, s.cfg.PostgresUser, s.cfg.PostgresPassword, s.cfg.PostgresDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		`INSERT INTO accounts (username, balance) VALUES ('alice', 0), ('bob', 0), ('carol', 0)`,
+		`INSERT INTO transactions (from_account, to_account, amount) VALUES (1, 2, 10), (2, 1, 3), (2, 3, 2)`,
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	dbClient, err := service.ConnectDB(s.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbClient.DB().Close()
+	for id, balance := range map[int64]int64{1: -7, 2: 5, 3: 2} {
+		acc, err := dbClient.NewQuery().GetUser(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acc.Balance != balance {
+			t.Fatalf("expected account %d balance %v, got %v", id, balance, acc.Balance)
+		}
+		// Historical balances, computed from the transactions, agree
+		asOf, err := database.BalanceAsOf(ctx, dbClient.NewQuery(), id, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asOf != acc.Balance {
+			t.Fatalf("expected account %d balance as of now %v, got %v", id, acc.Balance, asOf)
+		}
+	}
+}
+
+func Test_ExportPages(t *testing.T) {
+	s := createStack(t)
+	ctx := context.Background()
+
+	dbClient, err := service.ConnectDB(s.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbClient.DB().Close()
+	db, err := sql.Open("pgx", fmt.Sprintf("host=%v port=%v user=%v password=%v dbname=%v sslmode=disable",
+		s.cfg.PostgresHost, s.cfg.PostgresPort, s.cfg.PostgresUser, s.cfg.PostgresPassword, s.cfg.PostgresDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const n = 2500
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO accounts (username, balance) SELECT 'user' || i, 0 FROM generate_series(1, $1) AS i`, n); err != nil {
+		t.Fatal(err)
+	}
+
+	q := dbClient.NewQuery()
+	var count int
+	var last int64
+	var started time.Time
+	if err := q.ExportAccounts(ctx, database.ExportParams{}, func(acc database.Account) error {
+		if acc.ID <= last {
+			t.Fatalf("expected IDs in order, got %d after %d", acc.ID, last)
+		}
+		last = acc.ID
+		count++
+		switch count {
+		case 1:
+			if started, err = q.GetTransactionTime(ctx); err != nil {
+				return err
+			}
+		case n:
+			// No DB transaction is held open across pages, so the export
+			// does not hold back checkpoints and interest
+			settled, err := database.SettledUntil(ctx, q, started.Add(time.Hour))
+			if err != nil {
+				return err
+			}
+			if !settled.After(started) {
+				t.Fatalf("expected export not to hold back settled time %v, got %v", started, settled)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count < n {
+		t.Fatalf("expected at least %d accounts, got %d", n, count)
+	}
+}
@@ -1,18 +1,26 @@
 package service
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 
 	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+	"github.com/julienschmidt/httprouter"
 )
 
 const (
 	StatusEndPnt = "/status"
 	HealthEndPnt = "/health"
 
+	LivezEndPnt    = "/livez"
+	ReadyzEndPnt   = "/readyz"
+	StartupzEndPnt = "/startupz"
+
+	LogLevelEndPnt = "/admin/log-level"
+
 	AccountsEndPnt             = "/accounts"
 	GetAccountEndPnt           = "/account-by-index"
 	GetAccountByEmailEndPnt    = "/account-by-email"
@@ -20,24 +28,98 @@ const (
 
 	GetAccountTransactionsEndPnt = "/account-txs"
 
-	GetTransactionByIndexEndPnt = "/tx"
+	AccountBalanceEndPnt   = "/v1/accounts/:id/balance"
+	AccountStatementEndPnt = "/v1/accounts/:id/statement"
+	AccountInterestEndPnt  = "/v1/accounts/:id/interest"
+	BalancesEndPnt         = "/v1/balances"
 
-	CreateTxEndPnt      = "/create-tx"
-	CreateAccountEndPnt = "/create-account"
+	GetTransactionByIndexEndPnt = "/tx"
+	TransactionsEndPnt          = "/transactions"
+
+	CreateTxEndPnt       = "/create-tx"
+	BatchTxEndPnt        = "/v1/transactions/batch"
+	CreateAccountEndPnt  = "/create-account"
+	AccountStatusEndPnt  = "/account-status"
+	OverdraftLimitEndPnt = "/v1/accounts/:id/overdraft-limit"
+	InterestRateEndPnt   = "/v1/accounts/:id/interest-rate"
+
+	AuditEndPnt  = "/audit"
+	VerifyEndPnt = "/verify"
+
+	ImportEndPnt    = "/v1/import"
+	ImportJobEndPnt = "/v1/import/:id"
+
+	ExportAccountsEndPnt     = "/v1/export/accounts"
+	ExportTransactionsEndPnt = "/v1/export/transactions"
+
+	ScheduledTransfersEndPnt      = "/v1/scheduled-transfers"
+	ScheduledTransferEndPnt       = "/v1/scheduled-transfers/:id"
+	ScheduledTransferRunsEndPnt   = "/v1/scheduled-transfers/:id/runs"
+	PauseScheduledTransferEndPnt  = "/v1/scheduled-transfers/:id/pause"
+	ResumeScheduledTransferEndPnt = "/v1/scheduled-transfers/:id/resume"
+	CancelScheduledTransferEndPnt = "/v1/scheduled-transfers/:id/cancel"
+
+	DepositsEndPnt         = "/v1/deposits"
+	DepositEndPnt          = "/v1/deposits/:id"
+	SettleDepositEndPnt    = "/v1/deposits/:id/settle"
+	FailDepositEndPnt      = "/v1/deposits/:id/fail"
+	WithdrawalsEndPnt      = "/v1/withdrawals"
+	WithdrawalEndPnt       = "/v1/withdrawals/:id"
+	SettleWithdrawalEndPnt = "/v1/withdrawals/:id/settle"
+	FailWithdrawalEndPnt   = "/v1/withdrawals/:id/fail"
+
+	FeeRulesEndPnt       = "/v1/fee-rules"
+	FeeRuleEndPnt        = "/v1/fee-rules/:id"
+	DisableFeeRuleEndPnt = "/v1/fee-rules/:id/disable"
 )
 
-func makeServiceAPIs(dbClient database.DBClient) *API {
+// makeServiceAPIs declares the routes of the service. Handlers read and write
+// through dbClient; import jobs are queued on im.
+func makeServiceAPIs(s *Service, dbClient database.DBClient, im *importer, config Config) *API {
+	schema, draining, health := s.schema, s.Draining, s.health
 	return MakeAPI([]EndPoint{
 		{
 			Path:       StatusEndPnt,
-			Handler:    Status(),
+			Public:     true,
+			Handler:    Status(schema),
 			MethodType: http.MethodGet,
 		},
 		{
 			Path:       HealthEndPnt,
-			Handler:    Health(dbClient),
+			Public:     true,
+			Handler:    Health(dbClient, schema, draining),
+			MethodType: http.MethodGet,
+		},
+		{
+			Path:       LivezEndPnt,
+			Public:     true,
+			Handler:    ProbeHandler(health, ProbeLiveness),
 			MethodType: http.MethodGet,
 		},
+		{
+			Path:       ReadyzEndPnt,
+			Public:     true,
+			Handler:    ProbeHandler(health, ProbeReadiness),
+			MethodType: http.MethodGet,
+		},
+		{
+			Path:       StartupzEndPnt,
+			Public:     true,
+			Handler:    ProbeHandler(health, ProbeStartup),
+			MethodType: http.MethodGet,
+		},
+		{
+			Path:       LogLevelEndPnt,
+			Admin:      true,
+			Handler:    GetLogLevel(s.Logging),
+			MethodType: http.MethodGet,
+		},
+		{
+			Path:       LogLevelEndPnt,
+			Admin:      true,
+			Handler:    SetLogLevel(s.Logging),
+			MethodType: http.MethodPut,
+		},
 		{
 			Path:       AccountsEndPnt,
 			Handler:    Accounts(dbClient),
@@ -47,68 +129,281 @@ func makeServiceAPIs(dbClient database.DBClient) *API {
 			Path:       GetAccountEndPnt,
 			Handler:    AccountByIndex(dbClient),
 			MethodType: http.MethodPost,
+			RateClass:  RateClassRead,
 		},
 		{
 			Path:       GetAccountByEmailEndPnt,
 			Handler:    AccountByEmail(dbClient),
 			MethodType: http.MethodPost,
+			RateClass:  RateClassRead,
 		},
 		{
 			Path:       GetAccountByUsernameEndPnt,
 			Handler:    AccountByUsername(dbClient),
 			MethodType: http.MethodPost,
+			RateClass:  RateClassRead,
 		},
 		{
 			Path:       GetAccountTransactionsEndPnt,
 			Handler:    TxHistory(dbClient),
 			MethodType: http.MethodPost,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       AccountBalanceEndPnt,
+			Handler:    AccountBalance(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       AccountStatementEndPnt,
+			Handler:    AccountStatement(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       BalancesEndPnt,
+			Handler:    Balances(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       TransactionsEndPnt,
+			Handler:    Transactions(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
 		},
 		{
 			Path:       GetTransactionByIndexEndPnt,
 			Handler:    TransactionByIndex(dbClient),
 			MethodType: http.MethodPost,
+			RateClass:  RateClassRead,
 		},
 		{
 			Path:       CreateTxEndPnt,
 			Handler:    CreateTx(dbClient),
 			MethodType: http.MethodPut,
 		},
+		{
+			Path:       BatchTxEndPnt,
+			Handler:    BatchTx(dbClient, config.MaxBatchSize),
+			MethodType: http.MethodPost,
+		},
 		{
 			Path:       CreateAccountEndPnt,
 			Handler:    CreateAccount(dbClient),
 			MethodType: http.MethodPut,
 		},
+		{
+			Path:       AccountStatusEndPnt,
+			Handler:    SetAccountStatus(dbClient),
+			MethodType: http.MethodPut,
+		},
+		{
+			Path:       OverdraftLimitEndPnt,
+			Handler:    SetOverdraftLimit(dbClient),
+			MethodType: http.MethodPut,
+		},
+		{
+			Path:       InterestRateEndPnt,
+			Handler:    SetInterestRate(dbClient),
+			MethodType: http.MethodPut,
+		},
+		{
+			Path:       AccountInterestEndPnt,
+			Handler:    AccountInterest(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       AuditEndPnt,
+			Admin:      true,
+			Handler:    Audit(dbClient),
+			MethodType: http.MethodGet,
+		},
+		{
+			Path:       VerifyEndPnt,
+			Admin:      true,
+			Handler:    VerifyChain(dbClient),
+			MethodType: http.MethodGet,
+		},
+		{
+			Path:       ImportEndPnt,
+			Handler:    im.Import(config.ImportMaxBytes),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       ImportJobEndPnt,
+			Handler:    im.Job(),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       ExportAccountsEndPnt,
+			Handler:    ExportAccounts(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       ExportTransactionsEndPnt,
+			Handler:    ExportTransactions(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       ScheduledTransfersEndPnt,
+			Handler:    CreateScheduledTransfer(dbClient),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       ScheduledTransfersEndPnt,
+			Handler:    ScheduledTransfers(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       ScheduledTransferEndPnt,
+			Handler:    ScheduledTransfer(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       ScheduledTransferRunsEndPnt,
+			Handler:    ScheduledTransferRuns(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       PauseScheduledTransferEndPnt,
+			Handler:    PauseScheduledTransfer(dbClient),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       ResumeScheduledTransferEndPnt,
+			Handler:    ResumeScheduledTransfer(dbClient),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       CancelScheduledTransferEndPnt,
+			Handler:    CancelScheduledTransfer(dbClient),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       DepositsEndPnt,
+			Handler:    CreateExternalTransfer(dbClient, database.ExternalTransferDeposit),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       DepositsEndPnt,
+			Handler:    ExternalTransfers(dbClient, database.ExternalTransferDeposit),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       DepositEndPnt,
+			Handler:    ExternalTransfer(dbClient, database.ExternalTransferDeposit),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       SettleDepositEndPnt,
+			Handler:    SettleExternalTransfer(dbClient, database.ExternalTransferDeposit),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       FailDepositEndPnt,
+			Handler:    FailExternalTransfer(dbClient, database.ExternalTransferDeposit),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       WithdrawalsEndPnt,
+			Handler:    CreateExternalTransfer(dbClient, database.ExternalTransferWithdrawal),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       WithdrawalsEndPnt,
+			Handler:    ExternalTransfers(dbClient, database.ExternalTransferWithdrawal),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       WithdrawalEndPnt,
+			Handler:    ExternalTransfer(dbClient, database.ExternalTransferWithdrawal),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       SettleWithdrawalEndPnt,
+			Handler:    SettleExternalTransfer(dbClient, database.ExternalTransferWithdrawal),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       FailWithdrawalEndPnt,
+			Handler:    FailExternalTransfer(dbClient, database.ExternalTransferWithdrawal),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       FeeRulesEndPnt,
+			Handler:    CreateFeeRule(dbClient),
+			MethodType: http.MethodPost,
+		},
+		{
+			Path:       FeeRulesEndPnt,
+			Handler:    FeeRules(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       FeeRuleEndPnt,
+			Handler:    FeeRule(dbClient),
+			MethodType: http.MethodGet,
+			RateClass:  RateClassRead,
+		},
+		{
+			Path:       DisableFeeRuleEndPnt,
+			Handler:    DisableFeeRule(dbClient),
+			MethodType: http.MethodPost,
+		},
 	})
 }
 
 // GET REQUESTS
 
-// StatusResponse contains status response fields.
+// StatusResponse contains status response fields. SchemaVersion is the DB
+// schema version expected by the running binary.
 type StatusResponse struct {
-	Message string `json:"message,omitempty"`
-	Version string `json:"version,omitempty"`
-	Service string `json:"service,omitempty"`
+	Message       string `json:"message,omitempty"`
+	Version       string `json:"version,omitempty"`
+	Service       string `json:"service,omitempty"`
+	SchemaVersion uint   `json:"schema_version,omitempty"`
 }
 
 // Status implements the status request endpoint. Always returns OK.
-func Status() http.HandlerFunc {
+func Status(schema *schemaInfo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := RespondWithJSON(w, http.StatusOK, &StatusResponse{Message: "OK", Version: Version, Service: ServiceName}); err != nil {
+		status := &StatusResponse{Message: "OK", Version: Version, Service: ServiceName}
+		if schema != nil {
+			status.SchemaVersion = schema.expected
+		}
+		if err := RespondWithJSON(w, http.StatusOK, status); err != nil {
 			RespondWithError(w, http.StatusInternalServerError, err)
 		}
 	}
 
 }
 
-// HealthResponse contains status response fields.
+// HealthResponse contains status response fields. SchemaVersion is the schema
+// version currently recorded in the DB.
 type HealthResponse struct {
-	Version  string   `json:"version,omitempty"`
-	Service  string   `json:"service,omitempty"`
-	Failures []string `json:"failures"`
+	Version       string   `json:"version,omitempty"`
+	Service       string   `json:"service,omitempty"`
+	SchemaVersion uint     `json:"schema_version,omitempty"`
+	Failures      []string `json:"failures"`
 }
 
-// Health pings the connected DB instance.
-func Health(dbClient database.DBClient) http.HandlerFunc {
+// Health pings the connected DB instance and verifies the DB schema version.
+// The service reports as unavailable while it is shutting down.
+func Health(dbClient database.DBClient, schema *schemaInfo, draining func() bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		health := &HealthResponse{
 			Service: ServiceName,
@@ -116,6 +411,10 @@ func Health(dbClient database.DBClient) http.HandlerFunc {
 		}
 		var failures = []string{}
 		var httpCode = http.StatusOK
+		if draining != nil && draining() {
+			failures = append(failures, "service: shutting down")
+			httpCode = http.StatusServiceUnavailable
+		}
 		if dbClient == nil || dbClient.DB() == nil {
 			failures = append(failures, "DB: No connection")
 			httpCode = http.StatusServiceUnavailable
@@ -129,6 +428,16 @@ func Health(dbClient database.DBClient) http.HandlerFunc {
 		if err := dbClient.DB().Ping(); err != nil {
 			failures = append(failures, fmt.Sprintf("DB: %v", err))
 			httpCode = http.StatusServiceUnavailable
+		} else if schema != nil {
+			version, dirty, err := dbClient.SchemaVersion(r.Context(), schema.table)
+			if err == nil {
+				health.SchemaVersion = version
+				err = schema.check(version, dirty)
+			}
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("DB schema: %v", err))
+				httpCode = http.StatusServiceUnavailable
+			}
 		}
 
 		health.Failures = failures
@@ -139,6 +448,51 @@ func Health(dbClient database.DBClient) http.HandlerFunc {
 	}
 }
 
+// LogLevelRequest changes the level of a log sink. All sinks are changed if
+// Sink is empty.
+type LogLevelRequest struct {
+	Sink  string `json:"sink,omitempty"`
+	Level string `json:"level"`
+}
+
+// GetLogLevel returns the current level of each log sink.
+func GetLogLevel(logs func() *Logging) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := logs()
+		if l == nil {
+			RespondWithError(w, http.StatusNotFound, "logging not configured")
+			return
+		}
+		if err := RespondWithJSON(w, http.StatusOK, l.Levels()); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// SetLogLevel changes the level of a log sink while the service is running.
+func SetLogLevel(logs func() *Logging) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := logs()
+		if l == nil {
+			RespondWithError(w, http.StatusNotFound, "logging not configured")
+			return
+		}
+		var req LogLevelRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := l.SetLevel(req.Sink, req.Level); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "log level changed", "sink", req.Sink, "level", req.Level, "principal", Principal(r.Context()))
+		if err := RespondWithJSON(w, http.StatusOK, l.Levels()); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
 const (
 	// This regex defines the regular expression for simple email formats
 	//
@@ -167,7 +521,14 @@ func validAccountParams(c database.CreateAccountParams) error {
 			return fmt.Errorf("invalid username: %v", err)
 		}
 	}
-	return nil
+	// System accounts are only created by migration
+	if c.AccountType != "" && c.AccountType != database.AccountTypeUser {
+		return fmt.Errorf("invalid account type '%v', only %v accounts can be created", c.AccountType, database.AccountTypeUser)
+	}
+	if c.ExternalReference.Valid && c.ExternalReference.String == "" {
+		return fmt.Errorf("external reference cannot be empty")
+	}
+	return validMetadata(c.Metadata)
 }
 
 func isValidString(input string, regex string) error {
@@ -183,11 +544,41 @@ func isValidString(input string, regex string) error {
 	return nil
 }
 
-// Accounts requests the full list if accounts stored in the DB - TODO paginate this request
+// Accounts requests the full list if accounts stored in the DB - TODO paginate this request.
+// The list can be filtered by account status with ?status=, and by ?category=,
+// ?external_reference= and ?metadata.<key>=.
 func Accounts(dbClient database.DBClient) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		status := query.Get("status")
+		if status != "" {
+			if err := validAccountStatus(status); err != nil {
+				RespondWithError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		metadata, err := metadataFilter(query)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		search := database.SearchAccountsParams{
+			Status:            nullString(query, "status"),
+			Category:          nullString(query, "category"),
+			ExternalReference: nullString(query, "external_reference"),
+			Metadata:          metadata,
+		}
+
 		// Execute Query against PSQL
-		acc, err := dbClient.NewQuery().GetUsers(context.Background())
+		var acc []database.Account
+		switch {
+		case search.Category.Valid || search.ExternalReference.Valid || string(metadata) != "{}":
+			acc, err = dbClient.NewQuery().SearchAccounts(r.Context(), search)
+		case status != "":
+			acc, err = dbClient.NewQuery().GetUsersByStatus(r.Context(), status)
+		default:
+			acc, err = dbClient.NewQuery().GetUsers(r.Context())
+		}
 		if err != nil {
 			if err.Error() != database.ErrNotFound.Error() {
 				RespondWithError(w, http.StatusInternalServerError, err)
@@ -223,7 +614,7 @@ func AccountByIndex(dbClient database.DBClient) http.HandlerFunc {
 		}
 
 		// Execute Query against PSQL
-		acc, err := dbClient.NewQuery().GetUser(context.Background(), c.ID)
+		acc, err := dbClient.NewQuery().GetUser(r.Context(), c.ID)
 		if err != nil {
 			if err.Error() != database.ErrNotFound.Error() {
 				RespondWithError(w, http.StatusInternalServerError, err)
@@ -257,7 +648,7 @@ func AccountByUsername(dbClient database.DBClient) http.HandlerFunc {
 		}
 
 		// Execute Query against PSQL
-		acc, err := dbClient.NewQuery().GetUserByUsername(context.Background(), c.Username)
+		acc, err := dbClient.NewQuery().GetUserByUsername(r.Context(), c.Username)
 		if err != nil {
 			if err.Error() != database.ErrNotFound.Error() {
 				RespondWithError(w, http.StatusInternalServerError, err)
@@ -295,7 +686,7 @@ func AccountByEmail(dbClient database.DBClient) http.HandlerFunc {
 			return
 		}
 		// Execute Query against PSQL
-		acc, err := dbClient.NewQuery().GetUserByEmail(context.Background(), c.Email)
+		acc, err := dbClient.NewQuery().GetUserByEmail(r.Context(), c.Email)
 		if err != nil {
 			if err.Error() != database.ErrNotFound.Error() {
 				RespondWithError(w, http.StatusInternalServerError, err)
@@ -333,7 +724,7 @@ func TransactionByIndex(dbClient database.DBClient) http.HandlerFunc {
 		}
 
 		// Execute Query against PSQL
-		tx, err := dbClient.NewQuery().GetTx(context.Background(), txParams.ID)
+		tx, err := dbClient.NewQuery().GetTx(r.Context(), txParams.ID)
 		if err != nil {
 			if err.Error() != database.ErrNotFound.Error() {
 				RespondWithError(w, http.StatusInternalServerError, err)
@@ -370,7 +761,7 @@ func TxHistory(dbClient database.DBClient) http.HandlerFunc {
 		}
 
 		// Execute Query against PSQL
-		txs, err := dbClient.NewQuery().GetUserTransactions(context.Background())
+		txs, err := dbClient.NewQuery().GetUserTransactions(r.Context())
 		if err != nil {
 			if err.Error() != database.ErrNotFound.Error() {
 				RespondWithError(w, http.StatusInternalServerError, err)
@@ -417,26 +808,40 @@ func CreateAccount(dbClient database.DBClient) http.HandlerFunc {
 		}
 
 		// Verify uniqueness
-		if u, _ := dbClient.NewQuery().GetUserByUsername(context.Background(), c.Username); u.ID != 0 {
+		if u, _ := dbClient.NewQuery().GetUserByUsername(r.Context(), c.Username); u.ID != 0 {
 			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("username already exists"))
 			return
 		}
 
-		if u, _ := dbClient.NewQuery().GetUserByEmail(context.Background(), c.Email); u.Email.Valid {
+		if u, _ := dbClient.NewQuery().GetUserByEmail(r.Context(), c.Email); u.Email.Valid {
 			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("email already exists"))
 			return
 		}
 
+		if c.ExternalReference.Valid {
+			if u, _ := dbClient.NewQuery().GetUserByExternalReference(r.Context(), c.ExternalReference); u.ID != 0 {
+				RespondWithError(w, http.StatusConflict, fmt.Errorf("external reference already exists"))
+				return
+			}
+		}
+
 		// Execute Query against PSQL
-		acc, err := dbClient.NewQuery().CreateAccount(context.Background(), database.CreateAccountParams{
-			Email:    c.Email,
-			Username: c.Username,
-			Balance:  0,
+		acc, err := dbClient.NewQuery().CreateAccount(r.Context(), database.CreateAccountParams{
+			Email:             c.Email,
+			Username:          c.Username,
+			Balance:           0,
+			AccountType:       c.AccountType,
+			Description:       c.Description,
+			ExternalReference: c.ExternalReference,
+			Category:          c.Category,
+			Metadata:          metadataOrEmpty(c.Metadata),
 		})
 		if err != nil {
+			logging.FromContext(r.Context()).ErrorContext(r.Context(), "cannot create account", "error", err)
 			RespondWithError(w, http.StatusInternalServerError, err)
 			return
 		}
+		logging.FromContext(r.Context()).DebugContext(r.Context(), "account created", "account_id", acc.ID)
 
 		if err := RespondWithJSON(w, http.StatusOK, acc); err != nil {
 			RespondWithError(w, http.StatusInternalServerError, err)
@@ -447,7 +852,8 @@ func CreateAccount(dbClient database.DBClient) http.HandlerFunc {
 }
 
 // CreateTx posts a new transaction to the DB. Transaction fields
-// are validated before the tx is registered
+// are validated before the tx is registered. The transaction is recorded, any
+// fees charged and the account balances updated in a single DB transaction.
 func CreateTx(dbClient database.DBClient) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var txParams database.CreateTransactionParams
@@ -456,45 +862,119 @@ func CreateTx(dbClient database.DBClient) http.HandlerFunc {
 			return
 		}
 
-		// Validate amount
-		if txParams.Amount.Int64 <= 0 {
-			err := fmt.Errorf("cannot send negative amount '%v'", txParams.Amount)
+		if err := validTxParams(txParams); err != nil {
 			RespondWithError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		if txParams.FromAccount.Int64 == txParams.ToAccount.Int64 {
-			err := fmt.Errorf("to and from account cannot match")
-			RespondWithError(w, http.StatusBadRequest, err)
+		// Execute Query against PSQL
+		var resp TxResponse
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			var err error
+			resp, err = postTransactionWithFees(r.Context(), q, txParams)
+			return err
+		}); err != nil {
+			logging.FromContext(r.Context()).WarnContext(r.Context(), "cannot create transaction", "error", err)
+			respondWithErr(w, err)
 			return
 		}
+		logging.FromContext(r.Context()).DebugContext(r.Context(), "transaction created", "tx_id", resp.ID,
+			"from_account", resp.FromAccount.Int64, "to_account", resp.ToAccount.Int64, "amount", resp.Amount.Int64, "fee", resp.TotalFee)
 
-		// Check to and from account exist
-		if _, err := dbClient.NewQuery().GetUser(context.Background(), txParams.FromAccount.Int64); err != nil {
+		if err := RespondWithJSON(w, http.StatusOK, resp); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+
+	}
+
+}
+
+// AccountStatusRequest changes the status of an account. A reason must be given.
+type AccountStatusRequest struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// SetAccountStatus freezes, unfreezes or closes an account. Frozen and closed
+// accounts cannot send or receive transactions and closed accounts cannot be
+// reopened.
+func SetAccountStatus(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AccountStatusRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
 			RespondWithError(w, http.StatusBadRequest, err)
 			return
 		}
-		if _, err := dbClient.NewQuery().GetUser(context.Background(), txParams.ToAccount.Int64); err != nil {
+		if req.ID == 0 {
+			RespondWithError(w, http.StatusBadRequest, "cannot supply account ID = 0")
+			return
+		}
+		if err := validAccountStatus(req.Status); err != nil {
 			RespondWithError(w, http.StatusBadRequest, err)
 			return
 		}
+		if req.Reason == "" {
+			RespondWithError(w, http.StatusBadRequest, "a reason must be supplied")
+			return
+		}
 
-		// Execute Query against PSQL
-		tx, err := dbClient.NewQuery().CreateTransaction(context.Background(), database.CreateTransactionParams{
-			FromAccount: txParams.FromAccount,
-			ToAccount:   txParams.ToAccount,
-			Amount:      txParams.Amount,
-		})
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, err)
+		var acc database.Account
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			var err error
+			acc, err = changeAccountStatus(r.Context(), q, req.ID, req.Status, req.Reason)
+			return err
+		}); err != nil {
+			respondWithErr(w, err)
 			return
 		}
-		// TODO, update user balance - requires using DB Tx (with rollback)
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "account status changed", "account_id", acc.ID,
+			"status", acc.Status, "reason", req.Reason, "principal", Principal(r.Context()))
 
-		if err := RespondWithJSON(w, http.StatusOK, tx); err != nil {
+		if err := RespondWithJSON(w, http.StatusOK, acc); err != nil {
 			RespondWithError(w, http.StatusInternalServerError, err)
 		}
-
 	}
+}
+
+// OverdraftLimitRequest changes the overdraft limit of an account.
+type OverdraftLimitRequest struct {
+	OverdraftLimit int64 `json:"overdraft_limit"`
+}
 
+// SetOverdraftLimit changes how far below zero the user account :id may go.
+// The change is recorded in the audit log.
+func SetOverdraftLimit(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid account id"))
+			return
+		}
+		var req OverdraftLimitRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.OverdraftLimit < 0 {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("overdraft limit cannot be negative"))
+			return
+		}
+
+		var acc database.Account
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			var err error
+			acc, err = setOverdraftLimit(r.Context(), q, id, req.OverdraftLimit)
+			return err
+		}); err != nil {
+			respondWithErr(w, err)
+			return
+		}
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "account overdraft limit changed", "account_id", acc.ID,
+			"overdraft_limit", acc.OverdraftLimit, "principal", Principal(r.Context()))
+
+		if err := RespondWithJSON(w, http.StatusOK, acc); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
 }
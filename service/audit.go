@@ -0,0 +1,92 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// Audit lists audit log entries in the order they were written. Entries can be
+// filtered with ?entity_type=, ?entity_id=, ?from= and ?to= (RFC 3339, from
+// inclusive and to exclusive). Results are paged with ?after_id= and ?limit=.
+func Audit(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := auditParams(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		entries, err := dbClient.NewQuery().ListAuditEntries(r.Context(), params)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if entries == nil {
+			entries = []database.AuditLog{}
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, entries); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func auditParams(v url.Values) (database.ListAuditEntriesParams, error) {
+	p := database.ListAuditEntriesParams{MaxResults: defaultAuditLimit}
+	if s := v.Get("entity_type"); s != "" {
+		switch s {
+		case database.AuditEntityAccount, database.AuditEntityTransaction, database.AuditEntityScheduledTransfer,
+			database.AuditEntityExternalTransfer, database.AuditEntityFeeRule:
+		default:
+			return p, fmt.Errorf("invalid entity_type '%v', must be one of %v|%v|%v|%v|%v", s, database.AuditEntityAccount,
+				database.AuditEntityTransaction, database.AuditEntityScheduledTransfer, database.AuditEntityExternalTransfer,
+				database.AuditEntityFeeRule)
+		}
+		p.EntityType = sql.NullString{String: s, Valid: true}
+	}
+	if s := v.Get("entity_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid entity_id '%v'", s)
+		}
+		p.EntityID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	for _, t := range []struct {
+		name string
+		dst  *sql.NullTime
+	}{{"from", &p.FromTime}, {"to", &p.ToTime}} {
+		if s := v.Get(t.name); s != "" {
+			ts, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return p, fmt.Errorf("invalid %v time '%v', must be RFC 3339", t.name, s)
+			}
+			*t.dst = sql.NullTime{Time: ts, Valid: true}
+		}
+	}
+	if s := v.Get("after_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 0 {
+			return p, fmt.Errorf("invalid after_id '%v'", s)
+		}
+		p.AfterID = id
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			return p, fmt.Errorf("invalid limit '%v', must be between 1 and %d", s, maxAuditLimit)
+		}
+		p.MaxResults = int32(n)
+	}
+	return p, nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxBalanceAccounts = 1000
+
+// BalanceResponse is the balance of an account at an instant.
+type BalanceResponse struct {
+	AccountID int64     `json:"account_id"`
+	Balance   int64     `json:"balance"`
+	AsOf      time.Time `json:"as_of"`
+}
+
+// AccountBalance returns the balance of the account :id after all transactions
+// created at or before ?as_of= (RFC 3339), defaulting to now.
+func AccountBalance(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid account id"))
+			return
+		}
+		asOf, err := asOfParam(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		balance, err := database.BalanceAsOf(r.Context(), dbClient.NewQuery(), id, asOf)
+		if err != nil {
+			if isNotFound(err) {
+				RespondWithError(w, http.StatusNotFound, database.ErrNotFound)
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, BalanceResponse{AccountID: id, Balance: balance, AsOf: asOf}); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// Balances returns the balances of the comma separated accounts ?ids= at the
+// instant ?as_of= (RFC 3339), defaulting to now. Unknown accounts are omitted.
+func Balances(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids, err := idsParam(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		asOf, err := asOfParam(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		rows, err := database.BalancesAsOf(r.Context(), dbClient.NewQuery(), ids, asOf)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		balances := make([]BalanceResponse, len(rows))
+		for i, row := range rows {
+			balances[i] = BalanceResponse{AccountID: row.AccountID, Balance: row.Balance, AsOf: asOf}
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, balances); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func asOfParam(v url.Values) (time.Time, error) {
+	s := v.Get("as_of")
+	if s == "" {
+		return time.Now().UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid as_of '%v', must be an RFC 3339 timestamp", s)
+	}
+	return t, nil
+}
+
+func idsParam(v url.Values) ([]int64, error) {
+	s := v.Get("ids")
+	if s == "" {
+		return nil, fmt.Errorf("ids is required")
+	}
+	fields := strings.Split(s, ",")
+	if len(fields) > maxBalanceAccounts {
+		return nil, fmt.Errorf("too many ids, at most %d are allowed", maxBalanceAccounts)
+	}
+	ids := make([]int64, len(fields))
+	for i, f := range fields {
+		id, err := strconv.ParseInt(strings.TrimSpace(f), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id '%v'", f)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// balanceCheckpointWorker checkpoints the closing balances of completed UTC
+// days on start and then every interval. A day is completed once every DB
+// transaction that started on or before it has finished.
+func balanceCheckpointWorker(dbClient database.DBClient, interval time.Duration) WorkerFunc {
+	return func(ctx context.Context) error {
+		checkpoint := func() {
+			days, err := database.CheckpointBalances(ctx, dbClient.NewQuery(), time.Now())
+			if err != nil {
+				slog.Error("cannot checkpoint balances", "error", err)
+			}
+			if days > 0 {
+				slog.Debug("checkpointed balances", "days", days)
+			}
+		}
+
+		checkpoint()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				checkpoint()
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
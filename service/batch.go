@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+)
+
+// BatchTxRequest posts several transfers. By default they are applied in a
+// single DB transaction, so either all or none are applied. With BestEffort
+// each transfer is applied separately and failures are only reported.
+type BatchTxRequest struct {
+	Transactions []database.CreateTransactionParams `json:"transactions"`
+	BestEffort   bool                               `json:"best_effort"`
+}
+
+// BatchTxResponse reports the outcome of each transfer in request order.
+// Error is set if an all-or-nothing batch was rejected.
+type BatchTxResponse struct {
+	Applied int             `json:"applied"`
+	Failed  int             `json:"failed"`
+	Results []BatchTxResult `json:"results"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// BatchTxResult is the outcome of one transfer. Transaction is set, with the
+// fees charged to its sender, if it was applied and Error if it failed.
+// Transfers of a rejected all-or-nothing batch that did not fail themselves
+// have neither.
+type BatchTxResult struct {
+	Index       int         `json:"index"`
+	Transaction *TxResponse `json:"transaction,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// BatchTx posts up to maxItems transfers and charges their fees. Every transfer
+// is validated before any is applied. An all-or-nothing batch locks all of its
+// accounts and the fee revenue account in ascending ID order before posting,
+// so that concurrent batches cannot deadlock, and is rejected with the status
+// of the first failing transfer.
+func BatchTx(dbClient database.DBClient, maxItems int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BatchTxRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(req.Transactions) == 0 {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("no transactions"))
+			return
+		}
+		if len(req.Transactions) > maxItems {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("too many transactions, at most %d are allowed", maxItems))
+			return
+		}
+
+		resp := BatchTxResponse{Results: make([]BatchTxResult, len(req.Transactions))}
+		fail := func(i int, err error) {
+			resp.Results[i].Error = err.Error()
+			resp.Failed++
+		}
+		var valid []int
+		for i, p := range req.Transactions {
+			resp.Results[i].Index = i
+			if err := validTxParams(p); err != nil {
+				fail(i, err)
+				continue
+			}
+			valid = append(valid, i)
+		}
+
+		if req.BestEffort {
+			for _, i := range valid {
+				var tx TxResponse
+				err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+					var err error
+					tx, err = postTransactionWithFees(r.Context(), q, req.Transactions[i])
+					return err
+				})
+				if err != nil {
+					fail(i, err)
+					continue
+				}
+				resp.Results[i].Transaction = &tx
+				resp.Applied++
+			}
+			if err := RespondWithJSON(w, http.StatusOK, resp); err != nil {
+				RespondWithError(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		if resp.Failed > 0 {
+			resp.Error = "invalid transactions, none were applied"
+			if err := RespondWithJSON(w, http.StatusBadRequest, resp); err != nil {
+				RespondWithError(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		failed := -1
+		err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			// Lock every account up front, including the fee revenue account
+			// that fees are paid to. Unknown accounts are reported by
+			// postTransactionWithFees against the first transfer naming them.
+			ids := []int64{database.FeeRevenueAccountID}
+			for _, p := range req.Transactions {
+				ids = append(ids, p.FromAccount.Int64, p.ToAccount.Int64)
+			}
+			slices.Sort(ids)
+			for _, id := range slices.Compact(ids) {
+				if _, err := q.GetUserForUpdate(r.Context(), id); err != nil && !isNotFound(err) {
+					return err
+				}
+			}
+			for i, p := range req.Transactions {
+				tx, err := postTransactionWithFees(r.Context(), q, p)
+				if err != nil {
+					failed = i
+					return err
+				}
+				resp.Results[i].Transaction = &tx
+			}
+			return nil
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).WarnContext(r.Context(), "cannot apply transaction batch", "error", err)
+			code := http.StatusInternalServerError
+			var ae *apiError
+			if errors.As(err, &ae) {
+				code = ae.code
+			}
+			for i := range resp.Results {
+				resp.Results[i].Transaction = nil
+			}
+			resp.Error = fmt.Sprintf("%v, none were applied", err)
+			if failed >= 0 {
+				fail(failed, err)
+				resp.Error = fmt.Sprintf("transaction %d: %v", failed, resp.Error)
+			}
+			if err := RespondWithJSON(w, code, resp); err != nil {
+				RespondWithError(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+		resp.Applied = len(req.Transactions)
+		logging.FromContext(r.Context()).DebugContext(r.Context(), "transaction batch applied", "transactions", resp.Applied)
+
+		if err := RespondWithJSON(w, http.StatusOK, resp); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
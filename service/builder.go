@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"path/filepath"
 
 	"github.com/ATMackay/psql-ledger/database"
 )
@@ -15,7 +17,8 @@ func BuildService(cfg Config) (*Service, error) {
 
 	config, defaultUsed := sanitizeConfig(cfg)
 
-	if err := InitLogging(config.LogLevel, config.LogFormat, config.LogToFile); err != nil {
+	logs, err := InitLogging(config)
+	if err != nil {
 		return nil, err
 	}
 
@@ -23,25 +26,101 @@ func BuildService(cfg Config) (*Service, error) {
 		slog.Warn("no config parameters supplied: using default")
 	}
 
-	db, err := makePostgresDBClient(config)
+	if _, err := ParseAPIKeys(config.APIKeys); err != nil {
+		return nil, fmt.Errorf("invalid api_keys: %v", err)
+	}
+	if _, err := ParseAPIKeys(config.AdminAPIKeys); err != nil {
+		return nil, fmt.Errorf("invalid admin_api_keys: %v", err)
+	}
+
+	shutdownTracing, err := InitTracing(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize tracing: %v", err)
+	}
+
+	db, err := ConnectDB(config)
 	if err != nil {
+		_ = shutdownTracing(context.Background())
 		return nil, fmt.Errorf("could not make postgres DB: %v", err)
 	}
 
+	schema, err := prepareSchema(config)
+	if err != nil {
+		_ = db.DB().Close()
+		_ = shutdownTracing(context.Background())
+		return nil, fmt.Errorf("DB schema check failed: %v", err)
+	}
+
 	slog.Info("connected to postgresDB",
 		"DBHost", config.PostgresHost,
 		"DBPort", config.PostgresPort,
 		"DBUser", config.PostgresUser,
 		"DBName", config.PostgresDB)
 
-	return New(config.Port, config.MaxThreads, db), nil
+	s := newService(config, db, schema)
+	s.shutdownTracing = shutdownTracing
+	s.setLogging(logs)
+
+	if config.ChainCheckpointFile != "" {
+		key, err := LoadSigningKey(config.ChainSigningKeyFile)
+		if err != nil {
+			_ = db.DB().Close()
+			_ = shutdownTracing(context.Background())
+			return nil, fmt.Errorf("cannot load chain signing key: %v", err)
+		}
+		s.AddWorker("chain-checkpoint", checkpointWorker(db, key, config.ChainCheckpointFile, config.ChainCheckpointInterval))
+	}
+	s.AddWorker("balance-checkpoint", balanceCheckpointWorker(db, config.BalanceCheckpointInterval))
+	return s, nil
 }
 
 func New(port, threads int, dbClient database.DBClient) *Service {
+	config := DefaultConfig
+	config.Port = port
+	config.MaxThreads = threads
+	return newService(config, dbClient, nil)
+}
+
+func newService(config Config, dbClient database.DBClient, schema *schemaInfo) *Service {
 	s := &Service{
-		dbClient: dbClient,
+		dbClient:        dbClient,
+		schema:          schema,
+		drainPeriod:     config.ShutdownDrainPeriod,
+		shutdownTimeout: config.ShutdownTimeout,
+		health:          NewHealthRegistry(config.HealthCheckTimeout),
 	}
-	h := NewHTTPService(port, makeServiceAPIs(dbClient))
+	s.registerHealthChecks(config)
+	// Changes made through the API are recorded in the audit log and
+	// transactions are appended to the hash chain
+	ledger := database.NewAuditingClient(database.NewChainingClient(dbClient))
+	im := newImporter(ledger, config.ImportChunkSize)
+	api := makeServiceAPIs(s, ledger, im, config)
+	s.AddWorker("import", im.run)
+	s.AddWorker("scheduler", newScheduler(ledger, config.SchedulerInterval).run)
+	s.AddWorker("interest", newInterestJob(ledger, config.InterestInterval).run)
+	// API keys are validated by BuildService
+	api.RequireAPIKeys(config.apiKeys())
+	api.SetRateLimits(config.rateLimits())
+	h := NewHTTPService(config.Port, api)
 	s.server = &h
 	return s
 }
+
+// registerHealthChecks registers the built-in checks backing the /livez, /readyz
+// and /startupz probes. The liveness probe has no dependency checks so that a
+// failing DB does not cause the process to be restarted.
+func (s *Service) registerHealthChecks(config Config) {
+	ttl := config.HealthCacheTTL
+	s.health.Register(flagCheck("shutdown", "service shutting down", func() bool { return !s.Draining() }), 0, ProbeReadiness)
+	s.health.Register(flagCheck("started", "service starting", s.started.Load), 0, ProbeStartup)
+	if pool, ok := s.dbClient.(poolStats); ok {
+		s.health.Register(poolCheck(pool, config.HealthPoolMaxSaturation), ttl, ProbeReadiness)
+	}
+	s.health.Register(dbPingCheck(s.dbClient, config.HealthDBMaxLatency), ttl, ProbeReadiness|ProbeStartup)
+	if s.schema != nil {
+		s.health.Register(schemaCheck(s.dbClient, s.schema), ttl, ProbeReadiness|ProbeStartup)
+	}
+	if config.LogToFile {
+		s.health.Register(diskCheck(filepath.Dir(config.LogFile), config.HealthMinFreeDiskMB<<20), ttl, ProbeReadiness)
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+)
+
+// VerifyChain walks the transaction hash chain and reports the first broken
+// link, if any.
+func VerifyChain(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, err := database.VerifyChain(r.Context(), dbClient.NewQuery())
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := RespondWithJSON(w, http.StatusOK, v); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// Checkpoint records the head of a ledger's hash chain at a point in time,
+// signed with the service's ed25519 key. Hashes and keys are hex encoded.
+type Checkpoint struct {
+	LedgerID  int64     `json:"ledger_id"`
+	TxID      int64     `json:"tx_id"`
+	Hash      string    `json:"hash"`
+	Time      time.Time `json:"time"`
+	PublicKey string    `json:"public_key"`
+	Signature string    `json:"signature"`
+}
+
+func (c Checkpoint) message() []byte {
+	return []byte(fmt.Sprintf("psql-ledger checkpoint|%d|%d|%s|%s", c.LedgerID, c.TxID, c.Hash, c.Time.UTC().Format(time.RFC3339Nano)))
+}
+
+// SignCheckpoint returns a checkpoint of the chain head signed with key.
+func SignCheckpoint(key ed25519.PrivateKey, head database.LedgerChain, now time.Time) Checkpoint {
+	c := Checkpoint{
+		LedgerID:  head.ID,
+		TxID:      head.LastTxID,
+		Hash:      hex.EncodeToString(head.Hash),
+		Time:      now.UTC(),
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+	c.Signature = hex.EncodeToString(ed25519.Sign(key, c.message()))
+	return c
+}
+
+// VerifyCheckpoint checks that the checkpoint was signed by pub.
+func VerifyCheckpoint(c Checkpoint, pub ed25519.PublicKey) error {
+	if c.PublicKey != hex.EncodeToString(pub) {
+		return fmt.Errorf("checkpoint signed by unexpected key %v", c.PublicKey)
+	}
+	sig, err := hex.DecodeString(c.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(pub, c.message(), sig) {
+		return errors.New("invalid checkpoint signature")
+	}
+	return nil
+}
+
+// GenerateSigningKey writes a new hex encoded ed25519 seed to path, which must
+// not exist, and returns the key.
+func GenerateSigningKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key.Seed())); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+// LoadSigningKey reads a hex encoded ed25519 seed written by GenerateSigningKey.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key in %v: want %d hex encoded bytes", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ReadCheckpoints reads the checkpoints appended to path.
+func ReadCheckpoints(path string) ([]Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cps []Checkpoint
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var c Checkpoint
+		if err := dec.Decode(&c); err != nil {
+			return nil, fmt.Errorf("checkpoint %d: %w", len(cps)+1, err)
+		}
+		cps = append(cps, c)
+	}
+	return cps, nil
+}
+
+// checkpointWorker appends a signed checkpoint of the chain head to path every
+// interval and once more on shutdown. Nothing is written if no transactions
+// have been added since the previous checkpoint.
+func checkpointWorker(dbClient database.DBClient, key ed25519.PrivateKey, path string, interval time.Duration) WorkerFunc {
+	return func(ctx context.Context) error {
+		var lastTxID int64 = -1
+		write := func(ctx context.Context) {
+			head, err := dbClient.NewQuery().GetLedgerChain(ctx, database.DefaultLedgerID)
+			if err != nil {
+				slog.Error("cannot read ledger chain", "error", err)
+				return
+			}
+			if head.LastTxID == lastTxID {
+				return
+			}
+			if err := appendCheckpoint(path, SignCheckpoint(key, head, time.Now())); err != nil {
+				slog.Error("cannot write chain checkpoint", "file", path, "error", err)
+				return
+			}
+			lastTxID = head.LastTxID
+			slog.Debug("wrote chain checkpoint", "tx_id", head.LastTxID)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				write(ctx)
+			case <-ctx.Done():
+				// The DB is still open while workers stop
+				write(context.WithoutCancel(ctx))
+				return nil
+			}
+		}
+	}
+}
+
+func appendCheckpoint(path string, c Checkpoint) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
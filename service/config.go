@@ -2,38 +2,247 @@ package service
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/ATMackay/psql-ledger/database"
 	yaml "gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Port             int    `yaml:"port"`
-	LogLevel         string `yaml:"loglevel"`
-	LogFormat        string `yaml:"logformat"`
-	LogToFile        bool   `yaml:"logtofile"`
-	PostgresHost     string `yaml:"postgres_host"`
-	PostgresPort     int    `yaml:"postgres_port"`
-	PostgresUser     string `yaml:"postgres_user"`
-	PostgresPassword string `yaml:"postgres_password"`
-	PostgresDB       string `yaml:"postgres_db"`
-	MigrationsPath   string `yaml:"migrations_path"`
-	MaxThreads       int    `yaml:"max_threads"`
+	Port                      int           `yaml:"port"`
+	LogLevel                  string        `yaml:"loglevel"`
+	LogFormat                 string        `yaml:"logformat"`
+	LogToFile                 bool          `yaml:"logtofile"`
+	LogFile                   string        `yaml:"logfile"`
+	LogFileLevel              string        `yaml:"logfile_level"`
+	LogMaxSizeMB              int           `yaml:"log_max_size_mb"`
+	LogMaxAgeDays             int           `yaml:"log_max_age_days"`
+	LogMaxBackups             int           `yaml:"log_max_backups"`
+	LogCompress               bool          `yaml:"log_compress"`
+	PostgresHost              string        `yaml:"postgres_host"`
+	PostgresPort              int           `yaml:"postgres_port"`
+	PostgresUser              string        `yaml:"postgres_user"`
+	PostgresPassword          string        `yaml:"postgres_password"`
+	PostgresDB                string        `yaml:"postgres_db"`
+	CreateDatabaseIfMissing   bool          `yaml:"create_database_if_missing"`
+	PostgresDBOwner           string        `yaml:"postgres_db_owner"`
+	PostgresMaintenanceDB     string        `yaml:"postgres_maintenance_db"`
+	StartupTimeout            time.Duration `yaml:"startup_timeout"`
+	StartupRetryInterval      time.Duration `yaml:"startup_retry_interval"`
+	StartupRetryMaxInterval   time.Duration `yaml:"startup_retry_max_interval"`
+	MigrationsPath            string        `yaml:"migrations_path"`
+	MigrationsTable           string        `yaml:"migrations_table"`
+	SkipMigrations            bool          `yaml:"skip_migrations"`
+	SchemaCheck               string        `yaml:"schema_check"`
+	MaxThreads                int           `yaml:"max_threads"`
+	ShutdownDrainPeriod       time.Duration `yaml:"shutdown_drain_period"`
+	ShutdownTimeout           time.Duration `yaml:"shutdown_timeout"`
+	HealthCacheTTL            time.Duration `yaml:"health_cache_ttl"`
+	HealthCheckTimeout        time.Duration `yaml:"health_check_timeout"`
+	HealthDBMaxLatency        time.Duration `yaml:"health_db_max_latency"`
+	HealthPoolMaxSaturation   float64       `yaml:"health_pool_max_saturation"`
+	HealthMinFreeDiskMB       uint64        `yaml:"health_min_free_disk_mb"`
+	TracingExporter           string        `yaml:"tracing_exporter"`
+	TracingOTLPEndpoint       string        `yaml:"tracing_otlp_endpoint"`
+	TracingOTLPInsecure       bool          `yaml:"tracing_otlp_insecure"`
+	TracingFile               string        `yaml:"tracing_file"`
+	TracingSampleRatio        float64       `yaml:"tracing_sample_ratio"`
+	APIKeys                   []string      `yaml:"api_keys"`
+	AdminAPIKeys              []string      `yaml:"admin_api_keys"`
+	RateLimit                 bool          `yaml:"rate_limit"`
+	RateLimitReadRPS          float64       `yaml:"rate_limit_read_rps"`
+	RateLimitReadBurst        int           `yaml:"rate_limit_read_burst"`
+	RateLimitWriteRPS         float64       `yaml:"rate_limit_write_rps"`
+	RateLimitWriteBurst       int           `yaml:"rate_limit_write_burst"`
+	MaxInFlightRequests       int           `yaml:"max_in_flight_requests"`
+	ChainCheckpointFile       string        `yaml:"chain_checkpoint_file"`
+	ChainCheckpointInterval   time.Duration `yaml:"chain_checkpoint_interval"`
+	ChainSigningKeyFile       string        `yaml:"chain_signing_key_file"`
+	BalanceCheckpointInterval time.Duration `yaml:"balance_checkpoint_interval"`
+	ImportChunkSize           int           `yaml:"import_chunk_size"`
+	ImportMaxBytes            int64         `yaml:"import_max_bytes"`
+	MaxBatchSize              int           `yaml:"max_batch_size"`
+	SchedulerInterval         time.Duration `yaml:"scheduler_interval"`
+	InterestInterval          time.Duration `yaml:"interest_interval"`
 }
 
 var emptyConfig = Config{}
 
 var DefaultConfig = Config{
-	Port:             8080,
-	LogLevel:         "info",
-	LogFormat:        "text",
-	LogToFile:        false,
-	PostgresHost:     "localhost",          // Default Postgres database configuration
-	PostgresPort:     5432,                 //
-	PostgresUser:     "root",               //
-	PostgresPassword: "secret",             //
-	PostgresDB:       "bank",               //
-	MigrationsPath:   "../sqlc/migrations", // local project migrations directory
-	MaxThreads:       1,                    // Not multi-threaded by default
+	Port:                      8080,
+	LogLevel:                  "info",
+	LogFormat:                 "text",
+	LogToFile:                 false,                           // Log to LogFile as well as stderr
+	LogFile:                   "log.out",                       //
+	LogFileLevel:              "",                              // Same as LogLevel if empty
+	LogMaxSizeMB:              100,                             // Rotate the log file at this size
+	LogMaxAgeDays:             0,                               // Keep rotated files forever if 0
+	LogMaxBackups:             10,                              // Number of rotated files kept, all if 0
+	LogCompress:               false,                           // gzip rotated files
+	PostgresHost:              "localhost",                     // Default Postgres database configuration
+	PostgresPort:              5432,                            //
+	PostgresUser:              "root",                          //
+	PostgresPassword:          "secret",                        //
+	PostgresDB:                "bank",                          //
+	PostgresDBOwner:           "root",                          //
+	PostgresMaintenanceDB:     "postgres",                      // Used to check for and create PostgresDB
+	StartupTimeout:            30 * time.Second,                // Wait for postgres to become ready
+	StartupRetryInterval:      500 * time.Millisecond,          //
+	StartupRetryMaxInterval:   5 * time.Second,                 //
+	MigrationsPath:            "",                              // Use embedded migrations by default
+	MigrationsTable:           database.DefaultMigrationsTable, //
+	SchemaCheck:               SchemaCheckStrict,               // Refuse to serve unless the DB schema matches the binary
+	MaxThreads:                1,                               // Not multi-threaded by default
+	ShutdownDrainPeriod:       0,                               // Time /health reports unavailable before the server stops
+	ShutdownTimeout:           defaultShutdownTimeout,          // Time allowed for in-flight requests and workers to finish
+	HealthCacheTTL:            time.Second,                     // Probe results are reused for this long
+	HealthCheckTimeout:        2 * time.Second,                 //
+	HealthDBMaxLatency:        500 * time.Millisecond,          // DB ping round trip above which the service is not ready
+	HealthPoolMaxSaturation:   1,                               // Fraction of pooled DB clients in use at which the service is not ready
+	HealthMinFreeDiskMB:       100,                             // Only checked when logging to file
+	TracingExporter:           TracingNone,                     // none|stdout|file|otlp
+	TracingOTLPEndpoint:       "localhost:4318",                // OTLP/HTTP collector
+	TracingFile:               "traces.out",                    //
+	TracingSampleRatio:        1,                               // Fraction of new traces recorded
+	APIKeys:                   nil,                             // principal:key entries, authentication disabled if empty
+	AdminAPIKeys:              nil,                             // principal:key entries also accepted by admin endpoints
+	RateLimit:                 false,                           // Per API key or client IP token buckets
+	RateLimitReadRPS:          50,                              //
+	RateLimitReadBurst:        100,                             //
+	RateLimitWriteRPS:         10,                              //
+	RateLimitWriteBurst:       20,                              //
+	MaxInFlightRequests:       0,                               // Respond 503 above this many concurrent requests, unlimited if 0
+	ChainCheckpointFile:       "",                              // Signed hash chain checkpoints are appended here, disabled if empty
+	ChainCheckpointInterval:   time.Hour,                       //
+	ChainSigningKeyFile:       "",                              // Hex encoded ed25519 seed, required for checkpoints
+	BalanceCheckpointInterval: time.Hour,                       // Daily closing balances of completed days are checkpointed this often
+	ImportChunkSize:           1000,                            // Rows loaded per DB transaction by bulk imports
+	ImportMaxBytes:            64 << 20,                        // Largest accepted import file
+	MaxBatchSize:              1000,                            // Most transfers accepted by /v1/transactions/batch
+	SchedulerInterval:         10 * time.Second,                // Due scheduled transfers are run this often
+	InterestInterval:          time.Hour,                       // Interest is accrued for completed days and posted for completed months this often
+}
+
+const redacted = "********"
+
+// Redacted returns a copy of the config with secrets masked, suitable for printing.
+func (c Config) Redacted() Config {
+	if c.PostgresPassword != "" {
+		c.PostgresPassword = redacted
+	}
+	c.APIKeys = redactAPIKeys(c.APIKeys)
+	c.AdminAPIKeys = redactAPIKeys(c.AdminAPIKeys)
+	return c
+}
+
+func redactAPIKeys(entries []string) []string {
+	if len(entries) == 0 {
+		return entries
+	}
+	keys := make([]string, len(entries))
+	for i, k := range entries {
+		principal, _, _ := strings.Cut(k, ":")
+		keys[i] = principal + ":" + redacted
+	}
+	return keys
+}
+
+// Validate checks that the config parameters are usable. Empty values are
+// not considered invalid since they are replaced with defaults by BuildService.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d out of range", c.Port))
+	}
+	if c.PostgresPort < 0 || c.PostgresPort > 65535 {
+		errs = append(errs, fmt.Errorf("postgres_port %d out of range", c.PostgresPort))
+	}
+	if c.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid loglevel: %v", err))
+		}
+	}
+	if c.LogFileLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.LogFileLevel)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid logfile_level: %v", err))
+		}
+	}
+	if c.LogMaxSizeMB < 0 || c.LogMaxAgeDays < 0 || c.LogMaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("log rotation limits must not be negative"))
+	}
+	switch c.LogFormat {
+	case "", "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("invalid logformat '%v', must be text or json", c.LogFormat))
+	}
+	if c.ShutdownDrainPeriod < 0 || c.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("shutdown drain period and timeout must not be negative"))
+	}
+	if c.StartupTimeout < 0 || c.StartupRetryInterval < 0 || c.StartupRetryMaxInterval < 0 {
+		errs = append(errs, fmt.Errorf("startup timeout and retry intervals must not be negative"))
+	}
+	if c.StartupRetryInterval > 0 && c.StartupRetryMaxInterval > 0 && c.StartupRetryInterval > c.StartupRetryMaxInterval {
+		errs = append(errs, fmt.Errorf("startup_retry_interval must not exceed startup_retry_max_interval"))
+	}
+	switch c.SchemaCheck {
+	case "", SchemaCheckStrict, SchemaCheckAllowNewer, SchemaCheckOff:
+	default:
+		errs = append(errs, fmt.Errorf("invalid schema_check '%v', must be one of %v|%v|%v", c.SchemaCheck, SchemaCheckStrict, SchemaCheckAllowNewer, SchemaCheckOff))
+	}
+	if c.HealthCacheTTL < 0 || c.HealthCheckTimeout < 0 || c.HealthDBMaxLatency < 0 {
+		errs = append(errs, fmt.Errorf("health check durations must not be negative"))
+	}
+	if c.HealthPoolMaxSaturation < 0 || c.HealthPoolMaxSaturation > 1 {
+		errs = append(errs, fmt.Errorf("health_pool_max_saturation must be between 0 and 1, got %v", c.HealthPoolMaxSaturation))
+	}
+	switch c.TracingExporter {
+	case "", TracingNone, TracingStdout, TracingFile, TracingOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("invalid tracing_exporter '%v', must be one of %v|%v|%v|%v", c.TracingExporter, TracingNone, TracingStdout, TracingFile, TracingOTLP))
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing_sample_ratio must be between 0 and 1, got %v", c.TracingSampleRatio))
+	}
+	if c.RateLimitReadRPS < 0 || c.RateLimitReadBurst < 0 || c.RateLimitWriteRPS < 0 || c.RateLimitWriteBurst < 0 || c.MaxInFlightRequests < 0 {
+		errs = append(errs, fmt.Errorf("rate limits must not be negative"))
+	}
+	if _, err := ParseAPIKeys(c.APIKeys); err != nil {
+		errs = append(errs, fmt.Errorf("api_keys: %v", err))
+	}
+	if _, err := ParseAPIKeys(c.AdminAPIKeys); err != nil {
+		errs = append(errs, fmt.Errorf("admin_api_keys: %v", err))
+	}
+	if c.ChainCheckpointInterval < 0 {
+		errs = append(errs, fmt.Errorf("chain_checkpoint_interval must not be negative"))
+	}
+	if c.BalanceCheckpointInterval < 0 {
+		errs = append(errs, fmt.Errorf("balance_checkpoint_interval must not be negative"))
+	}
+	if c.ImportChunkSize < 0 || c.ImportMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("import_chunk_size and import_max_bytes must not be negative"))
+	}
+	if c.MaxBatchSize < 0 {
+		errs = append(errs, fmt.Errorf("max_batch_size must not be negative"))
+	}
+	if c.SchedulerInterval < 0 {
+		errs = append(errs, fmt.Errorf("scheduler_interval must not be negative"))
+	}
+	if c.InterestInterval < 0 {
+		errs = append(errs, fmt.Errorf("interest_interval must not be negative"))
+	}
+	if c.ChainCheckpointFile != "" && c.ChainSigningKeyFile == "" {
+		errs = append(errs, fmt.Errorf("chain_signing_key_file is required with chain_checkpoint_file"))
+	}
+	if c.MaxThreads < 0 {
+		errs = append(errs, fmt.Errorf("max_threads must not be negative, got %d", c.MaxThreads))
+	}
+	return errors.Join(errs...)
 }
 
 func isEmpty(c Config) bool {
@@ -64,6 +273,18 @@ func sanitizeConfig(config Config) (cfg Config, defaultUsed bool) {
 		cfg.LogFormat = DefaultConfig.LogFormat
 	}
 
+	if config.LogFile == "" {
+		cfg.LogFile = DefaultConfig.LogFile
+	}
+
+	if config.LogMaxSizeMB == 0 {
+		cfg.LogMaxSizeMB = DefaultConfig.LogMaxSizeMB
+	}
+
+	if config.LogMaxBackups == 0 {
+		cfg.LogMaxBackups = DefaultConfig.LogMaxBackups
+	}
+
 	if config.PostgresHost == "" {
 		cfg.PostgresHost = DefaultConfig.PostgresHost
 	}
@@ -84,12 +305,146 @@ func sanitizeConfig(config Config) (cfg Config, defaultUsed bool) {
 		cfg.PostgresDB = DefaultConfig.PostgresDB
 	}
 
-	if config.MigrationsPath == "" {
-		cfg.MigrationsPath = DefaultConfig.MigrationsPath
+	if config.PostgresDBOwner == "" {
+		cfg.PostgresDBOwner = cfg.PostgresUser
+	}
+
+	if config.PostgresMaintenanceDB == "" {
+		cfg.PostgresMaintenanceDB = DefaultConfig.PostgresMaintenanceDB
+	}
+
+	if config.StartupTimeout == 0 {
+		cfg.StartupTimeout = DefaultConfig.StartupTimeout
+	}
+
+	if config.StartupRetryInterval == 0 {
+		cfg.StartupRetryInterval = DefaultConfig.StartupRetryInterval
+	}
+
+	if config.StartupRetryMaxInterval == 0 {
+		cfg.StartupRetryMaxInterval = DefaultConfig.StartupRetryMaxInterval
+	}
+
+	if config.MigrationsTable == "" {
+		cfg.MigrationsTable = DefaultConfig.MigrationsTable
+	}
+
+	if config.SchemaCheck == "" {
+		cfg.SchemaCheck = DefaultConfig.SchemaCheck
 	}
 
 	if config.MaxThreads == 0 {
 		cfg.MaxThreads = DefaultConfig.MaxThreads
 	}
+
+	if config.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = DefaultConfig.ShutdownTimeout
+	}
+
+	if config.HealthCacheTTL == 0 {
+		cfg.HealthCacheTTL = DefaultConfig.HealthCacheTTL
+	}
+
+	if config.HealthCheckTimeout == 0 {
+		cfg.HealthCheckTimeout = DefaultConfig.HealthCheckTimeout
+	}
+
+	if config.HealthDBMaxLatency == 0 {
+		cfg.HealthDBMaxLatency = DefaultConfig.HealthDBMaxLatency
+	}
+
+	if config.HealthPoolMaxSaturation == 0 {
+		cfg.HealthPoolMaxSaturation = DefaultConfig.HealthPoolMaxSaturation
+	}
+
+	if config.HealthMinFreeDiskMB == 0 {
+		cfg.HealthMinFreeDiskMB = DefaultConfig.HealthMinFreeDiskMB
+	}
+
+	if config.RateLimitReadRPS == 0 {
+		cfg.RateLimitReadRPS = DefaultConfig.RateLimitReadRPS
+	}
+
+	if config.RateLimitReadBurst == 0 {
+		cfg.RateLimitReadBurst = DefaultConfig.RateLimitReadBurst
+	}
+
+	if config.RateLimitWriteRPS == 0 {
+		cfg.RateLimitWriteRPS = DefaultConfig.RateLimitWriteRPS
+	}
+
+	if config.RateLimitWriteBurst == 0 {
+		cfg.RateLimitWriteBurst = DefaultConfig.RateLimitWriteBurst
+	}
+
+	if config.TracingExporter == "" {
+		cfg.TracingExporter = DefaultConfig.TracingExporter
+	}
+
+	if config.TracingOTLPEndpoint == "" {
+		cfg.TracingOTLPEndpoint = DefaultConfig.TracingOTLPEndpoint
+	}
+
+	if config.TracingFile == "" {
+		cfg.TracingFile = DefaultConfig.TracingFile
+	}
+
+	if config.TracingSampleRatio == 0 {
+		cfg.TracingSampleRatio = DefaultConfig.TracingSampleRatio
+	}
+
+	if config.ChainCheckpointInterval == 0 {
+		cfg.ChainCheckpointInterval = DefaultConfig.ChainCheckpointInterval
+	}
+
+	if config.BalanceCheckpointInterval == 0 {
+		cfg.BalanceCheckpointInterval = DefaultConfig.BalanceCheckpointInterval
+	}
+
+	if config.ImportChunkSize == 0 {
+		cfg.ImportChunkSize = DefaultConfig.ImportChunkSize
+	}
+
+	if config.ImportMaxBytes == 0 {
+		cfg.ImportMaxBytes = DefaultConfig.ImportMaxBytes
+	}
+
+	if config.MaxBatchSize == 0 {
+		cfg.MaxBatchSize = DefaultConfig.MaxBatchSize
+	}
+	if config.SchedulerInterval == 0 {
+		cfg.SchedulerInterval = DefaultConfig.SchedulerInterval
+	}
+	if config.InterestInterval == 0 {
+		cfg.InterestInterval = DefaultConfig.InterestInterval
+	}
 	return
 }
+
+// SanitizeConfig returns a copy of the config with unset values replaced by defaults.
+func SanitizeConfig(config Config) Config {
+	cfg, _ := sanitizeConfig(config)
+	return cfg
+}
+
+// apiKeys returns the API keys accepted by the HTTP API, with the admin keys
+// marked. Invalid entries are skipped; they are rejected by Validate.
+func (c Config) apiKeys() []APIKey {
+	keys, _ := ParseAPIKeys(c.APIKeys)
+	admins, _ := ParseAPIKeys(c.AdminAPIKeys)
+	for _, k := range admins {
+		k.Admin = true
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// rateLimits returns the rate limits applied to the HTTP API.
+func (c Config) rateLimits() RateLimits {
+	l := RateLimits{MaxInFlight: c.MaxInFlightRequests}
+	if c.RateLimit {
+		l.ReadRate, l.ReadBurst = c.RateLimitReadRPS, c.RateLimitReadBurst
+		l.WriteRate, l.WriteBurst = c.RateLimitWriteRPS, c.RateLimitWriteBurst
+	}
+	return l
+}
@@ -2,40 +2,127 @@ package service
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 
 	"github.com/ATMackay/psql-ledger/database"
 	"github.com/lib/pq"
 )
 
-func makePostgresDBClient(config Config) (database.DBClient, error) {
+// ConnectDB returns a client with config.MaxThreads connections to the configured
+// postgres database. Migrations are not applied. ConnectDB waits for postgres to
+// accept connections and, if enabled, creates the database if it does not exist.
+func ConnectDB(config Config) (database.DBClient, error) {
+
+	config, _ = sanitizeConfig(config)
+
+	if err := ensureDatabase(config); err != nil {
+		return nil, err
+	}
 
 	d, err := makeClientSet(config)
 	if err != nil {
 		return nil, err
 	}
+
+	return d, nil
+}
+
+// ensureDatabase connects to the maintenance DB, waiting for postgres to become
+// ready, and checks that the configured database exists, creating it if allowed.
+func ensureDatabase(config Config) error {
+	ctx := context.Background()
+
+	c, err := newConnector(config, config.PostgresMaintenanceDB)
+	if err != nil {
+		return err
+	}
+
+	var maintenance *database.PSQLClient
+	if err := startupBackoff(config).retry(ctx, "connect to postgres", func() error {
+		var err error
+		maintenance, err = database.NewPSQLClient(config.PostgresMaintenanceDB, c)
+		return retryablePostgresErr(err)
+	}); err != nil {
+		return fmt.Errorf("NewPSQLClient err: %v", err)
+	}
+	defer maintenance.DB().Close()
+
 	// check DB exists
-	exists, err := d.CheckDatabaseExists(context.Background(), config.PostgresDB)
+	exists, err := maintenance.CheckDatabaseExists(ctx, config.PostgresDB)
 	if err != nil {
-		return nil, fmt.Errorf("CheckDatabaseExists err: %v", err)
+		return fmt.Errorf("CheckDatabaseExists err: %v", err)
 	}
 
-	if !exists {
-		slog.Debug(fmt.Sprintf("DB %v not found", config.PostgresDB))
-		// TODO - attempt DB creation again..
-		return nil, fmt.Errorf("DB %v does not exist", config.PostgresDB)
-	} else {
+	if exists {
 		slog.Debug(fmt.Sprintf("found DB %v", config.PostgresDB))
+		return nil
 	}
 
-	if err := d.InitializeSchema(config.MigrationsPath); err != nil {
-		slog.Warn(fmt.Sprintf("InitializeSchema failed: %v", err))
-	} else {
-		slog.Debug(fmt.Sprintf("migrated DB using schema path '%v'", config.MigrationsPath))
+	slog.Debug(fmt.Sprintf("DB %v not found", config.PostgresDB))
+	if !config.CreateDatabaseIfMissing {
+		return fmt.Errorf("DB %v does not exist", config.PostgresDB)
 	}
 
-	return d, nil
+	if err := maintenance.CreateDatabase(ctx, config.PostgresDB, config.PostgresDBOwner); err != nil {
+		return fmt.Errorf("CreateDatabase err: %v", err)
+	}
+	slog.Info("created DB", "DBName", config.PostgresDB, "owner", config.PostgresDBOwner)
+	return nil
+}
+
+func startupBackoff(config Config) backoff {
+	return backoff{
+		initial: config.StartupRetryInterval,
+		max:     config.StartupRetryMaxInterval,
+		timeout: config.StartupTimeout,
+	}
+}
+
+// NewMigrator returns a schema migrator with a dedicated connection to the
+// configured postgres database. The connection is closed by Migrator.Close.
+func NewMigrator(config Config) (*database.Migrator, error) {
+
+	config, _ = sanitizeConfig(config)
+
+	c, err := newConnector(config, config.PostgresDB)
+	if err != nil {
+		return nil, err
+	}
+	dbClient, err := database.NewPSQLClient(config.PostgresDB, c)
+	if err != nil {
+		return nil, fmt.Errorf("NewPSQLClient err: %v", err)
+	}
+
+	legacyTable := legacyMigrationsTable
+	if config.MigrationsPath != "" {
+		legacyTable = config.MigrationsPath
+	}
+	renamed, err := dbClient.RenameMigrationsTable(context.Background(), legacyTable, config.MigrationsTable)
+	if err != nil {
+		return nil, fmt.Errorf("cannot rename legacy migrations table: %w", err)
+	}
+	if renamed {
+		slog.Info("renamed legacy migrations table", "from", legacyTable, "to", config.MigrationsTable)
+	}
+
+	return dbClient.NewMigrator(migrationsFS(config), config.MigrationsTable)
+}
+
+func newConnector(config Config, dbName string) (driver.Connector, error) {
+	c, err := pq.NewConnector(fmt.Sprintf("host=%v port=%v user=%v password=%v dbname=%v sslmode=disable",
+		config.PostgresHost,
+		config.PostgresPort,
+		config.PostgresUser,
+		config.PostgresPassword,
+		dbName))
+	if err != nil {
+		return nil, fmt.Errorf("NewConnector err: %v", err)
+	}
+	return c, nil
 }
 
 type aggregatedClient struct {
@@ -48,21 +135,16 @@ func makeClientSet(config Config) (aggregatedClient, error) {
 	a := aggregatedClient{clients: clients}
 	for i := 0; i < n; i++ {
 		// creates n new connections
-		c, err := pq.NewConnector(fmt.Sprintf("host=%v port=%v user=%v password=%v dbname=%v sslmode=disable",
-			config.PostgresHost,
-			config.PostgresPort,
-			config.PostgresUser,
-			config.PostgresPassword,
-			config.PostgresDB))
+		c, err := newConnector(config, config.PostgresDB)
 		if err != nil {
-			return a, fmt.Errorf("NewConnector err: %v", err)
+			return a, err
 		}
 		dbClient, err := database.NewPSQLClient(config.PostgresDB, c)
 		if err != nil {
 			return a, fmt.Errorf("NewPSQLClient err: %v", err)
 		}
 		slog.Debug("new client", "index", i)
-		a.clients <- dbClient
+		a.clients <- database.NewTracingClient(dbClient)
 	}
 	return a, nil
 
@@ -76,20 +158,57 @@ func (a aggregatedClient) CheckDatabaseExists(ctx context.Context, dbName string
 	return cl.CheckDatabaseExists(ctx, dbName)
 }
 
-func (a aggregatedClient) InitializeSchema(schemaPath string) error {
+func (a aggregatedClient) InitializeSchema(migrations fs.FS, migrationsTable string) error {
 	cl := <-a.clients
 	defer func() {
 		a.clients <- cl
 	}()
-	return cl.InitializeSchema(schemaPath)
+	return cl.InitializeSchema(migrations, migrationsTable)
 }
 
+func (a aggregatedClient) SchemaVersion(ctx context.Context, migrationsTable string) (uint, bool, error) {
+	cl := <-a.clients
+	defer func() {
+		a.clients <- cl
+	}()
+	return cl.SchemaVersion(ctx, migrationsTable)
+}
+
+// PoolStats reports how many of the pooled DB clients are currently checked out.
+func (a aggregatedClient) PoolStats() (inUse, size int) {
+	return cap(a.clients) - len(a.clients), cap(a.clients)
+}
+
+// DB returns a handle to the pooled DB connections. Ping uses a single
+// connection whereas Close closes all of them.
 func (a aggregatedClient) DB() database.DB {
+	return aggregatedDB(a)
+}
+
+type aggregatedDB aggregatedClient
+
+func (a aggregatedDB) Ping() error {
 	cl := <-a.clients
 	defer func() {
 		a.clients <- cl
 	}()
-	return cl.DB()
+	return cl.DB().Ping()
+}
+
+// Close closes every pooled connection. It blocks until all clients have been
+// returned to the pool.
+func (a aggregatedDB) Close() error {
+	var errs []error
+	for i := 0; i < cap(a.clients); i++ {
+		cl := <-a.clients
+		if err := cl.DB().Close(); err != nil {
+			errs = append(errs, err)
+		}
+		defer func() {
+			a.clients <- cl
+		}()
+	}
+	return errors.Join(errs...)
 }
 
 func (a aggregatedClient) NewQuery() database.DBQuery {
@@ -115,3 +234,11 @@ func (a aggregatedClient) NewQueryWithTx() (database.DBQuery, error) {
 	}()
 	return cl.NewQueryWithTx()
 }
+
+func (a aggregatedClient) ExecTx(ctx context.Context, fn func(database.DBQuery) error) error {
+	cl := <-a.clients
+	defer func() {
+		a.clients <- cl
+	}()
+	return cl.ExecTx(ctx, fn)
+}
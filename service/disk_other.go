@@ -0,0 +1,9 @@
+//go:build !unix
+
+package service
+
+import "errors"
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("disk space check not supported on this platform")
+}
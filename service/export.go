@@ -0,0 +1,396 @@
+package service
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Export formats.
+const (
+	ExportCSV     = "csv"
+	ExportJSONL   = "jsonl"
+	ExportParquet = "parquet"
+)
+
+const (
+	// exportFlushRows is the number of rows written between flushes of the
+	// response.
+	exportFlushRows = 1000
+	// exportRowGroupSize is the number of rows buffered per Parquet row group.
+	exportRowGroupSize = 64 << 10
+)
+
+// ExportAccount is an exported account. Optional columns are null in CSV as an
+// empty field.
+type ExportAccount struct {
+	ID                int64           `json:"id" parquet:"id"`
+	Username          string          `json:"username" parquet:"username"`
+	AccountType       string          `json:"account_type" parquet:"account_type"`
+	Balance           int64           `json:"balance" parquet:"balance"`
+	OverdraftLimit    int64           `json:"overdraft_limit" parquet:"overdraft_limit"`
+	Email             *string         `json:"email" parquet:"email,optional"`
+	Status            string          `json:"status" parquet:"status"`
+	StatusReason      *string         `json:"status_reason" parquet:"status_reason,optional"`
+	CreatedAt         time.Time       `json:"created_at" parquet:"created_at"`
+	StatusUpdatedAt   *time.Time      `json:"status_updated_at" parquet:"status_updated_at,optional"`
+	Description       *string         `json:"description" parquet:"description,optional"`
+	ExternalReference *string         `json:"external_reference" parquet:"external_reference,optional"`
+	Category          *string         `json:"category" parquet:"category,optional"`
+	Metadata          json.RawMessage `json:"metadata" parquet:"metadata,json"`
+}
+
+// ExportTransaction is an exported transaction. Hashes are hex encoded.
+type ExportTransaction struct {
+	ID                int64           `json:"id" parquet:"id"`
+	FromAccount       int64           `json:"from_account" parquet:"from_account"`
+	ToAccount         int64           `json:"to_account" parquet:"to_account"`
+	Amount            int64           `json:"amount" parquet:"amount"`
+	CreatedAt         time.Time       `json:"created_at" parquet:"created_at"`
+	PrevHash          string          `json:"prev_hash" parquet:"prev_hash"`
+	Hash              string          `json:"hash" parquet:"hash"`
+	ChainVersion      int32           `json:"chain_version" parquet:"chain_version"`
+	Description       *string         `json:"description" parquet:"description,optional"`
+	ExternalReference *string         `json:"external_reference" parquet:"external_reference,optional"`
+	Category          *string         `json:"category" parquet:"category,optional"`
+	Metadata          json.RawMessage `json:"metadata" parquet:"metadata,json"`
+}
+
+func newExportAccount(a database.Account) ExportAccount {
+	e := ExportAccount{ID: a.ID, Username: a.Username, AccountType: a.AccountType, Balance: a.Balance, OverdraftLimit: a.OverdraftLimit, Status: a.Status, CreatedAt: a.CreatedAt.Time.UTC(),
+		Description: optionalString(a.Description), ExternalReference: optionalString(a.ExternalReference), Category: optionalString(a.Category), Metadata: metadataOrEmpty(a.Metadata)}
+	if a.Email.Valid {
+		e.Email = &a.Email.String
+	}
+	if a.StatusReason.Valid {
+		e.StatusReason = &a.StatusReason.String
+	}
+	if a.StatusUpdatedAt.Valid {
+		t := a.StatusUpdatedAt.Time.UTC()
+		e.StatusUpdatedAt = &t
+	}
+	return e
+}
+
+func newExportTransaction(tx database.Transaction) ExportTransaction {
+	return ExportTransaction{
+		ID:                tx.ID,
+		FromAccount:       tx.FromAccount.Int64,
+		ToAccount:         tx.ToAccount.Int64,
+		Amount:            tx.Amount.Int64,
+		CreatedAt:         tx.CreatedAt.Time.UTC(),
+		PrevHash:          hex.EncodeToString(tx.PrevHash),
+		Hash:              hex.EncodeToString(tx.Hash),
+		ChainVersion:      int32(tx.ChainVersion),
+		Description:       optionalString(tx.Description),
+		ExternalReference: optionalString(tx.ExternalReference),
+		Category:          optionalString(tx.Category),
+		Metadata:          metadataOrEmpty(tx.Metadata),
+	}
+}
+
+func optionalString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func (ExportAccount) csvHeader() []string {
+	return []string{"id", "username", "account_type", "balance", "overdraft_limit", "email", "status", "status_reason", "created_at", "status_updated_at", "description", "external_reference", "category", "metadata"}
+}
+
+func (e ExportAccount) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(e.ID, 10),
+		e.Username,
+		e.AccountType,
+		strconv.FormatInt(e.Balance, 10),
+		strconv.FormatInt(e.OverdraftLimit, 10),
+		fmtOptional(e.Email, func(s string) string { return s }),
+		e.Status,
+		fmtOptional(e.StatusReason, func(s string) string { return s }),
+		e.CreatedAt.Format(time.RFC3339Nano),
+		fmtOptional(e.StatusUpdatedAt, func(t time.Time) string { return t.Format(time.RFC3339Nano) }),
+		fmtOptional(e.Description, func(s string) string { return s }),
+		fmtOptional(e.ExternalReference, func(s string) string { return s }),
+		fmtOptional(e.Category, func(s string) string { return s }),
+		string(e.Metadata),
+	}
+}
+
+func (ExportTransaction) csvHeader() []string {
+	return []string{"id", "from_account", "to_account", "amount", "created_at", "prev_hash", "hash", "chain_version", "description", "external_reference", "category", "metadata"}
+}
+
+func (e ExportTransaction) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(e.ID, 10),
+		strconv.FormatInt(e.FromAccount, 10),
+		strconv.FormatInt(e.ToAccount, 10),
+		strconv.FormatInt(e.Amount, 10),
+		e.CreatedAt.Format(time.RFC3339Nano),
+		e.PrevHash,
+		e.Hash,
+		strconv.Itoa(int(e.ChainVersion)),
+		fmtOptional(e.Description, func(s string) string { return s }),
+		fmtOptional(e.ExternalReference, func(s string) string { return s }),
+		fmtOptional(e.Category, func(s string) string { return s }),
+		string(e.Metadata),
+	}
+}
+
+func fmtOptional[T any](v *T, format func(T) string) string {
+	if v == nil {
+		return ""
+	}
+	return format(*v)
+}
+
+// exportRow is implemented by the exported row types.
+type exportRow interface {
+	ExportAccount | ExportTransaction
+	csvHeader() []string
+	csvRecord() []string
+}
+
+// exportWriter encodes rows as they are read from the DB. flush writes any
+// buffered rows that form a complete unit of the format.
+type exportWriter[T exportRow] interface {
+	write(T) error
+	flush() error
+	close() error
+}
+
+func newExportWriter[T exportRow](w io.Writer, format string) exportWriter[T] {
+	switch format {
+	case ExportCSV:
+		return &csvExport[T]{w: csv.NewWriter(w)}
+	case ExportParquet:
+		return &parquetExport[T]{w: parquet.NewGenericWriter[T](w, parquet.MaxRowsPerRowGroup(exportRowGroupSize), parquet.Compression(&parquet.Snappy))}
+	}
+	return &jsonlExport[T]{enc: json.NewEncoder(w)}
+}
+
+type csvExport[T exportRow] struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (c *csvExport[T]) writeHeader() error {
+	if c.headerWritten {
+		return nil
+	}
+	c.headerWritten = true
+	var zero T
+	return c.w.Write(zero.csvHeader())
+}
+
+func (c *csvExport[T]) write(row T) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.w.Write(row.csvRecord())
+}
+
+func (c *csvExport[T]) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvExport[T]) close() error {
+	// An empty export still has a header row
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+type jsonlExport[T exportRow] struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlExport[T]) write(row T) error { return j.enc.Encode(row) }
+
+func (j *jsonlExport[T]) flush() error { return nil }
+
+func (j *jsonlExport[T]) close() error { return nil }
+
+// parquetExport buffers rows into row groups, so the response is only written
+// to when a row group is complete.
+type parquetExport[T exportRow] struct {
+	w   *parquet.GenericWriter[T]
+	buf []T
+}
+
+func (p *parquetExport[T]) write(row T) error {
+	if p.buf = append(p.buf, row); len(p.buf) < exportFlushRows {
+		return nil
+	}
+	return p.flush()
+}
+
+func (p *parquetExport[T]) flush() error {
+	_, err := p.w.Write(p.buf)
+	p.buf = p.buf[:0]
+	return err
+}
+
+func (p *parquetExport[T]) close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.w.Close()
+}
+
+func exportContentType(format string) string {
+	switch format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "application/x-ndjson"
+}
+
+// exportParams reads ?format=csv|jsonl|parquet (default jsonl), ?since_id=
+// and ?since= (RFC 3339). Without since_id the system accounts created by
+// migration, which have negative IDs, are included.
+func exportParams(v url.Values) (string, database.ExportParams, error) {
+	p := database.ExportParams{SinceID: math.MinInt64}
+	format := v.Get("format")
+	switch format {
+	case "":
+		format = ExportJSONL
+	case ExportCSV, ExportJSONL, ExportParquet:
+	default:
+		return "", p, fmt.Errorf("invalid format '%v', must be one of %v|%v|%v", format, ExportCSV, ExportJSONL, ExportParquet)
+	}
+	if s := v.Get("since_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 0 {
+			return "", p, fmt.Errorf("invalid since_id '%v'", s)
+		}
+		p.SinceID = id
+	}
+	if s := v.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return "", p, fmt.Errorf("invalid since '%v', must be an RFC 3339 timestamp", s)
+		}
+		p.Since = sql.NullTime{Time: t, Valid: true}
+	}
+	return format, p, nil
+}
+
+// acceptsGzip reports whether the request lists gzip in Accept-Encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, q, _ := strings.Cut(strings.TrimSpace(e), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(q, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportAccounts streams all accounts, or those created since ?since= with an
+// ID above ?since_id=, in ID order as ?format=csv|jsonl|parquet.
+func ExportAccounts(dbClient database.DBClient) http.HandlerFunc {
+	return exportHandler(dbClient, "accounts", func(q database.DBQuery, r *http.Request, p database.ExportParams, ew exportWriter[ExportAccount], flush func() error) error {
+		n := 0
+		return q.ExportAccounts(r.Context(), p, func(a database.Account) error {
+			if err := ew.write(newExportAccount(a)); err != nil {
+				return err
+			}
+			if n++; n%exportFlushRows == 0 {
+				return flush()
+			}
+			return nil
+		})
+	})
+}
+
+// ExportTransactions streams all transactions, or those created since ?since=
+// with an ID above ?since_id=, in ID order as ?format=csv|jsonl|parquet.
+func ExportTransactions(dbClient database.DBClient) http.HandlerFunc {
+	return exportHandler(dbClient, "transactions", func(q database.DBQuery, r *http.Request, p database.ExportParams, ew exportWriter[ExportTransaction], flush func() error) error {
+		n := 0
+		return q.ExportTransactions(r.Context(), p, func(tx database.Transaction) error {
+			if err := ew.write(newExportTransaction(tx)); err != nil {
+				return err
+			}
+			if n++; n%exportFlushRows == 0 {
+				return flush()
+			}
+			return nil
+		})
+	})
+}
+
+// exportHandler streams the rows written by export. Rows are read a page at a
+// time outside any DB transaction, so a slow client does not hold one open.
+// The response is gzip encoded if the client accepts it.
+func exportHandler[T exportRow](dbClient database.DBClient, name string, export func(database.DBQuery, *http.Request, database.ExportParams, exportWriter[T], func() error) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, p, err := exportParams(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", exportContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", name, format))
+		w.Header().Add("Vary", "Accept-Encoding")
+		var out io.Writer = w
+		var gz *gzip.Writer
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz = gzip.NewWriter(w)
+			out = gz
+		}
+		w.WriteHeader(http.StatusOK)
+
+		rc := http.NewResponseController(w)
+		ew := newExportWriter[T](out, format)
+		flush := func() error {
+			if err := ew.flush(); err != nil {
+				return err
+			}
+			if gz != nil {
+				if err := gz.Flush(); err != nil {
+					return err
+				}
+			}
+			return rc.Flush()
+		}
+
+		// The status has been sent, so failures can only be logged and the
+		// response cut short
+		err = export(dbClient.NewQuery(), r, p, ew, flush)
+		if err == nil {
+			err = ew.close()
+		}
+		if err == nil && gz != nil {
+			err = gz.Close()
+		}
+		if err != nil {
+			slog.Error("cannot stream export", "export", name, "request_id", logging.RequestID(r.Context()), "error", err)
+		}
+	}
+}
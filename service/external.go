@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultExternalTransfersLimit = 100
+	maxExternalTransfersLimit     = 1000
+
+	maxExternalReferenceLength = 128
+)
+
+// ExternalTransferRequest creates a deposit to or a withdrawal from a user
+// account over an external payment rail. ExternalReference identifies the
+// transfer on the rail and must be unique among deposits or withdrawals.
+// Status is pending by default, for rails that settle asynchronously, or
+// settled if the rail has already settled the transfer.
+type ExternalTransferRequest struct {
+	AccountID         int64  `json:"account_id"`
+	Amount            int64  `json:"amount"`
+	ExternalReference string `json:"external_reference"`
+	Status            string `json:"status,omitempty"`
+}
+
+// ExternalTransferFailRequest gives the reason a pending transfer failed.
+type ExternalTransferFailRequest struct {
+	Reason string `json:"reason"`
+}
+
+// systemAccountID returns the system account funds of kind are moved to or
+// from.
+func systemAccountID(kind string) int64 {
+	if kind == database.ExternalTransferDeposit {
+		return database.DepositsAccountID
+	}
+	return database.WithdrawalsAccountID
+}
+
+func validExternalTransferRequest(req ExternalTransferRequest) error {
+	if req.AccountID <= 0 {
+		return fmt.Errorf("invalid account_id '%v'", req.AccountID)
+	}
+	if req.Amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	if req.ExternalReference == "" {
+		return fmt.Errorf("external_reference is required")
+	}
+	if len(req.ExternalReference) > maxExternalReferenceLength {
+		return fmt.Errorf("external_reference must be at most %d characters", maxExternalReferenceLength)
+	}
+	switch req.Status {
+	case "", database.ExternalTransferPending, database.ExternalTransferSettled:
+	default:
+		return fmt.Errorf("invalid status '%v', must be one of %v|%v", req.Status, database.ExternalTransferPending, database.ExternalTransferSettled)
+	}
+	return nil
+}
+
+// CreateExternalTransfer creates a deposit or withdrawal of kind. Repeating a
+// request with the same external reference, account and amount returns the
+// existing transfer, so that rails may retry.
+func CreateExternalTransfer(dbClient database.DBClient, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ExternalTransferRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := validExternalTransferRequest(req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var et database.ExternalTransfer
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			var err error
+			et, err = createExternalTransfer(r.Context(), q, kind, req)
+			return err
+		}); err != nil {
+			respondWithErr(w, err)
+			return
+		}
+		logging.FromContext(r.Context()).InfoContext(r.Context(), kind+" created", "external_transfer_id", et.ID, "account_id", et.AccountID,
+			"amount", et.Amount, "external_reference", et.ExternalReference, "status", et.Status)
+
+		if err := RespondWithJSON(w, http.StatusOK, et); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// Deposit credits amount to a user account as a settled deposit in its own DB
+// transaction. It is used by tools, such as the seed command, that fund
+// accounts without the API.
+func Deposit(ctx context.Context, dbClient database.DBClient, accountID, amount int64, externalReference string) (database.ExternalTransfer, error) {
+	req := ExternalTransferRequest{AccountID: accountID, Amount: amount, ExternalReference: externalReference, Status: database.ExternalTransferSettled}
+	if err := validExternalTransferRequest(req); err != nil {
+		return database.ExternalTransfer{}, err
+	}
+	var et database.ExternalTransfer
+	err := dbClient.ExecTx(ctx, func(q database.DBQuery) error {
+		var err error
+		et, err = createExternalTransfer(ctx, q, database.ExternalTransferDeposit, req)
+		return err
+	})
+	return et, err
+}
+
+// createExternalTransfer records a transfer of kind. Settled deposits and all
+// withdrawals are posted against the system account of kind, so that funds
+// being withdrawn cannot be spent while the withdrawal is pending. It must be
+// called within ExecTx.
+func createExternalTransfer(ctx context.Context, q database.DBQuery, kind string, req ExternalTransferRequest) (database.ExternalTransfer, error) {
+	// Locking the account serializes retries of the same request. The system
+	// account is locked with it so that both are locked in ascending ID order,
+	// as they are when the transfer is settled or failed.
+	accs, err := lockAccounts(ctx, q, systemAccountID(kind), req.AccountID)
+	if err != nil {
+		return database.ExternalTransfer{}, err
+	}
+	if accs[req.AccountID].AccountType == database.AccountTypeSystem {
+		return database.ExternalTransfer{}, newAPIError(http.StatusBadRequest, "account %d is a system account", req.AccountID)
+	}
+
+	existing, err := q.GetExternalTransferByReference(ctx, database.GetExternalTransferByReferenceParams{Kind: kind, ExternalReference: req.ExternalReference})
+	switch {
+	case err == nil:
+		if existing.AccountID != req.AccountID || existing.Amount != req.Amount {
+			return existing, newAPIError(http.StatusConflict, "external reference %q is already used by %v %d", req.ExternalReference, kind, existing.ID)
+		}
+		return existing, nil
+	case !isNotFound(err):
+		return existing, err
+	}
+	if err := requireActive(accs[req.AccountID]); err != nil {
+		return database.ExternalTransfer{}, err
+	}
+
+	status := req.Status
+	if status == "" {
+		status = database.ExternalTransferPending
+	}
+	params := database.CreateExternalTransferParams{
+		Kind:              kind,
+		AccountID:         req.AccountID,
+		SystemAccountID:   systemAccountID(kind),
+		Amount:            req.Amount,
+		ExternalReference: req.ExternalReference,
+		Status:            status,
+	}
+	if kind == database.ExternalTransferWithdrawal || status == database.ExternalTransferSettled {
+		from, to := params.SystemAccountID, params.AccountID
+		if kind == database.ExternalTransferWithdrawal {
+			from, to = to, from
+		}
+		tx, err := postExternalTransfer(ctx, q, from, to, params.Amount)
+		if err != nil {
+			return database.ExternalTransfer{}, err
+		}
+		params.TransactionID = sql.NullInt64{Int64: tx.ID, Valid: true}
+	}
+	return q.CreateExternalTransfer(ctx, params)
+}
+
+// postExternalTransfer posts the movement of amount between a user account
+// and a system account.
+func postExternalTransfer(ctx context.Context, q database.DBQuery, from, to, amount int64) (database.Transaction, error) {
+	return postTransaction(ctx, q, database.CreateTransactionParams{
+		FromAccount: sql.NullInt64{Int64: from, Valid: true},
+		ToAccount:   sql.NullInt64{Int64: to, Valid: true},
+		Amount:      sql.NullInt64{Int64: amount, Valid: true},
+	})
+}
+
+// ExternalTransfers lists transfers of kind in ID order, optionally only those
+// of ?account_id= or with ?status=. Results are paged with ?after_id= and
+// ?limit=.
+func ExternalTransfers(dbClient database.DBClient, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := externalTransfersParams(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		params.Kind = kind
+
+		ets, err := dbClient.NewQuery().ListExternalTransfers(r.Context(), params)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if ets == nil {
+			ets = []database.ExternalTransfer{}
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, ets); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func externalTransfersParams(v url.Values) (database.ListExternalTransfersParams, error) {
+	p := database.ListExternalTransfersParams{MaxResults: defaultExternalTransfersLimit}
+	if s := v.Get("account_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid account_id '%v'", s)
+		}
+		p.AccountID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	if s := v.Get("status"); s != "" {
+		switch s {
+		case database.ExternalTransferPending, database.ExternalTransferSettled, database.ExternalTransferFailed:
+		default:
+			return p, fmt.Errorf("invalid status '%v', must be one of %v|%v|%v", s, database.ExternalTransferPending,
+				database.ExternalTransferSettled, database.ExternalTransferFailed)
+		}
+		p.Status = sql.NullString{String: s, Valid: true}
+	}
+	if s := v.Get("after_id"); s != "" {
+		afterID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || afterID < 0 {
+			return p, fmt.Errorf("invalid after_id '%v'", s)
+		}
+		p.AfterID = afterID
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxExternalTransfersLimit {
+			return p, fmt.Errorf("invalid limit '%v', must be between 1 and %d", s, maxExternalTransfersLimit)
+		}
+		p.MaxResults = int32(n)
+	}
+	return p, nil
+}
+
+func externalTransferID(r *http.Request, kind string) (int64, error) {
+	id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v id", kind)
+	}
+	return id, nil
+}
+
+// ExternalTransfer returns the transfer :id of kind.
+func ExternalTransfer(dbClient database.DBClient, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := externalTransferID(r, kind)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		et, err := dbClient.NewQuery().GetExternalTransfer(r.Context(), id)
+		if err != nil || et.Kind != kind {
+			if err == nil || isNotFound(err) {
+				RespondWithError(w, http.StatusNotFound, database.ErrNotFound)
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, et); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// SettleExternalTransfer settles the pending transfer :id of kind. A deposit
+// is posted to its account when settled.
+func SettleExternalTransfer(dbClient database.DBClient, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setExternalTransferStatus(w, r, dbClient, kind, database.ExternalTransferSettled, "")
+	}
+}
+
+// FailExternalTransfer fails the pending transfer :id of kind with a reason.
+// A withdrawal is reversed, returning the funds to its account.
+func FailExternalTransfer(dbClient database.DBClient, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ExternalTransferFailRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Reason == "" {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("a reason is required"))
+			return
+		}
+		setExternalTransferStatus(w, r, dbClient, kind, database.ExternalTransferFailed, req.Reason)
+	}
+}
+
+func setExternalTransferStatus(w http.ResponseWriter, r *http.Request, dbClient database.DBClient, kind, status, reason string) {
+	id, err := externalTransferID(r, kind)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var et database.ExternalTransfer
+	if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+		var err error
+		et, err = changeExternalTransferStatus(r.Context(), q, kind, id, status, reason)
+		return err
+	}); err != nil {
+		respondWithErr(w, err)
+		return
+	}
+	logging.FromContext(r.Context()).InfoContext(r.Context(), kind+" status changed", "external_transfer_id", et.ID,
+		"status", et.Status, "principal", Principal(r.Context()))
+
+	if err := RespondWithJSON(w, http.StatusOK, et); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// changeExternalTransferStatus settles or fails a pending transfer, posting a
+// settled deposit or reversing a failed withdrawal. It must be called within
+// ExecTx.
+func changeExternalTransferStatus(ctx context.Context, q database.DBQuery, kind string, id int64, status, reason string) (database.ExternalTransfer, error) {
+	et, err := q.GetExternalTransferForUpdate(ctx, id)
+	if err != nil || et.Kind != kind {
+		if err == nil || isNotFound(err) {
+			return et, newAPIError(http.StatusNotFound, "%v %d: %v", kind, id, database.ErrNotFound)
+		}
+		return et, err
+	}
+	if et.Status != database.ExternalTransferPending {
+		return et, newAPIError(http.StatusConflict, "%v %d is %v", kind, id, et.Status)
+	}
+
+	params := database.UpdateExternalTransferStatusParams{ID: id, Status: status, TransactionID: et.TransactionID}
+	switch {
+	case status == database.ExternalTransferSettled && kind == database.ExternalTransferDeposit:
+		tx, err := postExternalTransfer(ctx, q, et.SystemAccountID, et.AccountID, et.Amount)
+		if err != nil {
+			return et, err
+		}
+		params.TransactionID = sql.NullInt64{Int64: tx.ID, Valid: true}
+	case status == database.ExternalTransferFailed && kind == database.ExternalTransferWithdrawal:
+		// The funds are returned from the withdrawals account even if the
+		// account has been frozen since
+		tx, err := postReversal(ctx, q, database.CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: et.SystemAccountID, Valid: true},
+			ToAccount:   sql.NullInt64{Int64: et.AccountID, Valid: true},
+			Amount:      sql.NullInt64{Int64: et.Amount, Valid: true},
+		})
+		if err != nil {
+			return et, err
+		}
+		params.ReversalTransactionID = sql.NullInt64{Int64: tx.ID, Valid: true}
+	}
+	if reason != "" {
+		params.FailureReason = sql.NullString{String: reason, Valid: true}
+	}
+	return q.UpdateExternalTransferStatus(ctx, params)
+}
@@ -0,0 +1,455 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultFeeRulesLimit = 100
+	maxFeeRulesLimit     = 1000
+
+	maxFeeRuleNameLength = 128
+	maxFeeTiers          = 32
+	maxBasisPoints       = 10000
+)
+
+// FeeTier is a band of a tiered fee rule charging FlatFee plus BasisPoints of
+// amounts up to and including UpTo. The last tier has no UpTo and covers all
+// larger amounts.
+type FeeTier struct {
+	UpTo        *int64 `json:"up_to,omitempty"`
+	FlatFee     int64  `json:"flat_fee"`
+	BasisPoints int64  `json:"basis_points"`
+}
+
+// FeeRuleRequest creates a fee rule charged to the sender of transactions
+// from accounts of AccountType, which may only be user, from FromAccount and
+// to ToAccount. Unset keys
+// match any value. A flat rule charges FlatFee, a percentage rule charges
+// BasisPoints of the amount and a tiered rule charges the fee of the tier the
+// amount falls in. The fee is then raised to MinFee and lowered to MaxFee if
+// they are set.
+type FeeRuleRequest struct {
+	Name        string    `json:"name"`
+	AccountType string    `json:"account_type,omitempty"`
+	FromAccount *int64    `json:"from_account,omitempty"`
+	ToAccount   *int64    `json:"to_account,omitempty"`
+	Kind        string    `json:"kind"`
+	FlatFee     int64     `json:"flat_fee,omitempty"`
+	BasisPoints int64     `json:"basis_points,omitempty"`
+	MinFee      *int64    `json:"min_fee,omitempty"`
+	MaxFee      *int64    `json:"max_fee,omitempty"`
+	Tiers       []FeeTier `json:"tiers,omitempty"`
+}
+
+// FeeCharge is the fee charged for a transaction by one rule.
+type FeeCharge struct {
+	FeeRuleID int64  `json:"fee_rule_id"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Amount    int64  `json:"amount"`
+}
+
+// TxResponse is a posted transaction and the breakdown of the fees charged to
+// its sender. The total fee is paid to the fee revenue account by
+// FeeTransaction, which is only set if a fee was charged.
+type TxResponse struct {
+	database.Transaction
+	Fees           []FeeCharge           `json:"fees"`
+	TotalFee       int64                 `json:"total_fee"`
+	FeeTransaction *database.Transaction `json:"fee_transaction,omitempty"`
+}
+
+func validBasisPoints(bps int64) error {
+	if bps < 0 || bps > maxBasisPoints {
+		return fmt.Errorf("invalid basis_points '%v', must be between 0 and %d", bps, maxBasisPoints)
+	}
+	return nil
+}
+
+func validFeeTiers(tiers []FeeTier) error {
+	if len(tiers) == 0 || len(tiers) > maxFeeTiers {
+		return fmt.Errorf("a tiered rule must have between 1 and %d tiers", maxFeeTiers)
+	}
+	var prev int64
+	for i, t := range tiers {
+		last := i == len(tiers)-1
+		switch {
+		case last && t.UpTo != nil:
+			return fmt.Errorf("the last tier must not have up_to")
+		case !last && (t.UpTo == nil || *t.UpTo <= prev):
+			return fmt.Errorf("tier %d: up_to must be greater than %d", i, prev)
+		case t.FlatFee < 0:
+			return fmt.Errorf("tier %d: flat_fee cannot be negative", i)
+		}
+		if err := validBasisPoints(t.BasisPoints); err != nil {
+			return fmt.Errorf("tier %d: %v", i, err)
+		}
+		if t.UpTo != nil {
+			prev = *t.UpTo
+		}
+	}
+	return nil
+}
+
+// feeRuleParams validates a request and returns the parameters of the rule.
+func feeRuleParams(req FeeRuleRequest) (database.CreateFeeRuleParams, error) {
+	p := database.CreateFeeRuleParams{Name: req.Name, Kind: req.Kind, FlatFee: req.FlatFee, BasisPoints: req.BasisPoints, Tiers: json.RawMessage("[]")}
+	if req.Name == "" || len(req.Name) > maxFeeRuleNameLength {
+		return p, fmt.Errorf("name must be between 1 and %d characters", maxFeeRuleNameLength)
+	}
+	// Transfers from system accounts are not charged fees
+	switch req.AccountType {
+	case "":
+	case database.AccountTypeUser:
+		p.AccountType = sql.NullString{String: req.AccountType, Valid: true}
+	default:
+		return p, fmt.Errorf("invalid account_type '%v', fees are only charged to %v accounts", req.AccountType, database.AccountTypeUser)
+	}
+	if req.FromAccount != nil {
+		p.FromAccount = sql.NullInt64{Int64: *req.FromAccount, Valid: true}
+	}
+	if req.ToAccount != nil {
+		p.ToAccount = sql.NullInt64{Int64: *req.ToAccount, Valid: true}
+	}
+	if p.FromAccount.Valid && p.ToAccount.Valid && p.FromAccount.Int64 == p.ToAccount.Int64 {
+		return p, fmt.Errorf("to and from account cannot match")
+	}
+
+	switch req.Kind {
+	case database.FeeRuleFlat:
+		if req.FlatFee <= 0 || req.BasisPoints != 0 || len(req.Tiers) > 0 {
+			return p, fmt.Errorf("a flat rule must have a positive flat_fee and no basis_points or tiers")
+		}
+	case database.FeeRulePercentage:
+		if req.BasisPoints <= 0 || req.FlatFee != 0 || len(req.Tiers) > 0 {
+			return p, fmt.Errorf("a percentage rule must have positive basis_points and no flat_fee or tiers")
+		}
+		if err := validBasisPoints(req.BasisPoints); err != nil {
+			return p, err
+		}
+	case database.FeeRuleTiered:
+		if req.FlatFee != 0 || req.BasisPoints != 0 {
+			return p, fmt.Errorf("a tiered rule must have its flat_fee and basis_points in its tiers")
+		}
+		if err := validFeeTiers(req.Tiers); err != nil {
+			return p, err
+		}
+		b, err := json.Marshal(req.Tiers)
+		if err != nil {
+			return p, err
+		}
+		p.Tiers = b
+	default:
+		return p, fmt.Errorf("invalid kind '%v', must be one of %v|%v|%v", req.Kind, database.FeeRuleFlat,
+			database.FeeRulePercentage, database.FeeRuleTiered)
+	}
+
+	if req.MinFee != nil {
+		if *req.MinFee < 0 {
+			return p, fmt.Errorf("min_fee cannot be negative")
+		}
+		p.MinFee = sql.NullInt64{Int64: *req.MinFee, Valid: true}
+	}
+	if req.MaxFee != nil {
+		if *req.MaxFee < 0 {
+			return p, fmt.Errorf("max_fee cannot be negative")
+		}
+		p.MaxFee = sql.NullInt64{Int64: *req.MaxFee, Valid: true}
+	}
+	if p.MinFee.Valid && p.MaxFee.Valid && p.MinFee.Int64 > p.MaxFee.Int64 {
+		return p, fmt.Errorf("min_fee cannot be greater than max_fee")
+	}
+	return p, nil
+}
+
+// percentOf returns bps basis points of amount rounded half up. The amount is
+// split so that the product cannot overflow.
+func percentOf(amount, bps int64) int64 {
+	return amount/maxBasisPoints*bps + (amount%maxBasisPoints*bps+maxBasisPoints/2)/maxBasisPoints
+}
+
+// ruleFee returns the fee charged by rule on a transaction of amount.
+func ruleFee(rule database.FeeRule, amount int64) (int64, error) {
+	var fee int64
+	switch rule.Kind {
+	case database.FeeRuleFlat:
+		fee = rule.FlatFee
+	case database.FeeRulePercentage:
+		fee = percentOf(amount, rule.BasisPoints)
+	case database.FeeRuleTiered:
+		var tiers []FeeTier
+		if err := json.Unmarshal(rule.Tiers, &tiers); err != nil {
+			return 0, fmt.Errorf("fee rule %d has invalid tiers: %w", rule.ID, err)
+		}
+		for _, t := range tiers {
+			if t.UpTo == nil || amount <= *t.UpTo {
+				fee = t.FlatFee + percentOf(amount, t.BasisPoints)
+				break
+			}
+		}
+	default:
+		return 0, fmt.Errorf("fee rule %d has invalid kind '%v'", rule.ID, rule.Kind)
+	}
+	if rule.MinFee.Valid && fee < rule.MinFee.Int64 {
+		fee = rule.MinFee.Int64
+	}
+	if rule.MaxFee.Valid && fee > rule.MaxFee.Int64 {
+		fee = rule.MaxFee.Int64
+	}
+	return fee, nil
+}
+
+// postTransactionWithFees posts a transfer requested by a client and charges
+// its sender the fees of every matching active rule. Transfers to or from
+// system accounts are rejected. The fees are paid to the fee revenue account
+// by a single fee transaction and the sender must have the funds for both. It
+// must be called within ExecTx.
+func postTransactionWithFees(ctx context.Context, q database.DBQuery, p database.CreateTransactionParams) (TxResponse, error) {
+	from, to, amount := p.FromAccount.Int64, p.ToAccount.Int64, p.Amount.Int64
+	resp := TxResponse{Fees: []FeeCharge{}}
+
+	// Account types cannot change, so they are checked and the rules are
+	// found before the accounts are locked
+	accs := make(map[int64]database.Account, 2)
+	for _, id := range []int64{from, to} {
+		acc, err := q.GetUser(ctx, id)
+		if err != nil {
+			if isNotFound(err) {
+				return resp, newAPIError(http.StatusBadRequest, "account %d: %v", id, database.ErrNotFound)
+			}
+			return resp, err
+		}
+		if err := requireUserAccount(acc); err != nil {
+			return resp, err
+		}
+		accs[id] = acc
+	}
+	rules, err := q.ListMatchingFeeRules(ctx, database.ListMatchingFeeRulesParams{AccountType: accs[from].AccountType, FromAccount: from, ToAccount: to})
+	if err != nil {
+		return resp, err
+	}
+	for _, rule := range rules {
+		fee, err := ruleFee(rule, amount)
+		if err != nil {
+			return resp, err
+		}
+		if fee == 0 {
+			continue
+		}
+		if fee > math.MaxInt64-amount-resp.TotalFee {
+			return resp, newAPIError(http.StatusBadRequest, "fees on amount %d overflow", amount)
+		}
+		resp.Fees = append(resp.Fees, FeeCharge{FeeRuleID: rule.ID, Name: rule.Name, Kind: rule.Kind, Amount: fee})
+		resp.TotalFee += fee
+	}
+	if resp.TotalFee == 0 {
+		resp.Transaction, err = postTransaction(ctx, q, p)
+		return resp, err
+	}
+
+	// Lock the fee revenue account in order with the others and check that the
+	// sender can pay the fees before anything is written
+	if accs, err = lockAccounts(ctx, q, from, to, database.FeeRevenueAccountID); err != nil {
+		return resp, err
+	}
+	for _, id := range []int64{from, to, database.FeeRevenueAccountID} {
+		if err := requireActive(accs[id]); err != nil {
+			return resp, err
+		}
+	}
+	if err := requireFunds(accs[from], amount+resp.TotalFee); err != nil {
+		return resp, err
+	}
+
+	if resp.Transaction, err = postTransaction(ctx, q, p); err != nil {
+		return resp, err
+	}
+	feeTx, err := postTransaction(ctx, q, database.CreateTransactionParams{
+		FromAccount: p.FromAccount,
+		ToAccount:   sql.NullInt64{Int64: database.FeeRevenueAccountID, Valid: true},
+		Amount:      sql.NullInt64{Int64: resp.TotalFee, Valid: true},
+		Category:    sql.NullString{String: database.TransactionCategoryFee, Valid: true},
+	})
+	if err != nil {
+		return resp, err
+	}
+	resp.FeeTransaction = &feeTx
+	for _, fee := range resp.Fees {
+		if _, err := q.CreateTransactionFee(ctx, database.CreateTransactionFeeParams{
+			TransactionID:    resp.Transaction.ID,
+			FeeRuleID:        fee.FeeRuleID,
+			FeeTransactionID: feeTx.ID,
+			Amount:           fee.Amount,
+		}); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// CreateFeeRule creates an active fee rule. Rules cannot be changed, only
+// disabled, so that every fee charged keeps the rule it was charged by.
+func CreateFeeRule(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req FeeRuleRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		params, err := feeRuleParams(req)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var fr database.FeeRule
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			var ids []int64
+			for _, id := range []sql.NullInt64{params.FromAccount, params.ToAccount} {
+				if id.Valid {
+					ids = append(ids, id.Int64)
+				}
+			}
+			if _, err := lockAccounts(r.Context(), q, ids...); err != nil {
+				return err
+			}
+			var err error
+			fr, err = q.CreateFeeRule(r.Context(), params)
+			return err
+		}); err != nil {
+			respondWithErr(w, err)
+			return
+		}
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "fee rule created", "fee_rule_id", fr.ID, "name", fr.Name,
+			"kind", fr.Kind, "principal", Principal(r.Context()))
+
+		if err := RespondWithJSON(w, http.StatusOK, fr); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// FeeRules lists fee rules in ID order, optionally only those with ?status=.
+// Results are paged with ?after_id= and ?limit=.
+func FeeRules(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := feeRulesParams(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		frs, err := dbClient.NewQuery().ListFeeRules(r.Context(), params)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if frs == nil {
+			frs = []database.FeeRule{}
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, frs); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func feeRulesParams(v url.Values) (database.ListFeeRulesParams, error) {
+	p := database.ListFeeRulesParams{MaxResults: defaultFeeRulesLimit}
+	if s := v.Get("status"); s != "" {
+		switch s {
+		case database.FeeRuleActive, database.FeeRuleDisabled:
+		default:
+			return p, fmt.Errorf("invalid status '%v', must be one of %v|%v", s, database.FeeRuleActive, database.FeeRuleDisabled)
+		}
+		p.Status = sql.NullString{String: s, Valid: true}
+	}
+	if s := v.Get("after_id"); s != "" {
+		afterID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || afterID < 0 {
+			return p, fmt.Errorf("invalid after_id '%v'", s)
+		}
+		p.AfterID = afterID
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxFeeRulesLimit {
+			return p, fmt.Errorf("invalid limit '%v', must be between 1 and %d", s, maxFeeRulesLimit)
+		}
+		p.MaxResults = int32(n)
+	}
+	return p, nil
+}
+
+// FeeRule returns the fee rule :id.
+func FeeRule(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid fee rule id"))
+			return
+		}
+
+		fr, err := dbClient.NewQuery().GetFeeRule(r.Context(), id)
+		if err != nil {
+			if isNotFound(err) {
+				RespondWithError(w, http.StatusNotFound, database.ErrNotFound)
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, fr); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// DisableFeeRule stops the active fee rule :id being charged.
+func DisableFeeRule(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid fee rule id"))
+			return
+		}
+
+		var fr database.FeeRule
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			var err error
+			if fr, err = q.GetFeeRule(r.Context(), id); err != nil {
+				if isNotFound(err) {
+					return newAPIError(http.StatusNotFound, "fee rule %d: %v", id, database.ErrNotFound)
+				}
+				return err
+			}
+			if fr.Status != database.FeeRuleActive {
+				return newAPIError(http.StatusConflict, "fee rule %d is %v", id, fr.Status)
+			}
+			fr, err = q.UpdateFeeRuleStatus(r.Context(), database.UpdateFeeRuleStatusParams{ID: id, Status: database.FeeRuleDisabled})
+			return err
+		}); err != nil {
+			respondWithErr(w, err)
+			return
+		}
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "fee rule disabled", "fee_rule_id", fr.ID,
+			"principal", Principal(r.Context()))
+
+		if err := RespondWithJSON(w, http.StatusOK, fr); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
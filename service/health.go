@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+)
+
+// Probe identifies a health endpoint a check contributes to.
+type Probe int
+
+const (
+	// ProbeLiveness checks report whether the process should be restarted.
+	ProbeLiveness Probe = 1 << iota
+	// ProbeReadiness checks report whether the service can take traffic.
+	ProbeReadiness
+	// ProbeStartup checks report whether the service has finished starting.
+	ProbeStartup
+)
+
+// CheckResult is the outcome of a single health check.
+type CheckResult struct {
+	Name      string         `json:"name"`
+	Healthy   bool           `json:"healthy"`
+	Message   string         `json:"message,omitempty"`
+	LatencyMs float64        `json:"latency_ms"`
+	Details   map[string]any `json:"details,omitempty"`
+	CheckedAt time.Time      `json:"checked_at"`
+}
+
+// HealthChecker checks a single service dependency.
+type HealthChecker interface {
+	Name() string
+	Check(ctx context.Context) CheckResult
+}
+
+// CheckFunc adapts a function to the HealthChecker interface.
+type CheckFunc struct {
+	CheckName string
+	Fn        func(ctx context.Context) CheckResult
+}
+
+func (c CheckFunc) Name() string {
+	return c.CheckName
+}
+
+func (c CheckFunc) Check(ctx context.Context) CheckResult {
+	return c.Fn(ctx)
+}
+
+type registeredCheck struct {
+	checker HealthChecker
+	probes  Probe
+	ttl     time.Duration
+
+	mu     sync.Mutex
+	last   CheckResult
+	hasRun bool
+}
+
+// HealthRegistry holds the health checks run by the probe endpoints. Results are
+// cached per check so that frequent probes do not overload dependencies.
+type HealthRegistry struct {
+	mu      sync.RWMutex
+	checks  []*registeredCheck
+	timeout time.Duration
+}
+
+// NewHealthRegistry returns an empty registry. Each check is given timeout to complete.
+func NewHealthRegistry(timeout time.Duration) *HealthRegistry {
+	return &HealthRegistry{timeout: timeout}
+}
+
+// Register adds a check to the supplied probes. Its result is reused for ttl;
+// a zero ttl runs the check on every probe.
+func (h *HealthRegistry) Register(c HealthChecker, ttl time.Duration, probes Probe) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, &registeredCheck{checker: c, probes: probes, ttl: ttl})
+}
+
+// Run executes, or returns cached results for, all checks registered for the
+// probe. Checks run in registration order.
+func (h *HealthRegistry) Run(ctx context.Context, probe Probe) []CheckResult {
+	h.mu.RLock()
+	var checks []*registeredCheck
+	for _, c := range h.checks {
+		if c.probes&probe != 0 {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	results := make([]CheckResult, 0, len(checks))
+	for _, c := range checks {
+		results = append(results, h.run(ctx, c))
+	}
+	return results
+}
+
+func (h *HealthRegistry) run(ctx context.Context, c *registeredCheck) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hasRun && time.Since(c.last.CheckedAt) < c.ttl {
+		return c.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	start := time.Now()
+	res := c.checker.Check(ctx)
+	res.Name = c.checker.Name()
+	res.CheckedAt = start
+	if res.LatencyMs == 0 {
+		res.LatencyMs = msSince(start)
+	}
+
+	c.last, c.hasRun = res, true
+	return res
+}
+
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
+
+func healthy() CheckResult {
+	return CheckResult{Healthy: true}
+}
+
+func unhealthy(format string, a ...any) CheckResult {
+	return CheckResult{Healthy: false, Message: fmt.Sprintf(format, a...)}
+}
+
+// dbPingCheck pings the DB and fails if the round trip exceeds maxLatency.
+func dbPingCheck(dbClient database.DBClient, maxLatency time.Duration) HealthChecker {
+	return CheckFunc{CheckName: "db", Fn: func(ctx context.Context) CheckResult {
+		if dbClient == nil || dbClient.DB() == nil {
+			return unhealthy("no connection")
+		}
+		start := time.Now()
+		err := dbClient.DB().Ping()
+		latency := time.Since(start)
+		res := healthy()
+		if err != nil {
+			res = unhealthy("%v", err)
+		} else if maxLatency > 0 && latency > maxLatency {
+			res = unhealthy("ping latency %v exceeds threshold %v", latency, maxLatency)
+		}
+		res.LatencyMs = float64(latency.Microseconds()) / 1000
+		return res
+	}}
+}
+
+// schemaCheck verifies that the DB schema version is accepted by the binary.
+func schemaCheck(dbClient database.DBClient, schema *schemaInfo) HealthChecker {
+	return CheckFunc{CheckName: "schema", Fn: func(ctx context.Context) CheckResult {
+		version, dirty, err := dbClient.SchemaVersion(ctx, schema.table)
+		if err != nil {
+			return unhealthy("%v", err)
+		}
+		res := healthy()
+		if err := schema.check(version, dirty); err != nil {
+			res = unhealthy("%v", err)
+		}
+		res.Details = map[string]any{"schema_version": version, "expected_version": schema.expected}
+		return res
+	}}
+}
+
+// poolStats is implemented by DB clients that pool a fixed number of connections.
+type poolStats interface {
+	PoolStats() (inUse, size int)
+}
+
+// poolCheck fails if the fraction of pooled DB clients in use reaches maxSaturation.
+func poolCheck(pool poolStats, maxSaturation float64) HealthChecker {
+	return CheckFunc{CheckName: "pool", Fn: func(ctx context.Context) CheckResult {
+		inUse, size := pool.PoolStats()
+		saturation := float64(inUse) / float64(size)
+		res := healthy()
+		if saturation >= maxSaturation {
+			res = unhealthy("%d of %d DB clients in use", inUse, size)
+		}
+		res.Details = map[string]any{"in_use": inUse, "size": size, "saturation": saturation}
+		return res
+	}}
+}
+
+// diskCheck fails if the free space on the file system containing path falls
+// below minFreeBytes.
+func diskCheck(path string, minFreeBytes uint64) HealthChecker {
+	return CheckFunc{CheckName: "disk", Fn: func(ctx context.Context) CheckResult {
+		free, err := freeDiskSpace(path)
+		if err != nil {
+			return unhealthy("%v", err)
+		}
+		res := healthy()
+		if free < minFreeBytes {
+			res = unhealthy("%d bytes free on log volume, minimum is %d", free, minFreeBytes)
+		}
+		res.Details = map[string]any{"path": path, "free_bytes": free}
+		return res
+	}}
+}
+
+// BacklogCheck fails if the depth of a queue, e.g. an outbox table, exceeds max.
+func BacklogCheck(name string, depth func(ctx context.Context) (int64, error), max int64) HealthChecker {
+	return CheckFunc{CheckName: name, Fn: func(ctx context.Context) CheckResult {
+		n, err := depth(ctx)
+		if err != nil {
+			return unhealthy("%v", err)
+		}
+		res := healthy()
+		if n > max {
+			res = unhealthy("backlog of %d exceeds threshold %d", n, max)
+		}
+		res.Details = map[string]any{"backlog": n, "threshold": max}
+		return res
+	}}
+}
+
+// flagCheck reports unhealthy with msg while fn returns false.
+func flagCheck(name, msg string, fn func() bool) HealthChecker {
+	return CheckFunc{CheckName: name, Fn: func(ctx context.Context) CheckResult {
+		if !fn() {
+			return unhealthy("%s", msg)
+		}
+		return healthy()
+	}}
+}
+
+// ProbeResponse contains probe response fields. Individual check results are
+// only included in verbose mode.
+type ProbeResponse struct {
+	Status  string        `json:"status"`
+	Service string        `json:"service,omitempty"`
+	Version string        `json:"version,omitempty"`
+	Checks  []CheckResult `json:"checks,omitempty"`
+}
+
+// ProbeHandler runs the checks registered for the probe and responds with 503
+// if any fail. Append ?verbose to the request URL to list each check result.
+func ProbeHandler(registry *HealthRegistry, probe Probe) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results := registry.Run(r.Context(), probe)
+		resp := &ProbeResponse{Status: "ok", Service: ServiceName, Version: Version}
+		httpCode := http.StatusOK
+		for _, res := range results {
+			if !res.Healthy {
+				resp.Status = "failed"
+				httpCode = http.StatusServiceUnavailable
+			}
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			resp.Checks = results
+		}
+		if err := RespondWithJSON(w, httpCode, resp); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
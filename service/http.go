@@ -7,14 +7,22 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ATMackay/psql-ledger/logging"
 	"github.com/julienschmidt/httprouter"
+	"golang.org/x/time/rate"
 )
 
 type HTTPService struct {
 	server *http.Server
+	errCh  chan error
 }
 
 func NewHTTPService(port int, api *API) HTTPService {
@@ -27,6 +35,7 @@ func NewHTTPService(port int, api *API) HTTPService {
 			Handler:           handler,
 			ReadHeaderTimeout: 5 * time.Second,
 		},
+		errCh: make(chan error, 1),
 	}
 }
 
@@ -34,25 +43,60 @@ func (h *HTTPService) Addr() string {
 	return h.server.Addr
 }
 
+// Handler returns the root HTTP handler of the server.
+func (h *HTTPService) Handler() http.Handler {
+	return h.server.Handler
+}
+
 func (h *HTTPService) Start() {
 	go func() {
 		slog.Info(fmt.Sprintf("server listening on http://0.0.0.0%v", h.Addr()))
 		if err := h.server.ListenAndServe(); err != nil {
 			slog.Warn("serverTerminated", "error", err)
+			if !errors.Is(err, http.ErrServerClosed) {
+				h.errCh <- err
+			}
 		}
 	}()
 }
 
+// Err returns a channel that receives the error if the server fails.
+func (h *HTTPService) Err() <-chan error {
+	return h.errCh
+}
+
 func (h *HTTPService) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	return h.Shutdown(ctx)
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests
+// to complete or for ctx to expire.
+func (h *HTTPService) Shutdown(ctx context.Context) error {
 	return h.server.Shutdown(ctx)
 }
 
+// EndPoint describes a route. Public endpoints do not require an API key and
+// are not rate limited. Admin endpoints require an admin API key. RateClass
+// defaults to read for GET requests and write otherwise.
 type EndPoint struct {
 	Path       string
 	Handler    http.HandlerFunc
 	MethodType string
+	Public     bool
+	Admin      bool
+	RateClass  string
+}
+
+func (e EndPoint) rateClass() string {
+	if e.RateClass != "" {
+		return e.RateClass
+	}
+	if e.MethodType == http.MethodGet {
+		return RateClassRead
+	}
+	return RateClassWrite
 }
 
 func NewEndpoint(path, methodType string, handler http.HandlerFunc) EndPoint {
@@ -65,6 +109,9 @@ func NewEndpoint(path, methodType string, handler http.HandlerFunc) EndPoint {
 
 type API struct {
 	Endpoints []EndPoint
+
+	auth   authenticator
+	limits RateLimits
 }
 
 func MakeAPI(endpoints []EndPoint) *API {
@@ -79,13 +126,39 @@ func (a *API) AddEndpoint(e EndPoint) {
 	a.Endpoints = append(a.Endpoints, e)
 }
 
+// RequireAPIKeys requires one of the supplied API keys on all non-public
+// endpoints, and one of the admin keys on admin endpoints.
+func (a *API) RequireAPIKeys(keys []APIKey) {
+	a.auth = authenticator{keys: keys}
+}
+
+// SetRateLimits sets the rate limits applied to non-public endpoints.
+func (a *API) SetRateLimits(l RateLimits) {
+	a.limits = l
+}
+
 func (a *API) Routes() *httprouter.Router {
 
 	router := httprouter.New()
 
+	idempotencyKeys := newIdempotencyStore()
+	limiter := newRateLimiter(a.limits)
+	inFlight := new(atomic.Int64)
+
 	for _, e := range a.Endpoints {
 
-		router.Handler(e.MethodType, e.Path, logHTTPRequest(e.Handler))
+		var h http.Handler = e.Handler
+		// Reads sent as POST, such as /account-by-index, are not cached
+		if e.rateClass() == RateClassWrite {
+			h = idempotent(idempotencyKeys, h)
+		}
+		if !e.Public {
+			h = limiter.limit(e.rateClass(), h)
+			h = a.auth.authenticate(e.Admin, h)
+			h = limitInFlight(a.limits.MaxInFlight, inFlight, h)
+		}
+
+		router.Handler(e.MethodType, e.Path, traceHTTPRequest(e.MethodType, e.Path, withRequestID(logHTTPRequest(h))))
 
 	}
 	return router
@@ -94,28 +167,35 @@ func (a *API) Routes() *httprouter.Router {
 // HTTP logging middleware
 
 // logHTTPRequest provides logging middleware. It surfaces low level request/response data from the http server.
+// A logger carrying the request ID is added to the request context for use by handlers and the DB layer.
 func logHTTPRequest(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		start := time.Now()
-		statusRecorder := &responseRecorder{ResponseWriter: w}
-		h.ServeHTTP(statusRecorder, req)
+		logger := slog.Default().With("request_id", logging.RequestID(req.Context()))
+		info := &requestInfo{}
+		ctx := logging.WithLogger(context.WithValue(req.Context(), requestInfoKey, info), logger)
+
+		body := &countingReader{ReadCloser: req.Body}
+		req.Body = body
+		statusRecorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		h.ServeHTTP(statusRecorder, req.WithContext(ctx))
 		elapsed := time.Since(start)
 		httpCode := statusRecorder.statusCode
+
+		level := slog.LevelInfo
 		if httpCode > 499 {
-			slog.Error(req.URL.Path, "http_method", req.Method,
-				"http_code", httpCode,
-				"elapsed_microseconds", elapsed.Microseconds())
-			return
+			level = slog.LevelError
+		} else if httpCode > 399 {
+			level = slog.LevelWarn
 		}
-		if httpCode > 399 {
-			slog.Warn(req.URL.Path, "http_method", req.Method,
-				"http_code", httpCode,
-				"elapsed_microseconds", elapsed.Microseconds())
-			return
-		}
-		slog.Info(req.URL.Path, "http_method", req.Method,
+		logger.Log(ctx, level, req.URL.Path, "http_method", req.Method,
 			"http_code", httpCode,
-			"elapsed_microseconds", elapsed.Microseconds())
+			"elapsed_microseconds", elapsed.Microseconds(),
+			"remote_addr", req.RemoteAddr,
+			"user_agent", req.UserAgent(),
+			"request_bytes", body.n.Load(),
+			"response_bytes", statusRecorder.bytes,
+			"principal", info.principal)
 	})
 }
 
@@ -123,7 +203,7 @@ type responseRecorder struct {
 	http.ResponseWriter
 
 	statusCode int
-	response   []byte
+	bytes      int
 }
 
 func (w *responseRecorder) WriteHeader(statusCode int) {
@@ -132,8 +212,14 @@ func (w *responseRecorder) WriteHeader(statusCode int) {
 }
 
 func (w *responseRecorder) Write(b []byte) (int, error) {
-	w.response = b
-	return w.ResponseWriter.Write(b)
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController flush streamed responses.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func RespondWithJSON(w http.ResponseWriter, code int, payload any) error {
@@ -159,11 +245,15 @@ func RespondWithError(w http.ResponseWriter, code int, msg any) {
 	case string:
 		message = m
 	}
-	_ = RespondWithJSON(w, code, map[string]string{"error": message})
+	body := map[string]string{"error": message}
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	_ = RespondWithJSON(w, code, body)
 }
 
 func HandleResponseErr(resp *http.Response) error {
-	if resp.StatusCode != 200 {
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
 		var v jsonErr
 		if err := DecodeJSON(resp.Body, &v); err != nil {
 			return fmt.Errorf("cannot parse JSON body from error response: %w", err)
@@ -174,5 +264,130 @@ func HandleResponseErr(resp *http.Response) error {
 }
 
 type jsonErr struct {
-	Err string `json:"error"`
+	Err       string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Rate limit classes. Each client has a separate token bucket per class.
+const (
+	RateClassRead  = "read"
+	RateClassWrite = "write"
+)
+
+// RateLimits configures request rate limiting. A zero rate disables the limit
+// for that class and a zero MaxInFlight disables the in-flight cap.
+type RateLimits struct {
+	ReadRate    float64
+	ReadBurst   int
+	WriteRate   float64
+	WriteBurst  int
+	MaxInFlight int
+}
+
+const (
+	limiterIdleTimeout = 10 * time.Minute
+	maxLimiters        = 10000
+)
+
+type classLimit struct {
+	rate  float64
+	burst int
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter holds a token bucket per client and rate class. Clients are
+// identified by their authenticated principal or, failing that, remote IP.
+type rateLimiter struct {
+	limits map[string]classLimit
+
+	mu      sync.Mutex
+	buckets map[string]*limiterEntry
+}
+
+func newRateLimiter(l RateLimits) *rateLimiter {
+	return &rateLimiter{
+		limits:  map[string]classLimit{RateClassRead: {l.ReadRate, l.ReadBurst}, RateClassWrite: {l.WriteRate, l.WriteBurst}},
+		buckets: make(map[string]*limiterEntry),
+	}
+}
+
+func (r *rateLimiter) bucket(class, client string, now time.Time) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := class + " " + client
+	if e, ok := r.buckets[key]; ok {
+		e.lastSeen = now
+		return e.limiter
+	}
+	if len(r.buckets) >= maxLimiters {
+		for k, e := range r.buckets {
+			if now.Sub(e.lastSeen) > limiterIdleTimeout {
+				delete(r.buckets, k)
+			}
+		}
+	}
+	l := r.limits[class]
+	e := &limiterEntry{limiter: rate.NewLimiter(rate.Limit(l.rate), l.burst), lastSeen: now}
+	r.buckets[key] = e
+	return e.limiter
+}
+
+func clientKey(req *http.Request) string {
+	if p := Principal(req.Context()); p != "" {
+		return "principal:" + p
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// limit rejects requests exceeding the client's rate for the class with 429.
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set on
+// every response.
+func (r *rateLimiter) limit(class string, h http.Handler) http.Handler {
+	if r.limits[class].rate <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		now := time.Now()
+		l := r.bucket(class, clientKey(req), now)
+		allowed := l.AllowN(now, 1)
+		tokens := l.TokensAt(now)
+
+		// Seconds until the bucket is full again
+		reset := int(math.Ceil((float64(l.Burst()) - tokens) / float64(l.Limit())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(l.Burst()))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+		if !allowed {
+			retryAfter := int(math.Ceil((1 - tokens) / float64(l.Limit())))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			RespondWithError(w, http.StatusTooManyRequests, fmt.Sprintf("%v rate limit exceeded", class))
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// limitInFlight responds with 503 once max requests are being served.
+func limitInFlight(max int, inFlight *atomic.Int64, h http.Handler) http.Handler {
+	if max <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if inFlight.Add(1) > int64(max) {
+			inFlight.Add(-1)
+			w.Header().Set("Retry-After", "1")
+			RespondWithError(w, http.StatusServiceUnavailable, "too many requests in flight")
+			return
+		}
+		defer inFlight.Add(-1)
+		h.ServeHTTP(w, req)
+	})
 }
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header clients set on write requests so that
+// retries of the same request are only applied once.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	idempotencyTTL     = 24 * time.Hour
+	maxIdempotencyKeys = 10000
+)
+
+type cachedResponse struct {
+	inFlight bool
+	bodyHash []byte
+	code     int
+	header   http.Header
+	body     []byte
+	expires  time.Time
+}
+
+type idempotencyEntry struct {
+	key  string
+	resp *cachedResponse
+}
+
+// idempotencyStore holds the responses of completed write requests keyed by
+// principal, method, path and idempotency key. Entries are kept in memory for
+// idempotencyTTL. Once maxIdempotencyKeys are held the least recently used
+// entry is evicted.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newIdempotencyStore() *idempotencyStore {
+	return &idempotencyStore{entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// begin returns the cached response for key if one exists. Otherwise the key is
+// marked in-flight and the caller is responsible for calling finish or abort.
+func (s *idempotencyStore) begin(key string, now time.Time) (*cachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		c := el.Value.(*idempotencyEntry).resp
+		if c.inFlight || now.Before(c.expires) {
+			s.lru.MoveToFront(el)
+			return c, true
+		}
+		s.remove(el)
+	}
+	s.add(key, &cachedResponse{inFlight: true})
+	return nil, false
+}
+
+func (s *idempotencyStore) finish(key string, c *cachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*idempotencyEntry).resp = c
+		s.lru.MoveToFront(el)
+		return
+	}
+	// The in-flight entry was evicted
+	s.add(key, c)
+}
+
+func (s *idempotencyStore) abort(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+}
+
+// add inserts key as the most recently used entry, evicting the least recently
+// used entries above maxIdempotencyKeys.
+func (s *idempotencyStore) add(key string, c *cachedResponse) {
+	for s.lru.Len() >= maxIdempotencyKeys {
+		s.remove(s.lru.Back())
+	}
+	s.entries[key] = s.lru.PushFront(&idempotencyEntry{key: key, resp: c})
+}
+
+func (s *idempotencyStore) remove(el *list.Element) {
+	delete(s.entries, el.Value.(*idempotencyEntry).key)
+	s.lru.Remove(el)
+}
+
+func (s *idempotencyStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// idempotent replays the stored response for write requests carrying an
+// Idempotency-Key header that has already been processed. It is only applied to
+// mutating endpoints. Server errors are not
+// stored so that the client may retry them. A key reused with a different
+// request body is rejected, and the replayed response carries the request ID
+// of the retry.
+func idempotent(store *idempotencyStore, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		storeKey := fmt.Sprintf("%s %s %s %s", Principal(req.Context()), req.Method, req.URL.Path, key)
+
+		// The body is hashed as it is read so that it need not be buffered
+		hash := sha256.New()
+		body := req.Body
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(body, hash), body}
+
+		cached, ok := store.begin(storeKey, time.Now())
+		if ok {
+			if cached.inFlight {
+				RespondWithError(w, http.StatusConflict, "request with the same idempotency key is in progress")
+				return
+			}
+			if _, err := io.Copy(io.Discard, req.Body); err != nil {
+				RespondWithError(w, http.StatusBadRequest, err)
+				return
+			}
+			if !bytes.Equal(hash.Sum(nil), cached.bodyHash) {
+				RespondWithError(w, http.StatusUnprocessableEntity, "idempotency key was used with a different request body")
+				return
+			}
+			for k, v := range cached.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(cached.code)
+			_, _ = w.Write(cached.body)
+			return
+		}
+
+		rec := &bodyRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, req)
+
+		// Any of the body the handler did not read is hashed
+		_, err := io.Copy(io.Discard, req.Body)
+		if rec.code >= http.StatusInternalServerError || err != nil {
+			store.abort(storeKey)
+			return
+		}
+		header := w.Header().Clone()
+		header.Del(RequestIDHeader)
+		store.finish(storeKey, &cachedResponse{
+			bodyHash: hash.Sum(nil),
+			code:     rec.code,
+			header:   header,
+			body:     rec.body.Bytes(),
+			expires:  time.Now().Add(idempotencyTTL),
+		})
+	})
+}
+
+// bodyRecorder records the status code and full response body written by a handler.
+type bodyRecorder struct {
+	http.ResponseWriter
+
+	code int
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) WriteHeader(statusCode int) {
+	w.code = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
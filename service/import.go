@@ -0,0 +1,700 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Import kinds and formats.
+const (
+	ImportAccounts     = "accounts"
+	ImportTransactions = "transactions"
+
+	ImportCSV   = "csv"
+	ImportJSONL = "jsonl"
+)
+
+// Import job statuses.
+const (
+	ImportQueued    = "queued"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+const (
+	// maxImportErrors is the number of row errors reported per job. Further
+	// errors are only counted.
+	maxImportErrors = 1000
+	// maxImportJobs is the number of jobs whose status is retained.
+	maxImportJobs = 100
+	// importQueueSize is the number of jobs that may wait to be run.
+	importQueueSize = 10
+)
+
+var errImportDryRun = errors.New("dry run")
+
+// ImportJob reports the progress of an import. Rows counts the records read,
+// Imported those loaded (or that would have been loaded in a dry run) and
+// Failed those rejected. Each chunk is committed separately, so a failed job
+// may have been partially imported.
+type ImportJob struct {
+	ID              string           `json:"id"`
+	Kind            string           `json:"kind"`
+	Format          string           `json:"format"`
+	DryRun          bool             `json:"dry_run"`
+	Status          string           `json:"status"`
+	Rows            int              `json:"rows"`
+	Imported        int              `json:"imported"`
+	Failed          int              `json:"failed"`
+	Errors          []ImportRowError `json:"errors"`
+	ErrorsTruncated bool             `json:"errors_truncated,omitempty"`
+	Error           string           `json:"error,omitempty"`
+	CreatedAt       time.Time        `json:"created_at"`
+	StartedAt       *time.Time       `json:"started_at,omitempty"`
+	FinishedAt      *time.Time       `json:"finished_at,omitempty"`
+}
+
+// ImportRowError is a rejected record. Line is the line of the file the record
+// starts on.
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importAccount and importTransaction are the records of an import file, read
+// from CSV columns or JSONL fields of the same names. Metadata is a JSON object,
+// written as JSON text in a CSV column.
+type importAccount struct {
+	Username          string          `json:"username"`
+	Email             string          `json:"email"`
+	Description       string          `json:"description"`
+	ExternalReference string          `json:"external_reference"`
+	Category          string          `json:"category"`
+	Metadata          json.RawMessage `json:"metadata"`
+}
+
+type importTransaction struct {
+	FromAccount       int64           `json:"from_account"`
+	ToAccount         int64           `json:"to_account"`
+	Amount            int64           `json:"amount"`
+	Description       string          `json:"description"`
+	ExternalReference string          `json:"external_reference"`
+	Category          string          `json:"category"`
+	Metadata          json.RawMessage `json:"metadata"`
+}
+
+type importRow[T any] struct {
+	line   int
+	params T
+}
+
+// importer runs import jobs one at a time. Uploaded files are spooled to disk
+// so that the request can return before the job runs.
+type importer struct {
+	dbClient  database.DBClient
+	chunkSize int
+	queue     chan importTask
+
+	mu   sync.Mutex
+	jobs map[string]*ImportJob
+	ids  []string
+}
+
+type importTask struct {
+	job  *ImportJob
+	file string
+}
+
+func newImporter(dbClient database.DBClient, chunkSize int) *importer {
+	return &importer{
+		dbClient:  dbClient,
+		chunkSize: chunkSize,
+		queue:     make(chan importTask, importQueueSize),
+		jobs:      make(map[string]*ImportJob),
+	}
+}
+
+// Import spools the request body, a CSV or JSONL file of ?kind=accounts or
+// ?kind=transactions selected with ?format=csv|jsonl, and queues an import job.
+// With ?dry_run=true every chunk is rolled back. The job is returned with 202
+// Accepted and can be polled at the Location header.
+func (im *importer) Import(maxBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query()
+		job := &ImportJob{Kind: v.Get("kind"), Format: v.Get("format"), Status: ImportQueued, Errors: []ImportRowError{}, CreatedAt: time.Now().UTC()}
+		if job.Kind != ImportAccounts && job.Kind != ImportTransactions {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid kind '%v', must be one of %v|%v", job.Kind, ImportAccounts, ImportTransactions))
+			return
+		}
+		if job.Format != ImportCSV && job.Format != ImportJSONL {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid format '%v', must be one of %v|%v", job.Format, ImportCSV, ImportJSONL))
+			return
+		}
+		if s := v.Get("dry_run"); s != "" {
+			dryRun, err := strconv.ParseBool(s)
+			if err != nil {
+				RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid dry_run '%v'", s))
+				return
+			}
+			job.DryRun = dryRun
+		}
+
+		file, err := spoolImport(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				RespondWithError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("import file larger than %d bytes", maxBytes))
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		job.ID = newImportID()
+		im.add(job)
+		select {
+		case im.queue <- importTask{job: job, file: file}:
+		default:
+			_ = os.Remove(file)
+			err := fmt.Errorf("import queue is full")
+			im.finish(job, err)
+			RespondWithError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		slog.Info("import queued", "job_id", job.ID, "kind", job.Kind, "format", job.Format, "dry_run", job.DryRun)
+
+		w.Header().Set("Location", strings.Replace(ImportJobEndPnt, ":id", job.ID, 1))
+		if err := RespondWithJSON(w, http.StatusAccepted, im.get(job.ID)); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// Job returns the status and row errors of the import job :id.
+func (im *importer) Job() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job := im.get(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+		if job == nil {
+			RespondWithError(w, http.StatusNotFound, database.ErrNotFound)
+			return
+		}
+		if err := RespondWithJSON(w, http.StatusOK, job); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func spoolImport(r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "psql-ledger-import-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+func newImportID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (im *importer) add(job *ImportJob) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.jobs[job.ID] = job
+	im.ids = append(im.ids, job.ID)
+	// Forget the oldest finished jobs
+	for i := 0; len(im.jobs) > maxImportJobs && i < len(im.ids); {
+		if old := im.jobs[im.ids[i]]; old.Status == ImportCompleted || old.Status == ImportFailed {
+			delete(im.jobs, im.ids[i])
+			im.ids = slices.Delete(im.ids, i, i+1)
+			continue
+		}
+		i++
+	}
+}
+
+// get returns a copy of the job so that it can be encoded while the job runs.
+func (im *importer) get(id string) *ImportJob {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	job, ok := im.jobs[id]
+	if !ok {
+		return nil
+	}
+	c := *job
+	// Rows rejected by the DB checks are reported when their chunk is loaded
+	c.Errors = slices.Clone(job.Errors)
+	slices.SortStableFunc(c.Errors, func(a, b ImportRowError) int { return a.Line - b.Line })
+	return &c
+}
+
+func (im *importer) update(job *ImportJob, fn func(*ImportJob)) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	fn(job)
+}
+
+// run is a WorkerFunc running queued jobs until ctx is cancelled. Jobs still
+// queued on shutdown are failed.
+func (im *importer) run(ctx context.Context) error {
+	for {
+		select {
+		case t := <-im.queue:
+			im.process(ctx, t)
+		case <-ctx.Done():
+			for {
+				select {
+				case t := <-im.queue:
+					_ = os.Remove(t.file)
+					im.finish(t.job, errors.New("service shut down before the import started"))
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (im *importer) process(ctx context.Context, t importTask) {
+	defer os.Remove(t.file)
+	im.update(t.job, func(j *ImportJob) {
+		now := time.Now().UTC()
+		j.Status, j.StartedAt = ImportRunning, &now
+	})
+	ctx = database.WithActor(ctx, "import:"+t.job.ID)
+
+	f, err := os.Open(t.file)
+	if err != nil {
+		im.finish(t.job, err)
+		return
+	}
+	defer f.Close()
+
+	if t.job.Kind == ImportAccounts {
+		err = runImport(ctx, im, t.job, f, parseImportAccount, im.loadAccounts(t.job))
+	} else {
+		err = runImport(ctx, im, t.job, f, parseImportTransaction, im.loadTransactions)
+	}
+	im.finish(t.job, err)
+}
+
+func (im *importer) finish(job *ImportJob, err error) {
+	var done ImportJob
+	im.update(job, func(j *ImportJob) {
+		now := time.Now().UTC()
+		j.Status, j.FinishedAt = ImportCompleted, &now
+		if err != nil {
+			j.Status, j.Error = ImportFailed, err.Error()
+		}
+		done = *j
+	})
+	slog.Info("import finished", "job_id", done.ID, "status", done.Status, "rows", done.Rows, "imported", done.Imported, "failed", done.Failed, "error", err)
+}
+
+func (im *importer) reject(job *ImportJob, line int, err error) {
+	im.update(job, func(j *ImportJob) {
+		j.Failed++
+		if len(j.Errors) == maxImportErrors {
+			j.ErrorsTruncated = true
+			return
+		}
+		j.Errors = append(j.Errors, ImportRowError{Line: line, Error: err.Error()})
+	})
+}
+
+// loadFunc loads a chunk of valid rows within a DB transaction. It returns the
+// rows rejected by checks against the DB, which are not loaded.
+type loadFunc[T any] func(ctx context.Context, q database.DBQuery, rows []importRow[T]) (map[int]error, error)
+
+// runImport reads, validates and loads the records of r a chunk at a time.
+// Each chunk is loaded in its own DB transaction, which is rolled back in a
+// dry run.
+func runImport[T any](ctx context.Context, im *importer, job *ImportJob, r io.Reader, parse func(map[string]string) (T, error), load loadFunc[T]) error {
+	records, err := newImportReader(r, job.Format)
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]importRow[T], 0, im.chunkSize)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		var rejected map[int]error
+		err := im.dbClient.ExecTx(ctx, func(q database.DBQuery) error {
+			var err error
+			if rejected, err = load(ctx, q, chunk); err != nil {
+				return err
+			}
+			if job.DryRun {
+				return errImportDryRun
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errImportDryRun) {
+			return fmt.Errorf("cannot load rows from line %d: %w", chunk[0].line, err)
+		}
+		for _, row := range chunk {
+			if err, ok := rejected[row.line]; ok {
+				im.reject(job, row.line, err)
+			}
+		}
+		im.update(job, func(j *ImportJob) { j.Imported += len(chunk) - len(rejected) })
+		chunk = chunk[:0]
+		return nil
+	}
+
+	for {
+		line, fields, err := records.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("import interrupted: %w", err)
+		}
+		im.update(job, func(j *ImportJob) { j.Rows++ })
+		if err != nil {
+			var syntax *importSyntaxError
+			if !errors.As(err, &syntax) {
+				return err
+			}
+			im.reject(job, line, err)
+			continue
+		}
+		params, err := parse(fields)
+		if err != nil {
+			im.reject(job, line, err)
+			continue
+		}
+		if chunk = append(chunk, importRow[T]{line: line, params: params}); len(chunk) == im.chunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func parseImportAccount(fields map[string]string) (database.CreateAccountParams, error) {
+	p := database.CreateAccountParams{Username: fields["username"]}
+	if p.Username == "" {
+		return p, fmt.Errorf("username is required")
+	}
+	if email := fields["email"]; email != "" {
+		p.Email = sql.NullString{String: email, Valid: true}
+	}
+	p.Description, p.ExternalReference, p.Category = importString(fields, "description"), importString(fields, "external_reference"), importString(fields, "category")
+	p.Metadata = json.RawMessage(fields["metadata"])
+	if err := validAccountParams(p); err != nil {
+		return p, err
+	}
+	p.Metadata = metadataOrEmpty(p.Metadata)
+	return p, nil
+}
+
+func parseImportTransaction(fields map[string]string) (database.CreateTransactionParams, error) {
+	var p database.CreateTransactionParams
+	for _, f := range []struct {
+		name string
+		dst  *sql.NullInt64
+	}{{"from_account", &p.FromAccount}, {"to_account", &p.ToAccount}, {"amount", &p.Amount}} {
+		n, err := strconv.ParseInt(fields[f.name], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid %v '%v'", f.name, fields[f.name])
+		}
+		*f.dst = sql.NullInt64{Int64: n, Valid: true}
+	}
+	p.Description, p.ExternalReference, p.Category = importString(fields, "description"), importString(fields, "external_reference"), importString(fields, "category")
+	p.Metadata = json.RawMessage(fields["metadata"])
+	if err := validTxParams(p); err != nil {
+		return p, err
+	}
+	p.Metadata = metadataOrEmpty(p.Metadata)
+	return p, nil
+}
+
+// importString returns the optional field name, which is null if empty.
+func importString(fields map[string]string, name string) sql.NullString {
+	if s := fields[name]; s != "" {
+		return sql.NullString{String: s, Valid: true}
+	}
+	return sql.NullString{}
+}
+
+// loadAccounts applies the uniqueness checks of CreateAccount, including
+// against rows earlier in the file, and copies the remaining accounts.
+func (im *importer) loadAccounts(job *ImportJob) loadFunc[database.CreateAccountParams] {
+	usernames, emails, refs := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	return func(ctx context.Context, q database.DBQuery, rows []importRow[database.CreateAccountParams]) (map[int]error, error) {
+		rejected := make(map[int]error)
+		var valid []database.CreateAccountParams
+		for _, row := range rows {
+			p := row.params
+			switch {
+			case usernames[p.Username]:
+				rejected[row.line] = fmt.Errorf("username already exists")
+				continue
+			case p.Email.Valid && emails[p.Email.String]:
+				rejected[row.line] = fmt.Errorf("email already exists")
+				continue
+			case p.ExternalReference.Valid && refs[p.ExternalReference.String]:
+				rejected[row.line] = fmt.Errorf("external reference already exists")
+				continue
+			}
+			if u, err := q.GetUserByUsername(ctx, p.Username); err != nil && !isNotFound(err) {
+				return nil, err
+			} else if u.ID != 0 {
+				rejected[row.line] = fmt.Errorf("username already exists")
+				continue
+			}
+			if p.Email.Valid {
+				if u, err := q.GetUserByEmail(ctx, p.Email); err != nil && !isNotFound(err) {
+					return nil, err
+				} else if u.Email.Valid {
+					rejected[row.line] = fmt.Errorf("email already exists")
+					continue
+				}
+			}
+			if p.ExternalReference.Valid {
+				if u, err := q.GetUserByExternalReference(ctx, p.ExternalReference); err != nil && !isNotFound(err) {
+					return nil, err
+				} else if u.ID != 0 {
+					rejected[row.line] = fmt.Errorf("external reference already exists")
+					continue
+				}
+			}
+			usernames[p.Username] = true
+			if p.Email.Valid {
+				emails[p.Email.String] = true
+			}
+			if p.ExternalReference.Valid {
+				refs[p.ExternalReference.String] = true
+			}
+			valid = append(valid, p)
+		}
+		if len(valid) == 0 {
+			return rejected, nil
+		}
+		_, err := q.CopyAccounts(ctx, valid)
+		return rejected, err
+	}
+}
+
+// loadTransactions locks the accounts of the chunk in ascending ID order,
+// rejects transfers involving unknown, system or inactive accounts, exceeding
+// the sender's funds or reusing an external reference of the sender and copies
+// the remaining transactions, applying the net balance change of each account.
+// Imported transactions record transfers made elsewhere and are not charged
+// fees.
+func (im *importer) loadTransactions(ctx context.Context, q database.DBQuery, rows []importRow[database.CreateTransactionParams]) (map[int]error, error) {
+	var ids []int64
+	for _, row := range rows {
+		ids = append(ids, row.params.FromAccount.Int64, row.params.ToAccount.Int64)
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	accs := make(map[int64]database.Account, len(ids))
+	for _, id := range ids {
+		acc, err := q.GetUserForUpdate(ctx, id)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		accs[id] = acc
+	}
+
+	type ref struct {
+		from int64
+		ref  string
+	}
+	refs := make(map[ref]bool)
+	rejected := make(map[int]error)
+	var valid []database.CreateTransactionParams
+	deltas := make(map[int64]int64)
+rows:
+	for _, row := range rows {
+		p := row.params
+		for _, id := range []int64{p.FromAccount.Int64, p.ToAccount.Int64} {
+			acc, ok := accs[id]
+			if !ok {
+				rejected[row.line] = fmt.Errorf("account %d: %v", id, database.ErrNotFound)
+				continue rows
+			}
+			if err := requireUserAccount(acc); err != nil {
+				rejected[row.line] = err
+				continue rows
+			}
+			if err := requireActive(acc); err != nil {
+				rejected[row.line] = err
+				continue rows
+			}
+		}
+		if p.ExternalReference.Valid {
+			key := ref{p.FromAccount.Int64, p.ExternalReference.String}
+			if refs[key] {
+				rejected[row.line] = fmt.Errorf("external reference already exists")
+				continue
+			}
+			if _, err := q.GetTxByExternalReference(ctx, database.GetTxByExternalReferenceParams{FromAccount: p.FromAccount, ExternalReference: p.ExternalReference}); err == nil {
+				rejected[row.line] = fmt.Errorf("external reference already exists")
+				continue
+			} else if !isNotFound(err) {
+				return nil, err
+			}
+		}
+		// Balances are tracked through the chunk so that each transfer is
+		// checked against the funds left by the rows before it
+		from, to := accs[p.FromAccount.Int64], accs[p.ToAccount.Int64]
+		if err := requireFunds(from, p.Amount.Int64); err != nil {
+			rejected[row.line] = err
+			continue
+		}
+		from.Balance -= p.Amount.Int64
+		to.Balance += p.Amount.Int64
+		accs[from.ID], accs[to.ID] = from, to
+		if p.ExternalReference.Valid {
+			refs[ref{p.FromAccount.Int64, p.ExternalReference.String}] = true
+		}
+		valid = append(valid, p)
+		deltas[p.FromAccount.Int64] -= p.Amount.Int64
+		deltas[p.ToAccount.Int64] += p.Amount.Int64
+	}
+	if len(valid) == 0 {
+		return rejected, nil
+	}
+	if _, err := q.CopyTransactions(ctx, valid); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if delta, ok := deltas[id]; ok && delta != 0 {
+			if _, err := q.AddAccountBalance(ctx, database.AddAccountBalanceParams{ID: id, Amount: delta}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rejected, nil
+}
+
+// importSyntaxError is a record that cannot be decoded. It is reported against
+// the record rather than failing the job.
+type importSyntaxError struct {
+	err error
+}
+
+func (e *importSyntaxError) Error() string { return e.err.Error() }
+
+// importReader returns the records of an import file as field maps together
+// with the line each record starts on.
+type importReader interface {
+	next() (line int, fields map[string]string, err error)
+}
+
+func newImportReader(r io.Reader, format string) (importReader, error) {
+	if format == ImportJSONL {
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 64<<10), 1<<20)
+		return &jsonlImportReader{s: s}, nil
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("CSV header row is missing")
+		}
+		return nil, fmt.Errorf("cannot read CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	return &csvImportReader{r: cr, header: header}, nil
+}
+
+type csvImportReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func (c *csvImportReader) next() (int, map[string]string, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return parseErr.StartLine, nil, &importSyntaxError{err}
+		}
+		return 0, nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+	if len(record) != len(c.header) {
+		return line, nil, &importSyntaxError{fmt.Errorf("expected %d fields, got %d", len(c.header), len(record))}
+	}
+	fields := make(map[string]string, len(record))
+	for i, v := range record {
+		fields[c.header[i]] = strings.TrimSpace(v)
+	}
+	return line, fields, nil
+}
+
+type jsonlImportReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func (j *jsonlImportReader) next() (int, map[string]string, error) {
+	for j.s.Scan() {
+		j.line++
+		b := j.s.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return j.line, nil, &importSyntaxError{fmt.Errorf("invalid JSON: %v", err)}
+		}
+		fields := make(map[string]string, len(raw))
+		for k, v := range raw {
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				// Numbers are kept in their JSON form
+				s = string(v)
+			}
+			fields[k] = s
+		}
+		return j.line, fields, nil
+	}
+	if err := j.s.Err(); err != nil {
+		return j.line, nil, err
+	}
+	return j.line, nil, io.EOF
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+	"github.com/julienschmidt/httprouter"
+)
+
+// interestActor is recorded in the audit log for interest posted by the
+// interest job.
+const interestActor = "interest"
+
+// interestDenominator converts an annual rate in basis points to a daily
+// rate on an Actual/365 basis.
+const interestDenominator = maxBasisPoints * 365
+
+// InterestRateRequest sets the annual interest rate of an account.
+type InterestRateRequest struct {
+	AnnualRateBps int64 `json:"annual_rate_bps"`
+}
+
+// InterestResponse is the interest rate of an account and the interest accrued
+// since it was last posted. AccruedFrom and AccruedTo are the first and last
+// days accrued, and are only set if there are unposted accruals.
+type InterestResponse struct {
+	AccountID     int64      `json:"account_id"`
+	AnnualRateBps int64      `json:"annual_rate_bps"`
+	Accrued       int64      `json:"accrued"`
+	Accruals      int64      `json:"accruals"`
+	AccruedFrom   *time.Time `json:"accrued_from,omitempty"`
+	AccruedTo     *time.Time `json:"accrued_to,omitempty"`
+}
+
+// roundHalfEven returns n/d rounded to the nearest integer, with ties
+// rounded to the even integer. d must be positive.
+func roundHalfEven(n, d int64) int64 {
+	q, r := n/d, n%d
+	if r < 0 {
+		q, r = q-1, r+d
+	}
+	if 2*r > d || (2*r == d && q&1 != 0) {
+		q++
+	}
+	return q
+}
+
+// dailyInterest returns the interest earned in a day by a closing balance at
+// an annual rate of bps basis points, and the fraction of a minor unit,
+// in units of 1/interestDenominator, carried to the next day. The carry of the
+// previous day is included before rounding so that rounding errors do not
+// accumulate. Only positive balances earn interest. The balance is split so
+// that the product cannot overflow.
+func dailyInterest(balance, bps, carry int64) (int64, int64) {
+	if balance <= 0 || bps == 0 {
+		return 0, carry
+	}
+	n := balance%interestDenominator*bps + carry
+	rounded := roundHalfEven(n, interestDenominator)
+	return balance/interestDenominator*bps + rounded, n - rounded*interestDenominator
+}
+
+// setInterestRate changes the annual interest rate of the user account id.
+// Completed days are accrued at the old rate first, so a changed rate applies
+// from the start of the current UTC day. A first rate applies from the next
+// day. It must be called within ExecTx.
+func setInterestRate(ctx context.Context, q database.DBQuery, id, bps int64) (database.InterestRate, error) {
+	accs, err := lockAccounts(ctx, q, id)
+	if err != nil {
+		return database.InterestRate{}, err
+	}
+	acc := accs[id]
+	if acc.AccountType == database.AccountTypeSystem {
+		return database.InterestRate{}, newAPIError(http.StatusConflict, "account %d is a system account and does not earn interest", id)
+	}
+	if acc.Status == database.AccountStatusClosed {
+		return database.InterestRate{}, newAPIError(http.StatusConflict, "account %d is %v", id, acc.Status)
+	}
+	if err := accrueCompletedDays(ctx, q, id); err != nil {
+		return database.InterestRate{}, err
+	}
+	return q.UpsertInterestRate(ctx, database.UpsertInterestRateParams{AccountID: id, AnnualRateBps: bps})
+}
+
+// accrueCompletedDays accrues the interest of the account, if it has a rate,
+// for the UTC days completed before the current DB transaction started. It
+// must be called within ExecTx.
+func accrueCompletedDays(ctx context.Context, q database.DBQuery, id int64) error {
+	now, err := q.GetTransactionTime(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := accrueInterest(ctx, q, id, now.UTC().Truncate(24*time.Hour)); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// accrueInterest records the interest earned by the closing balance of the
+// account on each UTC day that ended at or before until and has not been
+// accrued, starting from the day after its rate was set, since the balance
+// earned interest for only part of that day. The rate is locked so that
+// each day is accrued once, and returns a not found error if the account has
+// no rate. It returns the number of days accrued and must be called within
+// ExecTx.
+func accrueInterest(ctx context.Context, q database.DBQuery, id int64, until time.Time) (int, error) {
+	rate, err := q.GetInterestRateForUpdate(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	var next time.Time
+	var carry int64
+	latest, err := q.GetLatestInterestAccrual(ctx, id)
+	switch {
+	case err == nil:
+		next, carry = latest.Day.UTC().AddDate(0, 0, 1), latest.Carry
+	case isNotFound(err):
+		next = rate.CreatedAt.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	default:
+		return 0, fmt.Errorf("cannot read latest interest accrual: %w", err)
+	}
+
+	var days int
+	for ; !next.AddDate(0, 0, 1).After(until); next = next.AddDate(0, 0, 1) {
+		balance, err := database.BalanceAsOf(ctx, q, id, next.AddDate(0, 0, 1).Add(-time.Microsecond))
+		if err != nil {
+			return days, fmt.Errorf("cannot read closing balance for %v: %w", next.Format(time.DateOnly), err)
+		}
+		var amount int64
+		amount, carry = dailyInterest(balance, rate.AnnualRateBps, carry)
+		if _, err := q.CreateInterestAccrual(ctx, database.CreateInterestAccrualParams{
+			AccountID:     id,
+			Day:           next,
+			Balance:       balance,
+			AnnualRateBps: rate.AnnualRateBps,
+			Amount:        amount,
+			Carry:         carry,
+		}); err != nil {
+			return days, fmt.Errorf("cannot accrue interest for %v: %w", next.Format(time.DateOnly), err)
+		}
+		days++
+	}
+	return days, nil
+}
+
+// postInterest posts the interest accrued by the account in each UTC month
+// that ended at or before until as a transaction from the interest expense
+// account, in its own DB transaction, and marks the accruals posted. Months
+// that accrued no interest are marked posted without a transaction. It
+// returns the number of months posted.
+func postInterest(ctx context.Context, dbClient database.DBClient, id int64, until time.Time) (int, error) {
+	u := until.UTC()
+	monthStart := time.Date(u.Year(), u.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var months int
+	for ctx.Err() == nil {
+		posted := false
+		err := dbClient.ExecTx(ctx, func(q database.DBQuery) error {
+			sum, err := q.SumUnpostedInterest(ctx, database.SumUnpostedInterestParams{AccountID: id, Before: sql.NullTime{Time: monthStart, Valid: true}})
+			if err != nil || sum.Accruals == 0 {
+				return err
+			}
+			from := sum.FromDay.Time.UTC()
+			end := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+			if sum, err = q.SumUnpostedInterest(ctx, database.SumUnpostedInterestParams{AccountID: id, Before: sql.NullTime{Time: end, Valid: true}}); err != nil {
+				return err
+			}
+			var txID sql.NullInt64
+			if sum.Amount > 0 {
+				tx, err := postTransaction(ctx, q, database.CreateTransactionParams{
+					FromAccount: sql.NullInt64{Int64: database.InterestExpenseAccountID, Valid: true},
+					ToAccount:   sql.NullInt64{Int64: id, Valid: true},
+					Amount:      sql.NullInt64{Int64: sum.Amount, Valid: true},
+					Category:    sql.NullString{String: database.TransactionCategoryInterest, Valid: true},
+				})
+				if err != nil {
+					return fmt.Errorf("cannot post interest for %v: %w", from.Format("2006-01"), err)
+				}
+				txID = sql.NullInt64{Int64: tx.ID, Valid: true}
+			}
+			posted = true
+			_, err = q.MarkInterestAccrualsPosted(ctx, database.MarkInterestAccrualsPostedParams{TransactionID: txID, AccountID: id, Before: end})
+			return err
+		})
+		if err != nil || !posted {
+			return months, err
+		}
+		months++
+	}
+	return months, nil
+}
+
+// interestJob accrues daily interest on the closing balances of accounts with
+// an interest rate and posts it monthly. Accruals are keyed by account and
+// day, so the job can be rerun, or run by several service instances, without
+// accruing or posting interest twice.
+type interestJob struct {
+	dbClient database.DBClient
+	interval time.Duration
+	now      func() time.Time
+}
+
+func newInterestJob(dbClient database.DBClient, interval time.Duration) *interestJob {
+	return &interestJob{dbClient: dbClient, interval: interval, now: time.Now}
+}
+
+// run accrues and posts interest on start and then every interval.
+func (j *interestJob) run(ctx context.Context) error {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		days, err := j.runUntil(ctx, j.now())
+		if err != nil && ctx.Err() == nil {
+			slog.Error("cannot accrue interest", "error", err)
+		}
+		if days > 0 {
+			slog.Debug("accrued interest", "days", days)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// runUntil accrues interest for the days and posts it for the months that
+// ended at or before until, and before any DB transaction in progress
+// started, and returns the number of account days accrued.
+// Failures are logged per account so that one account cannot hold back the
+// others; interest that cannot be posted, for example to a frozen account,
+// is retried on the next run.
+func (j *interestJob) runUntil(ctx context.Context, until time.Time) (int, error) {
+	ctx = database.WithActor(ctx, interestActor)
+	until, err := database.SettledUntil(ctx, j.dbClient.NewQuery(), until)
+	if err != nil {
+		return 0, err
+	}
+	rates, err := j.dbClient.NewQuery().ListInterestRates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, rate := range rates {
+		if ctx.Err() != nil {
+			break
+		}
+		var days int
+		if err := j.dbClient.ExecTx(ctx, func(q database.DBQuery) error {
+			var err error
+			days, err = accrueInterest(ctx, q, rate.AccountID, until)
+			return err
+		}); err != nil {
+			slog.Error("cannot accrue interest", "account_id", rate.AccountID, "error", err)
+			continue
+		}
+		total += days
+		months, err := postInterest(ctx, j.dbClient, rate.AccountID, until)
+		var ae *apiError
+		switch {
+		case errors.As(err, &ae):
+			slog.Warn("cannot post interest", "account_id", rate.AccountID, "error", err)
+		case err != nil:
+			slog.Error("cannot post interest", "account_id", rate.AccountID, "error", err)
+		}
+		if months > 0 {
+			slog.Debug("posted interest", "account_id", rate.AccountID, "months", months)
+		}
+	}
+	return total, nil
+}
+
+// SetInterestRate sets the annual interest rate, in basis points, earned by
+// the user account :id. The change is recorded in the audit log.
+func SetInterestRate(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid account id"))
+			return
+		}
+		var req InterestRateRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.AnnualRateBps < 0 || req.AnnualRateBps > maxBasisPoints {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid annual_rate_bps '%v', must be between 0 and %d", req.AnnualRateBps, maxBasisPoints))
+			return
+		}
+
+		var rate database.InterestRate
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			var err error
+			rate, err = setInterestRate(r.Context(), q, id, req.AnnualRateBps)
+			return err
+		}); err != nil {
+			respondWithErr(w, err)
+			return
+		}
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "account interest rate changed", "account_id", rate.AccountID,
+			"annual_rate_bps", rate.AnnualRateBps, "principal", Principal(r.Context()))
+
+		if err := RespondWithJSON(w, http.StatusOK, rate); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// AccountInterest returns the interest rate of account :id and the interest it
+// has accrued but not yet been paid. Accounts without a rate earn none.
+func AccountInterest(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid account id"))
+			return
+		}
+
+		q := dbClient.NewQuery()
+		if _, err := q.GetUser(r.Context(), id); err != nil {
+			if isNotFound(err) {
+				RespondWithError(w, http.StatusNotFound, database.ErrNotFound)
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp := InterestResponse{AccountID: id}
+		rate, err := q.GetInterestRate(r.Context(), id)
+		switch {
+		case err == nil:
+			resp.AnnualRateBps = rate.AnnualRateBps
+		case !isNotFound(err):
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		sum, err := q.SumUnpostedInterest(r.Context(), database.SumUnpostedInterestParams{AccountID: id})
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Accrued, resp.Accruals = sum.Amount, sum.Accruals
+		if sum.FromDay.Valid {
+			resp.AccruedFrom, resp.AccruedTo = &sum.FromDay.Time, &sum.ToDay.Time
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, resp); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
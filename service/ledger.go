@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/ATMackay/psql-ledger/database"
+)
+
+// apiError is an error carrying the HTTP status code it should be reported with.
+type apiError struct {
+	code int
+	err  error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+func newAPIError(code int, format string, a ...any) error {
+	return &apiError{code: code, err: fmt.Errorf(format, a...)}
+}
+
+// respondWithErr responds with the status code of an apiError or 500 otherwise.
+func respondWithErr(w http.ResponseWriter, err error) {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		RespondWithError(w, ae.code, ae.err)
+		return
+	}
+	RespondWithError(w, http.StatusInternalServerError, err)
+}
+
+// validTxParams checks the fields of a transfer between two accounts.
+func validTxParams(p database.CreateTransactionParams) error {
+	// Validate amount
+	if p.Amount.Int64 <= 0 {
+		return fmt.Errorf("cannot send negative amount '%v'", p.Amount)
+	}
+	if p.FromAccount.Int64 == p.ToAccount.Int64 {
+		return fmt.Errorf("to and from account cannot match")
+	}
+	if p.ExternalReference.Valid && p.ExternalReference.String == "" {
+		return fmt.Errorf("external reference cannot be empty")
+	}
+	return validMetadata(p.Metadata)
+}
+
+// lockAccounts locks the accounts for update in ascending ID order so that
+// concurrent transfers cannot deadlock. It must be called within ExecTx.
+func lockAccounts(ctx context.Context, q database.DBQuery, ids ...int64) (map[int64]database.Account, error) {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	accs := make(map[int64]database.Account, len(sorted))
+	for _, id := range sorted {
+		acc, err := q.GetUserForUpdate(ctx, id)
+		if err != nil {
+			if isNotFound(err) {
+				return nil, newAPIError(http.StatusBadRequest, "account %d: %v", id, database.ErrNotFound)
+			}
+			return nil, err
+		}
+		accs[id] = acc
+	}
+	return accs, nil
+}
+
+// isNotFound reports whether err is a missing row error. MemDB errors are only
+// comparable by message.
+func isNotFound(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || err.Error() == database.ErrNotFound.Error()
+}
+
+// requireActive rejects transfers to or from frozen and closed accounts.
+func requireActive(acc database.Account) error {
+	if acc.Status != database.AccountStatusActive {
+		return newAPIError(http.StatusConflict, "account %d is %v", acc.ID, acc.Status)
+	}
+	return nil
+}
+
+// requireUserAccount rejects transfers requested by clients to or from a
+// system account. Only deposits, withdrawals, fees and interest are posted
+// against system accounts.
+func requireUserAccount(acc database.Account) error {
+	if acc.AccountType == database.AccountTypeSystem {
+		return newAPIError(http.StatusBadRequest, "account %d is a system account", acc.ID)
+	}
+	return nil
+}
+
+// requireFunds rejects transfers of amount that would take the balance of a
+// user account below minus its overdraft limit. System accounts may have any
+// negative balance.
+func requireFunds(acc database.Account, amount int64) error {
+	if acc.AccountType == database.AccountTypeSystem || acc.Balance-amount >= -acc.OverdraftLimit {
+		return nil
+	}
+	return newAPIError(http.StatusConflict, "account %d has insufficient funds: balance %d, overdraft limit %d", acc.ID, acc.Balance, acc.OverdraftLimit)
+}
+
+// postTransaction records a transfer and moves the amount between the account
+// balances. The accounts are checked while locked and before anything is
+// written, so a rejected transfer leaves the DB transaction usable. It must be
+// called within ExecTx.
+func postTransaction(ctx context.Context, q database.DBQuery, p database.CreateTransactionParams) (database.Transaction, error) {
+	from, to := p.FromAccount.Int64, p.ToAccount.Int64
+	accs, err := lockAccounts(ctx, q, from, to)
+	if err != nil {
+		return database.Transaction{}, err
+	}
+	for _, id := range []int64{from, to} {
+		if err := requireActive(accs[id]); err != nil {
+			return database.Transaction{}, err
+		}
+	}
+	if err := requireFunds(accs[from], p.Amount.Int64); err != nil {
+		return database.Transaction{}, err
+	}
+	return recordTransaction(ctx, q, p)
+}
+
+// postReversal returns funds to the account p.ToAccount. Only the sender is
+// required to be active, so that a frozen account is not kept from its own
+// funds. It must be called within ExecTx.
+func postReversal(ctx context.Context, q database.DBQuery, p database.CreateTransactionParams) (database.Transaction, error) {
+	from, to := p.FromAccount.Int64, p.ToAccount.Int64
+	accs, err := lockAccounts(ctx, q, from, to)
+	if err != nil {
+		return database.Transaction{}, err
+	}
+	if err := requireActive(accs[from]); err != nil {
+		return database.Transaction{}, err
+	}
+	if err := requireFunds(accs[from], p.Amount.Int64); err != nil {
+		return database.Transaction{}, err
+	}
+	return recordTransaction(ctx, q, p)
+}
+
+// recordTransaction writes a transfer between accounts locked and checked by
+// the caller.
+func recordTransaction(ctx context.Context, q database.DBQuery, p database.CreateTransactionParams) (database.Transaction, error) {
+	from, to := p.FromAccount.Int64, p.ToAccount.Int64
+	// External references are unique per source account, which is locked.
+	if p.ExternalReference.Valid {
+		_, err := q.GetTxByExternalReference(ctx, database.GetTxByExternalReferenceParams{FromAccount: p.FromAccount, ExternalReference: p.ExternalReference})
+		if err == nil {
+			return database.Transaction{}, newAPIError(http.StatusConflict, "account %d already has a transaction with external reference %q", from, p.ExternalReference.String)
+		}
+		if !isNotFound(err) {
+			return database.Transaction{}, err
+		}
+	}
+
+	tx, err := q.CreateTransaction(ctx, database.CreateTransactionParams{
+		FromAccount:       p.FromAccount,
+		ToAccount:         p.ToAccount,
+		Amount:            p.Amount,
+		Description:       p.Description,
+		ExternalReference: p.ExternalReference,
+		Category:          p.Category,
+		Metadata:          metadataOrEmpty(p.Metadata),
+	})
+	if err != nil {
+		return database.Transaction{}, err
+	}
+	if _, err := q.AddAccountBalance(ctx, database.AddAccountBalanceParams{ID: from, Amount: -p.Amount.Int64}); err != nil {
+		return database.Transaction{}, err
+	}
+	if _, err := q.AddAccountBalance(ctx, database.AddAccountBalanceParams{ID: to, Amount: p.Amount.Int64}); err != nil {
+		return database.Transaction{}, err
+	}
+	return tx, nil
+}
+
+// PostTransaction posts a transfer between two accounts in its own DB
+// transaction with the checks of postTransaction. It is used by tools, such as
+// the seed command, that write to the ledger without the API. No fees are
+// charged, as they are for client transfers.
+func PostTransaction(ctx context.Context, dbClient database.DBClient, p database.CreateTransactionParams) (database.Transaction, error) {
+	if err := validTxParams(p); err != nil {
+		return database.Transaction{}, err
+	}
+	var tx database.Transaction
+	err := dbClient.ExecTx(ctx, func(q database.DBQuery) error {
+		var err error
+		tx, err = postTransaction(ctx, q, p)
+		return err
+	})
+	return tx, err
+}
+
+// accountStatusTransitions lists the statuses each status may change to.
+var accountStatusTransitions = map[string][]string{
+	database.AccountStatusActive: {database.AccountStatusFrozen, database.AccountStatusClosed},
+	database.AccountStatusFrozen: {database.AccountStatusActive},
+}
+
+func validAccountStatus(status string) error {
+	switch status {
+	case database.AccountStatusActive, database.AccountStatusFrozen, database.AccountStatusClosed:
+		return nil
+	}
+	return fmt.Errorf("invalid account status '%v', must be one of %v|%v|%v", status,
+		database.AccountStatusActive, database.AccountStatusFrozen, database.AccountStatusClosed)
+}
+
+// changeAccountStatus applies a status transition. Accounts may only be closed
+// once their balance is zero and no external transfer of theirs is pending,
+// since a pending deposit could not be settled nor a pending withdrawal
+// reversed into a closed account. Likewise, the interest accrued up to the
+// current day must have been posted. A closed account stops earning
+// interest. It must be called within ExecTx.
+func changeAccountStatus(ctx context.Context, q database.DBQuery, id int64, status, reason string) (database.Account, error) {
+	accs, err := lockAccounts(ctx, q, id)
+	if err != nil {
+		return database.Account{}, err
+	}
+	acc := accs[id]
+	if !slices.Contains(accountStatusTransitions[acc.Status], status) {
+		return database.Account{}, newAPIError(http.StatusConflict, "account %d cannot change status from %v to %v", id, acc.Status, status)
+	}
+	if status == database.AccountStatusClosed && acc.Balance != 0 {
+		return database.Account{}, newAPIError(http.StatusConflict, "account %d cannot be closed with non-zero balance %d", id, acc.Balance)
+	}
+	if status == database.AccountStatusClosed {
+		// New transfers lock the account, so none can be created meanwhile
+		for _, kind := range []string{database.ExternalTransferDeposit, database.ExternalTransferWithdrawal} {
+			pending, err := q.ListExternalTransfers(ctx, database.ListExternalTransfersParams{
+				Kind:       kind,
+				AccountID:  sql.NullInt64{Int64: id, Valid: true},
+				Status:     sql.NullString{String: database.ExternalTransferPending, Valid: true},
+				MaxResults: 1,
+			})
+			if err != nil {
+				return database.Account{}, err
+			}
+			if len(pending) > 0 {
+				return database.Account{}, newAPIError(http.StatusConflict, "account %d cannot be closed with pending %v %d", id, kind, pending[0].ID)
+			}
+		}
+		if err := accrueCompletedDays(ctx, q, id); err != nil {
+			return database.Account{}, err
+		}
+		interest, err := q.SumUnpostedInterest(ctx, database.SumUnpostedInterestParams{AccountID: id})
+		if err != nil {
+			return database.Account{}, err
+		}
+		if interest.Amount != 0 {
+			return database.Account{}, newAPIError(http.StatusConflict, "account %d cannot be closed with unposted interest %d", id, interest.Amount)
+		}
+		if err := q.DeleteInterestRate(ctx, id); err != nil {
+			return database.Account{}, err
+		}
+	}
+	return q.UpdateAccountStatus(ctx, database.UpdateAccountStatusParams{
+		ID:           id,
+		Status:       status,
+		StatusReason: sql.NullString{String: reason, Valid: reason != ""},
+	})
+}
+
+// setOverdraftLimit changes the overdraft limit of a user account. A limit
+// below the current overdraft stops the account sending funds until its
+// balance recovers. It must be called within ExecTx.
+func setOverdraftLimit(ctx context.Context, q database.DBQuery, id, limit int64) (database.Account, error) {
+	accs, err := lockAccounts(ctx, q, id)
+	if err != nil {
+		return database.Account{}, err
+	}
+	acc := accs[id]
+	if acc.AccountType == database.AccountTypeSystem {
+		return database.Account{}, newAPIError(http.StatusConflict, "account %d is a system account without an overdraft limit", id)
+	}
+	if acc.Status == database.AccountStatusClosed {
+		return database.Account{}, newAPIError(http.StatusConflict, "account %d is %v", id, acc.Status)
+	}
+	return q.UpdateAccountOverdraftLimit(ctx, database.UpdateAccountOverdraftLimitParams{ID: id, OverdraftLimit: limit})
+}
@@ -1,29 +1,81 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// InitLogging initializes an embedded slog Logger.
-func InitLogging(logLevelStr, logFormat string, toFile bool) error {
+// Log sinks whose level can be changed at runtime.
+const (
+	LogSinkStderr = "stderr"
+	LogSinkFile   = "file"
+)
 
-	level := slog.LevelInfo
-	if err := level.UnmarshalText([]byte(logLevelStr)); err != nil {
-		return err
+// Logging holds the log sinks installed by InitLogging. Each sink has its own
+// level which can be changed while the service is running.
+type Logging struct {
+	levels map[string]*slog.LevelVar
+	file   *lumberjack.Logger
+}
+
+// InitLogging installs the default slog logger. Logs are written to stderr at
+// LogLevel and, if LogToFile is set, to LogFile at LogFileLevel. The log file
+// is rotated once it reaches LogMaxSizeMB.
+func InitLogging(config Config) (*Logging, error) {
+	l := &Logging{levels: make(map[string]*slog.LevelVar)}
+
+	stderrLevel, err := parseLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
 	}
+	l.levels[LogSinkStderr] = stderrLevel
+	handlers := []slog.Handler{newLogHandler(os.Stderr, config.LogFormat, stderrLevel)}
 
-	logFile := os.Stderr
-	if toFile {
-		logFilePath := "log.out" // Define log file name
+	if config.LogToFile {
+		// The file sink logs at the stderr level unless configured otherwise
+		fileLevel := new(slog.LevelVar)
+		fileLevel.Set(stderrLevel.Level())
+		if config.LogFileLevel != "" {
+			if fileLevel, err = parseLevel(config.LogFileLevel); err != nil {
+				return nil, err
+			}
+		}
 		// Open log file (create if it doesn't exist, append if it does)
-		var err error
-		logFile, err = os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			return err
+		l.file = &lumberjack.Logger{
+			Filename:   config.LogFile,
+			MaxSize:    config.LogMaxSizeMB,
+			MaxAge:     config.LogMaxAgeDays,
+			MaxBackups: config.LogMaxBackups,
+			Compress:   config.LogCompress,
 		}
+		l.levels[LogSinkFile] = fileLevel
+		handlers = append(handlers, newLogHandler(l.file, config.LogFormat, fileLevel))
+	}
+
+	// Set as default logger
+	slog.SetDefault(slog.New(traceHandler{fanoutHandler(handlers)}))
+
+	return l, nil
+}
+
+func parseLevel(s string) (*slog.LevelVar, error) {
+	level := new(slog.LevelVar)
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return nil, err
 	}
+	return level, nil
+}
+
+func newLogHandler(w io.Writer, logFormat string, level slog.Leveler) slog.Handler {
 	handlerOpts := &slog.HandlerOptions{
 		Level:     level,
 		AddSource: true,
@@ -37,18 +89,117 @@ func InitLogging(logLevelStr, logFormat string, toFile bool) error {
 			return attr
 		},
 	}
-
-	// Base TextHandler with file output
-	var baseHandler slog.Handler
 	switch logFormat {
 	case "json":
-		baseHandler = slog.NewJSONHandler(logFile, handlerOpts)
+		return slog.NewJSONHandler(w, handlerOpts)
 	default:
-		baseHandler = slog.NewTextHandler(logFile, handlerOpts)
+		return slog.NewTextHandler(w, handlerOpts)
 	}
+}
 
-	// Set as default logger
-	slog.SetDefault(slog.New(baseHandler))
+// Levels returns the current level of each sink.
+func (l *Logging) Levels() map[string]string {
+	levels := make(map[string]string, len(l.levels))
+	for sink, level := range l.levels {
+		levels[sink] = level.Level().String()
+	}
+	return levels
+}
 
+// SetLevel changes the level of the named sink, or of all sinks if sink is empty.
+func (l *Logging) SetLevel(sink, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	if sink == "" {
+		for _, v := range l.levels {
+			v.Set(lvl)
+		}
+		return nil
+	}
+	v, ok := l.levels[sink]
+	if !ok {
+		sinks := make([]string, 0, len(l.levels))
+		for s := range l.levels {
+			sinks = append(sinks, s)
+		}
+		sort.Strings(sinks)
+		return fmt.Errorf("unknown log sink '%v', must be one of %v", sink, sinks)
+	}
+	v.Set(lvl)
 	return nil
 }
+
+// Reopen closes the log file so that it is reopened on the next write. It is
+// called on SIGHUP after the file has been moved by an external log rotator.
+func (l *Logging) Reopen() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Close closes the log file.
+func (l *Logging) Close() error {
+	return l.Reopen()
+}
+
+// fanoutHandler writes each record to every handler enabled at its level.
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, hh := range h {
+		if hh.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, hh := range h {
+		if hh.Enabled(ctx, r.Level) {
+			errs = append(errs, hh.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(h))
+	for i, hh := range h {
+		out[i] = hh.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(h))
+	for i, hh := range h {
+		out[i] = hh.WithGroup(name)
+	}
+	return out
+}
+
+// traceHandler adds the trace and span IDs of the active span, if any, to
+// records logged with a context.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ATMackay/psql-ledger/database"
+)
+
+const (
+	defaultTransactionsLimit = 100
+	maxTransactionsLimit     = 1000
+
+	// metadataFilterPrefix marks query parameters matched against metadata,
+	// e.g. ?metadata.order_id=123.
+	metadataFilterPrefix = "metadata."
+
+	maxMetadataBytes = 16 << 10
+)
+
+// validMetadata checks that metadata, when supplied, is a JSON object.
+func validMetadata(m json.RawMessage) error {
+	if metadataAbsent(m) {
+		return nil
+	}
+	if len(m) > maxMetadataBytes {
+		return fmt.Errorf("metadata exceeds %d bytes", maxMetadataBytes)
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(m, &obj); err != nil || obj == nil {
+		return fmt.Errorf("metadata must be a JSON object")
+	}
+	return nil
+}
+
+// metadataOrEmpty returns m or the column default '{}' if it was not supplied.
+// The DB is never sent an empty value, which is not valid jsonb.
+func metadataOrEmpty(m json.RawMessage) json.RawMessage {
+	if metadataAbsent(m) {
+		return json.RawMessage("{}")
+	}
+	return m
+}
+
+// metadataAbsent reports whether m was omitted or null, as it is when
+// marshalled from params without metadata.
+func metadataAbsent(m json.RawMessage) bool {
+	m = bytes.TrimSpace(m)
+	return len(m) == 0 || bytes.Equal(m, []byte("null"))
+}
+
+// metadataFilter builds a jsonb containment filter from the metadata.<key>
+// query parameters. Values that parse as JSON numbers, booleans or quoted
+// strings are matched as such; anything else is matched as a string.
+func metadataFilter(v url.Values) (json.RawMessage, error) {
+	filter := map[string]json.RawMessage{}
+	for k, vals := range v {
+		key, ok := strings.CutPrefix(k, metadataFilterPrefix)
+		if !ok {
+			continue
+		}
+		if key == "" {
+			return nil, fmt.Errorf("invalid metadata filter '%v', key cannot be empty", k)
+		}
+		if len(vals) != 1 {
+			return nil, fmt.Errorf("invalid metadata filter '%v', must be supplied once", k)
+		}
+		filter[key] = metadataFilterValue(vals[0])
+	}
+	return json.Marshal(filter)
+}
+
+func metadataFilterValue(s string) json.RawMessage {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		switch v.(type) {
+		case float64, bool, string:
+			return json.RawMessage(s)
+		}
+	}
+	b, _ := json.Marshal(s)
+	return b
+}
+
+func nullString(v url.Values, key string) sql.NullString {
+	if s := v.Get(key); s != "" {
+		return sql.NullString{String: s, Valid: true}
+	}
+	return sql.NullString{}
+}
+
+// Transactions lists transactions in the order they were written. They can be
+// filtered with ?account_id= (either side of the transfer), ?category=,
+// ?external_reference= and ?metadata.<key>=. Results are paged with ?after_id=
+// and ?limit=.
+func Transactions(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := transactionsParams(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		txs, err := dbClient.NewQuery().SearchTransactions(r.Context(), params)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if txs == nil {
+			txs = []database.Transaction{}
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, txs); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func transactionsParams(v url.Values) (database.SearchTransactionsParams, error) {
+	p := database.SearchTransactionsParams{
+		Category:          nullString(v, "category"),
+		ExternalReference: nullString(v, "external_reference"),
+		MaxResults:        defaultTransactionsLimit,
+	}
+	if s := v.Get("account_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid account_id '%v'", s)
+		}
+		p.AccountID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	metadata, err := metadataFilter(v)
+	if err != nil {
+		return p, err
+	}
+	p.Metadata = metadata
+	if s := v.Get("after_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 0 {
+			return p, fmt.Errorf("invalid after_id '%v'", s)
+		}
+		p.AfterID = id
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxTransactionsLimit {
+			return p, fmt.Errorf("invalid limit '%v', must be between 1 and %d", s, maxTransactionsLimit)
+		}
+		p.MaxResults = int32(n)
+	}
+	return p, nil
+}
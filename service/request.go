@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+)
+
+// RequestIDHeader is the header carrying the request ID. A valid ID supplied
+// by the client is reused, otherwise one is generated. The ID is echoed in the
+// response headers and in error response bodies.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._:-]+$`)
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// withRequestID assigns the request ID and sets it on the response.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIDHeader)
+		if len(id) > maxRequestIDLength || !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, req.WithContext(logging.WithRequestID(req.Context(), id)))
+	})
+}
+
+type ctxKey int
+
+const requestInfoKey ctxKey = iota
+
+// requestInfo collects request attributes set by inner middleware for the access log.
+type requestInfo struct {
+	principal string
+}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// Principal returns the authenticated principal of the request, or an empty
+// string if authentication is disabled.
+func Principal(ctx context.Context) string {
+	if info := requestInfoFromContext(ctx); info != nil {
+		return info.principal
+	}
+	return ""
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// APIKey maps an API key to the name of the principal it authenticates.
+// Admin keys are also accepted by admin endpoints.
+type APIKey struct {
+	Principal string
+	Key       string
+	Admin     bool
+}
+
+// ParseAPIKeys parses entries of the form principal:key.
+func ParseAPIKeys(entries []string) ([]APIKey, error) {
+	keys := make([]APIKey, 0, len(entries))
+	for _, e := range entries {
+		principal, key, ok := strings.Cut(e, ":")
+		if !ok || principal == "" || key == "" {
+			return nil, fmt.Errorf("invalid api key entry, want principal:key")
+		}
+		keys = append(keys, APIKey{Principal: principal, Key: key})
+	}
+	return keys, nil
+}
+
+// authenticator resolves the principal from a bearer token or X-API-Key header.
+// Authentication is disabled when no keys are configured.
+type authenticator struct {
+	keys []APIKey
+}
+
+func (a authenticator) principal(req *http.Request) (APIKey, bool) {
+	token := req.Header.Get("X-API-Key")
+	if t, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		token = t
+	}
+	if token == "" {
+		return APIKey{}, false
+	}
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.Key)) == 1 {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// authenticate rejects requests without a valid API key, or without an admin
+// key if admin is set, and records the authenticated principal for the access
+// log and as the audit log actor.
+func (a authenticator) authenticate(admin bool, h http.Handler) http.Handler {
+	if len(a.keys) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key, ok := a.principal(req)
+		if !ok {
+			RespondWithError(w, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+		if info := requestInfoFromContext(req.Context()); info != nil {
+			info.principal = key.Principal
+		}
+		if admin && !key.Admin {
+			RespondWithError(w, http.StatusForbidden, "admin API key required")
+			return
+		}
+		h.ServeHTTP(w, req.WithContext(database.WithActor(req.Context(), key.Principal)))
+	})
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// backoff describes an exponential backoff schedule.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	timeout time.Duration
+}
+
+// retry calls fn until it succeeds, returns a permanent error, or the backoff
+// timeout elapses. The wait between attempts doubles from b.initial up to b.max.
+func (b backoff) retry(ctx context.Context, op string, fn func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	wait := b.initial
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		slog.Warn(fmt.Sprintf("%v failed, retrying", op), "attempt", attempt, "retry_in", wait.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: gave up after %d attempts: %w", op, attempt, err)
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > b.max {
+			wait = b.max
+		}
+	}
+}
+
+// permanentError wraps errors that should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+// postgresStartingUp is the error code returned while the server is starting up
+// or shutting down.
+const postgresStartingUp = "57P03"
+
+// retryablePostgresErr marks errors returned by a running postgres server, such as
+// authentication failures, as permanent. Connection errors are retryable since the
+// server may not be accepting connections yet.
+func retryablePostgresErr(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code != postgresStartingUp {
+		return &permanentError{err: err}
+	}
+	return err
+}
@@ -0,0 +1,498 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+	"github.com/julienschmidt/httprouter"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	defaultScheduledRunsLimit = 100
+	maxScheduledRunsLimit     = 1000
+)
+
+// schedulerActor is recorded in the audit log for transfers made by the
+// scheduler.
+const schedulerActor = "scheduler"
+
+// ScheduledTransferRequest creates a transfer that repeats on a standard
+// five field cron expression (evaluated in UTC unless prefixed with
+// CRON_TZ=) or at a fixed interval such as "24h" from StartAt, which defaults
+// to now. Exactly one of Cron and Interval must be given. The transfer is not
+// run after EndAt, if set.
+type ScheduledTransferRequest struct {
+	FromAccount int64      `json:"from_account"`
+	ToAccount   int64      `json:"to_account"`
+	Amount      int64      `json:"amount"`
+	Cron        string     `json:"cron,omitempty"`
+	Interval    string     `json:"interval,omitempty"`
+	StartAt     *time.Time `json:"start_at,omitempty"`
+	EndAt       *time.Time `json:"end_at,omitempty"`
+}
+
+// intervalSchedule runs every interval from start.
+type intervalSchedule struct {
+	start time.Time
+	every time.Duration
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.start) {
+		return s.start
+	}
+	return s.start.Add((t.Sub(s.start)/s.every + 1) * s.every)
+}
+
+// parseSchedule returns the schedule of a cron expression or of an interval
+// in whole seconds starting at start.
+func parseSchedule(cronExpr string, every time.Duration, start time.Time) (cron.Schedule, error) {
+	if cronExpr != "" {
+		sched, err := cron.ParseStandard(cronExpr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%v': %v", cronExpr, err)
+		}
+		return sched, nil
+	}
+	if every < time.Second || every%time.Second != 0 {
+		return nil, fmt.Errorf("invalid interval '%v', must be a whole number of seconds", every)
+	}
+	return intervalSchedule{start: start, every: every}, nil
+}
+
+func storedSchedule(st database.ScheduledTransfer) (cron.Schedule, error) {
+	return parseSchedule(st.CronExpr.String, time.Duration(st.IntervalSeconds.Int64)*time.Second, st.StartAt)
+}
+
+// nextRun returns the first run of the transfer after t, which is null if the
+// schedule ends before then. Runs are never before the start of the schedule.
+func nextRun(sched cron.Schedule, st database.ScheduledTransfer, t time.Time) sql.NullTime {
+	if first := st.StartAt.Add(-time.Nanosecond); t.Before(first) {
+		t = first
+	}
+	next := sched.Next(t.UTC())
+	if next.IsZero() || st.EndAt.Valid && next.After(st.EndAt.Time) {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: next, Valid: true}
+}
+
+// scheduleParams validates a request and returns the parameters of the
+// transfer and its schedule.
+func scheduleParams(req ScheduledTransferRequest, now time.Time) (database.CreateScheduledTransferParams, error) {
+	p := database.CreateScheduledTransferParams{FromAccount: req.FromAccount, ToAccount: req.ToAccount, Amount: req.Amount, StartAt: now}
+	if err := validTxParams(database.CreateTransactionParams{
+		FromAccount: sql.NullInt64{Int64: req.FromAccount, Valid: true},
+		ToAccount:   sql.NullInt64{Int64: req.ToAccount, Valid: true},
+		Amount:      sql.NullInt64{Int64: req.Amount, Valid: true},
+	}); err != nil {
+		return p, err
+	}
+	if (req.Cron == "") == (req.Interval == "") {
+		return p, fmt.Errorf("exactly one of cron and interval must be supplied")
+	}
+	if req.StartAt != nil {
+		p.StartAt = *req.StartAt
+	}
+	if req.EndAt != nil {
+		if !req.EndAt.After(p.StartAt) {
+			return p, fmt.Errorf("end_at must be after start_at")
+		}
+		p.EndAt = sql.NullTime{Time: *req.EndAt, Valid: true}
+	}
+	var every time.Duration
+	if req.Interval != "" {
+		d, err := time.ParseDuration(req.Interval)
+		if err != nil {
+			return p, fmt.Errorf("invalid interval '%v'", req.Interval)
+		}
+		every = d
+		p.IntervalSeconds = sql.NullInt64{Int64: int64(d / time.Second), Valid: true}
+	} else {
+		p.CronExpr = sql.NullString{String: req.Cron, Valid: true}
+	}
+	sched, err := parseSchedule(req.Cron, every, p.StartAt)
+	if err != nil {
+		return p, err
+	}
+	p.NextRunAt = nextRun(sched, database.ScheduledTransfer{StartAt: p.StartAt, EndAt: p.EndAt}, time.Time{})
+	if !p.NextRunAt.Valid {
+		return p, fmt.Errorf("schedule has no runs before end_at")
+	}
+	return p, nil
+}
+
+// CreateScheduledTransfer creates a recurring transfer between two existing
+// accounts. Runs are made by the scheduler.
+func CreateScheduledTransfer(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ScheduledTransferRequest
+		if err := DecodeJSON(r.Body, &req); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		params, err := scheduleParams(req, time.Now().UTC())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var st database.ScheduledTransfer
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			accs, err := lockAccounts(r.Context(), q, params.FromAccount, params.ToAccount)
+			if err != nil {
+				return err
+			}
+			for _, acc := range accs {
+				if err := requireUserAccount(acc); err != nil {
+					return err
+				}
+			}
+			st, err = q.CreateScheduledTransfer(r.Context(), params)
+			return err
+		}); err != nil {
+			respondWithErr(w, err)
+			return
+		}
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "scheduled transfer created", "scheduled_transfer_id", st.ID,
+			"from_account", st.FromAccount, "to_account", st.ToAccount, "amount", st.Amount, "next_run_at", st.NextRunAt.Time)
+
+		if err := RespondWithJSON(w, http.StatusOK, st); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// ScheduledTransfers lists scheduled transfers in ID order, optionally only
+// those to or from ?account_id= or with ?status=.
+func ScheduledTransfers(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := scheduledTransfersParams(r.URL.Query())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		sts, err := dbClient.NewQuery().ListScheduledTransfers(r.Context(), params)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if sts == nil {
+			sts = []database.ScheduledTransfer{}
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, sts); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func scheduledTransfersParams(v url.Values) (database.ListScheduledTransfersParams, error) {
+	var p database.ListScheduledTransfersParams
+	if s := v.Get("account_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid account_id '%v'", s)
+		}
+		p.AccountID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	if s := v.Get("status"); s != "" {
+		switch s {
+		case database.ScheduledTransferActive, database.ScheduledTransferPaused, database.ScheduledTransferCancelled, database.ScheduledTransferCompleted:
+		default:
+			return p, fmt.Errorf("invalid status '%v', must be one of %v|%v|%v|%v", s, database.ScheduledTransferActive,
+				database.ScheduledTransferPaused, database.ScheduledTransferCancelled, database.ScheduledTransferCompleted)
+		}
+		p.Status = sql.NullString{String: s, Valid: true}
+	}
+	return p, nil
+}
+
+func scheduledTransferID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scheduled transfer id")
+	}
+	return id, nil
+}
+
+// ScheduledTransfer returns the scheduled transfer :id.
+func ScheduledTransfer(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := scheduledTransferID(r)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		st, err := dbClient.NewQuery().GetScheduledTransfer(r.Context(), id)
+		if err != nil {
+			if isNotFound(err) {
+				RespondWithError(w, http.StatusNotFound, database.ErrNotFound)
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, st); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// ScheduledTransferRuns lists the runs of the scheduled transfer :id in the
+// order they were made. Results are paged with ?after_id= and ?limit=.
+func ScheduledTransferRuns(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := scheduledTransferID(r)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		params := database.ListScheduledTransferRunsParams{ScheduledTransferID: id, MaxResults: defaultScheduledRunsLimit}
+		if s := r.URL.Query().Get("after_id"); s != "" {
+			afterID, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || afterID < 0 {
+				RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid after_id '%v'", s))
+				return
+			}
+			params.AfterID = afterID
+		}
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > maxScheduledRunsLimit {
+				RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid limit '%v', must be between 1 and %d", s, maxScheduledRunsLimit))
+				return
+			}
+			params.MaxResults = int32(n)
+		}
+
+		q := dbClient.NewQuery()
+		if _, err := q.GetScheduledTransfer(r.Context(), id); err != nil {
+			if isNotFound(err) {
+				RespondWithError(w, http.StatusNotFound, database.ErrNotFound)
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		runs, err := q.ListScheduledTransferRuns(r.Context(), params)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if runs == nil {
+			runs = []database.ScheduledTransferRun{}
+		}
+
+		if err := RespondWithJSON(w, http.StatusOK, runs); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// scheduledTransferTransitions lists the statuses each status may change to.
+// Cancelled and completed transfers cannot be changed.
+var scheduledTransferTransitions = map[string][]string{
+	database.ScheduledTransferActive: {database.ScheduledTransferPaused, database.ScheduledTransferCancelled},
+	database.ScheduledTransferPaused: {database.ScheduledTransferActive, database.ScheduledTransferCancelled},
+}
+
+// PauseScheduledTransfer stops the scheduled transfer :id from running until
+// it is resumed.
+func PauseScheduledTransfer(dbClient database.DBClient) http.HandlerFunc {
+	return setScheduledTransferStatus(dbClient, database.ScheduledTransferPaused)
+}
+
+// ResumeScheduledTransfer resumes the paused scheduled transfer :id. Runs
+// missed while it was paused are skipped.
+func ResumeScheduledTransfer(dbClient database.DBClient) http.HandlerFunc {
+	return setScheduledTransferStatus(dbClient, database.ScheduledTransferActive)
+}
+
+// CancelScheduledTransfer permanently stops the scheduled transfer :id.
+func CancelScheduledTransfer(dbClient database.DBClient) http.HandlerFunc {
+	return setScheduledTransferStatus(dbClient, database.ScheduledTransferCancelled)
+}
+
+func setScheduledTransferStatus(dbClient database.DBClient, status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := scheduledTransferID(r)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var st database.ScheduledTransfer
+		if err := dbClient.ExecTx(r.Context(), func(q database.DBQuery) error {
+			var err error
+			st, err = changeScheduledTransferStatus(r.Context(), q, id, status, time.Now())
+			return err
+		}); err != nil {
+			respondWithErr(w, err)
+			return
+		}
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "scheduled transfer status changed", "scheduled_transfer_id", st.ID,
+			"status", st.Status, "principal", Principal(r.Context()))
+
+		if err := RespondWithJSON(w, http.StatusOK, st); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// changeScheduledTransferStatus applies a status transition. A resumed
+// transfer is next run at its first scheduled time after now, and is
+// completed instead if there is none. It must be called within ExecTx.
+func changeScheduledTransferStatus(ctx context.Context, q database.DBQuery, id int64, status string, now time.Time) (database.ScheduledTransfer, error) {
+	st, err := q.GetScheduledTransferForUpdate(ctx, id)
+	if err != nil {
+		if isNotFound(err) {
+			return st, newAPIError(http.StatusNotFound, "scheduled transfer %d: %v", id, database.ErrNotFound)
+		}
+		return st, err
+	}
+	if !slices.Contains(scheduledTransferTransitions[st.Status], status) {
+		return st, newAPIError(http.StatusConflict, "scheduled transfer %d cannot change status from %v to %v", id, st.Status, status)
+	}
+	var next sql.NullTime
+	if status == database.ScheduledTransferActive {
+		sched, err := storedSchedule(st)
+		if err != nil {
+			return st, err
+		}
+		if next = nextRun(sched, st, now); !next.Valid {
+			status = database.ScheduledTransferCompleted
+		}
+	}
+	return q.UpdateScheduledTransferStatus(ctx, database.UpdateScheduledTransferStatusParams{ID: id, Status: status, NextRunAt: next})
+}
+
+// scheduler runs due scheduled transfers. Each run claims a single transfer
+// with SELECT ... FOR UPDATE SKIP LOCKED in its own DB transaction, so that
+// several service instances can share the work, a run is never made twice
+// and transfers do not lock accounts across each other's runs.
+type scheduler struct {
+	dbClient database.DBClient
+	interval time.Duration
+	now      func() time.Time
+}
+
+func newScheduler(dbClient database.DBClient, interval time.Duration) *scheduler {
+	return &scheduler{dbClient: dbClient, interval: interval, now: time.Now}
+}
+
+// run runs due transfers on start and then every interval.
+func (s *scheduler) run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		n, err := s.runDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("cannot run scheduled transfers", "error", err)
+		}
+		if n > 0 {
+			slog.Debug("ran scheduled transfers", "runs", n)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// runDue makes runs until no transfer is due and returns the number made.
+func (s *scheduler) runDue(ctx context.Context) (int, error) {
+	ctx = database.WithActor(ctx, schedulerActor)
+	n := 0
+	for ctx.Err() == nil {
+		ran := false
+		err := s.dbClient.ExecTx(ctx, func(q database.DBQuery) error {
+			due, err := q.ClaimDueScheduledTransfers(ctx, database.ClaimDueScheduledTransfersParams{Now: s.now(), MaxResults: 1})
+			if err != nil || len(due) == 0 {
+				return err
+			}
+			ran = true
+			return runScheduledTransfer(ctx, q, due[0], s.now())
+		})
+		if err != nil || !ran {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// runScheduledTransfer posts a due transfer, charges its fees and records the
+// run with the fees charged. Transfers rejected by the ledger, for example
+// because an account is frozen, are recorded as failed runs. Other errors leave
+// the transfer due so that it is retried. The transfer is then advanced to its
+// first scheduled time after both this run and now, so runs missed while the
+// scheduler was not running are skipped. It must be called within ExecTx.
+func runScheduledTransfer(ctx context.Context, q database.DBQuery, st database.ScheduledTransfer, now time.Time) error {
+	scheduledFor := st.NextRunAt.Time
+	run := database.CreateScheduledTransferRunParams{ScheduledTransferID: st.ID, ScheduledFor: scheduledFor, Status: database.ScheduledRunSucceeded}
+	tx, err := postTransactionWithFees(ctx, q, database.CreateTransactionParams{
+		FromAccount: sql.NullInt64{Int64: st.FromAccount, Valid: true},
+		ToAccount:   sql.NullInt64{Int64: st.ToAccount, Valid: true},
+		Amount:      sql.NullInt64{Int64: st.Amount, Valid: true},
+	})
+	var ae *apiError
+	switch {
+	case errors.As(err, &ae):
+		run.Status, run.Error = database.ScheduledRunFailed, sql.NullString{String: err.Error(), Valid: true}
+	case err != nil:
+		return fmt.Errorf("scheduled transfer %d: %w", st.ID, err)
+	default:
+		run.TransactionID = sql.NullInt64{Int64: tx.ID, Valid: true}
+		if run.Fees, err = json.Marshal(tx.Fees); err != nil {
+			return err
+		}
+		run.TotalFee = tx.TotalFee
+		if tx.FeeTransaction != nil {
+			run.FeeTransactionID = sql.NullInt64{Int64: tx.FeeTransaction.ID, Valid: true}
+		}
+	}
+	if _, err := q.CreateScheduledTransferRun(ctx, run); err != nil {
+		return err
+	}
+
+	sched, err := storedSchedule(st)
+	if err != nil {
+		return fmt.Errorf("scheduled transfer %d: %w", st.ID, err)
+	}
+	after := scheduledFor
+	if now.After(after) {
+		after = now
+	}
+	status, next := database.ScheduledTransferActive, nextRun(sched, st, after)
+	if !next.Valid {
+		status = database.ScheduledTransferCompleted
+	}
+	if _, err := q.AdvanceScheduledTransfer(ctx, database.AdvanceScheduledTransferParams{
+		ID:        st.ID,
+		NextRunAt: next,
+		LastRunAt: sql.NullTime{Time: scheduledFor, Valid: true},
+		Status:    status,
+	}); err != nil {
+		return err
+	}
+	slog.Debug("scheduled transfer run", "scheduled_transfer_id", st.ID, "scheduled_for", scheduledFor,
+		"status", run.Status, "error", run.Error.String, "next_run_at", next.Time)
+	return nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/ATMackay/psql-ledger/sqlc"
+)
+
+// Schema version check policies.
+const (
+	// SchemaCheckStrict refuses to serve unless the DB schema version equals the
+	// latest migration compiled into the binary.
+	SchemaCheckStrict = "strict"
+	// SchemaCheckAllowNewer also accepts a newer DB schema, e.g. during a rolling
+	// deploy where migrations have been applied ahead of the new binary.
+	SchemaCheckAllowNewer = "allow_newer"
+	// SchemaCheckOff disables schema version checks.
+	SchemaCheckOff = "off"
+)
+
+// legacyMigrationsTable is the migrations table name used by earlier releases, which
+// recorded the schema version in a table named after the default migrations path.
+const legacyMigrationsTable = "../sqlc/migrations"
+
+// schemaInfo holds the schema version the service expects to find in the DB.
+type schemaInfo struct {
+	expected uint
+	table    string
+	policy   string
+}
+
+// check returns an error if the supplied DB schema version is not acceptable
+// under the configured policy.
+func (s *schemaInfo) check(current uint, dirty bool) error {
+	if s.policy == SchemaCheckOff {
+		return nil
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty, a previous migration failed", current)
+	}
+	if current < s.expected {
+		return fmt.Errorf("schema version %d is older than expected version %d", current, s.expected)
+	}
+	if current > s.expected && s.policy != SchemaCheckAllowNewer {
+		return fmt.Errorf("schema version %d is newer than expected version %d", current, s.expected)
+	}
+	return nil
+}
+
+// migrationsFS returns the configured migrations directory, or the migrations
+// embedded in the binary if none is set.
+func migrationsFS(config Config) fs.FS {
+	if config.MigrationsPath != "" {
+		return os.DirFS(config.MigrationsPath)
+	}
+	return sqlc.Migrations()
+}
+
+// prepareSchema applies pending migrations, unless disabled, and then verifies
+// that the DB schema version matches the version expected by the binary.
+func prepareSchema(config Config) (*schemaInfo, error) {
+	m, err := NewMigrator(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create migrator: %w", err)
+	}
+	defer m.Close()
+
+	st, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	// A newer DB schema cannot be migrated up by this binary
+	if !config.SkipMigrations && !st.Dirty && st.Version <= st.Latest {
+		if err := m.Up(); err != nil {
+			return nil, fmt.Errorf("migration failed: %w", err)
+		}
+		if st, err = m.Status(); err != nil {
+			return nil, err
+		}
+		slog.Debug("migrated DB", "schema_version", st.Version)
+	}
+
+	schema := &schemaInfo{expected: st.Latest, table: config.MigrationsTable, policy: config.SchemaCheck}
+	if err := schema.check(st.Version, st.Dirty); err != nil {
+		return nil, err
+	}
+	slog.Info("verified DB schema", "schema_version", st.Version, "expected_version", st.Latest)
+	return schema, nil
+}
@@ -1,33 +1,202 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/ATMackay/psql-ledger/database"
 )
 
+const defaultShutdownTimeout = 15 * time.Second
+
+// WorkerFunc is a long running background task, e.g. a scheduler. It must
+// return when ctx is cancelled.
+type WorkerFunc func(ctx context.Context) error
+
+type worker struct {
+	name string
+	run  WorkerFunc
+}
+
 // Service represents the main psqllgedger service body with
 // HTTP interface and DB connection.
 type Service struct {
 	dbClient database.DBClient
 	server   *HTTPService
+	schema   *schemaInfo
+	health   *HealthRegistry
+	started  atomic.Bool
+
+	drainPeriod     time.Duration
+	shutdownTimeout time.Duration
+	draining        atomic.Bool
+
+	workers       []worker
+	workerWG      sync.WaitGroup
+	cancelWorkers context.CancelFunc
+
+	shutdownTracing func(context.Context) error
+	logs            *Logging
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// AddWorker registers a background task that is started with the service and
+// cancelled during shutdown once the HTTP server has stopped. Workers must be
+// added before the service is started.
+func (s *Service) AddWorker(name string, fn WorkerFunc) {
+	s.workers = append(s.workers, worker{name: name, run: fn})
 }
 
+// RegisterHealthCheck adds a check to the supplied health probes. The result is
+// cached for ttl.
+func (s *Service) RegisterHealthCheck(c HealthChecker, ttl time.Duration, probes Probe) {
+	s.health.Register(c, ttl, probes)
+}
+
+// Start starts the HTTP server and background workers without blocking.
 func (s *Service) Start() {
 	slog.Info("starting service", "version", Version, "commitDate", CommitDate, "buildDate", BuildDate, "gitCommitSha", GitCommitHash)
 	s.server.Start()
+	s.startWorkers()
+	s.started.Store(true)
 }
 
+// Run starts the service and blocks until ctx is cancelled or the HTTP server
+// fails. It then shuts the service down gracefully and returns once shutdown
+// has completed. The log file is reopened on SIGHUP.
+func (s *Service) Run(ctx context.Context) error {
+	s.Start()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var serveErr error
+	for running := true; running; {
+		select {
+		case <-hup:
+			if err := s.logs.Reopen(); err != nil {
+				slog.Error("cannot reopen log file", "error", err)
+				continue
+			}
+			slog.Info("log file reopened")
+		case <-ctx.Done():
+			slog.Info("shutdown requested", "reason", context.Cause(ctx))
+			running = false
+		case serveErr = <-s.server.Err():
+			slog.Error("server failed", "error", serveErr)
+			running = false
+		}
+	}
+
+	return errors.Join(serveErr, s.shutdown())
+}
+
+// Stop shuts the service down gracefully.
 func (s *Service) Stop(sig os.Signal) {
 	slog.Info("stopping service", "signal", sig)
 
-	if err := s.dbClient.DB().Close(); err != nil {
-		slog.Error("error closing db", "error", err)
+	if err := s.shutdown(); err != nil {
+		slog.Error("error stopping service", "error", err)
+	}
+}
+
+// Logging returns the log sinks installed by BuildService, or nil.
+func (s *Service) Logging() *Logging {
+	return s.logs
+}
+
+func (s *Service) setLogging(l *Logging) {
+	s.logs = l
+}
+
+// Draining reports whether the service is shutting down.
+func (s *Service) Draining() bool {
+	return s.draining.Load()
+}
+
+// shutdown stops the service in dependency order. Health checks report the
+// service as unavailable for the drain period so that load balancers stop routing
+// traffic, then the HTTP server waits for in-flight requests, background workers
+// are stopped, the DB connections are closed and finally buffered traces are flushed.
+func (s *Service) shutdown() error {
+	s.stopOnce.Do(func() {
+		s.draining.Store(true)
+		if s.drainPeriod > 0 {
+			slog.Info("draining", "period", s.drainPeriod.String())
+			time.Sleep(s.drainPeriod)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+
+		var errs []error
+		if err := s.server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping server: %w", err))
+		}
+
+		if err := s.stopWorkers(ctx); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := s.dbClient.DB().Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing db: %w", err))
+		}
+
+		if s.shutdownTracing != nil {
+			if err := s.shutdownTracing(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("error flushing traces: %w", err))
+			}
+		}
+
+		s.stopErr = errors.Join(errs...)
+		slog.Info("service stopped")
+	})
+	return s.stopErr
+}
+
+func (s *Service) startWorkers() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelWorkers = cancel
+	for _, w := range s.workers {
+		s.workerWG.Add(1)
+		go func(w worker) {
+			defer s.workerWG.Done()
+			slog.Debug("starting worker", "worker", w.name)
+			if err := w.run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("worker failed", "worker", w.name, "error", err)
+			}
+		}(w)
+	}
+}
+
+// stopWorkers cancels the background workers and waits for them to return.
+func (s *Service) stopWorkers(ctx context.Context) error {
+	if s.cancelWorkers == nil {
+		return nil
 	}
+	s.cancelWorkers()
 
-	if err := s.server.Stop(); err != nil {
-		slog.Error("error stopping server")
+	done := make(chan struct{})
+	go func() {
+		s.workerWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for background workers: %w", ctx.Err())
 	}
 }
 
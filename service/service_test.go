@@ -2,17 +2,36 @@ package service
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/sqlc"
+	"github.com/lib/pq"
+	"github.com/parquet-go/parquet-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	yaml "gopkg.in/yaml.v3"
 )
 
@@ -113,224 +132,1099 @@ func Test_SantizeConfig(t *testing.T) {
 	}
 }
 
-func Test_ServiceStartStop(t *testing.T) {
+func Test_ConfigValidate(t *testing.T) {
+	if err := DefaultConfig.Validate(); err != nil {
+		t.Fatalf("default config invalid: %v", err)
+	}
+	if err := emptyConfig.Validate(); err != nil {
+		t.Fatalf("empty config invalid: %v", err)
+	}
 
-	service := New(8080, 1, database.NewMemoryDBClient())
+	cfg := DefaultConfig
+	cfg.Port = 70000
+	cfg.LogLevel = "verbose"
+	cfg.LogFormat = "xml"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error")
+	}
 
-	service.Start()
+	if g, w := DefaultConfig.Redacted().PostgresPassword, redacted; g != w {
+		t.Fatalf("password not redacted, got %v", g)
+	}
+	if g, w := emptyConfig.Redacted().PostgresPassword, ""; g != w {
+		t.Fatalf("empty password should not be redacted, got %v", g)
+	}
+}
 
-	service.Stop(os.Interrupt)
+func Test_BackoffRetry(t *testing.T) {
+	b := backoff{initial: time.Millisecond, max: 4 * time.Millisecond, timeout: time.Second}
+
+	attempts := 0
+	if err := b.retry(context.Background(), "test", func() error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if g, w := attempts, 3; g != w {
+		t.Fatalf("unexpected attempts, want %v got %v", w, g)
+	}
+
+	// Errors from a running postgres server are not retried
+	attempts = 0
+	authErr := &pq.Error{Code: "28P01", Message: "password authentication failed"}
+	err := b.retry(context.Background(), "test", func() error {
+		attempts++
+		return retryablePostgresErr(fmt.Errorf("db ping err: %w", authErr))
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("expected permanent error after one attempt, got %v after %d attempts", err, attempts)
+	}
+
+	// Postgres starting up is retried until the timeout
+	b.timeout = 20 * time.Millisecond
+	attempts = 0
+	if err := b.retry(context.Background(), "test", func() error {
+		attempts++
+		return retryablePostgresErr(&pq.Error{Code: postgresStartingUp})
+	}); err == nil || attempts < 2 {
+		t.Fatalf("expected timeout error after several attempts, got %v after %d attempts", err, attempts)
+	}
 }
 
-func Test_API(t *testing.T) {
+func Test_SchemaCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		current uint
+		dirty   bool
+		wantErr bool
+	}{
+		{"strict-match", SchemaCheckStrict, 2, false, false},
+		{"strict-older", SchemaCheckStrict, 1, false, true},
+		{"strict-newer", SchemaCheckStrict, 3, false, true},
+		{"strict-dirty", SchemaCheckStrict, 2, true, true},
+		{"allow-newer-newer", SchemaCheckAllowNewer, 3, false, false},
+		{"allow-newer-older", SchemaCheckAllowNewer, 1, false, true},
+		{"off-older", SchemaCheckOff, 1, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &schemaInfo{expected: 2, policy: tt.policy}
+			if err := s.check(tt.current, tt.dirty); (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected result, wantErr %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
 
-	s := New(8080, 1, database.NewMemoryDBClient())
-	s.Start()
+func Test_HealthSchemaVersion(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	if err := dbClient.InitializeSchema(sqlc.Migrations(), database.DefaultMigrationsTable); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := database.LatestMigrationVersion(sqlc.Migrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		expected uint
+		code     int
+	}{
+		{"match", latest, http.StatusOK},
+		{"mismatch", latest + 1, http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newService(DefaultConfig, dbClient, &schemaInfo{expected: tt.expected, table: database.DefaultMigrationsTable, policy: SchemaCheckStrict})
+			rec := httptest.NewRecorder()
+			s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HealthEndPnt, nil))
+			if g, w := rec.Code, tt.code; g != w {
+				t.Fatalf("unexpected response code, want %v got %v: %s", w, g, rec.Body)
+			}
+			var h HealthResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &h); err != nil {
+				t.Fatal(err)
+			}
+			if g, w := h.SchemaVersion, latest; g != w {
+				t.Fatalf("unexpected schema version, want %v got %v", w, g)
+			}
+
+			rec = httptest.NewRecorder()
+			s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, StatusEndPnt, nil))
+			var st StatusResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+				t.Fatal(err)
+			}
+			if g, w := st.SchemaVersion, tt.expected; g != w {
+				t.Fatalf("unexpected status schema version, want %v got %v", w, g)
+			}
+		})
+	}
+}
+
+func Test_HealthProbes(t *testing.T) {
+	s := New(0, 1, database.NewMemoryDBClient())
+
+	var backlog int64
+	s.RegisterHealthCheck(BacklogCheck("outbox", func(ctx context.Context) (int64, error) { return backlog, nil }, 10), 0, ProbeReadiness)
+
+	probe := func(path string) (int, ProbeResponse) {
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp ProbeResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, _ := probe(LivezEndPnt); code != http.StatusOK {
+		t.Fatalf("unexpected livez code %v", code)
+	}
+	if code, _ := probe(StartupzEndPnt); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected startupz to fail before start, got %v", code)
+	}
+	s.started.Store(true)
+	if code, _ := probe(StartupzEndPnt); code != http.StatusOK {
+		t.Fatalf("unexpected startupz code %v", code)
+	}
+
+	code, resp := probe(ReadyzEndPnt)
+	if code != http.StatusOK || resp.Checks != nil {
+		t.Fatalf("unexpected readyz response %v %+v", code, resp)
+	}
+
+	backlog = 11
+	code, resp = probe(ReadyzEndPnt + "?verbose")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readyz to fail, got %v", code)
+	}
+	for _, c := range resp.Checks {
+		if c.Name == "outbox" && c.Healthy || c.Name != "outbox" && !c.Healthy {
+			t.Fatalf("unexpected check result %+v", c)
+		}
+	}
+
+	s.draining.Store(true)
+	backlog = 0
+	if code, _ := probe(ReadyzEndPnt); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readyz to fail while draining, got %v", code)
+	}
+}
+
+func Test_HealthRegistryCache(t *testing.T) {
+	var calls int
+	h := NewHealthRegistry(time.Second)
+	h.Register(CheckFunc{CheckName: "counter", Fn: func(ctx context.Context) CheckResult {
+		calls++
+		return healthy()
+	}}, time.Hour, ProbeReadiness)
+
+	for i := 0; i < 3; i++ {
+		h.Run(context.Background(), ProbeReadiness)
+	}
+	if len(h.Run(context.Background(), ProbeLiveness)) != 0 {
+		t.Fatal("check registered for wrong probe")
+	}
+	if calls != 1 {
+		t.Fatalf("expected cached result, check called %d times", calls)
+	}
+}
+
+func Test_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
 	t.Cleanup(func() {
-		s.Stop(os.Interrupt)
+		_ = tp.Shutdown(context.Background())
+		otel.SetTracerProvider(noop.NewTracerProvider())
 	})
-	time.Sleep(50 * time.Millisecond) // TODO - smell
 
-	testAccount := database.Account{ID: 1, Username: "myusername", Email: sql.NullString{String: "myname@emailprovider.com"}}
-	testAccount2 := database.Account{ID: 2, Username: "yourusername", Email: sql.NullString{String: "yourname@emailprovider.com"}}
-	testTx := database.Transaction{ID: 1, FromAccount: sql.NullInt64{Int64: 1}, ToAccount: sql.NullInt64{Int64: 2}, Amount: sql.NullInt64{Int64: 1}}
+	s := New(0, 1, database.NewTracingClient(database.NewMemoryDBClient()))
 
-	apiTests := []struct {
-		name             string
-		endpoint         string
-		methodType       string
-		body             func() []byte
-		expectedResponse any
-		expectedCode     int
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, AccountsEndPnt, nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	s.Server().Handler().ServeHTTP(rec, req)
+
+	if g := rec.Header().Get("traceparent"); !strings.Contains(g, traceID) {
+		t.Fatalf("expected traceparent response header with trace ID %v, got '%v'", traceID, g)
+	}
+
+	spans := recorder.Ended()
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans {
+		byName[span.Name()] = span
+	}
+	server, ok := byName["GET "+AccountsEndPnt]
+	if !ok {
+		t.Fatalf("missing server span, got %v spans", len(spans))
+	}
+	if g := server.SpanContext().TraceID().String(); g != traceID {
+		t.Fatalf("server span did not continue trace, got %v", g)
+	}
+	db, ok := byName["DBQuery.GetUsers"]
+	if !ok {
+		t.Fatal("missing DB span")
+	}
+	if db.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatal("DB span is not a child of the server span")
+	}
+}
+
+func Test_RequestID(t *testing.T) {
+	s := New(0, 1, database.NewMemoryDBClient())
+
+	for _, tt := range []struct {
+		name    string
+		header  string
+		reuseID bool
 	}{
-		//
-		// READ REQUESTS
-		//
-		{
-			"status",
-			StatusEndPnt,
-			http.MethodGet,
-			func() []byte { return nil },
-			&StatusResponse{Message: "OK", Version: Version, Service: ServiceName},
-			http.StatusOK,
-		},
-		{
-			"health",
-			HealthEndPnt,
-			http.MethodGet,
-			func() []byte { return nil },
-			&HealthResponse{Version: Version, Service: ServiceName, Failures: []string{}},
-			http.StatusOK,
-		},
-		//
-		// WRITE REQUESTS
-		//
-		{
-			"create-account",
-			CreateAccountEndPnt,
-			http.MethodPut,
-			func() []byte {
-				b, err := json.Marshal(testAccount)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			testAccount,
-			http.StatusOK,
-		},
-		{
-			"create-account-2",
-			CreateAccountEndPnt,
-			http.MethodPut,
-			func() []byte {
-				b, err := json.Marshal(testAccount2)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			testAccount2,
-			http.StatusOK,
-		},
-		//
-		// READ REQUESTS WITH DB LOOKUP
-		//
-		{
-			"create-transaction",
-			CreateTxEndPnt,
-			http.MethodPut,
-			func() []byte {
-				b, err := json.Marshal(testTx)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			testTx,
-			http.StatusOK,
-		},
-		{
-			"accounts",
-			AccountsEndPnt,
-			http.MethodGet,
-			func() []byte { return nil },
-			&[]database.Account{testAccount, testAccount2},
-			http.StatusOK,
-		},
-		{
-			"account-by-index",
-			GetAccountEndPnt,
-			http.MethodPost,
-			func() []byte {
-				accParams := database.Account{ID: 1}
-				b, err := json.Marshal(accParams)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			testAccount,
-			http.StatusOK,
-		},
-		{
-			"account-by-email",
-			GetAccountByEmailEndPnt,
-			http.MethodPost,
-			func() []byte {
-				accParams := &database.Account{Email: sql.NullString{String: "myname@emailprovider.com"}}
-				b, err := json.Marshal(accParams)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			testAccount,
-			http.StatusOK,
-		},
-		{
-			"account-by-username",
-			GetAccountByUsernameEndPnt,
-			http.MethodPost,
-			func() []byte {
-				accParams := database.Account{Username: "myusername"}
-				b, err := json.Marshal(accParams)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			testAccount,
-			http.StatusOK,
-		},
-		{
-			"transaction-by-id",
-			GetTransactionByIndexEndPnt,
-			http.MethodPost,
-			func() []byte {
-				accParams := database.Transaction{ID: 1}
-				b, err := json.Marshal(accParams)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			testTx,
-			http.StatusOK,
-		},
-		{
-			"account-txs",
-			GetAccountTransactionsEndPnt,
-			http.MethodPost,
-			func() []byte {
-				accParams := database.Account{ID: 1}
-				b, err := json.Marshal(accParams)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			&[]database.GetUserTransactionsRow{{TransactionID: testTx.ID, FromAccountID: testTx.FromAccount, ToAccountID: testTx.ToAccount, Amount: testTx.Amount}},
-			http.StatusOK,
-		},
-		//
-		// CLIENT ERRORS
-		//
-		{
-			"account-by-index-not-found",
-			GetAccountEndPnt,
-			http.MethodPost,
-			func() []byte {
-				accParams := database.Account{ID: 5}
-				b, err := json.Marshal(accParams)
-				if err != nil {
-					panic(err)
-				}
-				return b
-			},
-			map[string]string{"error": database.ErrNotFound.Error()},
-			http.StatusNotFound,
+		{"supplied", "abc-123", true},
+		{"missing", "", false},
+		{"invalid", "bad id\n", false},
+		{"too-long", strings.Repeat("a", maxRequestIDLength+1), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, GetAccountEndPnt, strings.NewReader(`{"id":99}`))
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			s.Server().Handler().ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if tt.reuseID && id != tt.header || !tt.reuseID && (id == "" || id == tt.header) {
+				t.Fatalf("unexpected request ID '%v'", id)
+			}
+			var body jsonErr
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.RequestID != id {
+				t.Fatalf("error body request ID %v does not match header %v", body.RequestID, id)
+			}
+		})
+	}
+}
+
+func Test_APIKeyAuth(t *testing.T) {
+	config := DefaultConfig
+	config.APIKeys = []string{"alice:secret-key"}
+	config.AdminAPIKeys = []string{"root:admin-key"}
+	s := newService(config, database.NewMemoryDBClient(), nil)
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		code   int
+	}{
+		{"public", StatusEndPnt, "", "", http.StatusOK},
+		{"missing-key", AccountsEndPnt, "", "", http.StatusUnauthorized},
+		{"invalid-key", AccountsEndPnt, "Authorization", "Bearer wrong", http.StatusUnauthorized},
+		{"bearer", AccountsEndPnt, "Authorization", "Bearer secret-key", http.StatusOK},
+		{"api-key-header", AccountsEndPnt, "X-API-Key", "secret-key", http.StatusOK},
+		{"admin-key", AccountsEndPnt, "X-API-Key", "admin-key", http.StatusOK},
+		{"log-level-not-admin", LogLevelEndPnt, "X-API-Key", "secret-key", http.StatusForbidden},
+		{"audit-not-admin", AuditEndPnt, "X-API-Key", "secret-key", http.StatusForbidden},
+		{"verify-not-admin", VerifyEndPnt, "X-API-Key", "secret-key", http.StatusForbidden},
+		{"audit-missing-key", AuditEndPnt, "", "", http.StatusUnauthorized},
+		// Logging is not configured by newService, but the request is authorized
+		{"log-level-admin", LogLevelEndPnt, "X-API-Key", "admin-key", http.StatusNotFound},
+		{"audit-admin", AuditEndPnt, "Authorization", "Bearer admin-key", http.StatusOK},
+		{"verify-admin", VerifyEndPnt, "X-API-Key", "admin-key", http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			s.Server().Handler().ServeHTTP(rec, req)
+			if g, w := rec.Code, tt.code; g != w {
+				t.Fatalf("unexpected response code, want %v got %v: %s", w, g, rec.Body)
+			}
+		})
+	}
+
+	if r := config.Redacted(); r.APIKeys[0] != "alice:"+redacted || r.AdminAPIKeys[0] != "root:"+redacted {
+		t.Fatalf("API keys not redacted: %v %v", r.APIKeys, r.AdminAPIKeys)
+	}
+}
+
+func Test_Logging(t *testing.T) {
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	config := DefaultConfig
+	config.LogLevel = "error"
+	config.LogToFile = true
+	config.LogFile = filepath.Join(t.TempDir(), "test.log")
+	config.LogFileLevel = "debug"
+	logs, err := InitLogging(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = logs.Close() })
+
+	slog.Debug("debug-message")
+	b, err := os.ReadFile(config.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "debug-message") {
+		t.Fatalf("file sink did not record debug message: %s", b)
+	}
+
+	// The log file is recreated after being moved by an external rotator
+	if err := os.Rename(config.LogFile, config.LogFile+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := logs.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	slog.Info("after-reopen")
+	if b, err = os.ReadFile(config.LogFile); err != nil || !strings.Contains(string(b), "after-reopen") {
+		t.Fatalf("log file not reopened: %v %s", err, b)
+	}
+
+	s := New(0, 1, database.NewMemoryDBClient())
+	s.setLogging(logs)
+	rec := httptest.NewRecorder()
+	s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, LogLevelEndPnt, strings.NewReader(`{"sink":"file","level":"warn"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response code %v: %s", rec.Code, rec.Body)
+	}
+	if g, w := logs.Levels()[LogSinkFile], "WARN"; g != w {
+		t.Fatalf("unexpected file level, want %v got %v", w, g)
+	}
+	if g, w := logs.Levels()[LogSinkStderr], "ERROR"; g != w {
+		t.Fatalf("unexpected stderr level, want %v got %v", w, g)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, LogLevelEndPnt, strings.NewReader(`{"sink":"syslog","level":"warn"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown sink to be rejected, got %v", rec.Code)
+	}
+}
+
+func Test_RateLimit(t *testing.T) {
+	config := DefaultConfig
+	config.RateLimit = true
+	config.RateLimitReadRPS = 0.001
+	config.RateLimitReadBurst = 2
+	config.RateLimitWriteRPS = 0.001
+	config.RateLimitWriteBurst = 1
+	s := newService(config, database.NewMemoryDBClient(), nil)
+
+	do := func(method, path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"id":1}`))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do(http.MethodGet, AccountsEndPnt, "10.0.0.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d unexpectedly limited: %v", i, rec.Code)
+		}
+	}
+	rec := do(http.MethodGet, AccountsEndPnt, "10.0.0.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" || rec.Header().Get("RateLimit-Remaining") != "0" || rec.Header().Get("RateLimit-Limit") != "2" {
+		t.Fatalf("unexpected rate limit headers %v", rec.Header())
+	}
+
+	// POST lookups share the read bucket
+	if rec := do(http.MethodPost, GetAccountEndPnt, "10.0.0.1:1234"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected read lookup to be limited, got %v", rec.Code)
+	}
+	// Writes and other clients have separate buckets
+	if rec := do(http.MethodPut, CreateAccountEndPnt, "10.0.0.1:1234"); rec.Code == http.StatusTooManyRequests {
+		t.Fatal("write limited by read bucket")
+	}
+	if rec := do(http.MethodGet, AccountsEndPnt, "10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("other client limited: %v", rec.Code)
+	}
+	// Probes are never limited
+	if rec := do(http.MethodGet, LivezEndPnt, "10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("probe limited: %v", rec.Code)
+	}
+}
+
+func Test_AccountStatus(t *testing.T) {
+	s := newService(DefaultConfig, database.NewMemoryDBClient(), nil)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	setStatus := func(id int64, status, reason string) int {
+		return do(http.MethodPut, AccountStatusEndPnt, AccountStatusRequest{ID: id, Status: status, Reason: reason}).Code
+	}
+	transfer := func(from, to, amount int64) int {
+		return do(http.MethodPut, CreateTxEndPnt, database.CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: from},
+			ToAccount:   sql.NullInt64{Int64: to},
+			Amount:      sql.NullInt64{Int64: amount},
+		}).Code
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+
+	tests := []struct {
+		name string
+		code int
+		fn   func() int
+	}{
+		{"missing-reason", http.StatusBadRequest, func() int { return setStatus(1, database.AccountStatusFrozen, "") }},
+		{"invalid-status", http.StatusBadRequest, func() int { return setStatus(1, "suspended", "fraud") }},
+		{"unknown-account", http.StatusBadRequest, func() int { return setStatus(5, database.AccountStatusFrozen, "fraud") }},
+		{"freeze", http.StatusOK, func() int { return setStatus(1, database.AccountStatusFrozen, "fraud review") }},
+		{"freeze-twice", http.StatusConflict, func() int { return setStatus(1, database.AccountStatusFrozen, "fraud review") }},
+		{"send-from-frozen", http.StatusConflict, func() int { return transfer(1, 2, 10) }},
+		{"send-to-frozen", http.StatusConflict, func() int { return transfer(2, 1, 10) }},
+		{"unfreeze", http.StatusOK, func() int { return setStatus(1, database.AccountStatusActive, "review complete") }},
+		{"send-without-funds", http.StatusConflict, func() int { return transfer(1, 2, 10) }},
+		{"overdraft-limit", http.StatusOK, func() int {
+			return do(http.MethodPut, "/v1/accounts/1/overdraft-limit", OverdraftLimitRequest{OverdraftLimit: 10}).Code
+		}},
+		{"send", http.StatusOK, func() int { return transfer(1, 2, 10) }},
+		{"close-non-zero-balance", http.StatusConflict, func() int { return setStatus(2, database.AccountStatusClosed, "customer request") }},
+		{"send-back", http.StatusOK, func() int { return transfer(2, 1, 10) }},
+		{"close", http.StatusOK, func() int { return setStatus(2, database.AccountStatusClosed, "customer request") }},
+		{"reopen", http.StatusConflict, func() int { return setStatus(2, database.AccountStatusActive, "customer request") }},
+		{"send-to-closed", http.StatusConflict, func() int { return transfer(1, 2, 10) }},
+	}
+	for _, tt := range tests {
+		if g, w := tt.fn(), tt.code; g != w {
+			t.Fatalf("%v: unexpected code, want %v got %v", tt.name, w, g)
+		}
+	}
+
+	// Filter accounts by status
+	rec := do(http.MethodGet, AccountsEndPnt+"?status="+database.AccountStatusClosed, nil)
+	var accs []database.Account
+	if err := json.Unmarshal(rec.Body.Bytes(), &accs); err != nil {
+		t.Fatal(err)
+	}
+	if len(accs) != 1 || accs[0].ID != 2 || accs[0].StatusReason.String != "customer request" {
+		t.Fatalf("unexpected closed accounts %+v", accs)
+	}
+	if rec := do(http.MethodGet, AccountsEndPnt+"?status=unknown", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid status filter, got %v", rec.Code)
+	}
+}
+
+func Test_Audit(t *testing.T) {
+	config := DefaultConfig
+	config.AdminAPIKeys = []string{"alice:secret-key"}
+	dbClient := database.NewMemoryDBClient()
+	s := newService(config, dbClient, nil)
+
+	do := func(method, path, requestID string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("X-API-Key", "secret-key")
+		req.Header.Set(RequestIDHeader, requestID)
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	audit := func(query string) []database.AuditLog {
+		rec := do(http.MethodGet, AuditEndPnt+query, "audit", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("audit %v: unexpected code %v: %s", query, rec.Code, rec.Body)
+		}
+		var entries []database.AuditLog
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	start := time.Now()
+	for _, acc := range []database.CreateAccountParams{{Username: "alice"}, {Username: "bob"}} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, "create-"+acc.Username, acc); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 10, 1)
+	tx := database.CreateTransactionParams{FromAccount: sql.NullInt64{Int64: 1}, ToAccount: sql.NullInt64{Int64: 2}, Amount: sql.NullInt64{Int64: 10}}
+	if rec := do(http.MethodPut, CreateTxEndPnt, "send", tx); rec.Code != http.StatusOK {
+		t.Fatalf("cannot create transaction: %s", rec.Body)
+	}
+	if rec := do(http.MethodPut, AccountStatusEndPnt, "freeze", AccountStatusRequest{ID: 2, Status: database.AccountStatusFrozen, Reason: "fraud review"}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot freeze account: %s", rec.Body)
+	}
+	// Nothing is recorded for rejected changes
+	if rec := do(http.MethodPut, CreateTxEndPnt, "rejected", tx); rec.Code != http.StatusConflict {
+		t.Fatalf("expected conflict, got %v", rec.Code)
+	}
+
+	type summary struct{ entity, action, requestID string }
+	var got []summary
+	for _, e := range audit("?entity_type=account&entity_id=2") {
+		if e.Actor != "alice" {
+			t.Fatalf("unexpected actor %v", e.Actor)
+		}
+		got = append(got, summary{e.EntityType, e.Action, e.RequestID})
+	}
+	want := []summary{
+		{database.AuditEntityAccount, database.AuditActionCreate, "create-bob"},
+		{database.AuditEntityAccount, database.AuditActionBalanceChange, "send"},
+		{database.AuditEntityAccount, database.AuditActionStatusChange, "freeze"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected audit entries, want %v got %v", want, got)
+	}
+
+	entries := audit("?entity_type=account&entity_id=2&after_id=5")
+	var before, after database.Account
+	if err := json.Unmarshal(entries[len(entries)-1].Before, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(entries[len(entries)-1].After, &after); err != nil {
+		t.Fatal(err)
+	}
+	if before.Status != database.AccountStatusActive || after.Status != database.AccountStatusFrozen {
+		t.Fatalf("unexpected before %+v and after %+v", before, after)
+	}
+
+	if n := len(audit("?entity_type=transaction")); n != 1 {
+		t.Fatalf("expected one transaction entry, got %v", n)
+	}
+	if n := len(audit("?limit=2")); n != 2 {
+		t.Fatalf("expected limit to apply, got %v entries", n)
+	}
+	if n := len(audit("?from=" + start.Add(time.Hour).Format(time.RFC3339))); n != 0 {
+		t.Fatalf("expected no entries after from time, got %v", n)
+	}
+	if n := len(audit("?to=" + start.Add(-time.Hour).Format(time.RFC3339))); n != 0 {
+		t.Fatalf("expected no entries before to time, got %v", n)
+	}
+	for _, q := range []string{"?entity_type=user", "?entity_id=x", "?from=yesterday", "?limit=0"} {
+		if rec := do(http.MethodGet, AuditEndPnt+q, "audit", nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%v: expected 400, got %v", q, rec.Code)
+		}
+	}
+}
+
+// createSystemAccount creates a system account, which may go negative without
+// limit, directly in the DB since the API only creates user accounts.
+func createSystemAccount(t *testing.T, dbClient database.DBClient, username string) {
+	t.Helper()
+	if _, err := dbClient.NewQuery().CreateAccount(context.Background(), database.CreateAccountParams{Username: username, AccountType: database.AccountTypeSystem}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setOverdraftLimits lets the test accounts ids go negative by up to limit.
+func setOverdraftLimits(t *testing.T, dbClient database.DBClient, limit int64, ids ...int64) {
+	t.Helper()
+	for _, id := range ids {
+		if _, err := dbClient.NewQuery().UpdateAccountOverdraftLimit(context.Background(), database.UpdateAccountOverdraftLimitParams{ID: id, OverdraftLimit: limit}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_ChainCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "chain.key")
+	if _, err := GenerateSigningKey(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateSigningKey(keyFile); err == nil {
+		t.Fatal("expected existing key not to be overwritten")
+	}
+	key, err := LoadSigningKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := dbClient.NewQuery().CreateAccount(context.Background(), database.CreateAccountParams{Username: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 100, 1)
+	b, _ := json.Marshal(database.CreateTransactionParams{FromAccount: sql.NullInt64{Int64: 1}, ToAccount: sql.NullInt64{Int64: 2}, Amount: sql.NullInt64{Int64: 5}})
+	rec := httptest.NewRecorder()
+	s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, CreateTxEndPnt, bytes.NewReader(b)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cannot create transaction: %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, VerifyEndPnt, nil))
+	var v database.ChainVerification
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || !v.Valid || v.LastTxID != 1 {
+		t.Fatalf("unexpected verification %v %+v", rec.Code, v)
+	}
+
+	// A checkpoint is written on shutdown
+	file := filepath.Join(dir, "checkpoints.jsonl")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := checkpointWorker(dbClient, key, file, time.Hour)(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cps, err := ReadCheckpoints(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 1 || cps[0].TxID != 1 || cps[0].Hash != v.HeadHash {
+		t.Fatalf("unexpected checkpoints %+v", cps)
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	if err := VerifyCheckpoint(cps[0], pub); err != nil {
+		t.Fatal(err)
+	}
+	tampered := cps[0]
+	tampered.TxID = 2
+	if err := VerifyCheckpoint(tampered, pub); err == nil {
+		t.Fatal("expected tampered checkpoint to fail verification")
+	}
+}
+
+func Test_LimitInFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var inFlight atomic.Int64
+	h := limitInFlight(1, &inFlight, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	close(release)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After, got %v %v", rec.Code, rec.Header())
+	}
+}
+
+func Test_IdempotencyStoreLimit(t *testing.T) {
+	store := newIdempotencyStore()
+	now := time.Now()
+	for i := 0; i < maxIdempotencyKeys+10; i++ {
+		key := fmt.Sprint(i)
+		if _, ok := store.begin(key, now); ok {
+			t.Fatalf("unexpected cached response for %v", key)
+		}
+		store.finish(key, &cachedResponse{code: http.StatusOK, expires: now.Add(idempotencyTTL)})
+		// Replaying the first key keeps it in use
+		if _, ok := store.begin("0", now); !ok {
+			t.Fatalf("expected key 0 to be cached after %v", key)
+		}
+	}
+	if g, w := store.len(), maxIdempotencyKeys; g != w {
+		t.Fatalf("unexpected number of keys, want %v got %v", w, g)
+	}
+	// The least recently used keys are evicted
+	if _, ok := store.begin("1", now); ok {
+		t.Fatal("expected key 1 to be evicted")
+	}
+}
+
+func Test_IdempotencyWritesOnly(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := do(http.MethodPost, GetAccountEndPnt, database.Account{ID: 1}); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected account not found, got %v", rec.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: "alice"}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	// The read is not replayed once the account exists
+	if rec := do(http.MethodPost, GetAccountEndPnt, database.Account{ID: 1}); rec.Code != http.StatusOK {
+		t.Fatalf("expected read not to be cached, got %v: %s", rec.Code, rec.Body)
+	}
+}
+
+func Test_IdempotencyReplay(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+	do := func(requestID string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPut, CreateAccountEndPnt, bytes.NewReader(b))
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		req.Header.Set(RequestIDHeader, requestID)
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	first := do("request-1", database.CreateAccountParams{Username: "alice"})
+	if first.Code != http.StatusOK {
+		t.Fatalf("cannot create account: %s", first.Body)
+	}
+	// A retry is replayed with its own request ID
+	retry := do("request-2", database.CreateAccountParams{Username: "alice"})
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the response to be replayed, got %v: %s", retry.Code, retry.Body)
+	}
+	if g, w := retry.Header().Get(RequestIDHeader), "request-2"; g != w {
+		t.Fatalf("unexpected request ID, want %v got %v", w, g)
+	}
+	// The key cannot be reused for a different request
+	if rec := do("request-3", database.CreateAccountParams{Username: "bob"}); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected key reuse to be rejected, got %v: %s", rec.Code, rec.Body)
+	}
+	if accs, err := dbClient.NewQuery().GetUsers(context.Background()); err != nil || len(accs) != 5 {
+		t.Fatalf("expected one account to be created, got %v (%v)", len(accs), err)
+	}
+}
+
+func Test_ServiceStartStop(t *testing.T) {
+
+	service := New(8080, 1, database.NewMemoryDBClient())
+
+	service.Start()
+
+	service.Stop(os.Interrupt)
+}
+
+func Test_ServiceRunShutdown(t *testing.T) {
+
+	s := New(8081, 1, database.NewMemoryDBClient())
+	s.drainPeriod = 100 * time.Millisecond
+
+	workerStopped := make(chan struct{})
+	s.AddWorker("test", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(workerStopped)
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+
+	// Health reports unavailable while draining
+	rec := httptest.NewRecorder()
+	s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HealthEndPnt, nil))
+	if g, w := rec.Code, http.StatusServiceUnavailable; g != w {
+		t.Fatalf("unexpected health code while draining, want %v got %v", w, g)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after shutdown")
+	}
+
+	select {
+	case <-workerStopped:
+	default:
+		t.Fatal("worker not stopped before Run returned")
+	}
+}
+
+func Test_API(t *testing.T) {
+
+	created := time.Date(2024, 11, 10, 9, 0, 0, 0, time.UTC)
+	dbClient := database.NewMemoryDBClient()
+	dbClient.SetClock(func() time.Time { return created })
+	s := New(8080, 1, dbClient)
+	s.Start()
+	t.Cleanup(func() {
+		s.Stop(os.Interrupt)
+	})
+	time.Sleep(50 * time.Millisecond) // TODO - smell
+
+	createdAt := sql.NullTime{Time: created, Valid: true}
+	testAccount := database.Account{ID: 1, Username: "myusername", Email: sql.NullString{String: "myname@emailprovider.com"}, CreatedAt: createdAt, Status: database.AccountStatusActive, AccountType: database.AccountTypeUser, Metadata: json.RawMessage("{}")}
+	testAccount2 := database.Account{ID: 2, Username: "yourusername", Email: sql.NullString{String: "yourname@emailprovider.com"}, CreatedAt: createdAt, Status: database.AccountStatusActive, AccountType: database.AccountTypeUser, Metadata: json.RawMessage("{}")}
+	testTx := database.Transaction{ID: 1, FromAccount: sql.NullInt64{Int64: 1}, ToAccount: sql.NullInt64{Int64: 2}, Amount: sql.NullInt64{Int64: 1}, CreatedAt: createdAt, Metadata: json.RawMessage("{}"), ChainVersion: database.ChainVersion}
+	testTx.PrevHash, testTx.Hash = database.GenesisHash(), database.ChainHash(database.GenesisHash(), testTx)
+
+	// testAccount may overdraw by 1 to send testTx
+	limitedAccount := testAccount
+	limitedAccount.OverdraftLimit = 1
+
+	// Balances after testTx is posted
+	sentAccount, receivedAccount := limitedAccount, testAccount2
+
+	// The system accounts created by migration are listed first
+	var systemAccounts []database.Account
+	for _, id := range []int64{database.InterestExpenseAccountID, database.FeeRevenueAccountID, database.WithdrawalsAccountID, database.DepositsAccountID} {
+		acc, err := dbClient.NewQuery().GetUser(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		systemAccounts = append(systemAccounts, acc)
+	}
+	sentAccount.Balance, receivedAccount.Balance = -1, 1
+
+	apiTests := []struct {
+		name             string
+		endpoint         string
+		methodType       string
+		body             func() []byte
+		expectedResponse any
+		expectedCode     int
+	}{
+		//
+		// READ REQUESTS
+		//
+		{
+			"status",
+			StatusEndPnt,
+			http.MethodGet,
+			func() []byte { return nil },
+			&StatusResponse{Message: "OK", Version: Version, Service: ServiceName},
+			http.StatusOK,
 		},
 		{
-			"account-by-email-not-found",
-			GetAccountByEmailEndPnt,
-			http.MethodPost,
+			"health",
+			HealthEndPnt,
+			http.MethodGet,
+			func() []byte { return nil },
+			&HealthResponse{Version: Version, Service: ServiceName, Failures: []string{}},
+			http.StatusOK,
+		},
+		//
+		// WRITE REQUESTS
+		//
+		{
+			"create-account",
+			CreateAccountEndPnt,
+			http.MethodPut,
 			func() []byte {
-				accParams := &database.Account{Email: sql.NullString{String: "notarealuser@emailprovider.com"}}
-				b, err := json.Marshal(accParams)
+				b, err := json.Marshal(testAccount)
 				if err != nil {
 					panic(err)
 				}
 				return b
 			},
-			map[string]string{"error": database.ErrNotFound.Error()},
-			http.StatusNotFound,
+			testAccount,
+			http.StatusOK,
 		},
 		{
-			"account-by-username-not-found",
-			GetAccountByUsernameEndPnt,
-			http.MethodPost,
-			func() []byte {
+			"create-account-2",
+			CreateAccountEndPnt,
+			http.MethodPut,
+			func() []byte {
+				b, err := json.Marshal(testAccount2)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			testAccount2,
+			http.StatusOK,
+		},
+		{
+			"overdraft-limit",
+			"/v1/accounts/1/overdraft-limit",
+			http.MethodPut,
+			func() []byte {
+				b, err := json.Marshal(OverdraftLimitRequest{OverdraftLimit: 1})
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			limitedAccount,
+			http.StatusOK,
+		},
+		//
+		// READ REQUESTS WITH DB LOOKUP
+		//
+		{
+			"create-transaction",
+			CreateTxEndPnt,
+			http.MethodPut,
+			func() []byte {
+				b, err := json.Marshal(testTx)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			&TxResponse{Transaction: testTx, Fees: []FeeCharge{}},
+			http.StatusOK,
+		},
+		{
+			"accounts",
+			AccountsEndPnt,
+			http.MethodGet,
+			func() []byte { return nil },
+			&[]database.Account{systemAccounts[0], systemAccounts[1], systemAccounts[2], systemAccounts[3], sentAccount, receivedAccount},
+			http.StatusOK,
+		},
+		{
+			"account-by-index",
+			GetAccountEndPnt,
+			http.MethodPost,
+			func() []byte {
+				accParams := database.Account{ID: 1}
+				b, err := json.Marshal(accParams)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			sentAccount,
+			http.StatusOK,
+		},
+		{
+			"account-by-email",
+			GetAccountByEmailEndPnt,
+			http.MethodPost,
+			func() []byte {
+				accParams := &database.Account{Email: sql.NullString{String: "myname@emailprovider.com"}}
+				b, err := json.Marshal(accParams)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			sentAccount,
+			http.StatusOK,
+		},
+		{
+			"account-by-username",
+			GetAccountByUsernameEndPnt,
+			http.MethodPost,
+			func() []byte {
+				accParams := database.Account{Username: "myusername"}
+				b, err := json.Marshal(accParams)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			sentAccount,
+			http.StatusOK,
+		},
+		{
+			"transaction-by-id",
+			GetTransactionByIndexEndPnt,
+			http.MethodPost,
+			func() []byte {
+				accParams := database.Transaction{ID: 1}
+				b, err := json.Marshal(accParams)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			testTx,
+			http.StatusOK,
+		},
+		{
+			"account-txs",
+			GetAccountTransactionsEndPnt,
+			http.MethodPost,
+			func() []byte {
+				accParams := database.Account{ID: 1}
+				b, err := json.Marshal(accParams)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			&[]database.GetUserTransactionsRow{{TransactionID: testTx.ID, FromAccountID: testTx.FromAccount, ToAccountID: testTx.ToAccount, Amount: testTx.Amount}},
+			http.StatusOK,
+		},
+		//
+		// CLIENT ERRORS
+		//
+		{
+			"account-by-index-not-found",
+			GetAccountEndPnt,
+			http.MethodPost,
+			func() []byte {
+				accParams := database.Account{ID: 5}
+				b, err := json.Marshal(accParams)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			map[string]string{"error": database.ErrNotFound.Error()},
+			http.StatusNotFound,
+		},
+		{
+			"account-by-email-not-found",
+			GetAccountByEmailEndPnt,
+			http.MethodPost,
+			func() []byte {
+				accParams := &database.Account{Email: sql.NullString{String: "notarealuser@emailprovider.com"}}
+				b, err := json.Marshal(accParams)
+				if err != nil {
+					panic(err)
+				}
+				return b
+			},
+			map[string]string{"error": database.ErrNotFound.Error()},
+			http.StatusNotFound,
+		},
+		{
+			"account-by-username-not-found",
+			GetAccountByUsernameEndPnt,
+			http.MethodPost,
+			func() []byte {
 				accParams := database.Account{Username: "notarealuser"}
 				b, err := json.Marshal(accParams)
 				if err != nil {
@@ -373,35 +1267,1759 @@ func Test_API(t *testing.T) {
 		},
 	}
 
-	for _, tt := range apiTests {
-		req, err := http.NewRequestWithContext(context.Background(), tt.methodType, fmt.Sprintf("http://0.0.0.0%v%v", s.server.Addr(), tt.endpoint), bytes.NewReader(tt.body()))
+	for _, tt := range apiTests {
+		req, err := http.NewRequestWithContext(context.Background(), tt.methodType, fmt.Sprintf("http://0.0.0.0%v%v", s.server.Addr(), tt.endpoint), bytes.NewReader(tt.body()))
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(RequestIDHeader, tt.name)
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		defer response.Body.Close()
+
+		// Read the response body
+		b, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, w := response.StatusCode, tt.expectedCode; g != w {
+			t.Errorf("%v unexpected response code, want %v got %v", tt.name, w, g)
+		}
+		if g, w := response.Header.Get(RequestIDHeader), tt.name; g != w {
+			t.Errorf("%v unexpected request ID, want %v got %v", tt.name, w, g)
+		}
+		if tt.expectedResponse != nil {
+			// Error bodies echo the request ID
+			if m, ok := tt.expectedResponse.(map[string]string); ok {
+				m["request_id"] = tt.name
+			}
+
+			expectedJSON, _ := json.Marshal(tt.expectedResponse)
+
+			if g, w := b, expectedJSON; !bytes.Equal(g, w) {
+				t.Errorf("%v unexpected response, want %s, got %s", tt.name, w, g)
+			}
+		}
+
+	}
+}
+
+func Test_Balances(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	now := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	dbClient.SetClock(func() time.Time { return now })
+	s := newService(DefaultConfig, dbClient, nil)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 100, 1)
+	tx := database.CreateTransactionParams{FromAccount: sql.NullInt64{Int64: 1}, ToAccount: sql.NullInt64{Int64: 2}, Amount: sql.NullInt64{Int64: 10}}
+	if rec := do(http.MethodPut, CreateTxEndPnt, tx); rec.Code != http.StatusOK {
+		t.Fatalf("cannot create transaction: %s", rec.Body)
+	}
+	now = now.Add(48 * time.Hour)
+	if rec := do(http.MethodPut, CreateTxEndPnt, tx); rec.Code != http.StatusOK {
+		t.Fatalf("cannot create transaction: %s", rec.Body)
+	}
+	if _, err := database.CheckpointBalances(context.Background(), dbClient.NewQuery(), now); err != nil {
+		t.Fatal(err)
+	}
+
+	asOf := time.Date(2024, 11, 2, 0, 0, 0, 0, time.UTC)
+	rec := do(http.MethodGet, "/v1/accounts/2/balance?as_of="+asOf.Format(time.RFC3339), nil)
+	var b BalanceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &b); err != nil {
+		t.Fatal(err)
+	}
+	if want := (BalanceResponse{AccountID: 2, Balance: 10, AsOf: asOf}); rec.Code != http.StatusOK || b != want {
+		t.Fatalf("unexpected balance %v %+v", rec.Code, b)
+	}
+
+	// The current balance is returned without as_of
+	rec = do(http.MethodGet, "/v1/balances?ids=1,2,3", nil)
+	var bs []BalanceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &bs); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(bs) != 2 || bs[0].Balance != -20 || bs[1].Balance != 20 {
+		t.Fatalf("unexpected balances %v %+v", rec.Code, bs)
+	}
+	for _, b := range bs {
+		acc, err := dbClient.NewQuery().GetUser(context.Background(), b.AccountID)
+		if err != nil || acc.Balance != b.Balance {
+			t.Fatalf("expected balance of account %d to be %v, got %v (%v)", b.AccountID, acc.Balance, b.Balance, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/v1/accounts/3/balance", http.StatusNotFound},
+		{"/v1/accounts/x/balance", http.StatusBadRequest},
+		{"/v1/accounts/1/balance?as_of=yesterday", http.StatusBadRequest},
+		{"/v1/balances", http.StatusBadRequest},
+		{"/v1/balances?ids=1,x", http.StatusBadRequest},
+	} {
+		if rec := do(http.MethodGet, tc.path, nil); rec.Code != tc.code {
+			t.Errorf("%v: expected %v, got %v: %s", tc.path, tc.code, rec.Code, rec.Body)
+		}
+	}
+}
+
+func Test_Statement(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	now := time.Date(2024, 10, 31, 12, 0, 0, 0, time.UTC)
+	dbClient.SetClock(func() time.Time { return now })
+	s := newService(DefaultConfig, dbClient, nil)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 100, 1, 2, 3)
+	send := func(from, to, amount int64) {
+		tx := database.CreateTransactionParams{FromAccount: sql.NullInt64{Int64: from}, ToAccount: sql.NullInt64{Int64: to}, Amount: sql.NullInt64{Int64: amount}}
+		if rec := do(http.MethodPut, CreateTxEndPnt, tx); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create transaction: %s", rec.Body)
+		}
+	}
+	// The October transaction sets the opening balance of the November statement
+	send(2, 1, 100)
+	now = time.Date(2024, 11, 5, 0, 0, 0, 0, time.UTC)
+	send(1, 2, 30)
+	now = now.Add(24 * time.Hour)
+	send(3, 1, 5)
+	now = time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	send(1, 3, 1)
+
+	const period = "?from=2024-11-01T00:00:00Z&to=2024-12-01T00:00:00Z"
+	rec := do(http.MethodGet, "/v1/accounts/1/statement"+period, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %v %v", rec.Code, rec.Header())
+	}
+	var got Statement
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid statement JSON: %v: %s", err, rec.Body)
+	}
+	want := Statement{
+		StatementHeader: StatementHeader{
+			AccountID:      1,
+			Username:       "alice",
+			From:           time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC),
+			To:             time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+			OpeningBalance: 100,
+		},
+		Transactions: []StatementLine{
+			{TransactionID: 2, CreatedAt: time.Date(2024, 11, 5, 0, 0, 0, 0, time.UTC), CounterpartyID: 2, CounterpartyUsername: "bob", Amount: -30, Balance: 70},
+			{TransactionID: 3, CreatedAt: time.Date(2024, 11, 6, 0, 0, 0, 0, time.UTC), CounterpartyID: 3, CounterpartyUsername: "carol", Amount: 5, Balance: 75},
+		},
+		StatementTotals: StatementTotals{ClosingBalance: 75, TotalCredits: 5, TotalDebits: 30, TransactionCount: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected statement\nwant %+v\ngot  %+v", want, got)
+	}
+
+	rec = do(http.MethodGet, "/v1/accounts/1/statement"+period+"&format=csv", nil)
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := [][]string{
+		{"date", "transaction_id", "description", "counterparty_id", "counterparty", "debit", "credit", "balance"},
+		{"2024-11-01T00:00:00Z", "", "Opening balance", "", "", "", "", "100"},
+		{"2024-11-05T00:00:00Z", "2", "Transfer", "2", "bob", "30", "", "70"},
+		{"2024-11-06T00:00:00Z", "3", "Transfer", "3", "carol", "", "5", "75"},
+		{"", "", "Closing balance", "", "", "", "", "75"},
+		{"", "", "Totals (2 transactions)", "", "", "30", "5", ""},
+	}
+	if !reflect.DeepEqual(records, wantCSV) {
+		t.Fatalf("unexpected CSV statement\nwant %v\ngot  %v", wantCSV, records)
+	}
+
+	rec = do(http.MethodGet, "/v1/accounts/1/statement"+period+"&format=html", nil)
+	for _, s := range []string{"<!DOCTYPE html>", "alice", "<td>bob (2)</td>", "</html>"} {
+		if !strings.Contains(rec.Body.String(), s) {
+			t.Fatalf("HTML statement missing %q: %s", s, rec.Body)
+		}
+	}
+
+	// An empty statement is still well formed
+	rec = do(http.MethodGet, "/v1/accounts/1/statement?from=2025-01-01T00:00:00Z&to=2025-02-01T00:00:00Z", nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.OpeningBalance != 74 || got.ClosingBalance != 74 || len(got.Transactions) != 0 {
+		t.Fatalf("unexpected empty statement %+v (%v)", got, err)
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/v1/accounts/9/statement", http.StatusNotFound},
+		{"/v1/accounts/1/statement?format=pdf", http.StatusBadRequest},
+		{"/v1/accounts/1/statement?from=2024-12-01T00:00:00Z&to=2024-11-01T00:00:00Z", http.StatusBadRequest},
+	} {
+		if rec := do(http.MethodGet, tc.path, nil); rec.Code != tc.code {
+			t.Errorf("%v: expected %v, got %v: %s", tc.path, tc.code, rec.Code, rec.Body)
+		}
+	}
+}
+
+func Test_Import(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	config := DefaultConfig
+	config.ImportChunkSize = 2
+	s := newService(config, dbClient, nil)
+	s.startWorkers()
+	defer func() { _ = s.stopWorkers(context.Background()) }()
+
+	run := func(query, body string) ImportJob {
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, ImportEndPnt+query, strings.NewReader(body)))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("import not accepted: %v %s", rec.Code, rec.Body)
+		}
+		var job ImportJob
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+		if loc := rec.Header().Get("Location"); loc != "/v1/import/"+job.ID {
+			t.Fatalf("unexpected Location %q", loc)
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			rec := httptest.NewRecorder()
+			s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/import/"+job.ID, nil))
+			if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+				t.Fatal(err)
+			}
+			if job.Status == ImportCompleted || job.Status == ImportFailed {
+				return job
+			}
+		}
+		t.Fatalf("import %v did not finish", job.ID)
+		return job
+	}
+
+	accounts := "username,email\nalice,alice@example.com\nbob,\n,nobody@example.com\ncarol,alice@example.com\nalice,\ndave,dave@example.com,extra\n"
+	job := run("?kind=accounts&format=csv", accounts)
+	wantErrors := []ImportRowError{
+		{Line: 4, Error: "username is required"},
+		{Line: 5, Error: "email already exists"},
+		{Line: 6, Error: "username already exists"},
+		{Line: 7, Error: "expected 2 fields, got 3"},
+	}
+	if job.Status != ImportCompleted || job.Rows != 6 || job.Imported != 2 || job.Failed != 4 || !reflect.DeepEqual(job.Errors, wantErrors) {
+		t.Fatalf("unexpected account import %+v", job)
+	}
+	accs, _ := dbClient.NewQuery().GetUsers(context.Background())
+	// Including the deposit, withdrawal, fee revenue and interest expense system accounts
+	if len(accs) != 6 {
+		t.Fatalf("expected 6 accounts, got %+v", accs)
+	}
+	setOverdraftLimits(t, dbClient, 100, 1, 2)
+
+	txs := `{"from_account": 1, "to_account": 2, "amount": 30}
+{"from_account": 2, "to_account": 1, "amount": "5"}
+
+{"from_account": 1, "to_account": 3, "amount": 1}
+{"from_account": 1, "to_account": 1, "amount": 1}
+not json
+{"from_account": 2, "to_account": 1, "amount": 10}
+`
+	job = run("?kind=transactions&format=jsonl&dry_run=true", txs)
+	if job.Status != ImportCompleted || !job.DryRun || job.Rows != 6 || job.Imported != 3 || job.Failed != 3 {
+		t.Fatalf("unexpected dry run %+v", job)
+	}
+	if got, _ := dbClient.NewQuery().ListTransactions(context.Background(), database.ListTransactionsParams{Limit: 10}); len(got) != 0 {
+		t.Fatalf("dry run created transactions %+v", got)
+	}
+
+	job = run("?kind=transactions&format=jsonl", txs)
+	wantErrors = []ImportRowError{
+		{Line: 4, Error: "account 3: not found"},
+		{Line: 5, Error: "to and from account cannot match"},
+		{Line: 6, Error: "invalid JSON: invalid character 'o' in literal null (expecting 'u')"},
+	}
+	if job.Status != ImportCompleted || job.Imported != 3 || !reflect.DeepEqual(job.Errors, wantErrors) {
+		t.Fatalf("unexpected transaction import %+v", job)
+	}
+	for id, want := range map[int64]int64{1: -15, 2: 15} {
+		if acc, _ := dbClient.NewQuery().GetUser(context.Background(), id); acc.Balance != want {
+			t.Errorf("account %d: expected balance %d, got %d", id, want, acc.Balance)
+		}
+	}
+	if v, err := database.VerifyChain(context.Background(), dbClient.NewQuery()); err != nil || !v.Valid || v.Transactions != 3 {
+		t.Fatalf("imported transactions not chained: %+v %v", v, err)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodPost, "/v1/import?kind=users&format=csv", http.StatusBadRequest},
+		{http.MethodPost, "/v1/import?kind=accounts&format=xml", http.StatusBadRequest},
+		{http.MethodPost, "/v1/import?kind=accounts&format=csv&dry_run=maybe", http.StatusBadRequest},
+		{http.MethodGet, "/v1/import/unknown", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%v: expected %v, got %v: %s", tc.path, tc.code, rec.Code, rec.Body)
+		}
+	}
+}
+
+func Test_Export(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	now := time.Date(2024, 11, 10, 9, 0, 0, 0, time.UTC)
+	dbClient.SetClock(func() time.Time { return now })
+	s := newService(DefaultConfig, dbClient, nil)
+
+	do := func(method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name, Email: sql.NullString{String: name + "@example.com", Valid: true}}, nil); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 100, 1)
+	for i := int64(1); i <= 3; i++ {
+		now = now.Add(time.Hour)
+		tx := database.CreateTransactionParams{FromAccount: sql.NullInt64{Int64: 1}, ToAccount: sql.NullInt64{Int64: 2}, Amount: sql.NullInt64{Int64: i}}
+		if rec := do(http.MethodPut, CreateTxEndPnt, tx, nil); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create transaction: %s", rec.Body)
+		}
+	}
+
+	rec := do(http.MethodGet, "/v1/export/accounts?format=csv&since_id=0", nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("unexpected response %v %v", rec.Code, rec.Header())
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := [][]string{
+		{"id", "username", "account_type", "balance", "overdraft_limit", "email", "status", "status_reason", "created_at", "status_updated_at", "description", "external_reference", "category", "metadata"},
+		{"1", "alice", "user", "-6", "100", "alice@example.com", "active", "", "2024-11-10T09:00:00Z", "", "", "", "", "{}"},
+		{"2", "bob", "user", "6", "0", "bob@example.com", "active", "", "2024-11-10T09:00:00Z", "", "", "", "", "{}"},
+	}
+	if !reflect.DeepEqual(records, wantCSV) {
+		t.Fatalf("unexpected CSV export\nwant %v\ngot  %v", wantCSV, records)
+	}
+
+	// Incremental exports select by ID and creation time
+	rec = do(http.MethodGet, "/v1/export/transactions?since_id=1&since=2024-11-10T12:00:00Z", nil, nil)
+	var txs []ExportTransaction
+	for dec := json.NewDecoder(rec.Body); dec.More(); {
+		var tx ExportTransaction
+		if err := dec.Decode(&tx); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	if len(txs) != 1 || txs[0].ID != 3 || txs[0].Amount != 3 || len(txs[0].Hash) != 64 {
+		t.Fatalf("unexpected incremental export %+v", txs)
+	}
+
+	rec = do(http.MethodGet, "/v1/export/transactions?format=parquet", nil, http.Header{"Accept-Encoding": {"gzip"}})
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("export not gzip encoded: %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, err = parquet.Read[ExportTransaction](bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 3 || txs[0].ID != 1 || txs[2].Amount != 3 || !txs[1].CreatedAt.Equal(time.Date(2024, 11, 10, 11, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected Parquet export %+v", txs)
+	}
+
+	// System accounts created by migration are exported unless since_id is set
+	rec = do(http.MethodGet, "/v1/export/accounts?format=parquet", nil, nil)
+	accs, err := parquet.Read[ExportAccount](bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accs) != 6 || accs[0].ID != database.InterestExpenseAccountID || accs[0].AccountType != database.AccountTypeSystem || accs[5].Email == nil || *accs[5].Email != "bob@example.com" || accs[5].StatusUpdatedAt != nil {
+		t.Fatalf("unexpected Parquet export %+v", accs)
+	}
+
+	// An empty CSV export has a header row
+	rec = do(http.MethodGet, "/v1/export/transactions?format=csv&since_id=3", nil, nil)
+	if got := rec.Body.String(); got != "id,from_account,to_account,amount,created_at,prev_hash,hash,chain_version,description,external_reference,category,metadata\n" {
+		t.Fatalf("unexpected empty export %q", got)
+	}
+
+	for _, path := range []string{"/v1/export/accounts?format=xml", "/v1/export/accounts?since_id=x", "/v1/export/transactions?since=yesterday"} {
+		if rec := do(http.MethodGet, path, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected %v, got %v: %s", path, http.StatusBadRequest, rec.Code, rec.Body)
+		}
+	}
+}
+
+func Test_BatchTx(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	config := DefaultConfig
+	config.MaxBatchSize = 4
+	s := newService(config, dbClient, nil)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 100, 1, 2, 3)
+	transfer := func(from, to, amount int64) database.CreateTransactionParams {
+		return database.CreateTransactionParams{FromAccount: sql.NullInt64{Int64: from, Valid: true}, ToAccount: sql.NullInt64{Int64: to, Valid: true}, Amount: sql.NullInt64{Int64: amount, Valid: true}}
+	}
+	batch := func(req BatchTxRequest, code int) BatchTxResponse {
+		t.Helper()
+		rec := do(http.MethodPost, BatchTxEndPnt, req)
+		if rec.Code != code {
+			t.Fatalf("expected %v, got %v: %s", code, rec.Code, rec.Body)
+		}
+		var resp BatchTxResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	balances := func() []int64 {
+		accs, _ := dbClient.NewQuery().GetUsers(context.Background())
+		var b []int64
+		for _, a := range accs {
+			// Skip the system accounts created by migration
+			if a.ID > 0 {
+				b = append(b, a.Balance)
+			}
+		}
+		return b
+	}
+
+	// Transfers in opposite directions lock the same accounts in the same order
+	resp := batch(BatchTxRequest{Transactions: []database.CreateTransactionParams{transfer(1, 2, 10), transfer(3, 1, 5), transfer(2, 1, 1)}}, http.StatusOK)
+	if resp.Applied != 3 || resp.Failed != 0 || resp.Results[1].Transaction == nil || resp.Results[1].Transaction.Amount.Int64 != 5 {
+		t.Fatalf("unexpected batch response %+v", resp)
+	}
+	if got, want := balances(), []int64{-4, 9, -5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected balances, want %v got %v", want, got)
+	}
+
+	// Nothing is applied if any transfer is invalid or fails
+	resp = batch(BatchTxRequest{Transactions: []database.CreateTransactionParams{transfer(1, 2, 10), transfer(1, 1, 5)}}, http.StatusBadRequest)
+	if resp.Applied != 0 || resp.Failed != 1 || resp.Results[1].Error != "to and from account cannot match" || resp.Results[0].Error != "" {
+		t.Fatalf("unexpected batch response %+v", resp)
+	}
+	resp = batch(BatchTxRequest{Transactions: []database.CreateTransactionParams{transfer(1, 2, 10), transfer(2, 9, 5)}}, http.StatusBadRequest)
+	if resp.Failed != 1 || resp.Results[0].Transaction != nil || resp.Results[1].Error != "account 9: not found" || resp.Error != "transaction 1: account 9: not found, none were applied" {
+		t.Fatalf("unexpected batch response %+v", resp)
+	}
+	if rec := do(http.MethodPut, AccountStatusEndPnt, AccountStatusRequest{ID: 3, Status: database.AccountStatusFrozen, Reason: "review"}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot freeze account: %s", rec.Body)
+	}
+	batch(BatchTxRequest{Transactions: []database.CreateTransactionParams{transfer(1, 2, 10), transfer(2, 3, 5)}}, http.StatusConflict)
+	if got, want := balances(), []int64{-4, 9, -5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rejected batch changed balances %v", got)
+	}
+
+	// Best effort batches apply what they can
+	resp = batch(BatchTxRequest{BestEffort: true, Transactions: []database.CreateTransactionParams{transfer(1, 2, 10), transfer(2, 3, 5), transfer(1, 1, 1), transfer(2, 1, 2)}}, http.StatusOK)
+	if resp.Applied != 2 || resp.Failed != 2 || resp.Results[1].Error != "account 3 is frozen" || resp.Results[3].Transaction == nil {
+		t.Fatalf("unexpected best effort response %+v", resp)
+	}
+	if got, want := balances(), []int64{-12, 17, -5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected balances, want %v got %v", want, got)
+	}
+
+	for _, req := range []BatchTxRequest{{}, {Transactions: make([]database.CreateTransactionParams, 5)}} {
+		if rec := do(http.MethodPost, BatchTxEndPnt, req); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %v, got %v: %s", http.StatusBadRequest, rec.Code, rec.Body)
+		}
+	}
+}
+
+func Test_ScheduledTransfers(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+	sc := newScheduler(database.NewAuditingClient(database.NewChainingClient(dbClient)), time.Hour)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 100, 1)
+	scheduled := func(method, path string, body any, code int) database.ScheduledTransfer {
+		t.Helper()
+		rec := do(method, path, body)
+		if rec.Code != code {
+			t.Fatalf("expected %v, got %v: %s", code, rec.Code, rec.Body)
+		}
+		var st database.ScheduledTransfer
+		if code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return st
+	}
+	runAt := func(now time.Time) int {
+		t.Helper()
+		sc.now = func() time.Time { return now }
+		n, err := sc.runDue(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	at := func(h, m int) time.Time { return time.Date(2024, 1, 1, h, m, 0, 0, time.UTC) }
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	// Hourly from midnight to 3am inclusive
+	st := scheduled(http.MethodPost, ScheduledTransfersEndPnt, ScheduledTransferRequest{FromAccount: 1, ToAccount: 2, Amount: 10, Interval: "1h", StartAt: ptr(at(0, 0)), EndAt: ptr(at(3, 0))}, http.StatusOK)
+	if st.Status != database.ScheduledTransferActive || !st.NextRunAt.Time.Equal(at(0, 0)) || st.IntervalSeconds.Int64 != 3600 {
+		t.Fatalf("unexpected scheduled transfer %+v", st)
+	}
+	if n := runAt(at(0, 30)); n != 1 {
+		t.Fatalf("expected 1 run, got %v", n)
+	}
+	if n := runAt(at(0, 59)); n != 0 {
+		t.Fatalf("expected no runs before the next is due, got %v", n)
+	}
+	// The 2am run is missed and skipped
+	if n := runAt(at(2, 30)); n != 1 {
+		t.Fatalf("expected 1 run, got %v", n)
+	}
+	st = scheduled(http.MethodGet, "/v1/scheduled-transfers/1", nil, http.StatusOK)
+	if !st.NextRunAt.Time.Equal(at(3, 0)) || !st.LastRunAt.Time.Equal(at(1, 0)) {
+		t.Fatalf("unexpected scheduled transfer %+v", st)
+	}
+	if n := runAt(at(3, 0)); n != 1 {
+		t.Fatalf("expected 1 run, got %v", n)
+	}
+	st = scheduled(http.MethodGet, "/v1/scheduled-transfers/1", nil, http.StatusOK)
+	if st.Status != database.ScheduledTransferCompleted || st.NextRunAt.Valid {
+		t.Fatalf("expected the schedule to be completed, got %+v", st)
+	}
+	if acc, _ := dbClient.NewQuery().GetUser(context.Background(), 2); acc.Balance != 30 {
+		t.Fatalf("expected balance 30, got %v", acc.Balance)
+	}
+
+	// Rejected transfers are recorded as failed runs
+	if rec := do(http.MethodPut, AccountStatusEndPnt, AccountStatusRequest{ID: 3, Status: database.AccountStatusFrozen, Reason: "review"}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot freeze account: %s", rec.Body)
+	}
+	st = scheduled(http.MethodPost, ScheduledTransfersEndPnt, ScheduledTransferRequest{FromAccount: 1, ToAccount: 3, Amount: 5, Cron: "0 12 * * *", StartAt: ptr(at(0, 0))}, http.StatusOK)
+	if !st.NextRunAt.Time.Equal(at(12, 0)) {
+		t.Fatalf("unexpected next run %v", st.NextRunAt.Time)
+	}
+	if n := runAt(at(12, 0)); n != 1 {
+		t.Fatalf("expected 1 run, got %v", n)
+	}
+	rec := do(http.MethodGet, "/v1/scheduled-transfers/2/runs", nil)
+	var runs []database.ScheduledTransferRun
+	if err := json.Unmarshal(rec.Body.Bytes(), &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Status != database.ScheduledRunFailed || runs[0].Error.String != "account 3 is frozen" || runs[0].TransactionID.Valid {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+	rec = do(http.MethodGet, "/v1/scheduled-transfers/1/runs?limit=2", nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Status != database.ScheduledRunSucceeded || !runs[1].ScheduledFor.Equal(at(1, 0)) || !runs[1].TransactionID.Valid {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+
+	// Paused transfers are not run and resume from now
+	scheduled(http.MethodPost, "/v1/scheduled-transfers/2/pause", nil, http.StatusOK)
+	if n := runAt(time.Now().AddDate(1, 0, 0)); n != 0 {
+		t.Fatalf("expected paused transfer not to run, got %v runs", n)
+	}
+	st = scheduled(http.MethodPost, "/v1/scheduled-transfers/2/resume", nil, http.StatusOK)
+	if st.Status != database.ScheduledTransferActive || !st.NextRunAt.Time.After(time.Now()) {
+		t.Fatalf("unexpected resumed transfer %+v", st)
+	}
+	scheduled(http.MethodPost, "/v1/scheduled-transfers/2/cancel", nil, http.StatusOK)
+	scheduled(http.MethodPost, "/v1/scheduled-transfers/2/resume", nil, http.StatusConflict)
+	scheduled(http.MethodPost, "/v1/scheduled-transfers/1/pause", nil, http.StatusConflict)
+	scheduled(http.MethodPost, "/v1/scheduled-transfers/9/pause", nil, http.StatusNotFound)
+
+	rec = do(http.MethodGet, "/v1/scheduled-transfers?account_id=3", nil)
+	var sts []database.ScheduledTransfer
+	if err := json.Unmarshal(rec.Body.Bytes(), &sts); err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].ID != 2 || sts[0].Status != database.ScheduledTransferCancelled {
+		t.Fatalf("unexpected scheduled transfers %+v", sts)
+	}
+
+	// Runs are audited as made by the scheduler
+	rec = do(http.MethodGet, "/audit?entity_type=scheduled_transfer&entity_id=1", nil)
+	var entries []database.AuditLog
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[1].Action != database.AuditActionRun || entries[1].Actor != schedulerActor {
+		t.Fatalf("unexpected audit entries %+v", entries)
+	}
+
+	for _, req := range []ScheduledTransferRequest{
+		{FromAccount: 1, ToAccount: 2, Amount: 1},
+		{FromAccount: 1, ToAccount: 2, Amount: 1, Interval: "1h", Cron: "@daily"},
+		{FromAccount: 1, ToAccount: 2, Amount: 1, Interval: "1500ms"},
+		{FromAccount: 1, ToAccount: 2, Amount: 1, Cron: "61 * * * *"},
+		{FromAccount: 1, ToAccount: 1, Amount: 1, Interval: "1h"},
+		{FromAccount: 1, ToAccount: 2, Amount: 0, Interval: "1h"},
+		{FromAccount: 1, ToAccount: 2, Amount: 1, Interval: "1h", StartAt: ptr(at(1, 0)), EndAt: ptr(at(0, 0))},
+		{FromAccount: 1, ToAccount: 2, Amount: 1, Cron: "0 12 * * *", StartAt: ptr(at(0, 0)), EndAt: ptr(at(11, 0))},
+		{FromAccount: 1, ToAccount: 9, Amount: 1, Interval: "1h"},
+	} {
+		if rec := do(http.MethodPost, ScheduledTransfersEndPnt, req); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %v for %+v, got %v: %s", http.StatusBadRequest, req, rec.Code, rec.Body)
+		}
+	}
+}
+
+func Test_OverdraftLimits(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	createSystemAccount(t, dbClient, "mint")
+	for _, acc := range []database.CreateAccountParams{{Username: "alice"}, {Username: "bob"}} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, acc); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	transfer := func(from, to, amount int64) *httptest.ResponseRecorder {
+		return do(http.MethodPut, CreateTxEndPnt, database.CreateTransactionParams{FromAccount: sql.NullInt64{Int64: from}, ToAccount: sql.NullInt64{Int64: to}, Amount: sql.NullInt64{Int64: amount}})
+	}
+	setLimit := func(id int64, limit int64) *httptest.ResponseRecorder {
+		return do(http.MethodPut, fmt.Sprintf("/v1/accounts/%d/overdraft-limit", id), OverdraftLimitRequest{OverdraftLimit: limit})
+	}
+	balances := func() []int64 {
+		accs, _ := dbClient.NewQuery().GetUsers(context.Background())
+		var b []int64
+		for _, a := range accs {
+			// Skip the system accounts created by migration
+			if a.ID > 0 {
+				b = append(b, a.Balance)
+			}
+		}
+		return b
+	}
+
+	// Clients cannot send from system accounts, which may go negative without
+	// limit
+	if rec := transfer(1, 2, 50); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected transfer from a system account to be rejected, got %v: %s", rec.Code, rec.Body)
+	}
+	if _, err := Deposit(context.Background(), dbClient, 2, 50, "wire-1"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := transfer(2, 3, 51); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "account 2 has insufficient funds: balance 50, overdraft limit 0") {
+		t.Fatalf("expected insufficient funds, got %v: %s", rec.Code, rec.Body)
+	}
+	if got, want := balances(), []int64{0, 50, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected balances, want %v got %v", want, got)
+	}
+
+	rec := setLimit(2, 20)
+	var acc database.Account
+	if err := json.Unmarshal(rec.Body.Bytes(), &acc); rec.Code != http.StatusOK || err != nil || acc.OverdraftLimit != 20 {
+		t.Fatalf("cannot set overdraft limit %v: %s", rec.Code, rec.Body)
+	}
+	if rec := transfer(2, 3, 70); rec.Code != http.StatusOK {
+		t.Fatalf("cannot overdraw within the limit: %s", rec.Body)
+	}
+	if rec := transfer(2, 3, 1); rec.Code != http.StatusConflict {
+		t.Fatalf("expected overdraft beyond the limit to be rejected, got %v", rec.Code)
+	}
+	if got, want := balances(), []int64{0, -20, 70}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected balances, want %v got %v", want, got)
+	}
+
+	rec = do(http.MethodGet, AuditEndPnt+"?entity_type=account&entity_id=2", nil)
+	var entries []database.AuditLog
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	var changes int
+	for _, e := range entries {
+		if e.Action == database.AuditActionOverdraftLimitChange {
+			changes++
+		}
+	}
+	if changes != 1 {
+		t.Fatalf("expected one overdraft limit change in %+v", entries)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		id    int64
+		limit int64
+		code  int
+	}{
+		{"negative", 2, -1, http.StatusBadRequest},
+		{"system-account", 1, 10, http.StatusConflict},
+		{"unknown-account", 9, 10, http.StatusBadRequest},
+	} {
+		if rec := setLimit(tc.id, tc.limit); rec.Code != tc.code {
+			t.Errorf("%v: expected %v, got %v: %s", tc.name, tc.code, rec.Code, rec.Body)
+		}
+	}
+	if rec := do(http.MethodPut, "/v1/accounts/x/overdraft-limit", OverdraftLimitRequest{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid ID to be rejected, got %v", rec.Code)
+	}
+}
+
+func Test_SystemAccounts(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: "treasury", AccountType: database.AccountTypeSystem}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected system account creation to be rejected, got %v: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: "alice"}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot create account: %s", rec.Body)
+	}
+	if _, err := Deposit(context.Background(), dbClient, 1, 100, "wire-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	transfer := func(from, to int64) database.CreateTransactionParams {
+		return database.CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: from, Valid: true},
+			ToAccount:   sql.NullInt64{Int64: to, Valid: true},
+			Amount:      sql.NullInt64{Int64: 10, Valid: true},
+		}
+	}
+	for _, id := range []int64{database.DepositsAccountID, database.WithdrawalsAccountID, database.FeeRevenueAccountID, database.InterestExpenseAccountID} {
+		for _, p := range []database.CreateTransactionParams{transfer(id, 1), transfer(1, id)} {
+			if rec := do(http.MethodPut, CreateTxEndPnt, p); rec.Code != http.StatusBadRequest {
+				t.Errorf("%v to %v: expected 400, got %v: %s", p.FromAccount.Int64, p.ToAccount.Int64, rec.Code, rec.Body)
+			}
+			for _, bestEffort := range []bool{false, true} {
+				rec := do(http.MethodPost, BatchTxEndPnt, BatchTxRequest{Transactions: []database.CreateTransactionParams{p}, BestEffort: bestEffort})
+				var resp BatchTxResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Applied != 0 || resp.Results[0].Error != fmt.Sprintf("account %d is a system account", id) {
+					t.Errorf("%v to %v: expected batch to be rejected, got %v: %s", p.FromAccount.Int64, p.ToAccount.Int64, rec.Code, rec.Body)
+				}
+			}
+			if rec := do(http.MethodPost, ScheduledTransfersEndPnt, ScheduledTransferRequest{FromAccount: p.FromAccount.Int64, ToAccount: p.ToAccount.Int64, Amount: 10, Interval: "1h"}); rec.Code != http.StatusBadRequest {
+				t.Errorf("%v to %v: expected scheduled transfer to be rejected, got %v: %s", p.FromAccount.Int64, p.ToAccount.Int64, rec.Code, rec.Body)
+			}
+		}
+	}
+	accs, err := dbClient.NewQuery().GetUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range accs {
+		if want := map[int64]int64{database.DepositsAccountID: -100, 1: 100}[acc.ID]; acc.Balance != want {
+			t.Fatalf("account %v: expected balance %v, got %v", acc.ID, want, acc.Balance)
+		}
+	}
+}
+
+func Test_ExternalTransfers(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	external := func(method, path string, body any, code int) database.ExternalTransfer {
+		t.Helper()
+		rec := do(method, path, body)
+		if rec.Code != code {
+			t.Fatalf("%v %v: expected %v, got %v: %s", method, path, code, rec.Code, rec.Body)
+		}
+		var et database.ExternalTransfer
+		if code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &et); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return et
+	}
+	balance := func(id int64) int64 {
+		acc, err := dbClient.NewQuery().GetUser(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return acc.Balance
+	}
+
+	// A pending deposit is only posted once settled
+	dep := external(http.MethodPost, DepositsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 100, ExternalReference: "wire-1"}, http.StatusOK)
+	if dep.Status != database.ExternalTransferPending || dep.SystemAccountID != database.DepositsAccountID || dep.TransactionID.Valid || balance(1) != 0 {
+		t.Fatalf("unexpected pending deposit %+v", dep)
+	}
+	// Retries return the existing deposit
+	if retry := external(http.MethodPost, DepositsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 100, ExternalReference: "wire-1"}, http.StatusOK); retry.ID != dep.ID {
+		t.Fatalf("expected retry to return deposit %v, got %+v", dep.ID, retry)
+	}
+	external(http.MethodPost, DepositsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 50, ExternalReference: "wire-1"}, http.StatusConflict)
+	dep = external(http.MethodPost, "/v1/deposits/1/settle", nil, http.StatusOK)
+	if dep.Status != database.ExternalTransferSettled || !dep.TransactionID.Valid || balance(1) != 100 || balance(database.DepositsAccountID) != -100 {
+		t.Fatalf("unexpected settled deposit %+v", dep)
+	}
+	external(http.MethodPost, "/v1/deposits/1/settle", nil, http.StatusConflict)
+	external(http.MethodPost, "/v1/deposits/1/fail", ExternalTransferFailRequest{Reason: "returned"}, http.StatusConflict)
+
+	// Deposits from synchronous rails are posted when created
+	dep = external(http.MethodPost, DepositsEndPnt, ExternalTransferRequest{AccountID: 2, Amount: 5, ExternalReference: "card-1", Status: database.ExternalTransferSettled}, http.StatusOK)
+	if !dep.TransactionID.Valid || balance(2) != 5 {
+		t.Fatalf("unexpected settled deposit %+v", dep)
+	}
+	dep = external(http.MethodPost, DepositsEndPnt, ExternalTransferRequest{AccountID: 2, Amount: 5, ExternalReference: "card-2"}, http.StatusOK)
+	dep = external(http.MethodPost, fmt.Sprintf("/v1/deposits/%d/fail", dep.ID), ExternalTransferFailRequest{Reason: "card declined"}, http.StatusOK)
+	if dep.Status != database.ExternalTransferFailed || dep.FailureReason.String != "card declined" || dep.TransactionID.Valid || balance(2) != 5 {
+		t.Fatalf("unexpected failed deposit %+v", dep)
+	}
+
+	// Withdrawals take the funds when created and return them if they fail
+	external(http.MethodPost, WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 101, ExternalReference: "wire-1"}, http.StatusConflict)
+	wd := external(http.MethodPost, WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 60, ExternalReference: "wire-1"}, http.StatusOK)
+	if wd.Kind != database.ExternalTransferWithdrawal || wd.SystemAccountID != database.WithdrawalsAccountID || !wd.TransactionID.Valid || balance(1) != 40 || balance(database.WithdrawalsAccountID) != 60 {
+		t.Fatalf("unexpected pending withdrawal %+v", wd)
+	}
+	wd = external(http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/fail", wd.ID), ExternalTransferFailRequest{Reason: "beneficiary bank rejected"}, http.StatusOK)
+	if !wd.ReversalTransactionID.Valid || balance(1) != 100 || balance(database.WithdrawalsAccountID) != 0 {
+		t.Fatalf("unexpected failed withdrawal %+v", wd)
+	}
+	external(http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/settle", wd.ID), nil, http.StatusConflict)
+	wd = external(http.MethodPost, WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 30, ExternalReference: "wire-2"}, http.StatusOK)
+	wd = external(http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/settle", wd.ID), nil, http.StatusOK)
+	if wd.Status != database.ExternalTransferSettled || wd.ReversalTransactionID.Valid || balance(1) != 70 {
+		t.Fatalf("unexpected settled withdrawal %+v", wd)
+	}
+
+	if got := external(http.MethodGet, "/v1/withdrawals/5", nil, http.StatusOK); got.ExternalReference != "wire-2" {
+		t.Fatalf("unexpected withdrawal %+v", got)
+	}
+	// Deposits and withdrawals share an ID sequence
+	external(http.MethodGet, "/v1/deposits/4", nil, http.StatusNotFound)
+	var list []database.ExternalTransfer
+	for query, want := range map[string]int{"": 3, "?account_id=2": 2, "?status=failed": 1, "?after_id=1&limit=1": 1, "?account_id=1&after_id=1": 0} {
+		rec := do(http.MethodGet, DepositsEndPnt+query, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list) != want {
+			t.Errorf("%v: expected %v deposits, got %v: %s", query, want, len(list), rec.Body)
+		}
+	}
+	if v, err := database.VerifyChain(context.Background(), dbClient.NewQuery()); err != nil || !v.Valid || v.Transactions != 5 {
+		t.Fatalf("external transfers not chained: %+v %v", v, err)
+	}
+	rec := do(http.MethodGet, AuditEndPnt+"?entity_type=external_transfer&entity_id=4", nil)
+	var entries []database.AuditLog
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil || len(entries) != 2 {
+		t.Fatalf("expected withdrawal 4 to be created and failed, got %s", rec.Body)
+	}
+
+	for _, tc := range []struct {
+		name, path string
+		body       any
+		code       int
+	}{
+		{"system-account", DepositsEndPnt, ExternalTransferRequest{AccountID: database.DepositsAccountID, Amount: 1, ExternalReference: "x"}, http.StatusBadRequest},
+		{"unknown-account", DepositsEndPnt, ExternalTransferRequest{AccountID: 9, Amount: 1, ExternalReference: "x"}, http.StatusBadRequest},
+		{"no-reference", DepositsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 1}, http.StatusBadRequest},
+		{"negative-amount", WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: -1, ExternalReference: "x"}, http.StatusBadRequest},
+		{"failed-status", WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 1, ExternalReference: "x", Status: database.ExternalTransferFailed}, http.StatusBadRequest},
+		{"no-reason", "/v1/withdrawals/5/fail", ExternalTransferFailRequest{}, http.StatusBadRequest},
+		{"unknown-withdrawal", "/v1/withdrawals/9/settle", nil, http.StatusNotFound},
+	} {
+		if rec := do(http.MethodPost, tc.path, tc.body); rec.Code != tc.code {
+			t.Errorf("%v: expected %v, got %v: %s", tc.name, tc.code, rec.Code, rec.Body)
+		}
+	}
+
+	// An account cannot be closed while a withdrawal could still be reversed
+	// into it
+	closeAccount := func(code int) {
+		t.Helper()
+		if rec := do(http.MethodPut, AccountStatusEndPnt, AccountStatusRequest{ID: 1, Status: database.AccountStatusClosed, Reason: "customer request"}); rec.Code != code {
+			t.Fatalf("close account: expected %v, got %v: %s", code, rec.Code, rec.Body)
+		}
+	}
+	wd = external(http.MethodPost, WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 70, ExternalReference: "wire-3"}, http.StatusOK)
+	closeAccount(http.StatusConflict)
+	external(http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/fail", wd.ID), ExternalTransferFailRequest{Reason: "beneficiary bank rejected"}, http.StatusOK)
+	wd = external(http.MethodPost, WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 70, ExternalReference: "wire-4"}, http.StatusOK)
+	external(http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/settle", wd.ID), nil, http.StatusOK)
+	closeAccount(http.StatusOK)
+	external(http.MethodPost, DepositsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 1, ExternalReference: "wire-5"}, http.StatusConflict)
+
+	// A frozen account takes no new transfers but a failed withdrawal is
+	// still returned to it
+	wd = external(http.MethodPost, WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 2, Amount: 5, ExternalReference: "card-1"}, http.StatusOK)
+	if rec := do(http.MethodPut, AccountStatusEndPnt, AccountStatusRequest{ID: 2, Status: database.AccountStatusFrozen, Reason: "fraud review"}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot freeze account: %s", rec.Body)
+	}
+	external(http.MethodPost, DepositsEndPnt, ExternalTransferRequest{AccountID: 2, Amount: 1, ExternalReference: "card-3"}, http.StatusConflict)
+	wd = external(http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/fail", wd.ID), ExternalTransferFailRequest{Reason: "card expired"}, http.StatusOK)
+	if !wd.ReversalTransactionID.Valid || balance(2) != 5 {
+		t.Fatalf("unexpected failed withdrawal %+v", wd)
+	}
+}
+
+// lockRecorder records the accounts locked for update within each DB
+// transaction.
+type lockRecorder struct {
+	database.DBClient
+	mu    sync.Mutex
+	locks [][]int64
+}
+
+type lockRecorderQuery struct {
+	database.DBQuery
+	record func(id int64)
+}
+
+func (l *lockRecorder) ExecTx(ctx context.Context, fn func(database.DBQuery) error) error {
+	l.mu.Lock()
+	l.locks = append(l.locks, nil)
+	i := len(l.locks) - 1
+	l.mu.Unlock()
+	return l.DBClient.ExecTx(ctx, func(q database.DBQuery) error {
+		return fn(lockRecorderQuery{DBQuery: q, record: func(id int64) {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.locks[i] = append(l.locks[i], id)
+		}})
+	})
+}
+
+func (q lockRecorderQuery) GetUserForUpdate(ctx context.Context, id int64) (database.Account, error) {
+	q.record(id)
+	return q.DBQuery.GetUserForUpdate(ctx, id)
+}
+
+func Test_ExternalTransferLockOrder(t *testing.T) {
+	dbClient := &lockRecorder{DBClient: database.NewMemoryDBClient()}
+	s := newService(DefaultConfig, dbClient, nil)
+
+	// do is called from several goroutines, so failures are reported with
+	// t.Errorf
+	do := func(method, path string, body any) int64 {
+		b, err := json.Marshal(body)
 		if err != nil {
-			t.Fatalf("%v: %v", tt.name, err)
+			t.Error(err)
+			return 0
 		}
-		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		var resp struct{ ID int64 }
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); rec.Code != http.StatusOK || err != nil {
+			t.Errorf("%v %v: expected 200, got %v: %s", method, path, rec.Code, rec.Body)
+		}
+		return resp.ID
+	}
+	do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: "alice"})
 
-		response, err := http.DefaultClient.Do(req)
+	// Deposits and withdrawals run concurrently with the settling and failing
+	// of others for the same account, so every DB transaction must lock the
+	// accounts in ascending ID order
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := do(http.MethodPost, DepositsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 10, ExternalReference: fmt.Sprint("deposit-", i)})
+			do(http.MethodPost, fmt.Sprintf("/v1/deposits/%d/settle", id), nil)
+			id = do(http.MethodPost, WithdrawalsEndPnt, ExternalTransferRequest{AccountID: 1, Amount: 5, ExternalReference: fmt.Sprint("withdrawal-", i)})
+			do(http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/fail", id), ExternalTransferFailRequest{Reason: "rejected by bank"})
+		}()
+	}
+	wg.Wait()
+
+	dbClient.mu.Lock()
+	defer dbClient.mu.Unlock()
+	for _, locks := range dbClient.locks {
+		// Locking an account again within the transaction does not wait
+		held := map[int64]bool{}
+		for j, id := range locks {
+			if !held[id] && j > 0 && id < slices.Max(locks[:j]) {
+				t.Fatalf("accounts locked out of order: %v", locks)
+			}
+			held[id] = true
+		}
+	}
+	if acc, _ := dbClient.NewQuery().GetUser(context.Background(), 1); acc.Balance != 100 {
+		t.Fatalf("expected balance 100, got %v", acc.Balance)
+	}
+}
+
+func Test_RuleFee(t *testing.T) {
+	upTo := func(n int64) *int64 { return &n }
+	tiers, err := json.Marshal([]FeeTier{{UpTo: upTo(100), FlatFee: 1}, {UpTo: upTo(1000), FlatFee: 2, BasisPoints: 10}, {FlatFee: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		rule   database.FeeRule
+		amount int64
+		want   int64
+	}{
+		{"flat", database.FeeRule{Kind: database.FeeRuleFlat, FlatFee: 25}, 1, 25},
+		{"percentage-rounds-down", database.FeeRule{Kind: database.FeeRulePercentage, BasisPoints: 150}, 33, 0},
+		{"percentage-rounds-half-up", database.FeeRule{Kind: database.FeeRulePercentage, BasisPoints: 50}, 100, 1},
+		{"percentage-min", database.FeeRule{Kind: database.FeeRulePercentage, BasisPoints: 150, MinFee: sql.NullInt64{Int64: 5, Valid: true}}, 100, 5},
+		{"percentage-max", database.FeeRule{Kind: database.FeeRulePercentage, BasisPoints: 150, MaxFee: sql.NullInt64{Int64: 50, Valid: true}}, 10000, 50},
+		{"percentage-no-overflow", database.FeeRule{Kind: database.FeeRulePercentage, BasisPoints: 10000}, 1<<62 + 1, 1<<62 + 1},
+		{"tiered-first", database.FeeRule{Kind: database.FeeRuleTiered, Tiers: tiers}, 100, 1},
+		{"tiered-middle", database.FeeRule{Kind: database.FeeRuleTiered, Tiers: tiers}, 1000, 3},
+		{"tiered-last", database.FeeRule{Kind: database.FeeRuleTiered, Tiers: tiers}, 1001, 10},
+	} {
+		if got, err := ruleFee(tc.rule, tc.amount); err != nil || got != tc.want {
+			t.Errorf("%v: expected fee %v, got %v %v", tc.name, tc.want, got, err)
+		}
+	}
+}
+
+func Test_Fees(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
 		if err != nil {
-			t.Fatalf("%v: %v", tt.name, err)
+			t.Fatal(err)
 		}
-		defer response.Body.Close()
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 20000, 1, 2)
 
-		// Read the response body
-		b, err := io.ReadAll(response.Body)
+	id := func(n int64) *int64 { return &n }
+	for _, req := range []FeeRuleRequest{
+		{Name: "transfer", AccountType: database.AccountTypeUser, Kind: database.FeeRulePercentage, BasisPoints: 150, MinFee: id(5), MaxFee: id(50)},
+		{Name: "alice-to-carol", FromAccount: id(1), ToAccount: id(3), Kind: database.FeeRuleTiered,
+			Tiers: []FeeTier{{UpTo: id(100), FlatFee: 1}, {UpTo: id(1000), FlatFee: 2, BasisPoints: 10}, {FlatFee: 10}}},
+		{Name: "from-bob", FromAccount: id(2), Kind: database.FeeRuleFlat, FlatFee: 3},
+	} {
+		if rec := do(http.MethodPost, FeeRulesEndPnt, req); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create fee rule %v: %s", req.Name, rec.Body)
+		}
+	}
+
+	balance := func(id int64) int64 {
+		acc, err := dbClient.NewQuery().GetUser(context.Background(), id)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if g, w := response.StatusCode, tt.expectedCode; g != w {
-			t.Errorf("%v unexpected response code, want %v got %v", tt.name, w, g)
+		return acc.Balance
+	}
+	send := func(from, to, amount int64, code int) TxResponse {
+		t.Helper()
+		rec := do(http.MethodPut, CreateTxEndPnt, database.CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: from, Valid: true},
+			ToAccount:   sql.NullInt64{Int64: to, Valid: true},
+			Amount:      sql.NullInt64{Int64: amount, Valid: true},
+		})
+		if rec.Code != code {
+			t.Fatalf("send %v from %v to %v: expected %v, got %v: %s", amount, from, to, code, rec.Code, rec.Body)
 		}
-		if tt.expectedResponse != nil {
+		var resp TxResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
 
-			expectedJSON, _ := json.Marshal(tt.expectedResponse)
+	for _, tc := range []struct {
+		from, to, amount int64
+		fees             []FeeCharge
+	}{
+		{1, 2, 100, []FeeCharge{{FeeRuleID: 1, Name: "transfer", Kind: database.FeeRulePercentage, Amount: 5}}},
+		{1, 3, 1000, []FeeCharge{
+			{FeeRuleID: 1, Name: "transfer", Kind: database.FeeRulePercentage, Amount: 15},
+			{FeeRuleID: 2, Name: "alice-to-carol", Kind: database.FeeRuleTiered, Amount: 3},
+		}},
+		{2, 1, 10000, []FeeCharge{
+			{FeeRuleID: 1, Name: "transfer", Kind: database.FeeRulePercentage, Amount: 50},
+			{FeeRuleID: 3, Name: "from-bob", Kind: database.FeeRuleFlat, Amount: 3},
+		}},
+	} {
+		resp := send(tc.from, tc.to, tc.amount, http.StatusOK)
+		var total int64
+		for _, fee := range tc.fees {
+			total += fee.Amount
+		}
+		if !reflect.DeepEqual(resp.Fees, tc.fees) || resp.TotalFee != total || resp.FeeTransaction == nil ||
+			resp.FeeTransaction.FromAccount.Int64 != tc.from || resp.FeeTransaction.ToAccount.Int64 != database.FeeRevenueAccountID ||
+			resp.FeeTransaction.Amount.Int64 != total || resp.FeeTransaction.ID != resp.ID+1 {
+			t.Fatalf("unexpected fees for %v from %v to %v: %+v", tc.amount, tc.from, tc.to, resp)
+		}
+	}
+	if a, b, c, rev := balance(1), balance(2), balance(3), balance(database.FeeRevenueAccountID); a != 8877 || b != -9953 || c != 1000 || rev != 76 {
+		t.Fatalf("unexpected balances %v %v %v, fee revenue %v", a, b, c, rev)
+	}
 
-			if g, w := b, expectedJSON; !bytes.Equal(g, w) {
-				t.Errorf("%v unexpected response, want %s, got %s", tt.name, w, g)
+	// Carol can send her balance but not the fee on top of it
+	send(3, 1, 1000, http.StatusConflict)
+	if c, rev := balance(3), balance(database.FeeRevenueAccountID); c != 1000 || rev != 76 {
+		t.Fatalf("rejected transfer changed balances %v, fee revenue %v", c, rev)
+	}
+	if resp := send(3, 1, 985, http.StatusOK); resp.TotalFee != 15 || balance(3) != 0 {
+		t.Fatalf("unexpected fees %+v", resp)
+	}
+
+	// Clients cannot move the fee revenue
+	send(database.FeeRevenueAccountID, 1, 91, http.StatusBadRequest)
+	if rev := balance(database.FeeRevenueAccountID); rev != 91 {
+		t.Fatalf("rejected transfer changed fee revenue %v", rev)
+	}
+
+	if rec := do(http.MethodPost, "/v1/fee-rules/1/disable", nil); rec.Code != http.StatusOK {
+		t.Fatalf("cannot disable fee rule: %s", rec.Body)
+	}
+	if resp := send(1, 2, 100, http.StatusOK); resp.TotalFee != 0 || resp.Fees == nil || resp.FeeTransaction != nil {
+		t.Fatalf("unexpected fees after rule disabled %+v", resp)
+	}
+
+	var rules []database.FeeRule
+	for query, want := range map[string]int{"": 3, "?status=active": 2, "?status=disabled": 1, "?after_id=1&limit=1": 1} {
+		rec := do(http.MethodGet, FeeRulesEndPnt+query, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &rules); err != nil || len(rules) != want {
+			t.Errorf("%v: expected %v fee rules, got %v: %s", query, want, len(rules), rec.Body)
+		}
+	}
+	rec := do(http.MethodGet, "/v1/fee-rules/2", nil)
+	var rule database.FeeRule
+	if err := json.Unmarshal(rec.Body.Bytes(), &rule); err != nil || rule.Kind != database.FeeRuleTiered || rule.Status != database.FeeRuleActive {
+		t.Fatalf("unexpected fee rule %s", rec.Body)
+	}
+	if v, err := database.VerifyChain(context.Background(), dbClient.NewQuery()); err != nil || !v.Valid || v.Transactions != 9 {
+		t.Fatalf("fee transactions not chained: %+v %v", v, err)
+	}
+	rec = do(http.MethodGet, AuditEndPnt+"?entity_type=fee_rule", nil)
+	var entries []database.AuditLog
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil || len(entries) != 4 || entries[3].Action != database.AuditActionStatusChange {
+		t.Fatalf("expected fee rules to be created and disabled, got %s", rec.Body)
+	}
+
+	for _, tc := range []struct {
+		name, method, path string
+		body               any
+		code               int
+	}{
+		{"no-name", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Kind: database.FeeRuleFlat, FlatFee: 1}, http.StatusBadRequest},
+		{"invalid-kind", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", Kind: "daily"}, http.StatusBadRequest},
+		{"invalid-account-type", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", AccountType: "admin", Kind: database.FeeRuleFlat, FlatFee: 1}, http.StatusBadRequest},
+		{"system-account-type", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", AccountType: database.AccountTypeSystem, Kind: database.FeeRuleFlat, FlatFee: 1}, http.StatusBadRequest},
+		{"flat-with-percentage", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", Kind: database.FeeRuleFlat, FlatFee: 1, BasisPoints: 1}, http.StatusBadRequest},
+		{"over-100-percent", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", Kind: database.FeeRulePercentage, BasisPoints: 10001}, http.StatusBadRequest},
+		{"min-above-max", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", Kind: database.FeeRulePercentage, BasisPoints: 1, MinFee: id(2), MaxFee: id(1)}, http.StatusBadRequest},
+		{"no-tiers", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", Kind: database.FeeRuleTiered}, http.StatusBadRequest},
+		{"unordered-tiers", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", Kind: database.FeeRuleTiered, Tiers: []FeeTier{{UpTo: id(10)}, {UpTo: id(5)}, {}}}, http.StatusBadRequest},
+		{"bounded-last-tier", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", Kind: database.FeeRuleTiered, Tiers: []FeeTier{{UpTo: id(10)}}}, http.StatusBadRequest},
+		{"unknown-account", http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "x", ToAccount: id(9), Kind: database.FeeRuleFlat, FlatFee: 1}, http.StatusBadRequest},
+		{"disabled", http.MethodPost, "/v1/fee-rules/1/disable", nil, http.StatusConflict},
+		{"unknown-rule", http.MethodPost, "/v1/fee-rules/9/disable", nil, http.StatusNotFound},
+		{"get-unknown-rule", http.MethodGet, "/v1/fee-rules/9", nil, http.StatusNotFound},
+		{"invalid-status", http.MethodGet, FeeRulesEndPnt + "?status=paused", nil, http.StatusBadRequest},
+	} {
+		if rec := do(tc.method, tc.path, tc.body); rec.Code != tc.code {
+			t.Errorf("%v: expected %v, got %v: %s", tc.name, tc.code, rec.Code, rec.Body)
+		}
+	}
+}
+
+func Test_FeesOnBatchAndScheduledTransfers(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+	sc := newScheduler(database.NewAuditingClient(database.NewChainingClient(dbClient)), time.Hour)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 1000, 1)
+	if rec := do(http.MethodPost, FeeRulesEndPnt, FeeRuleRequest{Name: "transfer", Kind: database.FeeRuleFlat, FlatFee: 2}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot create fee rule: %s", rec.Body)
+	}
+	balance := func(id int64) int64 {
+		acc, err := dbClient.NewQuery().GetUser(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return acc.Balance
+	}
+	transfer := func(amount int64) database.CreateTransactionParams {
+		return database.CreateTransactionParams{
+			FromAccount: sql.NullInt64{Int64: 1, Valid: true},
+			ToAccount:   sql.NullInt64{Int64: 2, Valid: true},
+			Amount:      sql.NullInt64{Int64: amount, Valid: true},
+		}
+	}
+	wantFees := []FeeCharge{{FeeRuleID: 1, Name: "transfer", Kind: database.FeeRuleFlat, Amount: 2}}
+
+	for _, bestEffort := range []bool{false, true} {
+		rec := do(http.MethodPost, BatchTxEndPnt, BatchTxRequest{Transactions: []database.CreateTransactionParams{transfer(10), transfer(20)}, BestEffort: bestEffort})
+		var resp BatchTxResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK || resp.Applied != 2 {
+			t.Fatalf("best_effort=%v: cannot apply batch: %s", bestEffort, rec.Body)
+		}
+		for _, res := range resp.Results {
+			if tx := res.Transaction; tx == nil || !reflect.DeepEqual(tx.Fees, wantFees) || tx.TotalFee != 2 || tx.FeeTransaction == nil ||
+				tx.FeeTransaction.ToAccount.Int64 != database.FeeRevenueAccountID || tx.FeeTransaction.Amount.Int64 != 2 {
+				t.Fatalf("best_effort=%v: unexpected result %+v", bestEffort, res)
 			}
 		}
+	}
+	if a, b, rev := balance(1), balance(2), balance(database.FeeRevenueAccountID); a != -68 || b != 60 || rev != 8 {
+		t.Fatalf("unexpected balances %v %v, fee revenue %v", a, b, rev)
+	}
+
+	// An all-or-nothing batch is rejected if the sender cannot also pay the fees
+	rec := do(http.MethodPost, BatchTxEndPnt, BatchTxRequest{Transactions: []database.CreateTransactionParams{transfer(500), transfer(432)}})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %v: %s", rec.Code, rec.Body)
+	}
+	if a, rev := balance(1), balance(database.FeeRevenueAccountID); a != -68 || rev != 8 {
+		t.Fatalf("rejected batch changed balances %v, fee revenue %v", a, rev)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if rec := do(http.MethodPost, ScheduledTransfersEndPnt, ScheduledTransferRequest{FromAccount: 1, ToAccount: 2, Amount: 10, Interval: "1h", StartAt: &start}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot schedule transfer: %s", rec.Body)
+	}
+	sc.now = func() time.Time { return start }
+	if n, err := sc.runDue(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected 1 run, got %v: %v", n, err)
+	}
+	rec = do(http.MethodGet, "/v1/scheduled-transfers/1/runs", nil)
+	var runs []database.ScheduledTransferRun
+	if err := json.Unmarshal(rec.Body.Bytes(), &runs); err != nil || len(runs) != 1 {
+		t.Fatalf("unexpected runs %s", rec.Body)
+	}
+	var fees []FeeCharge
+	if err := json.Unmarshal(runs[0].Fees, &fees); err != nil || !reflect.DeepEqual(fees, wantFees) || runs[0].TotalFee != 2 || !runs[0].FeeTransactionID.Valid {
+		t.Fatalf("unexpected run %+v", runs[0])
+	}
+	if a, b, rev := balance(1), balance(2), balance(database.FeeRevenueAccountID); a != -80 || b != 70 || rev != 10 {
+		t.Fatalf("unexpected balances %v %v, fee revenue %v", a, b, rev)
+	}
+	if v, err := database.VerifyChain(context.Background(), dbClient.NewQuery()); err != nil || !v.Valid || v.Transactions != 10 {
+		t.Fatalf("fee transactions not chained: %+v %v", v, err)
+	}
+}
+
+func Test_DailyInterest(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		balance, bps, carry   int64
+		wantAmount, wantCarry int64
+	}{
+		{"exact", 3650000, 100, 0, 100, 0},
+		{"negative-balance", -3650000, 100, 7, 0, 7},
+		{"zero-rate", 3650000, 0, 7, 0, 7},
+		{"rounds-down", 1000, 500, 0, 0, 500000},
+		{"carry-rounds-up", 1000, 500, 1500000, 1, -1650000},
+		{"tie-rounds-to-even", 1825, 1000, 0, 0, 1825000},
+		{"tie-rounds-up-to-even", 5475, 1000, 0, 2, -1825000},
+		{"no-overflow", 1 << 62, 10000, 0, (1<<62)/365 + 1, -360000},
+	} {
+		amount, carry := dailyInterest(tc.balance, tc.bps, tc.carry)
+		if amount != tc.wantAmount || carry != tc.wantCarry {
+			t.Errorf("%v: expected %v carry %v, got %v carry %v", tc.name, tc.wantAmount, tc.wantCarry, amount, carry)
+		}
+	}
+}
+
+func Test_Interest(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	// The rates set on January 29 apply from January 30
+	now := time.Date(2024, 1, 29, 10, 0, 0, 0, time.UTC)
+	dbClient.SetClock(func() time.Time { return now })
+	s := newService(DefaultConfig, dbClient, nil)
+	job := newInterestJob(database.NewAuditingClient(database.NewChainingClient(dbClient)), time.Hour)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: name}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	for id, amount := range map[int64]int64{1: 3650000, 2: 1000} {
+		if _, err := Deposit(context.Background(), dbClient, id, amount, fmt.Sprint("wire-", id)); err != nil {
+			t.Fatalf("cannot fund account %v: %v", id, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		id   int64
+		bps  int64
+		code int
+	}{
+		{"alice", 1, 100, http.StatusOK},
+		{"bob", 2, 500, http.StatusOK},
+		{"too-high", 1, 10001, http.StatusBadRequest},
+		{"negative", 1, -1, http.StatusBadRequest},
+		{"unknown-account", 99, 100, http.StatusBadRequest},
+		{"system-account", database.InterestExpenseAccountID, 100, http.StatusConflict},
+	} {
+		if rec := do(http.MethodPut, fmt.Sprintf("/v1/accounts/%d/interest-rate", tc.id), InterestRateRequest{AnnualRateBps: tc.bps}); rec.Code != tc.code {
+			t.Errorf("%v: expected %v, got %v: %s", tc.name, tc.code, rec.Code, rec.Body)
+		}
+	}
+
+	balance := func(id int64) int64 {
+		acc, err := dbClient.NewQuery().GetUser(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return acc.Balance
+	}
+	interest := func(id int64) InterestResponse {
+		t.Helper()
+		rec := do(http.MethodGet, fmt.Sprintf("/v1/accounts/%d/interest", id), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("cannot get interest of account %v: %s", id, rec.Body)
+		}
+		var resp InterestResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	runAt := func(at time.Time) int {
+		t.Helper()
+		now = at
+		days, err := job.runUntil(context.Background(), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return days
+	}
+
+	if days := runAt(time.Date(2024, 1, 30, 23, 0, 0, 0, time.UTC)); days != 0 {
+		t.Fatalf("expected no days accrued before the end of the day, got %v", days)
+	}
+	// January 30 and 31 are accrued and posted for both accounts
+	if days := runAt(time.Date(2024, 2, 1, 0, 10, 0, 0, time.UTC)); days != 4 {
+		t.Fatalf("expected 4 account days accrued, got %v", days)
+	}
+	if days := runAt(time.Date(2024, 2, 1, 0, 20, 0, 0, time.UTC)); days != 0 {
+		t.Fatalf("expected rerun to accrue nothing, got %v", days)
+	}
+	// Bob's 0.27 accrued in January rounds to nothing and is carried
+	if a, b, exp := balance(1), balance(2), balance(database.InterestExpenseAccountID); a != 3650200 || b != 1000 || exp != -200 {
+		t.Fatalf("unexpected balances after January posting alice %v bob %v interest expense %v", a, b, exp)
+	}
+	if resp := interest(1); resp.AnnualRateBps != 100 || resp.Accrued != 0 || resp.Accruals != 0 || resp.AccruedFrom != nil {
+		t.Fatalf("unexpected interest after posting %+v", resp)
+	}
+
+	runAt(time.Date(2024, 2, 10, 0, 10, 0, 0, time.UTC))
+	feb1, feb9 := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 9, 0, 0, 0, 0, time.UTC)
+	if resp := interest(1); resp.Accrued != 900 || resp.Accruals != 9 || resp.AccruedFrom == nil || !resp.AccruedFrom.Equal(feb1) || !resp.AccruedTo.Equal(feb9) {
+		t.Fatalf("unexpected alice interest %+v", resp)
+	}
+	if resp := interest(2); resp.Accrued != 2 || resp.Accruals != 9 {
+		t.Fatalf("unexpected bob interest %+v", resp)
+	}
+
+	// Interest cannot be posted to a frozen account and is retried
+	if rec := do(http.MethodPut, AccountStatusEndPnt, AccountStatusRequest{ID: 2, Status: database.AccountStatusFrozen, Reason: "review"}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot freeze account: %s", rec.Body)
+	}
+	march := time.Date(2024, 3, 1, 0, 10, 0, 0, time.UTC)
+	runAt(march)
+	if a, b := balance(1), balance(2); a != 3653100 || b != 1000 {
+		t.Fatalf("unexpected balances after February posting alice %v bob %v", a, b)
+	}
+	if resp := interest(2); resp.Accrued != 4 || resp.Accruals != 29 {
+		t.Fatalf("unexpected bob interest %+v", resp)
+	}
+	if rec := do(http.MethodPut, AccountStatusEndPnt, AccountStatusRequest{ID: 2, Status: database.AccountStatusActive, Reason: "cleared"}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot unfreeze account: %s", rec.Body)
+	}
+	if days := runAt(march); days != 0 {
+		t.Fatalf("expected rerun to accrue nothing, got %v", days)
+	}
+	if b, exp := balance(2), balance(database.InterestExpenseAccountID); b != 1004 || exp != -3104 {
+		t.Fatalf("unexpected balances after retry bob %v interest expense %v", b, exp)
+	}
+	if resp := interest(2); resp.Accrued != 0 || resp.Accruals != 0 {
+		t.Fatalf("unexpected bob interest %+v", resp)
+	}
+	if rec := do(http.MethodGet, "/v1/accounts/99/interest", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown account to be not found, got %v", rec.Code)
+	}
+}
+
+func Test_InterestRateChange(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	now := time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC)
+	dbClient.SetClock(func() time.Time { return now })
+	s := newService(DefaultConfig, dbClient, nil)
+	job := newInterestJob(dbClient, time.Hour)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: "alice"}); rec.Code != http.StatusOK {
+		t.Fatalf("cannot create account: %s", rec.Body)
+	}
+	// 100 a day at 1%
+	if _, err := Deposit(context.Background(), dbClient, 1, 3650000, "wire-1"); err != nil {
+		t.Fatal(err)
+	}
+	setRate := func(bps int64) {
+		t.Helper()
+		if rec := do(http.MethodPut, "/v1/accounts/1/interest-rate", InterestRateRequest{AnnualRateBps: bps}); rec.Code != http.StatusOK {
+			t.Fatalf("cannot set interest rate: %s", rec.Body)
+		}
+	}
+	runAt := func(at time.Time) int {
+		t.Helper()
+		now = at
+		days, err := job.runUntil(context.Background(), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return days
+	}
+
+	// A rate set just before midnight does not earn interest for that day
+	setRate(100)
+	if days := runAt(time.Date(2024, 1, 3, 0, 10, 0, 0, time.UTC)); days != 1 {
+		t.Fatalf("expected January 2 to be accrued, got %v days", days)
+	}
+
+	// January 3 and 4 have not been accrued when the rate changes, and are
+	// accrued at the old rate
+	now = time.Date(2024, 1, 5, 23, 59, 0, 0, time.UTC)
+	setRate(200)
+	if days := runAt(time.Date(2024, 1, 6, 0, 10, 0, 0, time.UTC)); days != 1 {
+		t.Fatalf("expected January 5 to be accrued, got %v days", days)
+	}
+	rec := do(http.MethodGet, "/v1/accounts/1/interest", nil)
+	var resp InterestResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.AnnualRateBps != 200 || resp.Accruals != 4 || resp.Accrued != 500 {
+		t.Fatalf("unexpected interest %+v", resp)
+	}
+
+	// An account cannot be closed until its interest is posted
+	withdrawAll := func(ref string) {
+		t.Helper()
+		acc, err := dbClient.NewQuery().GetUser(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := ExternalTransferRequest{AccountID: 1, Amount: acc.Balance, ExternalReference: ref, Status: database.ExternalTransferSettled}
+		if rec := do(http.MethodPost, WithdrawalsEndPnt, req); rec.Code != http.StatusOK {
+			t.Fatalf("cannot withdraw: %s", rec.Body)
+		}
+	}
+	closeAccount := func(code int) {
+		t.Helper()
+		if rec := do(http.MethodPut, AccountStatusEndPnt, AccountStatusRequest{ID: 1, Status: database.AccountStatusClosed, Reason: "customer request"}); rec.Code != code {
+			t.Fatalf("close account: expected %v, got %v: %s", code, rec.Code, rec.Body)
+		}
+	}
+	withdrawAll("wire-1")
+	closeAccount(http.StatusConflict)
+	runAt(time.Date(2024, 2, 1, 0, 10, 0, 0, time.UTC))
+	withdrawAll("wire-2")
+	closeAccount(http.StatusOK)
+
+	// and then stops earning interest
+	rates, err := dbClient.NewQuery().ListInterestRates(context.Background())
+	if err != nil || len(rates) != 0 {
+		t.Fatalf("expected the rate of the closed account to be removed, got %+v (%v)", rates, err)
+	}
+	if days := runAt(time.Date(2024, 3, 1, 0, 10, 0, 0, time.UTC)); days != 0 {
+		t.Fatalf("expected no interest accrued for the closed account, got %v days", days)
+	}
+}
+
+func Test_Metadata(t *testing.T) {
+	dbClient := database.NewMemoryDBClient()
+	s := newService(DefaultConfig, dbClient, nil)
+	s.startWorkers()
+	defer func() { _ = s.stopWorkers(context.Background()) }()
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(b)))
+		return rec
+	}
+	ref := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	accounts := []database.CreateAccountParams{
+		{Username: "mint"},
+		{Username: "alice", Description: ref("Alice's wallet"), ExternalReference: ref("crm-1"), Category: ref("retail"), Metadata: json.RawMessage(`{"tier": "gold", "region": "eu"}`)},
+		{Username: "bob", ExternalReference: ref("crm-2"), Category: ref("business"), Metadata: json.RawMessage(`{"tier": "silver"}`)},
+	}
+	for _, p := range accounts {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, p); rec.Code != http.StatusOK {
+			t.Fatalf("cannot create account: %s", rec.Body)
+		}
+	}
+	setOverdraftLimits(t, dbClient, 1000, 1)
+	if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: "carol", ExternalReference: ref("crm-1")}); rec.Code != http.StatusConflict {
+		t.Fatalf("expected duplicate account reference to conflict, got %v: %s", rec.Code, rec.Body)
+	}
+	for _, m := range []string{`[1, 2]`, `"tier"`, `1`} {
+		if rec := do(http.MethodPut, CreateAccountEndPnt, database.CreateAccountParams{Username: "carol", Metadata: json.RawMessage(m)}); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected metadata %v to be rejected, got %v", m, rec.Code)
+		}
+	}
+
+	listAccounts := func(query string) []string {
+		rec := do(http.MethodGet, AccountsEndPnt+query, nil)
+		var accs []database.Account
+		if err := json.Unmarshal(rec.Body.Bytes(), &accs); rec.Code != http.StatusOK || err != nil {
+			t.Fatalf("cannot list accounts %v: %s", query, rec.Body)
+		}
+		var names []string
+		for _, a := range accs {
+			names = append(names, a.Username)
+		}
+		return names
+	}
+	for query, want := range map[string][]string{
+		"?metadata.tier=gold":                    {"alice"},
+		"?metadata.tier=gold&metadata.region=us": nil,
+		"?category=business":                     {"bob"},
+		"?external_reference=crm-1":              {"alice"},
+		"?metadata.tier=silver&status=frozen":    nil,
+	} {
+		if got := listAccounts(query); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: want %v got %v", query, want, got)
+		}
+	}
+
+	transfer := func(from, to, amount int64, ref sql.NullString, metadata string) *httptest.ResponseRecorder {
+		return do(http.MethodPut, CreateTxEndPnt, database.CreateTransactionParams{
+			FromAccount:       sql.NullInt64{Int64: from, Valid: true},
+			ToAccount:         sql.NullInt64{Int64: to, Valid: true},
+			Amount:            sql.NullInt64{Int64: amount, Valid: true},
+			Description:       sql.NullString{String: "order payment", Valid: true},
+			ExternalReference: ref,
+			Category:          sql.NullString{String: "payment", Valid: true},
+			Metadata:          json.RawMessage(metadata),
+		})
+	}
+	rec := transfer(1, 2, 50, ref("order-123"), `{"order_id": 123, "channel": "web"}`)
+	var resp TxResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("cannot create transaction: %s", rec.Body)
+	}
+	if tx := resp.Transaction; tx.Description.String != "order payment" || tx.ExternalReference.String != "order-123" || tx.Category.String != "payment" || !strings.Contains(string(tx.Metadata), `"order_id"`) {
+		t.Fatalf("unexpected transaction %+v", tx)
+	}
+	// References are unique per sending account
+	if rec := transfer(1, 3, 5, ref("order-123"), `{}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected duplicate reference to conflict, got %v: %s", rec.Code, rec.Body)
+	}
+	if rec := transfer(2, 3, 5, ref("order-123"), `{"order_id": "123"}`); rec.Code != http.StatusOK {
+		t.Fatalf("cannot reuse reference of another account: %s", rec.Body)
+	}
+	if rec := transfer(1, 3, 5, sql.NullString{}, `null`); rec.Code != http.StatusOK {
+		t.Fatalf("cannot create transaction without metadata: %s", rec.Body)
+	}
+	if rec := transfer(1, 3, 5, sql.NullString{}, `[]`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected array metadata to be rejected, got %v", rec.Code)
+	}
 
+	listTxs := func(query string) []int64 {
+		rec := do(http.MethodGet, TransactionsEndPnt+query, nil)
+		var txs []database.Transaction
+		if err := json.Unmarshal(rec.Body.Bytes(), &txs); rec.Code != http.StatusOK || err != nil {
+			t.Fatalf("cannot list transactions %v: %s", query, rec.Body)
+		}
+		var ids []int64
+		for _, tx := range txs {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+	for query, want := range map[string][]int64{
+		"":                         {1, 2, 3},
+		"?metadata.order_id=123":   {1},
+		`?metadata.order_id="123"`: {2},
+		"?metadata.order_id=123&metadata.channel=web": {1},
+		"?external_reference=order-123":               {1, 2},
+		"?account_id=3&category=payment":              {2, 3},
+		"?after_id=1&limit=1":                         {2},
+	} {
+		if got := listTxs(query); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: want %v got %v", query, want, got)
+		}
+	}
+	for _, query := range []string{"?account_id=x", "?limit=0", "?after_id=-1", "?metadata.=1"} {
+		if rec := do(http.MethodGet, TransactionsEndPnt+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected %v, got %v", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+
+	// Imported transactions are checked against references already posted
+	body := `{"from_account": 1, "to_account": 2, "amount": 1, "external_reference": "order-123"}
+{"from_account": 1, "to_account": 2, "amount": 1, "external_reference": "order-124", "metadata": {"order_id": 124}}
+{"from_account": 1, "to_account": 2, "amount": 1, "external_reference": "order-124"}
+`
+	rec = httptest.NewRecorder()
+	s.Server().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, ImportEndPnt+"?kind=transactions&format=jsonl", strings.NewReader(body)))
+	var job ImportJob
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); rec.Code != http.StatusAccepted || err != nil {
+		t.Fatalf("import not accepted: %v %s", rec.Code, rec.Body)
+	}
+	for deadline := time.Now().Add(5 * time.Second); job.Status != ImportCompleted && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rec := do(http.MethodGet, "/v1/import/"+job.ID, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantErrors := []ImportRowError{{Line: 1, Error: "external reference already exists"}, {Line: 3, Error: "external reference already exists"}}
+	if job.Status != ImportCompleted || job.Imported != 1 || !reflect.DeepEqual(job.Errors, wantErrors) {
+		t.Fatalf("unexpected import %+v", job)
+	}
+	if got := listTxs("?metadata.order_id=124"); !reflect.DeepEqual(got, []int64{4}) {
+		t.Fatalf("imported metadata not searchable, got %v", got)
+	}
+
+	// Exports carry the new columns
+	rec = do(http.MethodGet, "/v1/export/transactions?since_id=3", nil)
+	var exported ExportTransaction
+	if err := json.Unmarshal(rec.Body.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.ID != 4 || exported.ExternalReference == nil || *exported.ExternalReference != "order-124" || exported.Description != nil || string(exported.Metadata) != `{"order_id":124}` {
+		t.Fatalf("unexpected export %+v", exported)
 	}
 }
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ATMackay/psql-ledger/database"
+	"github.com/ATMackay/psql-ledger/logging"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Statement formats.
+const (
+	StatementJSON = "json"
+	StatementCSV  = "csv"
+	StatementHTML = "html"
+)
+
+// statementPageSize is the number of transactions read from the DB at a time
+// while streaming a statement.
+const statementPageSize = 500
+
+// StatementHeader opens an account statement. OpeningBalance is the balance
+// before From.
+type StatementHeader struct {
+	AccountID      int64     `json:"account_id"`
+	Username       string    `json:"username"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	OpeningBalance int64     `json:"opening_balance"`
+}
+
+// StatementLine is a transaction on an account statement. Amount is positive
+// for credits and negative for debits and Balance is the running balance after
+// the transaction.
+type StatementLine struct {
+	TransactionID        int64     `json:"transaction_id"`
+	CreatedAt            time.Time `json:"created_at"`
+	CounterpartyID       int64     `json:"counterparty_id"`
+	CounterpartyUsername string    `json:"counterparty_username"`
+	Amount               int64     `json:"amount"`
+	Balance              int64     `json:"balance"`
+}
+
+// StatementTotals closes an account statement.
+type StatementTotals struct {
+	ClosingBalance   int64 `json:"closing_balance"`
+	TotalCredits     int64 `json:"total_credits"`
+	TotalDebits      int64 `json:"total_debits"`
+	TransactionCount int64 `json:"transaction_count"`
+}
+
+// Statement is an account statement as encoded in JSON.
+type Statement struct {
+	StatementHeader
+	Transactions []StatementLine `json:"transactions"`
+	StatementTotals
+}
+
+// statementWriter encodes a statement as it is read from the DB.
+type statementWriter interface {
+	contentType() string
+	header(StatementHeader) error
+	line(StatementLine) error
+	totals(StatementTotals) error
+}
+
+// AccountStatement streams the statement of the account :id for transactions
+// created in [?from=, ?to=) (RFC 3339), defaulting to the current UTC month up
+// to now, as ?format=json|csv|html. Transactions are read a page at a time so
+// that the period is never held in memory.
+func AccountStatement(dbClient database.DBClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("id"), 10, 64)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid account id"))
+			return
+		}
+		from, to, format, err := statementParams(r.URL.Query(), time.Now().UTC())
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		q := dbClient.NewQuery()
+		acc, err := q.GetUser(r.Context(), id)
+		if err != nil {
+			if isNotFound(err) {
+				RespondWithError(w, http.StatusNotFound, database.ErrNotFound)
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		// Timestamps are stored with microsecond precision
+		opening, err := database.BalanceAsOf(r.Context(), q, id, from.Add(-time.Microsecond))
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		var sw statementWriter
+		switch format {
+		case StatementCSV:
+			sw = &csvStatement{w: csv.NewWriter(w)}
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=statement-%d-%s.csv", id, from.Format(time.DateOnly)))
+		case StatementHTML:
+			sw = &htmlStatement{w: w}
+		default:
+			sw = &jsonStatement{w: w}
+		}
+		w.Header().Set("Content-Type", sw.contentType())
+		w.WriteHeader(http.StatusOK)
+
+		// The status has been sent, so failures can only be logged and the
+		// response cut short
+		h := StatementHeader{AccountID: id, Username: acc.Username, From: from, To: to, OpeningBalance: opening}
+		if err := streamStatement(r.Context(), q, sw, h, http.NewResponseController(w)); err != nil {
+			slog.Error("cannot stream statement", "account_id", id, "request_id", logging.RequestID(r.Context()), "error", err)
+		}
+	}
+}
+
+// streamStatement writes the statement opened by h to sw, flushing the response
+// after each page of transactions.
+func streamStatement(ctx context.Context, q database.DBQuery, sw statementWriter, h StatementHeader, rc *http.ResponseController) error {
+	if err := sw.header(h); err != nil {
+		return err
+	}
+	t := StatementTotals{ClosingBalance: h.OpeningBalance}
+	var afterID int64
+	for {
+		rows, err := q.ListAccountTransactions(ctx, database.ListAccountTransactionsParams{
+			AccountID:  sql.NullInt64{Int64: h.AccountID, Valid: true},
+			FromTime:   h.From,
+			ToTime:     h.To,
+			AfterID:    afterID,
+			MaxResults: statementPageSize,
+		})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			l := StatementLine{TransactionID: row.TransactionID, CreatedAt: row.TransactionCreatedAt.Time, Amount: row.Amount.Int64}
+			if row.FromAccountID.Int64 == h.AccountID {
+				l.CounterpartyID, l.CounterpartyUsername = row.ToAccountID.Int64, row.ToUsername
+				l.Amount = -l.Amount
+				t.TotalDebits += row.Amount.Int64
+			} else {
+				l.CounterpartyID, l.CounterpartyUsername = row.FromAccountID.Int64, row.FromUsername
+				t.TotalCredits += row.Amount.Int64
+			}
+			t.ClosingBalance += l.Amount
+			t.TransactionCount++
+			l.Balance = t.ClosingBalance
+			if err := sw.line(l); err != nil {
+				return err
+			}
+			afterID = row.TransactionID
+		}
+		if len(rows) < statementPageSize {
+			break
+		}
+		// Flushing is best effort, not all writers support it
+		_ = rc.Flush()
+	}
+	return sw.totals(t)
+}
+
+func statementParams(v url.Values, now time.Time) (from, to time.Time, format string, err error) {
+	to = now
+	from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if s := v.Get(t.name); s != "" {
+			if *t.dst, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				return from, to, "", fmt.Errorf("invalid %v '%v', must be an RFC 3339 timestamp", t.name, s)
+			}
+		}
+	}
+	if !from.Before(to) {
+		return from, to, "", fmt.Errorf("from must be before to")
+	}
+	switch format = v.Get("format"); format {
+	case "":
+		format = StatementJSON
+	case StatementJSON, StatementCSV, StatementHTML:
+	default:
+		return from, to, "", fmt.Errorf("unsupported format '%v', must be one of %v|%v|%v", format, StatementJSON, StatementCSV, StatementHTML)
+	}
+	return from, to, format, nil
+}
+
+// jsonStatement writes a Statement object one transaction at a time.
+type jsonStatement struct {
+	w     io.Writer
+	lines int
+}
+
+func (s *jsonStatement) contentType() string { return "application/json" }
+
+func (s *jsonStatement) header(h StatementHeader) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	// Leave the object open for the transactions
+	b = append(bytes.TrimSuffix(b, []byte("}")), []byte(`,"transactions":[`)...)
+	_, err = s.w.Write(b)
+	return err
+}
+
+func (s *jsonStatement) line(l StatementLine) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if s.lines > 0 {
+		b = append([]byte(","), b...)
+	}
+	s.lines++
+	_, err = s.w.Write(b)
+	return err
+}
+
+func (s *jsonStatement) totals(t StatementTotals) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	b = append([]byte("],"), bytes.TrimPrefix(b, []byte("{"))...)
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// csvStatement writes one record per transaction between opening and closing
+// balance records, followed by the totals.
+type csvStatement struct {
+	w *csv.Writer
+}
+
+func (s *csvStatement) contentType() string { return "text/csv" }
+
+func (s *csvStatement) header(h StatementHeader) error {
+	_ = s.w.Write([]string{"date", "transaction_id", "description", "counterparty_id", "counterparty", "debit", "credit", "balance"})
+	return s.write([]string{fmtTime(h.From), "", "Opening balance", "", "", "", "", strconv.FormatInt(h.OpeningBalance, 10)})
+}
+
+func (s *csvStatement) line(l StatementLine) error {
+	debit, credit := "", strconv.FormatInt(l.Amount, 10)
+	if l.Amount < 0 {
+		debit, credit = strconv.FormatInt(-l.Amount, 10), ""
+	}
+	return s.write([]string{
+		fmtTime(l.CreatedAt),
+		strconv.FormatInt(l.TransactionID, 10),
+		"Transfer",
+		strconv.FormatInt(l.CounterpartyID, 10),
+		l.CounterpartyUsername,
+		debit,
+		credit,
+		strconv.FormatInt(l.Balance, 10),
+	})
+}
+
+func (s *csvStatement) totals(t StatementTotals) error {
+	_ = s.w.Write([]string{"", "", "Closing balance", "", "", "", "", strconv.FormatInt(t.ClosingBalance, 10)})
+	return s.write([]string{"", "", fmt.Sprintf("Totals (%d transactions)", t.TransactionCount), "", "", strconv.FormatInt(t.TotalDebits, 10), strconv.FormatInt(t.TotalCredits, 10), ""})
+}
+
+func (s *csvStatement) write(record []string) error {
+	if err := s.w.Write(record); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func fmtTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// htmlStatement writes a self-contained HTML document styled for printing to
+// PDF.
+type htmlStatement struct {
+	w io.Writer
+}
+
+func (s *htmlStatement) contentType() string { return "text/html; charset=utf-8" }
+
+func (s *htmlStatement) header(h StatementHeader) error {
+	return statementTemplates.ExecuteTemplate(s.w, "header", h)
+}
+
+func (s *htmlStatement) line(l StatementLine) error {
+	return statementTemplates.ExecuteTemplate(s.w, "line", l)
+}
+
+func (s *htmlStatement) totals(t StatementTotals) error {
+	return statementTemplates.ExecuteTemplate(s.w, "totals", t)
+}
+
+var statementTemplates = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05") },
+	"neg":  func(n int64) int64 { return -n },
+}).Parse(`
+{{- define "header" -}}
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Statement for account {{.AccountID}}</title>
+<style>
+@page { size: A4; margin: 20mm; }
+body { font-family: sans-serif; font-size: 10pt; }
+table { width: 100%; border-collapse: collapse; }
+th, td { padding: 4px 6px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num, th.num { text-align: right; font-variant-numeric: tabular-nums; }
+thead { display: table-header-group; }
+tr { page-break-inside: avoid; }
+tfoot td { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Account statement</h1>
+<p>Account {{.AccountID}} ({{.Username}})<br>{{date .From}} to {{date .To}} UTC</p>
+<table>
+<thead><tr><th>Date</th><th>Transaction</th><th>Counterparty</th><th class="num">Debit</th><th class="num">Credit</th><th class="num">Balance</th></tr></thead>
+<tbody>
+<tr><td>{{date .From}}</td><td></td><td>Opening balance</td><td></td><td></td><td class="num">{{.OpeningBalance}}</td></tr>
+{{end}}
+{{- define "line" -}}
+<tr><td>{{date .CreatedAt}}</td><td>{{.TransactionID}}</td><td>{{.CounterpartyUsername}} ({{.CounterpartyID}})</td>
+{{- if lt .Amount 0}}<td class="num">{{neg .Amount}}</td><td></td>{{else}}<td></td><td class="num">{{.Amount}}</td>{{end -}}
+<td class="num">{{.Balance}}</td></tr>
+{{end}}
+{{- define "totals" -}}
+</tbody>
+<tfoot>
+<tr><td></td><td></td><td>Closing balance</td><td></td><td></td><td class="num">{{.ClosingBalance}}</td></tr>
+<tr><td></td><td></td><td>Totals ({{.TransactionCount}} transactions)</td><td class="num">{{.TotalDebits}}</td><td class="num">{{.TotalCredits}}</td><td></td></tr>
+</tfoot>
+</table>
+</body>
+</html>
+{{end}}`))
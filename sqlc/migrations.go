@@ -0,0 +1,21 @@
+// Package sqlc holds the SQL schema and queries used to generate the database
+// package. The schema migrations are embedded so that the service binary does
+// not depend on files being present at runtime.
+package sqlc
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrations returns the embedded schema migrations.
+func Migrations() fs.FS {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		panic(err) // the directory is embedded at compile time
+	}
+	return sub
+}
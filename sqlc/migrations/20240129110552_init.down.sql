@@ -1,2 +1,2 @@
-DROP TABLE IF EXISTS accounts
-DROP TABLE IF EXISTS transactions
\ No newline at end of file
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS accounts;
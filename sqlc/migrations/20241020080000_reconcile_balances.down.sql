@@ -0,0 +1 @@
+-- Reconciled balances are kept
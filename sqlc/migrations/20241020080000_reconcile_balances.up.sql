@@ -0,0 +1,6 @@
+-- Transfers move account balances from this version on. Balances of existing
+-- accounts, which were not updated by earlier transfers, are recomputed from
+-- their transactions.
+UPDATE "accounts" a SET "balance" =
+  COALESCE((SELECT SUM("amount") FROM "transactions" WHERE "to_account" = a."id"), 0) -
+  COALESCE((SELECT SUM("amount") FROM "transactions" WHERE "from_account" = a."id"), 0);
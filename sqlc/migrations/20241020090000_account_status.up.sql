@@ -0,0 +1,9 @@
+ALTER TABLE "accounts" ADD COLUMN "status" varchar NOT NULL DEFAULT 'active';
+
+ALTER TABLE "accounts" ADD COLUMN "status_reason" varchar;
+
+ALTER TABLE "accounts" ADD COLUMN "status_updated_at" timestamptz;
+
+ALTER TABLE "accounts" ADD CONSTRAINT "accounts_status_check" CHECK ("status" IN ('active', 'frozen', 'closed'));
+
+CREATE INDEX ON "accounts" ("status");
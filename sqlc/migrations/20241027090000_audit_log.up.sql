@@ -0,0 +1,30 @@
+CREATE TABLE "audit_log" (
+  "id" bigserial PRIMARY KEY,
+  "entity_type" varchar NOT NULL,
+  "entity_id" bigint NOT NULL,
+  "action" varchar NOT NULL,
+  "actor" varchar NOT NULL,
+  "request_id" varchar NOT NULL DEFAULT '',
+  "before" jsonb NOT NULL DEFAULT 'null',
+  "after" jsonb NOT NULL DEFAULT 'null',
+  "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX ON "audit_log" ("entity_type", "entity_id");
+
+CREATE INDEX ON "audit_log" ("created_at");
+
+-- The audit log is append-only
+CREATE FUNCTION "audit_log_append_only"() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append-only: % not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "audit_log_no_update_delete"
+BEFORE UPDATE OR DELETE ON "audit_log"
+FOR EACH ROW EXECUTE FUNCTION "audit_log_append_only"();
+
+CREATE TRIGGER "audit_log_no_truncate"
+BEFORE TRUNCATE ON "audit_log"
+FOR EACH STATEMENT EXECUTE FUNCTION "audit_log_append_only"();
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "ledger_chain";
+
+ALTER TABLE "transactions" DROP COLUMN IF EXISTS "hash";
+
+ALTER TABLE "transactions" DROP COLUMN IF EXISTS "prev_hash";
@@ -0,0 +1,30 @@
+ALTER TABLE "transactions" ADD COLUMN "prev_hash" bytea;
+
+ALTER TABLE "transactions" ADD COLUMN "hash" bytea;
+
+-- Head of the hash chain of each ledger. The row is locked while a
+-- transaction is appended so that the chain follows transaction ID order.
+CREATE TABLE "ledger_chain" (
+  "id" bigint PRIMARY KEY,
+  "last_tx_id" bigint NOT NULL DEFAULT 0,
+  "hash" bytea NOT NULL,
+  "updated_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+-- Chain existing transactions. The canonical encoding must match
+-- database.CanonicalTxBytes.
+DO $$
+DECLARE
+  r record;
+  prev bytea := decode(repeat('00', 32), 'hex');
+  h bytea;
+BEGIN
+  FOR r IN SELECT * FROM "transactions" ORDER BY "id" LOOP
+    h := sha256(prev || convert_to(format('%s|%s|%s|%s|%s', r.id, r.from_account, r.to_account, r.amount,
+      to_char(r.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')), 'UTF8'));
+    UPDATE "transactions" SET "prev_hash" = prev, "hash" = h WHERE "id" = r.id;
+    prev := h;
+  END LOOP;
+  INSERT INTO "ledger_chain" ("id", "last_tx_id", "hash")
+  VALUES (1, COALESCE((SELECT max("id") FROM "transactions"), 0), prev);
+END $$;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "transactions_created_at_idx";
+
+DROP TABLE IF EXISTS "balance_checkpoints";
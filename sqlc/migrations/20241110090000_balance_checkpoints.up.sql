@@ -0,0 +1,14 @@
+-- Closing balance of each account at the end of each UTC day. Historical
+-- balances are computed from the latest checkpoint before the requested time
+-- plus the transactions since.
+CREATE TABLE "balance_checkpoints" (
+  "account_id" bigint NOT NULL REFERENCES "accounts" ("id") ON DELETE CASCADE,
+  "day" date NOT NULL,
+  "balance" bigint NOT NULL,
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  PRIMARY KEY ("account_id", "day")
+);
+
+CREATE INDEX ON "balance_checkpoints" ("day");
+
+CREATE INDEX ON "transactions" ("created_at");
@@ -0,0 +1,37 @@
+-- Standing orders. A transfer repeats on a cron expression or a fixed
+-- interval from start_at until end_at, if set. next_run_at is null once the
+-- schedule has finished or been cancelled.
+CREATE TABLE "scheduled_transfers" (
+  "id" bigserial PRIMARY KEY,
+  "from_account" bigint NOT NULL REFERENCES "accounts" ("id"),
+  "to_account" bigint NOT NULL REFERENCES "accounts" ("id"),
+  "amount" bigint NOT NULL CHECK ("amount" > 0),
+  "cron_expr" varchar,
+  "interval_seconds" bigint CHECK ("interval_seconds" > 0),
+  "start_at" timestamptz NOT NULL,
+  "end_at" timestamptz,
+  "next_run_at" timestamptz,
+  "last_run_at" timestamptz,
+  "status" varchar NOT NULL DEFAULT 'active' CHECK ("status" IN ('active', 'paused', 'cancelled', 'completed')),
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  "updated_at" timestamptz NOT NULL DEFAULT (now()),
+  CHECK (("cron_expr" IS NULL) <> ("interval_seconds" IS NULL))
+);
+
+CREATE INDEX ON "scheduled_transfers" ("next_run_at") WHERE "status" = 'active';
+
+CREATE INDEX ON "scheduled_transfers" ("from_account");
+
+CREATE INDEX ON "scheduled_transfers" ("to_account");
+
+-- The outcome of each run of a scheduled transfer
+CREATE TABLE "scheduled_transfer_runs" (
+  "id" bigserial PRIMARY KEY,
+  "scheduled_transfer_id" bigint NOT NULL REFERENCES "scheduled_transfers" ("id") ON DELETE CASCADE,
+  "scheduled_for" timestamptz NOT NULL,
+  "transaction_id" bigint REFERENCES "transactions" ("id"),
+  "status" varchar NOT NULL CHECK ("status" IN ('succeeded', 'failed')),
+  "error" varchar,
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  UNIQUE ("scheduled_transfer_id", "scheduled_for")
+);
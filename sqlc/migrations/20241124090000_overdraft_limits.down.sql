@@ -0,0 +1,3 @@
+ALTER TABLE "accounts" DROP COLUMN IF EXISTS "overdraft_limit";
+
+ALTER TABLE "accounts" DROP COLUMN IF EXISTS "account_type";
@@ -0,0 +1,10 @@
+-- Accounts may not go below minus their overdraft limit. System accounts, such
+-- as a treasury issuing funds, may have any negative balance. Existing
+-- accounts already below their limit can still receive funds.
+ALTER TABLE "accounts" ADD COLUMN "account_type" varchar NOT NULL DEFAULT 'user';
+
+ALTER TABLE "accounts" ADD COLUMN "overdraft_limit" bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE "accounts" ADD CONSTRAINT "accounts_account_type_check" CHECK ("account_type" IN ('user', 'system'));
+
+ALTER TABLE "accounts" ADD CONSTRAINT "accounts_overdraft_limit_check" CHECK ("overdraft_limit" >= 0);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS "external_transfers";
+
+-- System accounts referenced by transactions are kept with their balances
+DELETE FROM "accounts" WHERE "id" IN (-1, -2)
+  AND NOT EXISTS (SELECT 1 FROM "transactions" WHERE "from_account" = "accounts"."id" OR "to_account" = "accounts"."id")
+  AND NOT EXISTS (SELECT 1 FROM "scheduled_transfers" WHERE "from_account" = "accounts"."id" OR "to_account" = "accounts"."id");
@@ -0,0 +1,29 @@
+-- System accounts through which funds enter and leave the ledger. They have
+-- reserved negative IDs so that they do not take IDs from the accounts
+-- sequence, and usernames that cannot be chosen through the API. The accounts
+-- are kept by the down migration once transactions reference them.
+INSERT INTO "accounts" ("id", "username", "balance", "account_type") VALUES
+  (-1, 'external_deposits', 0, 'system'),
+  (-2, 'external_withdrawals', 0, 'system')
+ON CONFLICT ("id") DO UPDATE SET "account_type" = 'system';
+
+-- Deposits and withdrawals made over external payment rails. A deposit is
+-- posted once settled. A withdrawal is posted when it is created, so that the
+-- funds cannot be spent while it is pending, and reversed if it fails.
+CREATE TABLE "external_transfers" (
+  "id" bigserial PRIMARY KEY,
+  "kind" varchar NOT NULL CHECK ("kind" IN ('deposit', 'withdrawal')),
+  "account_id" bigint NOT NULL REFERENCES "accounts" ("id"),
+  "system_account_id" bigint NOT NULL REFERENCES "accounts" ("id"),
+  "amount" bigint NOT NULL CHECK ("amount" > 0),
+  "external_reference" varchar NOT NULL,
+  "status" varchar NOT NULL DEFAULT 'pending' CHECK ("status" IN ('pending', 'settled', 'failed')),
+  "failure_reason" varchar,
+  "transaction_id" bigint REFERENCES "transactions" ("id"),
+  "reversal_transaction_id" bigint REFERENCES "transactions" ("id"),
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  "updated_at" timestamptz NOT NULL DEFAULT (now()),
+  UNIQUE ("kind", "external_reference")
+);
+
+CREATE INDEX ON "external_transfers" ("account_id");
@@ -0,0 +1,13 @@
+ALTER TABLE "scheduled_transfer_runs"
+  DROP COLUMN IF EXISTS "fee_transaction_id",
+  DROP COLUMN IF EXISTS "total_fee",
+  DROP COLUMN IF EXISTS "fees";
+
+DROP TABLE IF EXISTS "transaction_fees";
+
+DROP TABLE IF EXISTS "fee_rules";
+
+-- The system account is kept with its balance if transactions reference it
+DELETE FROM "accounts" WHERE "id" = -3
+  AND NOT EXISTS (SELECT 1 FROM "transactions" WHERE "from_account" = "accounts"."id" OR "to_account" = "accounts"."id")
+  AND NOT EXISTS (SELECT 1 FROM "scheduled_transfers" WHERE "from_account" = "accounts"."id" OR "to_account" = "accounts"."id");
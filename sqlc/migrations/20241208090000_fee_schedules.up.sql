@@ -0,0 +1,47 @@
+-- System account to which transaction fees are paid
+INSERT INTO "accounts" ("id", "username", "balance", "account_type") VALUES
+  (-3, 'fee_revenue', 0, 'system')
+ON CONFLICT ("id") DO UPDATE SET "account_type" = 'system';
+
+-- Fees charged to the sender of a transaction. A rule applies to transactions
+-- from accounts of account_type and along the route from_account to
+-- to_account; unset keys match any value. The fee is a flat amount, a
+-- percentage of the amount in basis points, or the flat amount and percentage
+-- of the tier the amount falls in, clamped to min_fee and max_fee. Rules are
+-- disabled rather than changed so that charged fees keep their rule.
+CREATE TABLE "fee_rules" (
+  "id" bigserial PRIMARY KEY,
+  "name" varchar NOT NULL,
+  "account_type" varchar CHECK ("account_type" IN ('user', 'system')),
+  "from_account" bigint REFERENCES "accounts" ("id"),
+  "to_account" bigint REFERENCES "accounts" ("id"),
+  "kind" varchar NOT NULL CHECK ("kind" IN ('flat', 'percentage', 'tiered')),
+  "flat_fee" bigint NOT NULL DEFAULT 0 CHECK ("flat_fee" >= 0),
+  "basis_points" bigint NOT NULL DEFAULT 0 CHECK ("basis_points" BETWEEN 0 AND 10000),
+  "min_fee" bigint CHECK ("min_fee" >= 0),
+  "max_fee" bigint CHECK ("max_fee" >= 0),
+  "tiers" jsonb NOT NULL DEFAULT '[]',
+  "status" varchar NOT NULL DEFAULT 'active' CHECK ("status" IN ('active', 'disabled')),
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  "updated_at" timestamptz NOT NULL DEFAULT (now()),
+  CHECK ("min_fee" IS NULL OR "max_fee" IS NULL OR "min_fee" <= "max_fee")
+);
+
+-- The fees charged for a transaction by each rule. The total is posted to the
+-- fee revenue account as a single fee transaction.
+CREATE TABLE "transaction_fees" (
+  "transaction_id" bigint NOT NULL REFERENCES "transactions" ("id"),
+  "fee_rule_id" bigint NOT NULL REFERENCES "fee_rules" ("id"),
+  "fee_transaction_id" bigint NOT NULL REFERENCES "transactions" ("id"),
+  "amount" bigint NOT NULL CHECK ("amount" > 0),
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  PRIMARY KEY ("transaction_id", "fee_rule_id")
+);
+
+CREATE INDEX ON "transaction_fees" ("fee_transaction_id");
+
+-- The fees charged for each run of a scheduled transfer
+ALTER TABLE "scheduled_transfer_runs"
+  ADD COLUMN "fee_transaction_id" bigint REFERENCES "transactions" ("id"),
+  ADD COLUMN "total_fee" bigint NOT NULL DEFAULT 0,
+  ADD COLUMN "fees" jsonb NOT NULL DEFAULT '[]';
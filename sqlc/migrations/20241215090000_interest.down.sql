@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS "interest_accruals";
+
+DROP TABLE IF EXISTS "interest_rates";
+
+-- The system account is kept with its balance if transactions reference it
+DELETE FROM "accounts" WHERE "id" = -4
+  AND NOT EXISTS (SELECT 1 FROM "transactions" WHERE "from_account" = "accounts"."id" OR "to_account" = "accounts"."id")
+  AND NOT EXISTS (SELECT 1 FROM "scheduled_transfers" WHERE "from_account" = "accounts"."id" OR "to_account" = "accounts"."id");
@@ -0,0 +1,33 @@
+-- System account from which interest is paid
+INSERT INTO "accounts" ("id", "username", "balance", "account_type") VALUES
+  (-4, 'interest_expense', 0, 'system')
+ON CONFLICT ("id") DO UPDATE SET "account_type" = 'system';
+
+-- Annual interest rate of an account in basis points. Interest accrues daily
+-- from the day after the rate is first set.
+CREATE TABLE "interest_rates" (
+  "account_id" bigint PRIMARY KEY REFERENCES "accounts" ("id") ON DELETE CASCADE,
+  "annual_rate_bps" bigint NOT NULL CHECK ("annual_rate_bps" BETWEEN 0 AND 10000),
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  "updated_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+-- Interest accrued on the closing balance of an account for each UTC day,
+-- rounded to whole minor units. carry is the rounding remainder brought
+-- forward to the next day, in units of 1/3650000 of a minor unit. Accruals
+-- are posted monthly and are then marked with posted_at and the transaction
+-- paying them, if any.
+CREATE TABLE "interest_accruals" (
+  "account_id" bigint NOT NULL REFERENCES "accounts" ("id") ON DELETE CASCADE,
+  "day" date NOT NULL,
+  "balance" bigint NOT NULL,
+  "annual_rate_bps" bigint NOT NULL,
+  "amount" bigint NOT NULL CHECK ("amount" >= 0),
+  "carry" bigint NOT NULL,
+  "posted_at" timestamptz,
+  "transaction_id" bigint REFERENCES "transactions" ("id"),
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  PRIMARY KEY ("account_id", "day")
+);
+
+CREATE INDEX ON "interest_accruals" ("account_id", "day") WHERE "posted_at" IS NULL;
@@ -0,0 +1,17 @@
+ALTER TABLE "transactions" DROP COLUMN IF EXISTS "chain_version";
+
+ALTER TABLE "transactions" DROP COLUMN IF EXISTS "metadata";
+
+ALTER TABLE "transactions" DROP COLUMN IF EXISTS "category";
+
+ALTER TABLE "transactions" DROP COLUMN IF EXISTS "external_reference";
+
+ALTER TABLE "transactions" DROP COLUMN IF EXISTS "description";
+
+ALTER TABLE "accounts" DROP COLUMN IF EXISTS "metadata";
+
+ALTER TABLE "accounts" DROP COLUMN IF EXISTS "category";
+
+ALTER TABLE "accounts" DROP COLUMN IF EXISTS "external_reference";
+
+ALTER TABLE "accounts" DROP COLUMN IF EXISTS "description";
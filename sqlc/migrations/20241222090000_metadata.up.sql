@@ -0,0 +1,43 @@
+-- Optional descriptive fields of accounts and transactions. The external
+-- reference identifies the record in another system: it is unique among
+-- accounts, and among the transactions sent from an account. Metadata is a
+-- free-form JSON object, searched by containment through a GIN index.
+ALTER TABLE "accounts" ADD COLUMN "description" varchar;
+
+ALTER TABLE "accounts" ADD COLUMN "external_reference" varchar;
+
+ALTER TABLE "accounts" ADD COLUMN "category" varchar;
+
+ALTER TABLE "accounts" ADD COLUMN "metadata" jsonb NOT NULL DEFAULT '{}';
+
+ALTER TABLE "accounts" ADD CONSTRAINT "accounts_metadata_check" CHECK (jsonb_typeof("metadata") = 'object');
+
+CREATE UNIQUE INDEX ON "accounts" ("external_reference") WHERE "external_reference" IS NOT NULL;
+
+CREATE INDEX ON "accounts" ("category");
+
+CREATE INDEX ON "accounts" USING GIN ("metadata" jsonb_path_ops);
+
+ALTER TABLE "transactions" ADD COLUMN "description" varchar;
+
+ALTER TABLE "transactions" ADD COLUMN "external_reference" varchar;
+
+ALTER TABLE "transactions" ADD COLUMN "category" varchar;
+
+ALTER TABLE "transactions" ADD COLUMN "metadata" jsonb NOT NULL DEFAULT '{}';
+
+ALTER TABLE "transactions" ADD CONSTRAINT "transactions_metadata_check" CHECK (jsonb_typeof("metadata") = 'object');
+
+CREATE UNIQUE INDEX ON "transactions" ("from_account", "external_reference") WHERE "external_reference" IS NOT NULL;
+
+CREATE INDEX ON "transactions" ("category");
+
+CREATE INDEX ON "transactions" USING GIN ("metadata" jsonb_path_ops);
+
+-- Version of the canonical encoding covered by the chain hash of each
+-- transaction. Transactions chained before these fields existed keep version
+-- 1; new transactions use version 2, which covers them. See
+-- database.CanonicalTxBytes.
+ALTER TABLE "transactions" ADD COLUMN "chain_version" smallint NOT NULL DEFAULT 1;
+
+ALTER TABLE "transactions" ALTER COLUMN "chain_version" SET DEFAULT 2;
@@ -6,6 +6,16 @@ WHERE id = $1 LIMIT 1;
 SELECT * FROM accounts
 ORDER BY username;
 
+-- name: GetUserForUpdate :one
+SELECT * FROM accounts
+WHERE id = $1 LIMIT 1
+FOR UPDATE;
+
+-- name: GetUsersByStatus :many
+SELECT * FROM accounts
+WHERE status = $1
+ORDER BY username;
+
 -- name: GetUserByUsername :one
 SELECT * FROM accounts
 WHERE username = $1 LIMIT 1;
@@ -24,17 +34,35 @@ WHERE id = $1;
 
 -- name: CreateAccount :one
 INSERT INTO accounts (
-	username, balance, email
+	username, balance, email, account_type, description, external_reference, category, metadata
 ) VALUES (
-	$1, $2, $3
+	$1, $2, $3, COALESCE(NULLIF($4::varchar, ''), 'user'), $5, $6, $7, COALESCE($8::jsonb, '{}')
 )
 RETURNING *;
 
+-- name: UpdateAccountOverdraftLimit :one
+UPDATE accounts
+SET overdraft_limit = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateAccountStatus :one
+UPDATE accounts
+SET status = $2, status_reason = $3, status_updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: AddAccountBalance :one
+UPDATE accounts
+SET balance = balance + sqlc.arg(amount)
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
 -- name: CreateTransaction :one
 INSERT INTO transactions (
-	from_account, to_account, amount
+	from_account, to_account, amount, description, external_reference, category, metadata
 ) VALUES (
-	$1, $2, $3
+	$1, $2, $3, $4, $5, $6, COALESCE($7::jsonb, '{}')
 )
 RETURNING *;
 
@@ -57,3 +85,351 @@ WHERE
     from_acc.username = 'desired_username' OR to_acc.username = 'desired_username'
 ORDER BY
     t.created_at DESC;
+
+-- name: ListAccountTransactions :many
+SELECT
+    t.id AS transaction_id,
+    t.from_account AS from_account_id,
+    from_acc.username AS from_username,
+    t.to_account AS to_account_id,
+    to_acc.username AS to_username,
+    t.amount,
+    t.created_at AS transaction_created_at
+FROM
+    transactions t
+JOIN
+    accounts from_acc ON t.from_account = from_acc.id
+JOIN
+    accounts to_acc ON t.to_account = to_acc.id
+WHERE
+    (t.from_account = sqlc.arg(account_id) OR t.to_account = sqlc.arg(account_id))
+    AND t.created_at >= sqlc.arg(from_time)::timestamptz
+    AND t.created_at < sqlc.arg(to_time)::timestamptz
+    AND t.id > sqlc.arg(after_id)
+ORDER BY
+    t.id
+LIMIT sqlc.arg(max_results);
+
+-- name: CreateAuditEntry :one
+INSERT INTO audit_log (
+	entity_type, entity_id, action, actor, request_id, before, after
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7
+)
+RETURNING *;
+
+-- name: ListAuditEntries :many
+SELECT * FROM audit_log
+WHERE (sqlc.narg(entity_type)::varchar IS NULL OR entity_type = sqlc.narg(entity_type))
+  AND (sqlc.narg(entity_id)::bigint IS NULL OR entity_id = sqlc.narg(entity_id))
+  AND (sqlc.narg(from_time)::timestamptz IS NULL OR created_at >= sqlc.narg(from_time))
+  AND (sqlc.narg(to_time)::timestamptz IS NULL OR created_at < sqlc.narg(to_time))
+  AND id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg(max_results);
+
+-- name: ListTransactions :many
+SELECT * FROM transactions
+WHERE id > $1
+ORDER BY id
+LIMIT $2;
+
+-- name: SetTransactionHash :one
+UPDATE transactions
+SET prev_hash = $2, hash = $3
+WHERE id = $1
+RETURNING *;
+
+-- name: GetLedgerChain :one
+SELECT * FROM ledger_chain
+WHERE id = $1 LIMIT 1;
+
+-- name: GetLedgerChainForUpdate :one
+SELECT * FROM ledger_chain
+WHERE id = $1 LIMIT 1
+FOR UPDATE;
+
+-- name: UpdateLedgerChain :one
+UPDATE ledger_chain
+SET last_tx_id = $2, hash = $3, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetFirstTransactionTime :one
+SELECT created_at FROM transactions
+ORDER BY id LIMIT 1;
+
+-- name: GetLatestBalanceCheckpointDay :one
+SELECT day FROM balance_checkpoints
+ORDER BY day DESC LIMIT 1;
+
+-- name: GetOldestTransactionStart :one
+-- Transactions take created_at from the start of their DB transaction, so
+-- none created before the oldest DB transaction in progress can still commit.
+-- Sessions of other roles are only visible with pg_read_all_stats.
+SELECT COALESCE(min(xact_start), now())::timestamptz AS xact_start FROM pg_stat_activity
+WHERE datname = current_database() AND backend_type = 'client backend';
+
+-- name: GetTransactionTime :one
+-- The start of the current DB transaction, which is the created_at of the
+-- rows it writes.
+SELECT now()::timestamptz AS now;
+
+-- name: CreateBalanceCheckpoints :execrows
+INSERT INTO balance_checkpoints (account_id, day, balance)
+SELECT a.id, sqlc.arg(day)::date,
+  (COALESCE(prev.balance, 0) + COALESCE((
+    SELECT SUM(CASE WHEN t.to_account = a.id THEN t.amount ELSE -t.amount END)
+    FROM transactions t
+    WHERE (t.to_account = a.id OR t.from_account = a.id)
+      AND t.created_at < sqlc.arg(day_end)::timestamptz
+      AND (prev.balance IS NULL OR t.created_at >= sqlc.arg(day_start)::timestamptz)
+  ), 0))::bigint
+FROM accounts a
+LEFT JOIN balance_checkpoints prev ON prev.account_id = a.id AND prev.day = sqlc.arg(day)::date - 1
+ON CONFLICT (account_id, day) DO NOTHING;
+
+-- name: GetAccountBalanceAsOf :one
+SELECT a.id AS account_id,
+  (COALESCE(cp.balance, 0) + COALESCE((
+    SELECT SUM(CASE WHEN t.to_account = a.id THEN t.amount ELSE -t.amount END)
+    FROM transactions t
+    WHERE (t.to_account = a.id OR t.from_account = a.id)
+      AND t.created_at <= sqlc.arg(as_of)::timestamptz
+      AND t.created_at >= COALESCE((cp.day + 1)::timestamp AT TIME ZONE 'UTC', '-infinity')
+  ), 0))::bigint AS balance
+FROM accounts a
+LEFT JOIN LATERAL (
+  SELECT day, balance FROM balance_checkpoints c
+  WHERE c.account_id = a.id AND c.day < sqlc.arg(as_of_day)::date
+  ORDER BY day DESC LIMIT 1
+) cp ON true
+WHERE a.id = sqlc.arg(id);
+
+-- name: GetAccountBalancesAsOf :many
+SELECT a.id AS account_id,
+  (COALESCE(cp.balance, 0) + COALESCE((
+    SELECT SUM(CASE WHEN t.to_account = a.id THEN t.amount ELSE -t.amount END)
+    FROM transactions t
+    WHERE (t.to_account = a.id OR t.from_account = a.id)
+      AND t.created_at <= sqlc.arg(as_of)::timestamptz
+      AND t.created_at >= COALESCE((cp.day + 1)::timestamp AT TIME ZONE 'UTC', '-infinity')
+  ), 0))::bigint AS balance
+FROM accounts a
+LEFT JOIN LATERAL (
+  SELECT day, balance FROM balance_checkpoints c
+  WHERE c.account_id = a.id AND c.day < sqlc.arg(as_of_day)::date
+  ORDER BY day DESC LIMIT 1
+) cp ON true
+WHERE a.id = ANY(sqlc.arg(ids)::bigint[])
+ORDER BY a.id;
+
+-- name: CreateScheduledTransfer :one
+INSERT INTO scheduled_transfers (
+	from_account, to_account, amount, cron_expr, interval_seconds, start_at, end_at, next_run_at
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7, $8
+)
+RETURNING *;
+
+-- name: GetScheduledTransfer :one
+SELECT * FROM scheduled_transfers
+WHERE id = $1 LIMIT 1;
+
+-- name: GetScheduledTransferForUpdate :one
+SELECT * FROM scheduled_transfers
+WHERE id = $1 LIMIT 1
+FOR UPDATE;
+
+-- name: ListScheduledTransfers :many
+SELECT * FROM scheduled_transfers
+WHERE (sqlc.narg(account_id)::bigint IS NULL OR from_account = sqlc.narg(account_id) OR to_account = sqlc.narg(account_id))
+  AND (sqlc.narg(status)::varchar IS NULL OR status = sqlc.narg(status))
+ORDER BY id;
+
+-- name: UpdateScheduledTransferStatus :one
+UPDATE scheduled_transfers
+SET status = $2, next_run_at = $3, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: ClaimDueScheduledTransfers :many
+SELECT * FROM scheduled_transfers
+WHERE status = 'active' AND next_run_at <= sqlc.arg(now)::timestamptz
+ORDER BY next_run_at, id
+LIMIT sqlc.arg(max_results)
+FOR UPDATE SKIP LOCKED;
+
+-- name: AdvanceScheduledTransfer :one
+UPDATE scheduled_transfers
+SET next_run_at = $2, last_run_at = $3, status = $4, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: CreateScheduledTransferRun :one
+INSERT INTO scheduled_transfer_runs (
+	scheduled_transfer_id, scheduled_for, transaction_id, status, error, fee_transaction_id, total_fee, fees
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7, COALESCE($8::jsonb, '[]')
+)
+RETURNING *;
+
+-- name: ListScheduledTransferRuns :many
+SELECT * FROM scheduled_transfer_runs
+WHERE scheduled_transfer_id = sqlc.arg(scheduled_transfer_id) AND id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg(max_results);
+
+-- name: CreateExternalTransfer :one
+INSERT INTO external_transfers (
+	kind, account_id, system_account_id, amount, external_reference, status, transaction_id
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7
+)
+RETURNING *;
+
+-- name: GetExternalTransfer :one
+SELECT * FROM external_transfers
+WHERE id = $1 LIMIT 1;
+
+-- name: GetExternalTransferForUpdate :one
+SELECT * FROM external_transfers
+WHERE id = $1 LIMIT 1
+FOR UPDATE;
+
+-- name: GetExternalTransferByReference :one
+SELECT * FROM external_transfers
+WHERE kind = $1 AND external_reference = $2 LIMIT 1;
+
+-- name: ListExternalTransfers :many
+SELECT * FROM external_transfers
+WHERE kind = sqlc.arg(kind)
+  AND (sqlc.narg(account_id)::bigint IS NULL OR account_id = sqlc.narg(account_id))
+  AND (sqlc.narg(status)::varchar IS NULL OR status = sqlc.narg(status))
+  AND id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg(max_results);
+
+-- name: UpdateExternalTransferStatus :one
+UPDATE external_transfers
+SET status = $2, failure_reason = $3, transaction_id = $4, reversal_transaction_id = $5, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: CreateFeeRule :one
+INSERT INTO fee_rules (
+	name, account_type, from_account, to_account, kind, flat_fee, basis_points, min_fee, max_fee, tiers
+) VALUES (
+	$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+RETURNING *;
+
+-- name: GetFeeRule :one
+SELECT * FROM fee_rules
+WHERE id = $1 LIMIT 1;
+
+-- name: ListFeeRules :many
+SELECT * FROM fee_rules
+WHERE (sqlc.narg(status)::varchar IS NULL OR status = sqlc.narg(status))
+  AND id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg(max_results);
+
+-- name: ListMatchingFeeRules :many
+SELECT * FROM fee_rules
+WHERE status = 'active'
+  AND (account_type IS NULL OR account_type = sqlc.arg(account_type))
+  AND (from_account IS NULL OR from_account = sqlc.arg(from_account))
+  AND (to_account IS NULL OR to_account = sqlc.arg(to_account))
+ORDER BY id;
+
+-- name: UpdateFeeRuleStatus :one
+UPDATE fee_rules
+SET status = $2, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: CreateTransactionFee :one
+INSERT INTO transaction_fees (
+	transaction_id, fee_rule_id, fee_transaction_id, amount
+) VALUES (
+	$1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: UpsertInterestRate :one
+INSERT INTO interest_rates (
+	account_id, annual_rate_bps
+) VALUES (
+	$1, $2
+)
+ON CONFLICT (account_id) DO UPDATE SET annual_rate_bps = EXCLUDED.annual_rate_bps, updated_at = now()
+RETURNING *;
+
+-- name: DeleteInterestRate :exec
+DELETE FROM interest_rates
+WHERE account_id = $1;
+
+-- name: GetInterestRate :one
+SELECT * FROM interest_rates
+WHERE account_id = $1 LIMIT 1;
+
+-- name: GetInterestRateForUpdate :one
+SELECT * FROM interest_rates
+WHERE account_id = $1 LIMIT 1
+FOR UPDATE;
+
+-- name: ListInterestRates :many
+SELECT * FROM interest_rates
+ORDER BY account_id;
+
+-- name: CreateInterestAccrual :one
+INSERT INTO interest_accruals (
+	account_id, day, balance, annual_rate_bps, amount, carry
+) VALUES (
+	$1, $2, $3, $4, $5, $6
+)
+RETURNING *;
+
+-- name: GetLatestInterestAccrual :one
+SELECT * FROM interest_accruals
+WHERE account_id = $1
+ORDER BY day DESC
+LIMIT 1;
+
+-- name: SumUnpostedInterest :one
+SELECT COALESCE(SUM(amount), 0)::bigint AS amount, COUNT(*) AS accruals, MIN(day)::date AS from_day, MAX(day)::date AS to_day
+FROM interest_accruals
+WHERE account_id = sqlc.arg(account_id) AND posted_at IS NULL
+  AND (sqlc.narg(before)::date IS NULL OR day < sqlc.narg(before));
+
+-- name: MarkInterestAccrualsPosted :execrows
+UPDATE interest_accruals
+SET posted_at = now(), transaction_id = sqlc.narg(transaction_id)
+WHERE account_id = sqlc.arg(account_id) AND posted_at IS NULL AND day < sqlc.arg(before);
+
+-- name: GetUserByExternalReference :one
+SELECT * FROM accounts
+WHERE external_reference = $1 LIMIT 1;
+
+-- name: GetTxByExternalReference :one
+SELECT * FROM transactions
+WHERE from_account = $1 AND external_reference = $2 LIMIT 1;
+
+-- name: SearchAccounts :many
+SELECT * FROM accounts
+WHERE (sqlc.narg(status)::varchar IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(category)::varchar IS NULL OR category = sqlc.narg(category))
+  AND (sqlc.narg(external_reference)::varchar IS NULL OR external_reference = sqlc.narg(external_reference))
+  AND (sqlc.narg(metadata)::jsonb IS NULL OR metadata @> sqlc.narg(metadata))
+ORDER BY username;
+
+-- name: SearchTransactions :many
+SELECT * FROM transactions
+WHERE (sqlc.narg(account_id)::bigint IS NULL OR from_account = sqlc.narg(account_id) OR to_account = sqlc.narg(account_id))
+  AND (sqlc.narg(category)::varchar IS NULL OR category = sqlc.narg(category))
+  AND (sqlc.narg(external_reference)::varchar IS NULL OR external_reference = sqlc.narg(external_reference))
+  AND (sqlc.narg(metadata)::jsonb IS NULL OR metadata @> sqlc.narg(metadata))
+  AND id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg(max_results);